@@ -0,0 +1,213 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/boreq/guinea"
+	"github.com/boreq/starlight-nick-server/config"
+	"github.com/boreq/starlight-nick-server/data"
+	"github.com/pkg/errors"
+)
+
+var dbbenchCmd = guinea.Command{
+	Run: runDbbench,
+	Arguments: []guinea.Argument{
+		{
+			Name:        "config",
+			Optional:    false,
+			Multiple:    false,
+			Description: "Config file",
+		},
+	},
+	Options: []guinea.Option{
+		guinea.Option{
+			Name:        "concurrency",
+			Type:        guinea.Int,
+			Default:     10,
+			Description: "Number of concurrent workers. Default: 10",
+		},
+		guinea.Option{
+			Name:        "gets",
+			Type:        guinea.Int,
+			Default:     10000,
+			Description: "Number of Get operations. Default: 10000",
+		},
+		guinea.Option{
+			Name:        "puts",
+			Type:        guinea.Int,
+			Default:     1000,
+			Description: "Number of Put operations. Default: 1000",
+		},
+		guinea.Option{
+			Name:        "lists",
+			Type:        guinea.Int,
+			Default:     10,
+			Description: "Number of List operations. Default: 10",
+		},
+		guinea.Option{
+			Name:        "identities",
+			Type:        guinea.Int,
+			Default:     10,
+			Description: "Number of ephemeral identities used to sign the data. Default: 10",
+		},
+	},
+	ShortDescription: "benchmarks the database",
+	Description: `
+Executes concurrent Get, Put and List operations directly against the database
+specified in the config file, bypassing HTTP, and reports the throughput and
+latency percentiles. The Put operations store valid nick data signed using
+ephemeral identities generated for the duration of the test so a scratch
+database should be used. The server can't be running as it holds the database.
+`,
+}
+
+func runDbbench(c guinea.Context) error {
+	conf, err := config.Load(c.Arguments[0])
+	if err != nil {
+		return err
+	}
+
+	options, err := repositoryOptions(conf)
+	if err != nil {
+		return err
+	}
+
+	params := dbbenchParams{
+		Path:        conf.DatabasePath,
+		Options:     options,
+		Concurrency: c.Options["concurrency"].Int(),
+		Gets:        c.Options["gets"].Int(),
+		Puts:        c.Options["puts"].Int(),
+		Lists:       c.Options["lists"].Int(),
+		Identities:  c.Options["identities"].Int(),
+	}
+
+	report, err := dbbench(params)
+	if err != nil {
+		return err
+	}
+
+	report.Print(params)
+	return nil
+}
+
+type dbbenchParams struct {
+	Path        string
+	Options     data.Options
+	Concurrency int
+	Gets        int
+	Puts        int
+	Lists       int
+	Identities  int
+}
+
+// dbbenchReport reuses the report of the loadtest, the requests are the
+// executed operations.
+type dbbenchReport struct {
+	loadtestReport
+}
+
+func (r dbbenchReport) Print(params dbbenchParams) {
+	fmt.Printf("operations:  %d (%d Get, %d Put, %d List)\n", r.Requests, params.Gets, params.Puts, params.Lists)
+	fmt.Printf("errors:      %d\n", r.Errors)
+	fmt.Printf("concurrency: %d\n", params.Concurrency)
+	fmt.Printf("duration:    %s\n", r.Duration)
+	fmt.Printf("throughput:  %.2f ops/s\n", r.Throughput())
+	for _, p := range []float64{50, 90, 99, 100} {
+		fmt.Printf("p%-3.0f        %s\n", p, r.Percentile(p))
+	}
+}
+
+// dbbenchOperation executes a single operation against the repository.
+type dbbenchOperation func(repository *data.BoltRepository) error
+
+func dbbench(params dbbenchParams) (*dbbenchReport, error) {
+	if params.Concurrency < 1 {
+		return nil, errors.New("concurrency must be at least 1")
+	}
+	if params.Identities < 1 {
+		return nil, errors.New("at least one identity is required")
+	}
+
+	var records []*data.NickData
+	for i := 0; i < params.Identities; i++ {
+		iden, err := generateIdentity()
+		if err != nil {
+			return nil, errors.Wrap(err, "could not generate an identity")
+		}
+
+		nickData, err := signNickData(iden, fmt.Sprintf("dbbench%d", i), time.Now())
+		if err != nil {
+			return nil, errors.Wrap(err, "could not sign the nick data")
+		}
+		records = append(records, nickData)
+	}
+
+	repository, err := data.NewBoltRepository(params.Path, params.Options)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open the database")
+	}
+	defer repository.Close()
+
+	operations := make(chan dbbenchOperation)
+	go func() {
+		defer close(operations)
+		for i := 0; i < params.Gets || i < params.Puts || i < params.Lists; i++ {
+			record := records[i%len(records)]
+			if i < params.Puts {
+				operations <- func(repository *data.BoltRepository) error {
+					return repository.Put(record)
+				}
+			}
+			if i < params.Gets {
+				operations <- func(repository *data.BoltRepository) error {
+					_, err := repository.Get(record.Id)
+					return err
+				}
+			}
+			if i < params.Lists {
+				operations <- func(repository *data.BoltRepository) error {
+					_, err := repository.List(context.Background())
+					return err
+				}
+			}
+		}
+	}()
+
+	report := &dbbenchReport{}
+	lock := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+
+	start := time.Now()
+	for i := 0; i < params.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for operation := range operations {
+				operationStart := time.Now()
+				err := operation(repository)
+				latency := time.Since(operationStart)
+
+				lock.Lock()
+				report.Requests++
+				if err != nil {
+					report.Errors++
+				} else {
+					report.Latencies = append(report.Latencies, latency)
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	report.Duration = time.Since(start)
+
+	sort.Slice(report.Latencies, func(i, j int) bool {
+		return report.Latencies[i] < report.Latencies[j]
+	})
+	return report, nil
+}
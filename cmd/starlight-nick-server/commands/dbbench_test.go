@@ -0,0 +1,38 @@
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/boreq/starlight-nick-server/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDbbench(t *testing.T) {
+	// given
+	dir, err := ioutil.TempDir("", "test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	params := dbbenchParams{
+		Path:        filepath.Join(dir, "database.bolt"),
+		Options:     data.DefaultOptions(),
+		Concurrency: 2,
+		Gets:        10,
+		Puts:        5,
+		Lists:       2,
+		Identities:  1,
+	}
+
+	// when
+	report, err := dbbench(params)
+
+	// then
+	require.NoError(t, err)
+	require.Equal(t, 17, report.Requests)
+	require.Equal(t, 0, report.Errors)
+	require.True(t, report.Throughput() > 0, "throughput should be non-zero")
+	require.True(t, report.Percentile(50) > 0, "latency should be non-zero")
+}
@@ -0,0 +1,170 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/boreq/guinea"
+	"github.com/boreq/starlight-nick-server/config"
+	"github.com/boreq/starlight-nick-server/data"
+	"github.com/pkg/errors"
+)
+
+var diffCmd = guinea.Command{
+	Run: runDiff,
+	Arguments: []guinea.Argument{
+		{
+			Name:        "config-a",
+			Optional:    false,
+			Multiple:    false,
+			Description: "Config file of the first database",
+		},
+		{
+			Name:        "config-b",
+			Optional:    false,
+			Multiple:    false,
+			Description: "Config file of the second database",
+		},
+	},
+	Options: []guinea.Option{
+		guinea.Option{
+			Name:        "details",
+			Type:        guinea.Bool,
+			Default:     false,
+			Description: "Print the ids of the differing entries",
+		},
+	},
+	ShortDescription: "compares two databases",
+	Description: `
+Opens the databases specified in the two config files in read-only mode and
+reports the entries present only in one of them and the entries present in both
+of them but with a different content. This can be used to verify the integrity
+of replicas and backups.
+`,
+}
+
+func runDiff(c guinea.Context) error {
+	confA, err := config.Load(c.Arguments[0])
+	if err != nil {
+		return err
+	}
+
+	confB, err := config.Load(c.Arguments[1])
+	if err != nil {
+		return err
+	}
+
+	report, err := diffDatabases(confA.DatabasePath, confB.DatabasePath)
+	if err != nil {
+		return err
+	}
+
+	report.Print(c.Options["details"].Bool())
+	return nil
+}
+
+type diffReport struct {
+	// OnlyInA contains the ids of the entries present only in the first
+	// database.
+	OnlyInA []string
+
+	// OnlyInB contains the ids of the entries present only in the second
+	// database.
+	OnlyInB []string
+
+	// Different contains the ids of the entries present in both databases
+	// but with a different content.
+	Different []string
+
+	// Identical is the number of entries which are the same in both
+	// databases.
+	Identical int
+}
+
+// Equal returns true if no differences were found.
+func (r diffReport) Equal() bool {
+	return len(r.OnlyInA) == 0 && len(r.OnlyInB) == 0 && len(r.Different) == 0
+}
+
+func (r diffReport) Print(details bool) {
+	fmt.Printf("only in a: %d\n", len(r.OnlyInA))
+	fmt.Printf("only in b: %d\n", len(r.OnlyInB))
+	fmt.Printf("different: %d\n", len(r.Different))
+	fmt.Printf("identical: %d\n", r.Identical)
+
+	if details {
+		printDiffDetails("only in a", r.OnlyInA)
+		printDiffDetails("only in b", r.OnlyInB)
+		printDiffDetails("different", r.Different)
+	}
+}
+
+func printDiffDetails(label string, ids []string) {
+	for _, id := range ids {
+		fmt.Printf("%s: %s\n", label, id)
+	}
+}
+
+// diffDatabases compares the entries stored in two databases. The entries are
+// compared using their content hashes.
+func diffDatabases(pathA, pathB string) (*diffReport, error) {
+	hashesA, err := loadContentHashes(pathA)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not load the first database")
+	}
+
+	hashesB, err := loadContentHashes(pathB)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not load the second database")
+	}
+
+	report := &diffReport{}
+	for id, hashA := range hashesA {
+		hashB, ok := hashesB[id]
+		switch {
+		case !ok:
+			report.OnlyInA = append(report.OnlyInA, id)
+		case !bytes.Equal(hashA, hashB):
+			report.Different = append(report.Different, id)
+		default:
+			report.Identical++
+		}
+	}
+	for id := range hashesB {
+		if _, ok := hashesA[id]; !ok {
+			report.OnlyInB = append(report.OnlyInB, id)
+		}
+	}
+
+	sort.Strings(report.OnlyInA)
+	sort.Strings(report.OnlyInB)
+	sort.Strings(report.Different)
+	return report, nil
+}
+
+// loadContentHashes returns the content hashes of all entries stored in the
+// database keyed by hex-encoded node ids.
+func loadContentHashes(path string) (map[string][]byte, error) {
+	options := data.DefaultOptions()
+	options.ReadOnly = true
+
+	repository, err := data.NewBoltRepository(path, options)
+	if err != nil {
+		return nil, err
+	}
+	defer repository.Close()
+
+	nickDatas, err := repository.List(context.Background())
+	if err != nil {
+		return nil, errors.Wrap(err, "list failed")
+	}
+
+	hashes := make(map[string][]byte)
+	for _, nickData := range nickDatas {
+		hashes[hex.EncodeToString(nickData.Id)] = nickData.ContentHash()
+	}
+	return hashes, nil
+}
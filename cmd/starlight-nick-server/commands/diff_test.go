@@ -0,0 +1,99 @@
+package commands
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boreq/starlight-nick-server/data"
+	"github.com/stretchr/testify/require"
+)
+
+func makeDiffDatabase(t *testing.T, dir, name string, nickDatas ...*data.NickData) string {
+	path := filepath.Join(dir, name)
+
+	repository, err := data.NewBoltRepository(path, data.DefaultOptions())
+	require.NoError(t, err)
+	defer repository.Close()
+
+	for _, nickData := range nickDatas {
+		require.NoError(t, repository.Put(nickData))
+	}
+	return path
+}
+
+func TestDiffDatabases(t *testing.T) {
+	// given
+	dir, err := ioutil.TempDir("", "nick_server_diff_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var ids []string
+	var nickDatas []*data.NickData
+	for _, nick := range []string{"identical", "onlyina", "onlyinb", "different"} {
+		iden, err := generateIdentity()
+		require.NoError(t, err)
+
+		nickData, err := signNickData(iden, nick, time.Date(1990, 1, 1, 1, 1, 1, 0, time.UTC))
+		require.NoError(t, err)
+
+		ids = append(ids, hex.EncodeToString(iden.Id))
+		nickDatas = append(nickDatas, nickData)
+
+		if nick == "different" {
+			changed, err := signNickData(iden, "changed", time.Date(1990, 1, 1, 1, 1, 2, 0, time.UTC))
+			require.NoError(t, err)
+			nickDatas = append(nickDatas, changed)
+		}
+	}
+
+	pathA := makeDiffDatabase(t, dir, "a.bolt", nickDatas[0], nickDatas[1], nickDatas[3])
+	pathB := makeDiffDatabase(t, dir, "b.bolt", nickDatas[0], nickDatas[2], nickDatas[4])
+
+	// when
+	report, err := diffDatabases(pathA, pathB)
+
+	// then
+	require.NoError(t, err)
+	require.False(t, report.Equal())
+	require.Equal(t, []string{ids[1]}, report.OnlyInA)
+	require.Equal(t, []string{ids[2]}, report.OnlyInB)
+	require.Equal(t, []string{ids[3]}, report.Different)
+	require.Equal(t, 1, report.Identical)
+}
+
+func TestDiffDatabasesIdentical(t *testing.T) {
+	// given
+	dir, err := ioutil.TempDir("", "nick_server_diff_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	iden, err := generateIdentity()
+	require.NoError(t, err)
+
+	nickData, err := signNickData(iden, "nick", time.Date(1990, 1, 1, 1, 1, 1, 0, time.UTC))
+	require.NoError(t, err)
+
+	pathA := makeDiffDatabase(t, dir, "a.bolt", nickData)
+	pathB := makeDiffDatabase(t, dir, "b.bolt", nickData)
+
+	// when
+	report, err := diffDatabases(pathA, pathB)
+
+	// then
+	require.NoError(t, err)
+	require.True(t, report.Equal())
+	require.Equal(t, 1, report.Identical)
+}
+
+func TestDiffDatabasesMissing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nick_server_diff_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = diffDatabases(filepath.Join(dir, "a.bolt"), filepath.Join(dir, "b.bolt"))
+	require.Error(t, err, "missing databases should not be created")
+}
@@ -0,0 +1,150 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/boreq/guinea"
+	"github.com/boreq/starlight-nick-server/config"
+	"github.com/boreq/starlight-nick-server/data"
+	"github.com/boreq/starlight/network/node"
+	"github.com/pkg/errors"
+)
+
+// exportPageSize is the number of entries read at once from the repositories
+// which can list the entries in pages.
+const exportPageSize = 1000
+
+var exportCmd = guinea.Command{
+	Run: runExport,
+	Arguments: []guinea.Argument{
+		{
+			Name:        "config",
+			Optional:    false,
+			Multiple:    false,
+			Description: "Config file",
+		},
+	},
+	Options: []guinea.Option{
+		guinea.Option{
+			Name:        "ndjson",
+			Type:        guinea.Bool,
+			Default:     false,
+			Description: "Write one JSON object per line instead of a JSON array",
+		},
+	},
+	ShortDescription: "exports all nick data",
+	Description: `
+Opens the database specified in the config file in read-only mode and writes
+all stored nick data to the standard output as a JSON array or, if the ndjson
+option is set, as one JSON object per line. The latter format can be used to
+seed another server. The entries are read in pages so the whole database isn't
+loaded into memory.
+`,
+}
+
+func runExport(c guinea.Context) error {
+	conf, err := config.Load(c.Arguments[0])
+	if err != nil {
+		return err
+	}
+
+	options, err := repositoryOptions(conf)
+	if err != nil {
+		return err
+	}
+	options.ReadOnly = true
+
+	repository, err := data.NewRepository(conf, options)
+	if err != nil {
+		return err
+	}
+	defer repository.Close()
+
+	w := bufio.NewWriter(os.Stdout)
+	if err := exportNickData(context.Background(), repository, w, c.Options["ndjson"].Bool()); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// pagedRepository is implemented by the repositories which can list the
+// entries in pages.
+type pagedRepository interface {
+	ListAfter(after node.ID, limit int) ([]data.NickData, node.ID, error)
+}
+
+// exportNickData writes all entries stored in the repository as a JSON array
+// or as one JSON object per line.
+func exportNickData(ctx context.Context, repository data.Repository, w io.Writer, ndjson bool) error {
+	encoder := json.NewEncoder(w)
+	first := true
+	write := func(nickData *data.NickData) error {
+		if !ndjson {
+			separator := ","
+			if first {
+				separator = "["
+			}
+			if _, err := io.WriteString(w, separator); err != nil {
+				return err
+			}
+		}
+		first = false
+		return encoder.Encode(nickData)
+	}
+
+	if err := iterateNickData(ctx, repository, write); err != nil {
+		return err
+	}
+
+	if !ndjson {
+		closing := "]\n"
+		if first {
+			closing = "[]\n"
+		}
+		if _, err := io.WriteString(w, closing); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// iterateNickData calls fn for each entry stored in the repository.
+func iterateNickData(ctx context.Context, repository data.Repository, fn func(nickData *data.NickData) error) error {
+	paged, ok := repository.(pagedRepository)
+	if !ok {
+		nickDatas, err := repository.List(ctx)
+		if err != nil {
+			return errors.Wrap(err, "list failed")
+		}
+		for i := range nickDatas {
+			if err := fn(&nickDatas[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var after node.ID
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		nickDatas, next, err := paged.ListAfter(after, exportPageSize)
+		if err != nil {
+			return errors.Wrap(err, "list after failed")
+		}
+		for i := range nickDatas {
+			if err := fn(&nickDatas[i]); err != nil {
+				return err
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		after = next
+	}
+}
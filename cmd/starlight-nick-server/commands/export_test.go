@@ -0,0 +1,90 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boreq/starlight-nick-server/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportNickData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nick_server_export_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	bolt, err := data.NewBoltRepository(filepath.Join(dir, "database.bolt"), data.DefaultOptions())
+	require.NoError(t, err)
+	defer bolt.Close()
+
+	repositories := map[string]data.Repository{
+		"memory": data.NewMemoryRepository(data.DefaultOptions()),
+		"bolt":   bolt,
+	}
+
+	var nickDatas []*data.NickData
+	for _, nick := range []string{"official", "support"} {
+		iden, err := generateIdentity()
+		require.NoError(t, err)
+
+		nickData, err := signNickData(iden, nick, time.Date(1990, 1, 1, 1, 1, 1, 0, time.UTC))
+		require.NoError(t, err)
+		nickDatas = append(nickDatas, nickData)
+	}
+
+	for name, repository := range repositories {
+		t.Run(name, func(t *testing.T) {
+			// given
+			for _, nickData := range nickDatas {
+				require.NoError(t, repository.Put(nickData))
+			}
+
+			for _, ndjson := range []bool{false, true} {
+				buf := &bytes.Buffer{}
+
+				// when
+				err := exportNickData(context.Background(), repository, buf, ndjson)
+				require.NoError(t, err)
+
+				// then
+				var exported []data.NickData
+				if ndjson {
+					scanner := bufio.NewScanner(buf)
+					for scanner.Scan() {
+						var nickData data.NickData
+						require.NoError(t, json.Unmarshal(scanner.Bytes(), &nickData))
+						exported = append(exported, nickData)
+					}
+					require.NoError(t, scanner.Err())
+				} else {
+					require.NoError(t, json.Unmarshal(buf.Bytes(), &exported))
+				}
+
+				require.Len(t, exported, len(nickDatas), "ndjson: %t", ndjson)
+				for _, nickData := range exported {
+					require.NoError(t, nickData.Validate(), "exported nick data should remain valid")
+				}
+			}
+		})
+	}
+}
+
+func TestExportNickDataEmpty(t *testing.T) {
+	// given
+	repository := data.NewMemoryRepository(data.DefaultOptions())
+	buf := &bytes.Buffer{}
+
+	// when
+	err := exportNickData(context.Background(), repository, buf, false)
+
+	// then
+	require.NoError(t, err)
+	require.Equal(t, "[]\n", buf.String())
+}
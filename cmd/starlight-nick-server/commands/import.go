@@ -0,0 +1,220 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/boreq/guinea"
+	"github.com/boreq/starlight-nick-server/config"
+	"github.com/boreq/starlight-nick-server/data"
+	"github.com/pkg/errors"
+)
+
+var importCmd = guinea.Command{
+	Run: runImport,
+	Arguments: []guinea.Argument{
+		{
+			Name:        "config",
+			Optional:    false,
+			Multiple:    false,
+			Description: "Config file",
+		},
+		{
+			Name:        "file",
+			Optional:    false,
+			Multiple:    false,
+			Description: "File containing the nick data",
+		},
+	},
+	Options: []guinea.Option{
+		guinea.Option{
+			Name:        "dry-run",
+			Type:        guinea.Bool,
+			Default:     false,
+			Description: "Only validate the nick data without opening the database",
+		},
+	},
+	ShortDescription: "imports nick data",
+	Description: `
+Reads nick data from a file containing a JSON array or one JSON object per
+line, as written by the export command, and stores it in the database specified
+in the config file. Entries which are invalid, older than the stored ones or
+which use nicks taken by other nodes are skipped. Entries identical to the
+stored ones are left untouched so importing the same file again changes
+nothing. A summary is printed once the import completes.
+`,
+}
+
+func runImport(c guinea.Context) error {
+	conf, err := config.Load(c.Arguments[0])
+	if err != nil {
+		return err
+	}
+
+	options, err := repositoryOptions(conf)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(c.Arguments[1])
+	if err != nil {
+		return errors.Wrap(err, "could not open the file")
+	}
+	defer f.Close()
+
+	if c.Options["dry-run"].Bool() {
+		summary, err := importNickData(nil, f, options)
+		if err != nil {
+			return err
+		}
+		fmt.Println("dry run, nothing was stored")
+		summary.Print()
+		return nil
+	}
+
+	repository, err := data.NewRepository(conf, options)
+	if err != nil {
+		return err
+	}
+	defer repository.Close()
+
+	summary, err := importNickData(repository, f, options)
+	if err != nil {
+		return err
+	}
+	summary.Print()
+	return nil
+}
+
+type importSummary struct {
+	// Imported is the number of stored entries. During a dry run it is
+	// the number of valid entries.
+	Imported int
+
+	// Unchanged is the number of entries identical to the stored ones.
+	Unchanged int
+
+	// Older is the number of entries skipped because newer nick data for
+	// the same node was already present.
+	Older int
+
+	// Conflicts is the number of entries skipped because the nick was
+	// already taken by a different node, is quarantined or is reserved.
+	Conflicts int
+
+	// Invalid is the number of entries which couldn't be decoded or
+	// failed the validation.
+	Invalid int
+}
+
+func (s importSummary) Print() {
+	fmt.Printf("imported: %d\n", s.Imported)
+	fmt.Printf("unchanged: %d\n", s.Unchanged)
+	fmt.Printf("skipped older: %d\n", s.Older)
+	fmt.Printf("skipped conflicts: %d\n", s.Conflicts)
+	fmt.Printf("invalid: %d\n", s.Invalid)
+}
+
+// importNickData stores the nick data read from a JSON array or from a stream
+// of JSON objects in the repository. If the repository is nil the entries are
+// only validated. Malformed JSON causes an error while entries which can't be
+// stored are counted in the summary.
+func importNickData(repository data.Repository, r io.Reader, options data.Options) (importSummary, error) {
+	var summary importSummary
+
+	br := bufio.NewReader(r)
+	array, err := startsWithArray(br)
+	if err != nil {
+		return summary, errors.Wrap(err, "could not read the file")
+	}
+
+	decoder := json.NewDecoder(br)
+	if array {
+		if _, err := decoder.Token(); err != nil {
+			return summary, errors.Wrap(err, "could not decode the array")
+		}
+	}
+
+	for i := 0; decoder.More(); i++ {
+		nickData := &data.NickData{}
+		if err := decoder.Decode(nickData); err != nil {
+			if _, ok := err.(*json.SyntaxError); ok || err == io.ErrUnexpectedEOF {
+				return summary, errors.Wrapf(err, "could not decode entry %d", i)
+			}
+			summary.Invalid++
+			continue
+		}
+
+		if err := importEntry(repository, nickData, options, &summary); err != nil {
+			return summary, errors.Wrapf(err, "could not import entry %d", i)
+		}
+	}
+
+	if array {
+		if _, err := decoder.Token(); err != nil {
+			return summary, errors.Wrap(err, "could not decode the array")
+		}
+	}
+	return summary, nil
+}
+
+func importEntry(repository data.Repository, nickData *data.NickData, options data.Options, summary *importSummary) error {
+	if err := nickData.ValidateWithPolicy(options.NickPolicy); err != nil {
+		summary.Invalid++
+		return nil
+	}
+
+	if repository == nil {
+		summary.Imported++
+		return nil
+	}
+
+	stored, err := repository.Get(nickData.Id)
+	if err != nil {
+		return errors.Wrap(err, "get failed")
+	}
+	if stored != nil && bytes.Equal(stored.ContentHash(), nickData.ContentHash()) {
+		summary.Unchanged++
+		return nil
+	}
+
+	switch err := errors.Cause(repository.Put(nickData)); {
+	case err == nil:
+		summary.Imported++
+	case err == data.NewerNickDataPresentErr, err == data.SameTimeChangeErr:
+		summary.Older++
+	case err == data.NickConflictErr, err == data.NickQuarantinedErr, err == data.ReservedNickErr:
+		summary.Conflicts++
+	case errors.Is(err, data.InvalidNickDataErr), err == data.NickKeyTooLongErr:
+		summary.Invalid++
+	default:
+		return errors.Wrap(err, "put failed")
+	}
+	return nil
+}
+
+// startsWithArray returns true if the first character other than whitespace is
+// the beginning of a JSON array.
+func startsWithArray(br *bufio.Reader) (bool, error) {
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			if err == io.EOF {
+				return false, nil
+			}
+			return false, err
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			if _, err := br.ReadByte(); err != nil {
+				return false, err
+			}
+		default:
+			return b[0] == '[', nil
+		}
+	}
+}
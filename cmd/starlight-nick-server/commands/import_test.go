@@ -0,0 +1,131 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boreq/starlight-nick-server/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportNickData(t *testing.T) {
+	// given
+	dump, nickDatas := makeImportDump(t)
+	repository := data.NewMemoryRepository(data.DefaultOptions())
+
+	// when
+	summary, err := importNickData(repository, bytes.NewReader(dump), data.DefaultOptions())
+
+	// then
+	require.NoError(t, err)
+	require.Equal(t, importSummary{Imported: 2, Older: 1, Conflicts: 1, Invalid: 2}, summary)
+
+	for _, nickData := range nickDatas[:2] {
+		stored, err := repository.GetByNick(nickData.Nick)
+		require.NoError(t, err)
+		require.Equal(t, nickData.Id, stored.Id)
+	}
+}
+
+func TestImportNickDataIdempotent(t *testing.T) {
+	// given
+	dump, _ := makeImportDump(t)
+	repository := data.NewMemoryRepository(data.DefaultOptions())
+
+	_, err := importNickData(repository, bytes.NewReader(dump), data.DefaultOptions())
+	require.NoError(t, err)
+
+	before, err := repository.List(context.Background())
+	require.NoError(t, err)
+
+	// when
+	summary, err := importNickData(repository, bytes.NewReader(dump), data.DefaultOptions())
+
+	// then
+	require.NoError(t, err)
+	require.Equal(t, importSummary{Unchanged: 2, Older: 1, Conflicts: 1, Invalid: 2}, summary)
+
+	after, err := repository.List(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, before, after)
+}
+
+func TestImportNickDataDryRun(t *testing.T) {
+	// given
+	dump, _ := makeImportDump(t)
+
+	// when
+	summary, err := importNickData(nil, bytes.NewReader(dump), data.DefaultOptions())
+
+	// then
+	require.NoError(t, err)
+	require.Equal(t, importSummary{Imported: 4, Invalid: 2}, summary)
+}
+
+func TestImportNickDataNDJSON(t *testing.T) {
+	// given
+	_, nickDatas := makeImportDump(t)
+	buf := &bytes.Buffer{}
+	for _, nickData := range nickDatas {
+		require.NoError(t, json.NewEncoder(buf).Encode(nickData))
+	}
+	repository := data.NewMemoryRepository(data.DefaultOptions())
+
+	// when
+	summary, err := importNickData(repository, buf, data.DefaultOptions())
+
+	// then
+	require.NoError(t, err)
+	require.Equal(t, importSummary{Imported: 2, Older: 1, Conflicts: 1, Invalid: 1}, summary)
+}
+
+func TestImportNickDataMalformed(t *testing.T) {
+	// given
+	repository := data.NewMemoryRepository(data.DefaultOptions())
+
+	// when
+	_, err := importNickData(repository, strings.NewReader(`[{"nick": `), data.DefaultOptions())
+
+	// then
+	require.Error(t, err)
+}
+
+// makeImportDump returns a JSON array containing two valid entries, an entry
+// older than the first one, an entry using the nick of the first one, an
+// entry with an invalid signature and an entry which can't be decoded. The
+// encoded entries are also returned in the same order.
+func makeImportDump(t *testing.T) ([]byte, []*data.NickData) {
+	first, err := generateIdentity()
+	require.NoError(t, err)
+	second, err := generateIdentity()
+	require.NoError(t, err)
+	third, err := generateIdentity()
+	require.NoError(t, err)
+
+	valid, err := signNickData(first, "official", time.Date(1991, 1, 1, 1, 1, 1, 0, time.UTC))
+	require.NoError(t, err)
+	other, err := signNickData(second, "support", time.Date(1991, 1, 1, 1, 1, 1, 0, time.UTC))
+	require.NoError(t, err)
+	older, err := signNickData(first, "previous", time.Date(1990, 1, 1, 1, 1, 1, 0, time.UTC))
+	require.NoError(t, err)
+	conflicting, err := signNickData(third, "official", time.Date(1992, 1, 1, 1, 1, 1, 0, time.UTC))
+	require.NoError(t, err)
+	tampered, err := signNickData(third, "tampered", time.Date(1992, 1, 1, 1, 1, 1, 0, time.UTC))
+	require.NoError(t, err)
+	tampered.Nick = "changed"
+
+	nickDatas := []*data.NickData{valid, other, older, conflicting, tampered}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("[")
+	for _, nickData := range nickDatas {
+		require.NoError(t, json.NewEncoder(buf).Encode(nickData))
+		buf.WriteString(",")
+	}
+	buf.WriteString(`{"id": "not hex"}]`)
+	return buf.Bytes(), nickDatas
+}
@@ -0,0 +1,306 @@
+package commands
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boreq/guinea"
+	"github.com/boreq/starlight-nick-server/data"
+	"github.com/boreq/starlight/network/node"
+	"github.com/pkg/errors"
+)
+
+var loadtestCmd = guinea.Command{
+	Run: runLoadtest,
+	Arguments: []guinea.Argument{
+		{
+			Name:        "base-url",
+			Optional:    false,
+			Multiple:    false,
+			Description: "Base URL of the server, eg. http://127.0.0.1:8118",
+		},
+	},
+	Options: []guinea.Option{
+		guinea.Option{
+			Name:        "concurrency",
+			Type:        guinea.Int,
+			Default:     10,
+			Description: "Number of concurrent workers. Default: 10",
+		},
+		guinea.Option{
+			Name:        "gets",
+			Type:        guinea.Int,
+			Default:     1000,
+			Description: "Number of GET requests. Default: 1000",
+		},
+		guinea.Option{
+			Name:        "puts",
+			Type:        guinea.Int,
+			Default:     100,
+			Description: "Number of PUT requests. Default: 100",
+		},
+		guinea.Option{
+			Name:        "identities",
+			Type:        guinea.Int,
+			Default:     10,
+			Description: "Number of ephemeral identities used to sign the data. Default: 10",
+		},
+	},
+	ShortDescription: "benchmarks a running server",
+	Description: `
+Fires concurrent GET and PUT requests against a running server and reports the
+throughput and latency percentiles. The PUT requests contain valid nick data
+signed using ephemeral identities generated for the duration of the test.
+`,
+}
+
+func runLoadtest(c guinea.Context) error {
+	params := loadtestParams{
+		BaseURL:     c.Arguments[0],
+		Concurrency: c.Options["concurrency"].Int(),
+		Gets:        c.Options["gets"].Int(),
+		Puts:        c.Options["puts"].Int(),
+		Identities:  c.Options["identities"].Int(),
+		Client:      http.DefaultClient,
+	}
+
+	report, err := loadtest(params)
+	if err != nil {
+		return err
+	}
+
+	report.Print(params)
+	return nil
+}
+
+// identityKeyBits is the size of the keys of the ephemeral identities.
+const identityKeyBits = 2048
+
+type loadtestParams struct {
+	BaseURL     string
+	Concurrency int
+	Gets        int
+	Puts        int
+	Identities  int
+	Client      *http.Client
+}
+
+type loadtestReport struct {
+	Requests  int
+	Errors    int
+	Duration  time.Duration
+	Latencies []time.Duration
+}
+
+// Throughput returns the number of requests per second.
+func (r loadtestReport) Throughput() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Requests) / r.Duration.Seconds()
+}
+
+// Percentile returns the latency percentile, p must be in range [0, 100].
+func (r loadtestReport) Percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	i := int(float64(len(r.Latencies)-1) * p / 100)
+	return r.Latencies[i]
+}
+
+func (r loadtestReport) Print(params loadtestParams) {
+	fmt.Printf("requests:    %d (%d GET, %d PUT)\n", r.Requests, params.Gets, params.Puts)
+	fmt.Printf("errors:      %d\n", r.Errors)
+	fmt.Printf("concurrency: %d\n", params.Concurrency)
+	fmt.Printf("duration:    %s\n", r.Duration)
+	fmt.Printf("throughput:  %.2f req/s\n", r.Throughput())
+	for _, p := range []float64{50, 90, 99, 100} {
+		fmt.Printf("p%-3.0f        %s\n", p, r.Percentile(p))
+	}
+}
+
+type loadtestRequest struct {
+	Method string
+	Path   string
+	Body   []byte
+}
+
+func loadtest(params loadtestParams) (*loadtestReport, error) {
+	if params.Concurrency < 1 {
+		return nil, errors.New("concurrency must be at least 1")
+	}
+	if params.Puts > 0 && params.Identities < 1 {
+		return nil, errors.New("at least one identity is required to send PUT requests")
+	}
+
+	records, err := generateLoadtestRecords(params.Identities)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not generate the records")
+	}
+
+	requests := make(chan loadtestRequest)
+	go func() {
+		defer close(requests)
+		for i := 0; i < params.Gets || i < params.Puts; i++ {
+			if i < params.Puts {
+				requests <- loadtestRequest{
+					Method: http.MethodPut,
+					Path:   "/nicks",
+					Body:   records[i%len(records)].Body,
+				}
+			}
+			if i < params.Gets {
+				path := "/nicks"
+				if len(records) > 0 {
+					path = "/nicks/" + records[i%len(records)].Id
+				}
+				requests <- loadtestRequest{
+					Method: http.MethodGet,
+					Path:   path,
+				}
+			}
+		}
+	}()
+
+	report := &loadtestReport{}
+	lock := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+
+	start := time.Now()
+	for i := 0; i < params.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for request := range requests {
+				latency, err := sendLoadtestRequest(params, request)
+
+				lock.Lock()
+				report.Requests++
+				if err != nil {
+					report.Errors++
+				} else {
+					report.Latencies = append(report.Latencies, latency)
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	report.Duration = time.Since(start)
+
+	sort.Slice(report.Latencies, func(i, j int) bool {
+		return report.Latencies[i] < report.Latencies[j]
+	})
+	return report, nil
+}
+
+func sendLoadtestRequest(params loadtestParams, request loadtestRequest) (time.Duration, error) {
+	var body io.Reader
+	if request.Body != nil {
+		body = bytes.NewReader(request.Body)
+	}
+
+	url := strings.TrimSuffix(params.BaseURL, "/") + request.Path
+	req, err := http.NewRequest(request.Method, url, body)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	resp, err := params.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(ioutil.Discard, resp.Body); err != nil {
+		return 0, err
+	}
+	latency := time.Since(start)
+
+	// Missing records are expected if the GETs race the PUTs.
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		return 0, errors.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return latency, nil
+}
+
+type loadtestRecord struct {
+	Id   string
+	Body []byte
+}
+
+func generateLoadtestRecords(n int) ([]loadtestRecord, error) {
+	var records []loadtestRecord
+	for i := 0; i < n; i++ {
+		iden, err := generateIdentity()
+		if err != nil {
+			return nil, errors.Wrap(err, "could not generate an identity")
+		}
+
+		nickData, err := signNickData(iden, fmt.Sprintf("loadtest%d", i), time.Now())
+		if err != nil {
+			return nil, errors.Wrap(err, "could not sign the nick data")
+		}
+
+		body, err := json.Marshal(nickData)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not marshal the nick data")
+		}
+
+		records = append(records, loadtestRecord{
+			Id:   hex.EncodeToString(iden.Id),
+			Body: body,
+		})
+	}
+	return records, nil
+}
+
+// generateIdentity creates a new ephemeral identity.
+func generateIdentity() (*node.Identity, error) {
+	key, err := rsa.GenerateKey(rand.Reader, identityKeyBits)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not generate the key")
+	}
+
+	block := &pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	}
+	return node.LoadIdentity(pem.EncodeToMemory(block))
+}
+
+// signNickData creates signed nick data for the given identity.
+func signNickData(iden *node.Identity, nick string, t time.Time) (*data.NickData, error) {
+	publicKey, err := iden.PubKey.Bytes()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get the public key")
+	}
+
+	nickData := &data.NickData{
+		Id:        iden.Id,
+		Nick:      nick,
+		Time:      t,
+		PublicKey: publicKey,
+	}
+
+	signature, err := iden.PrivKey.Sign(nickData.GetDataToSign(), data.SigningHash)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not sign the data")
+	}
+	nickData.Signature = signature
+	return nickData, nil
+}
@@ -0,0 +1,48 @@
+package commands
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadtest(t *testing.T) {
+	// given
+	var gets, puts int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(ioutil.Discard, r.Body)
+		switch r.Method {
+		case http.MethodGet:
+			atomic.AddInt32(&gets, 1)
+		case http.MethodPut:
+			atomic.AddInt32(&puts, 1)
+		}
+	}))
+	defer s.Close()
+
+	params := loadtestParams{
+		BaseURL:     s.URL,
+		Concurrency: 2,
+		Gets:        10,
+		Puts:        5,
+		Identities:  1,
+		Client:      s.Client(),
+	}
+
+	// when
+	report, err := loadtest(params)
+
+	// then
+	require.NoError(t, err)
+	require.Equal(t, 15, report.Requests)
+	require.Equal(t, 0, report.Errors)
+	require.True(t, report.Throughput() > 0, "throughput should be non-zero")
+	require.True(t, report.Percentile(50) > 0, "latency should be non-zero")
+	require.Equal(t, int32(10), atomic.LoadInt32(&gets))
+	require.Equal(t, int32(5), atomic.LoadInt32(&puts))
+}
@@ -9,6 +9,11 @@ var MainCmd = guinea.Command{
 	Subcommands: map[string]*guinea.Command{
 		"run":            &runCmd,
 		"default_config": &defaultConfigCmd,
+		"loadtest":       &loadtestCmd,
+		"diff":           &diffCmd,
+		"dbbench":        &dbbenchCmd,
+		"export":         &exportCmd,
+		"import":         &importCmd,
 	},
 	ShortDescription: "a nick server for starlight",
 	Description: `
@@ -1,12 +1,23 @@
 package commands
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
 	"github.com/boreq/guinea"
 	"github.com/boreq/starlight-nick-server/config"
 	"github.com/boreq/starlight-nick-server/data"
+	"github.com/boreq/starlight-nick-server/logging"
 	"github.com/boreq/starlight-nick-server/server"
+	"github.com/pkg/errors"
 )
 
+var log = logging.New("commands")
+
 var runCmd = guinea.Command{
 	Run: runRun,
 	Arguments: []guinea.Argument{
@@ -20,16 +31,117 @@ var runCmd = guinea.Command{
 	ShortDescription: "runs the server",
 }
 
+// repositoryOptions returns the repository options specified in the config.
+func repositoryOptions(conf *config.Config) (data.Options, error) {
+	nickRegexp, err := conf.CompiledNickRegexp()
+	if err != nil {
+		return data.Options{}, err
+	}
+
+	reservedNicks, err := data.NewReservedNicks(conf.ReservedNicks)
+	if err != nil {
+		return data.Options{}, err
+	}
+
+	options := data.DefaultOptions()
+	options.NickPolicy.StrictSeparators = conf.StrictNickSeparators
+	options.NickPolicy.Regexp = nickRegexp
+	options.NickPolicy.MinLength = conf.MinNickLength
+	options.NickPolicy.MaxLength = conf.MaxNickLength
+	options.NickPolicy.MaxClockSkew = time.Duration(conf.MaxClockSkew)
+	options.NickPolicy.SignatureHints = conf.SignatureHints
+	options.NegativeCacheSize = conf.NegativeCacheSize
+	options.NegativeCacheTTL = time.Duration(conf.NegativeCacheTTL)
+	options.CompressValues = conf.CompressValues
+	options.AllowSameTimeChanges = conf.AllowSameTimeChanges
+	options.NickQuarantine = time.Duration(conf.NickQuarantine)
+	options.MaxVersions = conf.MaxStoredVersions
+	options.ReservedNicks = reservedNicks
+	return options, nil
+}
+
 func runRun(c guinea.Context) error {
 	conf, err := config.Load(c.Arguments[0])
 	if err != nil {
 		return err
 	}
 
-	repository, err := data.NewBoltRepository(conf.DatabasePath)
+	options, err := repositoryOptions(conf)
 	if err != nil {
 		return err
 	}
 
-	return server.Serve(repository, conf.ServeAddress)
+	// The server shuts down gracefully so that the writes in progress
+	// aren't interrupted
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch conf.Mode {
+	case config.ModePrimary, "":
+		repository, err := data.NewRepository(conf, options)
+		if err != nil {
+			return err
+		}
+		if conf.SeedRecords != "" {
+			if err := seedRepository(repository, conf.SeedRecords); err != nil {
+				return errors.Wrap(err, "seeding failed")
+			}
+		}
+		return server.Serve(ctx, repository, conf, func() error {
+			return checkConsistency(repository, conf)
+		})
+	case config.ModeFollower:
+		if conf.FollowerReloadInterval <= 0 {
+			return fmt.Errorf("follower reload interval must be positive")
+		}
+		repository, err := data.NewFollower(conf.FollowerSourcePath, conf.DatabasePath, options)
+		if err != nil {
+			return err
+		}
+		go repository.Run(ctx, time.Duration(conf.FollowerReloadInterval))
+		return server.Serve(ctx, repository, conf, func() error {
+			return checkConsistency(repository, conf)
+		})
+	default:
+		return fmt.Errorf("unknown mode: %s", conf.Mode)
+	}
+}
+
+// consistencyRepository is implemented by the repositories which can verify
+// that their nick index agrees with the nick data.
+type consistencyRepository interface {
+	VerifyConsistency() ([]data.Discrepancy, error)
+}
+
+// repairingRepository is implemented by the repositories which can fix the
+// discrepancies between their nick index and the nick data.
+type repairingRepository interface {
+	RepairConsistency() ([]data.Discrepancy, error)
+}
+
+func checkConsistency(repository interface{}, conf *config.Config) error {
+	if !conf.CheckConsistency {
+		return nil
+	}
+
+	var discrepancies []data.Discrepancy
+	var err error
+	repaired := false
+	if r, ok := repository.(repairingRepository); ok && conf.RepairConsistency && conf.Mode != config.ModeFollower {
+		discrepancies, err = r.RepairConsistency()
+		repaired = true
+	} else if r, ok := repository.(consistencyRepository); ok {
+		discrepancies, err = r.VerifyConsistency()
+	} else {
+		return errors.New("consistency check is not supported by the repository")
+	}
+	if err != nil {
+		return errors.Wrap(err, "consistency check failed")
+	}
+
+	for _, discrepancy := range discrepancies {
+		log.Warn("discrepancy found", "kind", discrepancy.Kind, "nick", discrepancy.Nick, "id", discrepancy.Id, "repaired", repaired)
+	}
+	log.Info("consistency check finished", "discrepancies", len(discrepancies))
+	return nil
 }
@@ -0,0 +1,73 @@
+package commands
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+
+	"github.com/boreq/starlight-nick-server/data"
+	"github.com/pkg/errors"
+)
+
+// seedableRepository is implemented by the repositories which can store nick
+// data with reserved nicks.
+type seedableRepository interface {
+	PutWithOptions(nickData *data.NickData, putOptions data.PutOptions) (data.PutResult, error)
+}
+
+// seedRepository stores the nick data read from the file containing one JSON
+// encoded nick data per line. Seeding is idempotent: identical nick data is
+// stored again without changes and nick data older than the stored one is
+// skipped. The seed records can claim the reserved nicks as they are chosen by
+// the operator. Nick data with nicks which are taken by other nodes or
+// quarantined is skipped with a warning. Malformed or invalid nick data causes
+// an error.
+func seedRepository(repository data.Repository, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "could not open the seed file")
+	}
+	defer f.Close()
+
+	seeded := 0
+	line := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		nickData := &data.NickData{}
+		if err := json.Unmarshal(scanner.Bytes(), nickData); err != nil {
+			return errors.Wrapf(err, "could not decode the seed record in line %d", line)
+		}
+
+		switch err := errors.Cause(seedPut(repository, nickData)); err {
+		case nil:
+			seeded++
+		case data.NewerNickDataPresentErr:
+			log.Debug("newer nick data present, skipping the seed record", "line", line, "nick", nickData.Nick)
+		case data.NickConflictErr, data.NickQuarantinedErr, data.ReservedNickErr:
+			log.Warn("nick is not available, skipping the seed record", "line", line, "nick", nickData.Nick, "err", err)
+		default:
+			return errors.Wrapf(err, "could not store the seed record in line %d", line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "could not read the seed file")
+	}
+
+	log.Info("seeded the repository", "records", seeded)
+	return nil
+}
+
+// seedPut stores the seed record permitting reserved nicks if the repository
+// supports it.
+func seedPut(repository data.Repository, nickData *data.NickData) error {
+	if seedable, ok := repository.(seedableRepository); ok {
+		_, err := seedable.PutWithOptions(nickData, data.PutOptions{AllowReserved: true})
+		return err
+	}
+	return repository.Put(nickData)
+}
@@ -0,0 +1,140 @@
+package commands
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boreq/starlight-nick-server/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeedRepository(t *testing.T) {
+	// given
+	dir, err := ioutil.TempDir("", "nick_server_seed_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var lines []string
+	var nickDatas []*data.NickData
+	for _, nick := range []string{"official", "support"} {
+		iden, err := generateIdentity()
+		require.NoError(t, err)
+
+		nickData, err := signNickData(iden, nick, time.Date(1990, 1, 1, 1, 1, 1, 0, time.UTC))
+		require.NoError(t, err)
+		nickDatas = append(nickDatas, nickData)
+
+		j, err := json.Marshal(nickData)
+		require.NoError(t, err)
+		lines = append(lines, string(j))
+	}
+
+	seedPath := filepath.Join(dir, "seed.ndjson")
+	require.NoError(t, ioutil.WriteFile(seedPath, []byte(strings.Join(lines, "\n")+"\n"), 0600))
+
+	databasePath := filepath.Join(dir, "database.bolt")
+
+	// when
+	for i := 0; i < 2; i++ {
+		repository, err := data.NewBoltRepository(databasePath, data.DefaultOptions())
+		require.NoError(t, err)
+
+		err = seedRepository(repository, seedPath)
+		require.NoError(t, err, "seeding should succeed on startup %d", i)
+
+		require.NoError(t, repository.Close())
+	}
+
+	// then
+	repository, err := data.NewBoltRepository(databasePath, data.DefaultOptions())
+	require.NoError(t, err)
+	defer repository.Close()
+
+	for _, nickData := range nickDatas {
+		result, err := repository.GetByNick(nickData.Nick)
+		require.NoError(t, err)
+		require.NotNil(t, result, "nick %s should be seeded", nickData.Nick)
+		require.Equal(t, nickData.Id, result.Id)
+	}
+}
+
+func TestSeedRepositoryInvalid(t *testing.T) {
+	// given
+	dir, err := ioutil.TempDir("", "nick_server_seed_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	iden, err := generateIdentity()
+	require.NoError(t, err)
+
+	nickData, err := signNickData(iden, "official", time.Now())
+	require.NoError(t, err)
+	nickData.Nick = "tampered"
+
+	j, err := json.Marshal(nickData)
+	require.NoError(t, err)
+
+	seedPath := filepath.Join(dir, "seed.ndjson")
+	require.NoError(t, ioutil.WriteFile(seedPath, j, 0600))
+
+	repository, err := data.NewBoltRepository(filepath.Join(dir, "database.bolt"), data.DefaultOptions())
+	require.NoError(t, err)
+	defer repository.Close()
+
+	// when
+	err = seedRepository(repository, seedPath)
+
+	// then
+	require.Error(t, err, "invalid seed records should fail the startup")
+	require.Contains(t, err.Error(), "line 1")
+}
+
+func TestSeedRepositoryReservedNick(t *testing.T) {
+	// given
+	dir, err := ioutil.TempDir("", "nick_server_seed_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	iden, err := generateIdentity()
+	require.NoError(t, err)
+
+	base := time.Date(1990, 1, 1, 1, 1, 1, 0, time.UTC)
+	nickData, err := signNickData(iden, "official", base)
+	require.NoError(t, err)
+
+	j, err := json.Marshal(nickData)
+	require.NoError(t, err)
+
+	seedPath := filepath.Join(dir, "seed.ndjson")
+	require.NoError(t, ioutil.WriteFile(seedPath, j, 0600))
+
+	reserved, err := data.NewReservedNicks([]string{"official"})
+	require.NoError(t, err)
+
+	options := data.DefaultOptions()
+	options.ReservedNicks = reserved
+
+	repository, err := data.NewBoltRepository(filepath.Join(dir, "database.bolt"), options)
+	require.NoError(t, err)
+	defer repository.Close()
+
+	// when
+	err = seedRepository(repository, seedPath)
+
+	// then
+	require.NoError(t, err)
+
+	result, err := repository.GetByNick("official")
+	require.NoError(t, err)
+	require.NotNil(t, result, "reserved nick should be seeded")
+	require.Equal(t, iden.Id, result.Id)
+
+	updated, err := signNickData(iden, "official", base.Add(time.Minute))
+	require.NoError(t, err)
+	require.NoError(t, repository.Put(updated), "seeded node should be able to update its nick data")
+}
@@ -4,11 +4,344 @@ package config
 import (
 	"encoding/json"
 	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Modes in which the server can run.
+const (
+	// ModePrimary serves reads and writes using a local database.
+	ModePrimary = "primary"
+
+	// ModeFollower serves reads using a read-only copy of a database
+	// which is periodically reloaded from FollowerSourcePath. Writes are
+	// rejected.
+	ModeFollower = "follower"
+)
+
+// Storage engines which can be used to store the data.
+const (
+	StorageEngineBolt   = "bolt"
+	StorageEngineSQLite = "sqlite"
+	StorageEngineMemory = "memory"
 )
 
 type Config struct {
 	ServeAddress string
 	DatabasePath string
+
+	// EnableH2C enables HTTP/2 over cleartext connections in addition to
+	// HTTP/1.1.
+	EnableH2C bool
+
+	// TLSCertPath and TLSKeyPath point to the certificate and the key used
+	// by the public listener. The public listener uses plaintext if they
+	// are empty. Plaintext requests sent to the TLS listener are rejected.
+	TLSCertPath string
+	TLSKeyPath  string
+
+	// TLSRedirectAddress is the address of a plaintext listener which
+	// redirects all requests to the TLS listener. It is only used if TLS
+	// is enabled and is disabled if it is empty.
+	TLSRedirectAddress string
+
+	// GRPCAddress is the address of the listener serving the gRPC API
+	// defined in server/nickserver.proto. The gRPC listener is plaintext
+	// and is disabled if the address is empty.
+	GRPCAddress string
+
+	// StorageEngine is one of: bolt, sqlite, memory. Default: bolt. The
+	// memory engine ignores the database path and loses all data once the
+	// server exits.
+	StorageEngine string
+
+	// SecondaryStorageEngine and SecondaryDatabasePath specify a second
+	// database which receives a copy of all writes while the reads are
+	// served from the first one. This is used to migrate between storage
+	// engines without downtime. The writes aren't duplicated if the path is
+	// empty. Optional features of the first database, eg. the nick
+	// history, aren't available while the writes are duplicated.
+	SecondaryStorageEngine string
+	SecondaryDatabasePath  string
+
+	// Mode is one of: primary, follower. Default: primary.
+	Mode string
+
+	// FollowerSourcePath points to the database from which a follower
+	// reloads its copy, eg. a backup created by the primary. The copy is
+	// stored at DatabasePath.
+	FollowerSourcePath string
+
+	// FollowerReloadInterval specifies how often a follower reloads its
+	// copy of the database.
+	FollowerReloadInterval Duration
+
+	// ShutdownTimeout is the time given to the requests being processed to
+	// finish after the server receives SIGINT or SIGTERM. Default: 10s.
+	ShutdownTimeout Duration
+
+	// MaxConcurrentVerifications is the max number of writes, each
+	// requiring a signature verification, processed concurrently. The
+	// default of 8 is used if it is zero.
+	MaxConcurrentVerifications int
+
+	// MaxQueuedVerifications is the max number of writes waiting for
+	// verification. Further writes are rejected.
+	MaxQueuedVerifications int
+
+	// MaxConcurrentPuts is the max number of PUT requests processed
+	// concurrently. Further PUT requests are immediately rejected so that
+	// the write load doesn't affect the reads. The number of PUT requests
+	// isn't limited if it is zero.
+	MaxConcurrentPuts int
+
+	// MaxBodyBytes is the max size of the body of a PUT request. Larger
+	// requests fail with Request Entity Too Large. Default: 64KiB which is
+	// many times the size of nick data signed with a 4096-bit RSA key.
+	MaxBodyBytes int64
+
+	// AsyncWriteQueueSize is the max number of writes accepted using
+	// "?async=true" waiting to be stored. Asynchronous writes are disabled
+	// if it is zero.
+	AsyncWriteQueueSize int
+
+	// LatencyBudget is the target response time of all routes. The
+	// requests served within and above the budget are counted separately
+	// so that the fraction of requests meeting the target can be computed.
+	// The requests aren't counted if it is zero.
+	LatencyBudget Duration
+
+	// RouteLatencyBudgets overrides LatencyBudget for specific routes. The
+	// routes are identified by the method and the path pattern, eg.
+	// "GET /nicks/:id".
+	RouteLatencyBudgets map[string]Duration
+
+	// RequireChallenge requires the clients to sign a challenge issued by
+	// this server at GET /challenge together with the nick data. This
+	// prevents the nick data submitted to other servers from being
+	// replayed to this server.
+	RequireChallenge bool
+
+	// ChallengeTTL specifies for how long the issued challenges are valid.
+	ChallengeTTL Duration
+
+	// ChallengeSecret is used to authenticate the issued challenges. It
+	// should be shared by all instances of the same server. A random
+	// secret is generated on startup if it is empty.
+	ChallengeSecret string
+
+	// DisableList disables listing all nicks at GET /nicks, searching them
+	// and streaming the nick index at GET /index.
+	DisableList bool
+
+	// DisableMetrics disables exposing the metrics at GET /metrics.
+	DisableMetrics bool
+
+	// WriteAllowlist and WriteDenylist restrict the addresses of the
+	// clients which can change the nick data. The lists contain addresses
+	// or CIDR ranges, eg. "10.0.0.0/8". If the allowlist isn't empty only
+	// the clients on it can write. The clients on the denylist can't
+	// write even if they are on the allowlist. Rejected writes fail with
+	// Forbidden. The reads aren't restricted.
+	WriteAllowlist []string
+	WriteDenylist  []string
+
+	// TrustedProxies lists the addresses or CIDR ranges of the reverse
+	// proxies whose X-Forwarded-For headers are used to determine the
+	// addresses of the clients. The header is ignored if the request
+	// doesn't come from a trusted proxy.
+	TrustedProxies []string
+
+	// PutRateLimit is the number of writes per second which a single client
+	// address can sustain and PutRateBurst is the number of writes which it
+	// can perform at once. Further writes fail with Too Many Requests. The
+	// limits of at most RateLimitedClients most recently seen addresses
+	// are tracked. Writes aren't limited if the rate is zero.
+	PutRateLimit       float64
+	PutRateBurst       int
+	RateLimitedClients int
+
+	// UniformErrors replaces the errors which could be used to enumerate
+	// the stored data with generic ones: all rejected reads return Not
+	// Found and all rejected writes return Bad Request without explaining
+	// the reason. This makes debugging the clients harder.
+	UniformErrors bool
+
+	// UniformWriteDuration is the min duration of the writes if
+	// UniformErrors is enabled so that the reason for which a write was
+	// rejected can't be determined from the response time. The writes
+	// aren't delayed if it is zero.
+	UniformWriteDuration Duration
+
+	// MaxServedAge marks the nick data returned at GET /nicks/:id as stale
+	// if its time is older than this. The stale nick data is kept in the
+	// database. Nick data is never stale if it is zero.
+	MaxServedAge Duration
+
+	// WithholdStale responds with Not Found instead of returning the nick
+	// data marked as stale.
+	WithholdStale bool
+
+	// MaxBatchSize is the max number of elements accepted by the routes
+	// which accept lists, eg. POST /resolve. The default limit of 100 is
+	// used if it is zero.
+	MaxBatchSize int
+
+	// StrictQueryParams rejects requests containing query parameters which
+	// aren't supported by the route so that typos in the names of the
+	// parameters don't go unnoticed. Otherwise they are ignored.
+	StrictQueryParams bool
+
+	// Maintenance configures the periodic background tasks.
+	Maintenance MaintenanceConfig
+
+	// NegativeCacheSize is the max number of recently not found node ids
+	// remembered for NegativeCacheTTL so that repeated lookups of them are
+	// served from memory. The ids are forgotten once they are stored. The
+	// cache is disabled if the size is zero.
+	NegativeCacheSize int
+	NegativeCacheTTL  Duration
+
+	// CompressValues compresses the values stored in the database if that
+	// makes them smaller. Typical values don't compress well so this
+	// mostly costs CPU time. The values written before changing this
+	// option remain readable.
+	CompressValues bool
+
+	// CircuitBreakerThreshold is the number of consecutive requests failing
+	// due to repository errors after which the requests accessing the
+	// repository are rejected with Service Unavailable for
+	// CircuitBreakerCooldown. Afterwards a single request is let through
+	// to check if the repository recovered. The circuit breaker is
+	// disabled if the threshold is zero.
+	CircuitBreakerThreshold int
+	CircuitBreakerCooldown  Duration
+
+	// SeedRecords points to a file containing one JSON encoded nick data
+	// per line which is stored on startup, eg. to register the nicks of the
+	// official nodes. The records can claim the reserved nicks. Nick data
+	// older than the stored one is skipped. The server fails to start if
+	// the file contains invalid nick data. It is ignored in the follower
+	// mode.
+	SeedRecords string
+
+	// CheckConsistency verifies on startup that the nick index agrees with
+	// the stored nick data and logs the discrepancies.
+	CheckConsistency bool
+
+	// RepairConsistency fixes the discrepancies found by the consistency
+	// check by updating the nick index. It is ignored if CheckConsistency
+	// is disabled or in the follower mode.
+	RepairConsistency bool
+
+	// AllowSameTimeChanges accepts changes to the nick data which don't
+	// increase its time by at least one second. By default they are
+	// rejected so that nick data with the same time can't overwrite the
+	// stored nick data with a different nick.
+	AllowSameTimeChanges bool
+
+	// NickQuarantine is the period after a nick is released, because its
+	// owner changed it or was deleted, during which no one can claim it.
+	// This prevents sniping popular nicks as soon as they are released.
+	// The quarantine is disabled if it is zero.
+	NickQuarantine Duration
+
+	// MaxStoredVersions is the number of prior versions of the nick data of
+	// each node which are stored and served under /nicks/:id/versions so
+	// that the clients can verify the chain of changes. Each version takes
+	// as much space as the nick data itself. The versions aren't stored if
+	// it is zero.
+	MaxStoredVersions int
+
+	// MaxClockSkew is the max amount of time by which the time of the
+	// submitted nick data can be ahead of the clock of the server. Nick
+	// data from further in the future is rejected as otherwise it could
+	// never be replaced. The default of five minutes is used if it is
+	// zero.
+	MaxClockSkew Duration
+
+	// SignatureHints explains in the error messages that invalid
+	// signatures were created using a hash commonly used by mistake. It
+	// costs up to three additional signature verifications for every
+	// invalid signature so it should only be enabled while debugging the
+	// clients.
+	SignatureHints bool
+
+	// StrictNickSeparators disallows nicks containing consecutive or
+	// trailing separator characters.
+	StrictNickSeparators bool
+
+	// NickRegexp overrides the regular expression which nicks have to
+	// match. The default expression is used if it is empty.
+	NickRegexp string
+
+	// MinNickLength and MaxNickLength limit the length of the nicks in
+	// bytes. The defaults of 3 and 20 are used if they are zero. The
+	// limits are checked in addition to NickRegexp.
+	MinNickLength int
+	MaxNickLength int
+
+	// ReservedNicks lists the nicks which can't be registered, eg. "admin"
+	// or trademarked names. The nicks are compared case-insensitively. An
+	// entry ending with an asterisk reserves all nicks starting with the
+	// rest of the entry, eg. "admin*" also reserves "administrator".
+	// The nodes which already hold a reserved nick, eg. because they
+	// registered it before it was reserved or received it from
+	// SeedRecords, can keep updating their nick data.
+	ReservedNicks []string
+
+	// RobotsTxt is served at /robots.txt. By default crawling all routes
+	// is disallowed.
+	RobotsTxt string
+
+	// QuietScannerNotFound logs the requests for unknown routes which look
+	// like they were sent by vulnerability scanners at the debug level
+	// instead of the info level.
+	QuietScannerNotFound bool
+
+	// AdminServeAddress is the address of the listener serving the admin
+	// routes. The admin listener is disabled if it is empty.
+	AdminServeAddress string
+
+	// AdminTLSCertPath and AdminTLSKeyPath point to the certificate and
+	// the key used by the admin listener. The admin listener uses
+	// plaintext if they are empty.
+	AdminTLSCertPath string
+	AdminTLSKeyPath  string
+
+	// AdminClientCAPath points to the CA certificates used to verify the
+	// client certificates. If it is set the clients of the admin listener
+	// have to present a valid client certificate.
+	AdminClientCAPath string
+
+	nickRegexp       *regexp.Regexp
+	nickRegexpSource string
+}
+
+// Maintenance tasks which can be scheduled.
+const (
+	// MaintenanceTaskBackup copies the database to BackupPath.
+	MaintenanceTaskBackup = "backup"
+
+	// MaintenanceTaskIntegrity checks that the nick index agrees with the
+	// stored nick data and reports the discrepancies in the logs and the
+	// metrics without repairing them.
+	MaintenanceTaskIntegrity = "integrity"
+)
+
+type MaintenanceConfig struct {
+	// Tasks maps the names of the tasks which should run to the intervals
+	// in which they run, eg. {"backup": "1h"}.
+	Tasks map[string]Duration
+
+	// BackupPath is the path at which the backup task stores the copy of
+	// the database.
+	BackupPath string
 }
 
 // Default returns the default config.
@@ -16,20 +349,281 @@ func Default() *Config {
 	conf := &Config{
 		ServeAddress: "127.0.0.1:8118",
 		DatabasePath: "path/to/database.bolt",
+
+		EnableH2C: false,
+
+		TLSCertPath:        "",
+		TLSKeyPath:         "",
+		TLSRedirectAddress: "",
+
+		GRPCAddress: "",
+
+		StorageEngine: StorageEngineBolt,
+
+		SecondaryStorageEngine: StorageEngineBolt,
+		SecondaryDatabasePath:  "",
+
+		Mode:                   ModePrimary,
+		FollowerSourcePath:     "",
+		FollowerReloadInterval: Duration(5 * time.Minute),
+
+		ShutdownTimeout: Duration(10 * time.Second),
+
+		MaxConcurrentVerifications: 8,
+		MaxQueuedVerifications:     100,
+
+		MaxConcurrentPuts: 16,
+
+		MaxBodyBytes: 64 * 1024,
+
+		AsyncWriteQueueSize: 100,
+
+		LatencyBudget:       Duration(500 * time.Millisecond),
+		RouteLatencyBudgets: make(map[string]Duration),
+
+		RequireChallenge: false,
+		ChallengeTTL:     Duration(5 * time.Minute),
+		ChallengeSecret:  "",
+
+		DisableList:    false,
+		DisableMetrics: false,
+
+		WriteAllowlist: nil,
+		WriteDenylist:  nil,
+		TrustedProxies: nil,
+
+		PutRateLimit:       1,
+		PutRateBurst:       10,
+		RateLimitedClients: 10000,
+
+		UniformErrors:        false,
+		UniformWriteDuration: 0,
+
+		MaxServedAge:  0,
+		WithholdStale: false,
+
+		MaxBatchSize: 100,
+
+		StrictQueryParams: false,
+
+		Maintenance: MaintenanceConfig{
+			Tasks:      make(map[string]Duration),
+			BackupPath: "",
+		},
+
+		NegativeCacheSize: 0,
+		NegativeCacheTTL:  Duration(10 * time.Second),
+
+		CompressValues: false,
+
+		CircuitBreakerThreshold: 0,
+		CircuitBreakerCooldown:  Duration(10 * time.Second),
+
+		SeedRecords: "",
+
+		CheckConsistency:  false,
+		RepairConsistency: false,
+
+		AllowSameTimeChanges: false,
+		NickQuarantine:       0,
+		MaxStoredVersions:    0,
+
+		MaxClockSkew:   Duration(5 * time.Minute),
+		SignatureHints: false,
+
+		StrictNickSeparators: false,
+		NickRegexp:           "",
+		MinNickLength:        3,
+		MaxNickLength:        20,
+		ReservedNicks:        nil,
+
+		RobotsTxt:            "",
+		QuietScannerNotFound: false,
+
+		AdminServeAddress: "",
+		AdminTLSCertPath:  "",
+		AdminTLSKeyPath:   "",
+		AdminClientCAPath: "",
 	}
 	return conf
 }
 
-// Load loads the specified config file.
+// Load loads the specified config file. The fields missing from the file are
+// set to the values returned by Default.
 func Load(path string) (*Config, error) {
 	content, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 
-	conf := &Config{}
+	conf := Default()
 	if err := json.Unmarshal(content, conf); err != nil {
 		return nil, err
 	}
+	if err := conf.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid config")
+	}
 	return conf, nil
 }
+
+// Validate checks the parts of the config which would otherwise fail only
+// once they are used. In addition to the checks performed by ValidateSettings
+// it confirms that the databases can be created in their directories.
+func (c *Config) Validate() error {
+	if err := c.ValidateSettings(); err != nil {
+		return err
+	}
+	if err := validateDatabasePath(c.StorageEngine, c.DatabasePath); err != nil {
+		return errors.Wrap(err, "invalid database path")
+	}
+	if c.SecondaryDatabasePath != "" {
+		if err := validateDatabasePath(c.SecondaryStorageEngine, c.SecondaryDatabasePath); err != nil {
+			return errors.Wrap(err, "invalid secondary database path")
+		}
+	}
+	return nil
+}
+
+// ValidateSettings checks that the values are valid and consistent with each
+// other without accessing the file system.
+func (c *Config) ValidateSettings() error {
+	if _, err := c.CompiledNickRegexp(); err != nil {
+		return err
+	}
+	if c.MinNickLength < 0 {
+		return errors.New("min nick length can't be negative")
+	}
+	if c.MaxNickLength < 0 {
+		return errors.New("max nick length can't be negative")
+	}
+	if c.MinNickLength > 0 && c.MaxNickLength > 0 && c.MinNickLength > c.MaxNickLength {
+		return errors.New("min nick length can't be greater than max nick length")
+	}
+
+	if c.ServeAddress == "" {
+		return errors.New("serve address is required")
+	}
+	if err := validateAddress(c.ServeAddress); err != nil {
+		return errors.Wrapf(err, "invalid serve address %q", c.ServeAddress)
+	}
+	if c.AdminServeAddress != "" {
+		if err := validateAddress(c.AdminServeAddress); err != nil {
+			return errors.Wrapf(err, "invalid admin serve address %q", c.AdminServeAddress)
+		}
+	}
+	if c.GRPCAddress != "" {
+		if err := validateAddress(c.GRPCAddress); err != nil {
+			return errors.Wrapf(err, "invalid grpc address %q", c.GRPCAddress)
+		}
+	}
+
+	if (c.TLSCertPath == "") != (c.TLSKeyPath == "") {
+		return errors.New("tls requires both the certificate and the key")
+	}
+	if c.TLSRedirectAddress != "" {
+		if c.TLSCertPath == "" {
+			return errors.New("tls redirect address requires tls to be enabled")
+		}
+		if err := validateAddress(c.TLSRedirectAddress); err != nil {
+			return errors.Wrapf(err, "invalid tls redirect address %q", c.TLSRedirectAddress)
+		}
+		if c.TLSRedirectAddress == c.ServeAddress {
+			return errors.New("tls redirect address must differ from the serve address")
+		}
+	}
+	if (c.AdminTLSCertPath == "") != (c.AdminTLSKeyPath == "") {
+		return errors.New("admin tls requires both the certificate and the key")
+	}
+
+	if c.PutRateLimit < 0 {
+		return errors.New("put rate limit can't be negative")
+	}
+	if c.PutRateLimit > 0 && c.PutRateBurst < 1 {
+		return errors.New("put rate burst must be at least 1 if the put rate limit is set")
+	}
+	if c.RateLimitedClients < 0 {
+		return errors.New("rate limited clients can't be negative")
+	}
+	if c.MaxBodyBytes < 0 {
+		return errors.New("max body bytes can't be negative")
+	}
+	return nil
+}
+
+// validateAddress confirms that the address consists of an optional host and
+// a port.
+func validateAddress(address string) error {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if _, err := net.LookupPort("tcp", port); err != nil {
+		return err
+	}
+	return nil
+}
+
+// validateDatabasePath confirms that the database can be created at the path
+// if the storage engine stores the data in a file.
+func validateDatabasePath(engine, path string) error {
+	if engine == StorageEngineMemory {
+		return nil
+	}
+	if path == "" {
+		return errors.New("database path is required")
+	}
+
+	dir := filepath.Dir(path)
+	info, err := os.Stat(dir)
+	if err != nil {
+		return errors.Wrapf(err, "directory %q can't be accessed", dir)
+	}
+	if !info.IsDir() {
+		return errors.Errorf("%q is not a directory", dir)
+	}
+
+	f, err := ioutil.TempFile(dir, ".write-test")
+	if err != nil {
+		return errors.Wrapf(err, "directory %q is not writable", dir)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// CompiledNickRegexp returns the compiled NickRegexp or nil if it is empty.
+// The compiled expression is cached.
+func (c *Config) CompiledNickRegexp() (*regexp.Regexp, error) {
+	if c.NickRegexp == "" {
+		return nil, nil
+	}
+	if c.nickRegexp == nil || c.nickRegexpSource != c.NickRegexp {
+		r, err := regexp.Compile(c.NickRegexp)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid nick regexp %q", c.NickRegexp)
+		}
+		c.nickRegexp = r
+		c.nickRegexpSource = c.NickRegexp
+	}
+	return c.nickRegexp, nil
+}
+
+// Duration is a time.Duration represented as a string in the config file,
+// eg. "1m30s".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
@@ -0,0 +1,213 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateDefault(t *testing.T) {
+	require.NoError(t, Default().ValidateSettings())
+}
+
+func TestValidateNickRegexp(t *testing.T) {
+	// given
+	conf := Default()
+	conf.NickRegexp = `^[a-z]+$`
+
+	// when
+	err := conf.ValidateSettings()
+
+	// then
+	require.NoError(t, err)
+	r, err := conf.CompiledNickRegexp()
+	require.NoError(t, err)
+	require.True(t, r.MatchString("nick"))
+}
+
+func TestValidateMalformedNickRegexp(t *testing.T) {
+	// given
+	conf := Default()
+	conf.NickRegexp = `^[a-z+$`
+
+	// when
+	err := conf.ValidateSettings()
+
+	// then
+	require.EqualError(t, err, "invalid nick regexp \"^[a-z+$\": error parsing regexp: missing closing ]: `[a-z+$`")
+}
+
+func TestValidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	testCases := []struct {
+		Name          string
+		Modify        func(conf *Config)
+		ExpectedError string
+	}{
+		{
+			Name:   "valid",
+			Modify: func(conf *Config) {},
+		},
+		{
+			Name: "missing_address",
+			Modify: func(conf *Config) {
+				conf.ServeAddress = ""
+			},
+			ExpectedError: "serve address is required",
+		},
+		{
+			Name: "address_without_port",
+			Modify: func(conf *Config) {
+				conf.ServeAddress = "127.0.0.1"
+			},
+			ExpectedError: `invalid serve address "127.0.0.1"`,
+		},
+		{
+			Name: "address_with_invalid_port",
+			Modify: func(conf *Config) {
+				conf.ServeAddress = "127.0.0.1:99999"
+			},
+			ExpectedError: `invalid serve address "127.0.0.1:99999"`,
+		},
+		{
+			Name: "custom_nick_length",
+			Modify: func(conf *Config) {
+				conf.MinNickLength = 5
+				conf.MaxNickLength = 10
+			},
+		},
+		{
+			Name: "negative_min_nick_length",
+			Modify: func(conf *Config) {
+				conf.MinNickLength = -1
+			},
+			ExpectedError: "min nick length can't be negative",
+		},
+		{
+			Name: "negative_max_nick_length",
+			Modify: func(conf *Config) {
+				conf.MaxNickLength = -1
+			},
+			ExpectedError: "max nick length can't be negative",
+		},
+		{
+			Name: "min_nick_length_greater_than_max",
+			Modify: func(conf *Config) {
+				conf.MinNickLength = 10
+				conf.MaxNickLength = 5
+			},
+			ExpectedError: "min nick length can't be greater than max nick length",
+		},
+		{
+			Name: "missing_database_path",
+			Modify: func(conf *Config) {
+				conf.DatabasePath = ""
+			},
+			ExpectedError: "invalid database path: database path is required",
+		},
+		{
+			Name: "database_path_in_nonexistent_directory",
+			Modify: func(conf *Config) {
+				conf.DatabasePath = filepath.Join(dir, "missing", "database.bolt")
+			},
+			ExpectedError: "invalid database path: directory",
+		},
+		{
+			Name: "memory_engine_without_database_path",
+			Modify: func(conf *Config) {
+				conf.StorageEngine = StorageEngineMemory
+				conf.DatabasePath = ""
+			},
+		},
+		{
+			Name: "tls_certificate_without_key",
+			Modify: func(conf *Config) {
+				conf.TLSCertPath = "server.crt"
+			},
+			ExpectedError: "tls requires both the certificate and the key",
+		},
+		{
+			Name: "tls_redirect_without_tls",
+			Modify: func(conf *Config) {
+				conf.TLSRedirectAddress = ":80"
+			},
+			ExpectedError: "tls redirect address requires tls to be enabled",
+		},
+		{
+			Name: "rate_limit_without_burst",
+			Modify: func(conf *Config) {
+				conf.PutRateLimit = 1
+				conf.PutRateBurst = 0
+			},
+			ExpectedError: "put rate burst must be at least 1 if the put rate limit is set",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			// given
+			conf := Default()
+			conf.DatabasePath = filepath.Join(dir, "database.bolt")
+			testCase.Modify(conf)
+
+			// when
+			err := conf.Validate()
+
+			// then
+			if testCase.ExpectedError == "" {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), testCase.ExpectedError)
+			}
+		})
+	}
+}
+
+func TestLoadAppliesDefaults(t *testing.T) {
+	// given
+	dir, err := ioutil.TempDir("", "config_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.json")
+	content := fmt.Sprintf(`{"DatabasePath": %q}`, filepath.Join(dir, "database.bolt"))
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+
+	// when
+	conf, err := Load(path)
+
+	// then
+	require.NoError(t, err)
+	defaults := Default()
+	require.Equal(t, filepath.Join(dir, "database.bolt"), conf.DatabasePath)
+	require.Equal(t, defaults.PutRateLimit, conf.PutRateLimit)
+	require.Equal(t, defaults.PutRateBurst, conf.PutRateBurst)
+	require.Equal(t, defaults.MaxConcurrentPuts, conf.MaxConcurrentPuts)
+	require.Equal(t, defaults.AsyncWriteQueueSize, conf.AsyncWriteQueueSize)
+	require.Equal(t, defaults.LatencyBudget, conf.LatencyBudget)
+}
+
+func TestLoadValidates(t *testing.T) {
+	// given
+	dir, err := ioutil.TempDir("", "config_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"ServeAddress": "127.0.0.1"}`), 0600))
+
+	// when
+	_, err = Load(path)
+
+	// then
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `invalid config: invalid serve address "127.0.0.1"`)
+}
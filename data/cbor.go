@@ -0,0 +1,94 @@
+package data
+
+import (
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/pkg/errors"
+)
+
+// CBORContentType is the content type of the nick data encoded using CBOR.
+const CBORContentType = "application/cbor"
+
+// CBOREncMode encodes the time as an RFC 3339 string with the nanoseconds so
+// that it is decoded without a loss of precision. It is also used by the API
+// to encode the responses.
+var CBOREncMode = mustCBOREncMode()
+
+func mustCBOREncMode() cbor.EncMode {
+	mode, err := cbor.EncOptions{
+		Time:    cbor.TimeRFC3339Nano,
+		TimeTag: cbor.EncTagRequired,
+	}.EncMode()
+	if err != nil {
+		panic(err)
+	}
+	return mode
+}
+
+// cborNickData is the nick data encoded as a CBOR map. The keys are the same
+// as the keys of the JSON object but the binary fields, including the id, are
+// encoded as byte strings.
+type cborNickData struct {
+	Id          []byte    `cbor:"id"`
+	Nick        string    `cbor:"nick"`
+	Time        time.Time `cbor:"time"`
+	PublicKey   []byte    `cbor:"publicKey,omitempty"`
+	Signature   []byte    `cbor:"signature"`
+	Version     int       `cbor:"version,omitempty"`
+	DisplayName string    `cbor:"displayName,omitempty"`
+	Challenge   []byte    `cbor:"challenge,omitempty"`
+}
+
+// MarshalCBOR encodes the nick data as a CBOR map. The optional fields are
+// omitted if they are empty, the same as in the case of MarshalJSON.
+func (n NickData) MarshalCBOR() ([]byte, error) {
+	return CBOREncMode.Marshal(cborNickData{
+		Id:          n.Id,
+		Nick:        n.Nick,
+		Time:        n.Time,
+		PublicKey:   n.PublicKey,
+		Signature:   n.Signature,
+		Version:     n.Version,
+		DisplayName: n.DisplayName,
+		Challenge:   n.Challenge,
+	})
+}
+
+// UnmarshalCBOR decodes the nick data rejecting binary fields which exceed
+// their max lengths with FieldTooLongErr.
+func (n *NickData) UnmarshalCBOR(b []byte) error {
+	var aux cborNickData
+	if err := cbor.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+
+	fields := []struct {
+		Name   string
+		Value  []byte
+		MaxLen int
+	}{
+		{"id", aux.Id, maxIdLength},
+		{"publicKey", aux.PublicKey, maxPublicKeyLength},
+		{"signature", aux.Signature, maxSignatureLength},
+		{"challenge", aux.Challenge, maxChallengeLength},
+	}
+
+	for _, field := range fields {
+		if len(field.Value) > field.MaxLen {
+			return errors.Wrap(FieldTooLongErr, field.Name)
+		}
+	}
+
+	*n = NickData{
+		Id:          aux.Id,
+		Nick:        aux.Nick,
+		Time:        aux.Time,
+		PublicKey:   aux.PublicKey,
+		Signature:   aux.Signature,
+		Version:     aux.Version,
+		DisplayName: aux.DisplayName,
+		Challenge:   aux.Challenge,
+	}
+	return nil
+}
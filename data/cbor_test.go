@@ -0,0 +1,78 @@
+package data
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCBORRoundTrip(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		NickData NickData
+	}{
+		{
+			Name:     "initial_version",
+			NickData: *makeValidNickData(),
+		},
+		{
+			Name: "all_fields",
+			NickData: NickData{
+				Id:          []byte("id"),
+				Nick:        "nick",
+				Time:        time.Date(1990, 1, 1, 1, 1, 1, 1, time.UTC),
+				PublicKey:   []byte("public key"),
+				Signature:   []byte("signature"),
+				Version:     VersionChallenge,
+				DisplayName: "Nick",
+				Challenge:   []byte("challenge"),
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			b, err := cbor.Marshal(testCase.NickData)
+			require.NoError(t, err)
+
+			var result NickData
+			require.NoError(t, cbor.Unmarshal(b, &result))
+			require.True(t, testCase.NickData.Time.Equal(result.Time), "time should be equal")
+			result.Time = testCase.NickData.Time
+			require.Equal(t, testCase.NickData, result)
+		})
+	}
+}
+
+func TestCBORKeys(t *testing.T) {
+	b, err := cbor.Marshal(makeValidNickData())
+	require.NoError(t, err)
+
+	var result map[string]interface{}
+	require.NoError(t, cbor.Unmarshal(b, &result))
+	require.ElementsMatch(t, []string{"id", "nick", "time", "publicKey", "signature"}, keys(result))
+}
+
+func TestNickDataUnmarshalCBORFieldTooLong(t *testing.T) {
+	nickData := makeValidNickData()
+	nickData.Signature = bytes.Repeat([]byte{1}, maxSignatureLength+1)
+
+	b, err := cbor.Marshal(nickData)
+	require.NoError(t, err)
+
+	var result NickData
+	err = cbor.Unmarshal(b, &result)
+	require.Equal(t, FieldTooLongErr, errors.Cause(err))
+}
+
+func keys(m map[string]interface{}) []string {
+	var rv []string
+	for key := range m {
+		rv = append(rv, key)
+	}
+	return rv
+}
@@ -0,0 +1,221 @@
+package data
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/boltdb/bolt"
+	"github.com/boreq/starlight/network/node"
+	"github.com/pkg/errors"
+)
+
+// Kinds of discrepancies between the nick data and the nick index.
+const (
+	// DiscrepancyOrphanedIndexEntry is an index entry pointing to a node
+	// without nick data.
+	DiscrepancyOrphanedIndexEntry = "orphaned_index_entry"
+
+	// DiscrepancyStaleIndexEntry is an index entry pointing to a node
+	// which holds a different nick.
+	DiscrepancyStaleIndexEntry = "stale_index_entry"
+
+	// DiscrepancyMissingIndexEntry is nick data with a nick which isn't
+	// indexed or which is indexed for a different node.
+	DiscrepancyMissingIndexEntry = "missing_index_entry"
+)
+
+// Discrepancy describes a disagreement between the nick data and the nick
+// index.
+type Discrepancy struct {
+	Kind string
+	Nick string
+	Id   node.ID
+}
+
+// VerifyConsistency checks that the nick index agrees with the stored nick
+// data and returns the found discrepancies. The writes are atomic so no
+// discrepancies are expected, the check guards against bugs and corruption.
+func (r *BoltRepository) VerifyConsistency() ([]Discrepancy, error) {
+	var discrepancies []Discrepancy
+	if err := r.db.View(func(tx *bolt.Tx) error {
+		var err error
+		discrepancies, err = findDiscrepancies(tx)
+		return err
+	}); err != nil {
+		return nil, errors.Wrap(err, "view failed")
+	}
+	return discrepancies, nil
+}
+
+// VerifyConsistencyInChunks works like VerifyConsistency but reads the keys in
+// chunks, each in a separate short read transaction, so that checking a large
+// database never holds a long transaction open. The entries of each chunk are
+// checked within a single transaction so the writes executed in the meantime
+// don't cause false discrepancies. The check is aborted if the context is
+// cancelled.
+func (r *BoltRepository) VerifyConsistencyInChunks(ctx context.Context) ([]Discrepancy, error) {
+	var discrepancies []Discrepancy
+	for _, check := range []struct {
+		bucket string
+		fn     func(tx *bolt.Tx, key []byte) (*Discrepancy, error)
+	}{
+		{nicksBucket, checkIndexEntry},
+		{nickDataBucket, checkNickData},
+	} {
+		var keys [][]byte
+		if err := r.iterateBucket(ctx, check.bucket, func(k, v []byte) error {
+			keys = append(keys, append([]byte(nil), k...))
+			return nil
+		}, func() error {
+			defer func() { keys = keys[:0] }()
+			return r.db.View(func(tx *bolt.Tx) error {
+				for _, key := range keys {
+					discrepancy, err := check.fn(tx, key)
+					if err != nil {
+						return err
+					}
+					if discrepancy != nil {
+						discrepancies = append(discrepancies, *discrepancy)
+					}
+				}
+				return nil
+			})
+		}); err != nil {
+			return nil, errors.Wrapf(err, "could not check the %s bucket", check.bucket)
+		}
+	}
+	return discrepancies, nil
+}
+
+// RepairConsistency fixes the nick index so that it agrees with the stored
+// nick data and returns the found discrepancies. The nick data is
+// never modified as it is signed by the nodes.
+func (r *BoltRepository) RepairConsistency() ([]Discrepancy, error) {
+	if r.options.ReadOnly {
+		return nil, ReadOnlyErr
+	}
+
+	var discrepancies []Discrepancy
+	if err := r.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		discrepancies, err = findDiscrepancies(tx)
+		if err != nil {
+			return err
+		}
+		return repairDiscrepancies(tx, discrepancies)
+	}); err != nil {
+		return nil, errors.Wrap(err, "update failed")
+	}
+	return discrepancies, nil
+}
+
+func findDiscrepancies(tx *bolt.Tx) ([]Discrepancy, error) {
+	var discrepancies []Discrepancy
+
+	if err := tx.Bucket([]byte(nicksBucket)).ForEach(func(k, v []byte) error {
+		discrepancy, err := checkIndexEntry(tx, k)
+		if err != nil {
+			return err
+		}
+		if discrepancy != nil {
+			discrepancies = append(discrepancies, *discrepancy)
+		}
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "could not check the index")
+	}
+
+	if err := tx.Bucket([]byte(nickDataBucket)).ForEach(func(k, v []byte) error {
+		discrepancy, err := checkNickData(tx, k)
+		if err != nil {
+			return err
+		}
+		if discrepancy != nil {
+			discrepancies = append(discrepancies, *discrepancy)
+		}
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "could not check the nick data")
+	}
+
+	return discrepancies, nil
+}
+
+// checkIndexEntry returns a discrepancy if the index entry for the nick
+// doesn't point to the nick data holding that nick. Nil is returned if the
+// entry doesn't exist.
+func checkIndexEntry(tx *bolt.Tx, nick []byte) (*Discrepancy, error) {
+	id := tx.Bucket([]byte(nicksBucket)).Get(nick)
+	if id == nil {
+		return nil, nil
+	}
+	value := tx.Bucket([]byte(nickDataBucket)).Get(id)
+	if value == nil {
+		discrepancy := newDiscrepancy(DiscrepancyOrphanedIndexEntry, nick, id)
+		return &discrepancy, nil
+	}
+	nickData, err := unmarshalNickData(value)
+	if err != nil {
+		return nil, errors.Wrap(err, "unmarshal failed")
+	}
+	if nickData.Nick != string(nick) {
+		discrepancy := newDiscrepancy(DiscrepancyStaleIndexEntry, nick, id)
+		return &discrepancy, nil
+	}
+	return nil, nil
+}
+
+// checkNickData returns a discrepancy if the nick of the nick data isn't
+// indexed for its node. Nil is returned if the nick data doesn't exist.
+func checkNickData(tx *bolt.Tx, id []byte) (*Discrepancy, error) {
+	value := tx.Bucket([]byte(nickDataBucket)).Get(id)
+	if value == nil {
+		return nil, nil
+	}
+	nickData, err := unmarshalNickData(value)
+	if err != nil {
+		return nil, errors.Wrap(err, "unmarshal failed")
+	}
+	if !bytes.Equal(tx.Bucket([]byte(nicksBucket)).Get([]byte(nickData.Nick)), id) {
+		discrepancy := newDiscrepancy(DiscrepancyMissingIndexEntry, []byte(nickData.Nick), id)
+		return &discrepancy, nil
+	}
+	return nil, nil
+}
+
+func repairDiscrepancies(tx *bolt.Tx, discrepancies []Discrepancy) error {
+	nicksB := tx.Bucket([]byte(nicksBucket))
+
+	// The invalid entries are removed first so that the missing entries
+	// can take their place.
+	for _, discrepancy := range discrepancies {
+		if discrepancy.Kind == DiscrepancyOrphanedIndexEntry || discrepancy.Kind == DiscrepancyStaleIndexEntry {
+			if err := nicksB.Delete([]byte(discrepancy.Nick)); err != nil {
+				return errors.Wrap(err, "nicks bucket delete failed")
+			}
+		}
+	}
+
+	for _, discrepancy := range discrepancies {
+		if discrepancy.Kind == DiscrepancyMissingIndexEntry {
+			if nicksB.Get([]byte(discrepancy.Nick)) != nil {
+				// Multiple nodes hold the same nick, the indexed
+				// one keeps it
+				log.Warn("could not repair the index, nick is indexed for another node", "nick", discrepancy.Nick)
+				continue
+			}
+			if err := nicksB.Put([]byte(discrepancy.Nick), discrepancy.Id); err != nil {
+				return errors.Wrap(err, "nicks bucket put failed")
+			}
+		}
+	}
+	return nil
+}
+
+func newDiscrepancy(kind string, nick, id []byte) Discrepancy {
+	return Discrepancy{
+		Kind: kind,
+		Nick: string(nick),
+		Id:   append(node.ID(nil), id...),
+	}
+}
@@ -2,120 +2,578 @@ package data
 
 import (
 	"bytes"
+	"context"
 	"crypto"
+	_ "crypto/sha1"
+	"crypto/sha256"
 	_ "crypto/sha512"
-	"encoding/json"
+	"encoding/binary"
 	"fmt"
+	"os"
 	"regexp"
+	"strings"
+	"sync/atomic"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/boltdb/bolt"
+	"github.com/boreq/starlight-nick-server/logging"
 	scrypto "github.com/boreq/starlight/crypto"
 	"github.com/boreq/starlight/network/node"
 	"github.com/pkg/errors"
 )
 
+var log = logging.New("data")
+
 // SigningHash specifies the hash used for generating the signature.
 const SigningHash = crypto.SHA512
 
-// minNickLength specifies the min length of a nick.
-const minNickLength = 3
+// DefaultMinNickLength is used if MinLength of the nick policy isn't set.
+const DefaultMinNickLength = 3
 
-// maxNickLength specifies the max length of a nick.
-const maxNickLength = 20
+// DefaultMaxNickLength is used if MaxLength of the nick policy isn't set.
+const DefaultMaxNickLength = 20
 
 // nickRegexp is used to validate nicks.
 var nickRegexp = regexp.MustCompile(`^[a-zA-Z]{1}[a-zA-Z0-9\_\-\[\]]+$`)
 
+// maxDisplayNameLength specifies the max length of a display name in
+// characters.
+const maxDisplayNameLength = 64
+
+// Versions of the format of the signed data.
+const (
+	// VersionInitial signs the time, id and nick.
+	VersionInitial = 0
+
+	// VersionDisplayName additionally signs the display name.
+	VersionDisplayName = 1
+
+	// VersionChallenge additionally signs a challenge issued by the
+	// server which binds the nick data to that server.
+	VersionChallenge = 2
+
+	// latestVersion is the latest supported version.
+	latestVersion = VersionChallenge
+)
+
+// maxChallengeLength specifies the max length of a challenge in bytes.
+const maxChallengeLength = 64
+
 // NickData represents an intent to set a nickname.
 type NickData struct {
-	Id        node.ID   `json:"id"`
-	Nick      string    `json:"nick"`
-	Time      time.Time `json:"time"`
-	PublicKey []byte    `json:"publicKey"`
-	Signature []byte    `json:"signature"`
+	Id          node.ID   `json:"id"`
+	Nick        string    `json:"nick"`
+	Time        time.Time `json:"time"`
+	PublicKey   []byte    `json:"publicKey"`
+	Signature   []byte    `json:"signature"`
+	Version     int       `json:"version,omitempty"`
+	DisplayName string    `json:"displayName,omitempty"`
+	Challenge   []byte    `json:"challenge,omitempty"`
 }
 
 // GetDataToSign returns the data which should be signed to produce the
-// signature.
+// signature. The fields included in the data depend on the version.
 func (n NickData) GetDataToSign() []byte {
 	buf := &bytes.Buffer{}
 	buf.WriteString(fmt.Sprintf("%d", n.Time.Unix()))
 	buf.Write(n.Id)
 	buf.WriteString(n.Nick)
+	if n.Version >= VersionDisplayName {
+		buf.WriteByte(0)
+		buf.WriteString(fmt.Sprintf("%d", n.Version))
+		buf.WriteByte(0)
+		buf.WriteString(n.DisplayName)
+	}
+	if n.Version >= VersionChallenge {
+		buf.WriteByte(0)
+		buf.Write(n.Challenge)
+	}
 	return buf.Bytes()
 }
 
-// Validate checks if this struct is filled correctly.
+// ContentHash returns a hash identifying the content of this nick data. It is
+// computed over the signing data only so that it doesn't depend on the
+// signature. This prevents a malleable signature from changing the identity of
+// the content and makes the hash suitable for ETags.
+func (n NickData) ContentHash() []byte {
+	h := sha256.Sum256(n.GetDataToSign())
+	return h[:]
+}
+
+// Validate checks if this struct is filled correctly using the default nick
+// policy.
 func (n NickData) Validate() error {
+	return n.ValidateWithPolicy(DefaultNickPolicy())
+}
+
+// ValidateWithClock checks if this struct is filled correctly using the
+// default nick policy. The time is compared with the provided current time.
+func (n NickData) ValidateWithClock(now time.Time) error {
+	return n.validate(DefaultNickPolicy(), now)
+}
+
+// ValidateWithPolicy checks if this struct is filled correctly using the
+// provided nick policy. The returned errors are of type *ValidationError.
+func (n NickData) ValidateWithPolicy(policy NickPolicy) error {
+	return n.validate(policy, time.Now())
+}
+
+func (n NickData) validate(policy NickPolicy, now time.Time) error {
 	// Public key
+	if len(n.PublicKey) == 0 {
+		return newValidationError(ReasonPublicKey, errors.New("could not read the public key: public key is empty"))
+	}
 	publicKey, err := scrypto.NewPublicKey(n.PublicKey)
 	if err != nil {
-		return errors.Wrap(err, "could not read the public key")
+		return newValidationError(ReasonPublicKey, errors.Wrap(err, "could not read the public key"))
 	}
 
 	// Id
 	if !node.ValidateId(n.Id) {
-		return errors.New("id is invalid")
+		return newValidationError(ReasonId, errors.New("id is invalid"))
 	}
 	id, err := publicKey.Hash()
 	if err != nil {
-		return errors.Wrap(err, "could not hash the public key")
+		return newValidationError(ReasonId, errors.Wrap(err, "could not hash the public key"))
 	}
 	if !node.CompareId(id, n.Id) {
-		return errors.New("id does not match the public key")
+		return newValidationError(ReasonId, errors.New("id does not match the public key"))
 	}
 
 	// Nick
-	if err := ValidateNick(n.Nick); err != nil {
-		return errors.Wrap(err, "invalid nick")
+	if err := policy.ValidateNick(n.Nick); err != nil {
+		return newValidationError(ReasonNick, errors.Wrap(err, "invalid nick"))
 	}
 
 	// Time
 	if isZero := n.Time.IsZero(); isZero {
-		return errors.New("time is zero")
+		return newValidationError(ReasonTime, errors.New("time is zero"))
+	}
+	if maxClockSkew := policy.maxClockSkew(); n.Time.After(now.Add(maxClockSkew)) {
+		return newValidationError(ReasonTime, errors.Errorf("time is more than %s in the future", maxClockSkew))
+	}
+
+	// Version
+	if n.Version < VersionInitial || n.Version > latestVersion {
+		return newValidationError(ReasonVersion, errors.Errorf("unsupported version %d", n.Version))
+	}
+
+	// Display name
+	if n.DisplayName != "" {
+		if n.Version < VersionDisplayName {
+			return newValidationError(ReasonDisplayName, errors.Errorf("display name requires version %d", VersionDisplayName))
+		}
+		if err := ValidateDisplayName(n.DisplayName); err != nil {
+			return newValidationError(ReasonDisplayName, errors.Wrap(err, "invalid display name"))
+		}
+	}
+
+	// Challenge
+	if n.Version >= VersionChallenge {
+		if len(n.Challenge) == 0 {
+			return newValidationError(ReasonChallenge, errors.Errorf("version %d requires a challenge", VersionChallenge))
+		}
+		if len(n.Challenge) > maxChallengeLength {
+			return newValidationError(ReasonChallenge, errors.New("challenge is too long"))
+		}
+	} else if len(n.Challenge) != 0 {
+		return newValidationError(ReasonChallenge, errors.Errorf("challenge requires version %d", VersionChallenge))
 	}
 
 	// Signature
+	if len(n.Signature) == 0 {
+		return newValidationError(ReasonSignature, errors.New("could not validate the signature: signature is empty"))
+	}
 	data := n.GetDataToSign()
 	if err := publicKey.Validate(data, n.Signature, SigningHash); err != nil {
-		return errors.Wrap(err, "could not validate the signature")
+		return newValidationError(ReasonSignature, errors.Wrap(err, policy.signatureHint(publicKey, data, n.Signature)))
+	}
+
+	return nil
+}
+
+// ValidationReason categorizes the reasons for which nick data is invalid.
+type ValidationReason string
+
+const (
+	ReasonPublicKey   ValidationReason = "public_key"
+	ReasonId          ValidationReason = "id"
+	ReasonNick        ValidationReason = "nick"
+	ReasonTime        ValidationReason = "time"
+	ReasonVersion     ValidationReason = "version"
+	ReasonDisplayName ValidationReason = "display_name"
+	ReasonChallenge   ValidationReason = "challenge"
+	ReasonSignature   ValidationReason = "signature"
+)
+
+// ValidationReasons lists all validation reasons.
+var ValidationReasons = []ValidationReason{
+	ReasonPublicKey,
+	ReasonId,
+	ReasonNick,
+	ReasonTime,
+	ReasonVersion,
+	ReasonDisplayName,
+	ReasonChallenge,
+	ReasonSignature,
+}
+
+// ValidationError describes why nick data is invalid. It matches
+// InvalidNickDataErr when compared using errors.Is.
+type ValidationError struct {
+	Reason ValidationReason
+	Err    error
+}
+
+func newValidationError(reason ValidationReason, err error) error {
+	return &ValidationError{Reason: reason, Err: err}
+}
+
+func (e *ValidationError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == InvalidNickDataErr
+}
+
+// alternativeSigningHashes are the hashes which clients commonly use by
+// mistake instead of SigningHash.
+var alternativeSigningHashes = []crypto.Hash{crypto.SHA256, crypto.SHA384, crypto.SHA1}
+
+// signatureHint returns a message describing an invalid signature. If the
+// signature hints are enabled and the signature was created using a different
+// hash than SigningHash this is reported to simplify debugging the clients.
+// The signature is rejected regardless.
+func (p NickPolicy) signatureHint(publicKey *scrypto.PublicKey, data, signature []byte) string {
+	if !p.SignatureHints {
+		return "could not validate the signature"
 	}
+	for _, hash := range alternativeSigningHashes {
+		if err := publicKey.Validate(data, signature, hash); err == nil {
+			return fmt.Sprintf("could not validate the signature: the data was signed using %s instead of %s", hash, SigningHash)
+		}
+	}
+	return fmt.Sprintf("could not validate the signature: the %s digest of the data must be signed", SigningHash)
+}
 
+// ValidateDisplayName checks if the display name is valid. Display names are
+// free-form but can't contain control characters.
+func ValidateDisplayName(displayName string) error {
+	if !utf8.ValidString(displayName) {
+		return errors.New("display name is not valid UTF-8")
+	}
+	if n := utf8.RuneCountInString(displayName); n > maxDisplayNameLength {
+		return errors.Errorf("display name needs to be at most %d characters long", maxDisplayNameLength)
+	}
+	for _, r := range displayName {
+		if unicode.IsControl(r) {
+			return errors.New("display name must not contain control characters")
+		}
+	}
 	return nil
 }
 
-// ValidateNick checks if the nick is valid.
+// nickSeparators lists the characters which are considered to be separators
+// by the strict nick policy.
+const nickSeparators = "_-[]"
+
+// NickPolicy specifies the rules which nicks have to follow.
+type NickPolicy struct {
+	// StrictSeparators disallows consecutive separator characters and
+	// trailing separator characters other than a closing bracket.
+	StrictSeparators bool
+
+	// Regexp overrides the regular expression which nicks have to match.
+	// The default expression is used if it is nil.
+	Regexp *regexp.Regexp
+
+	// MinLength is the min length of a nick in bytes.
+	// DefaultMinNickLength is used if it is zero.
+	MinLength int
+
+	// MaxLength is the max length of a nick in bytes.
+	// DefaultMaxNickLength is used if it is zero.
+	MaxLength int
+
+	// MaxClockSkew is the max amount of time by which the time of the nick
+	// data can be ahead of the current time. Otherwise nick data with a
+	// time far in the future could never be replaced as newer nick data
+	// is required to change it. DefaultMaxClockSkew is used if it is zero.
+	MaxClockSkew time.Duration
+
+	// SignatureHints reports if an invalid signature was created using
+	// a hash commonly used by mistake instead of SigningHash. Checking this
+	// requires up to three additional signature verifications for every
+	// invalid signature so it should only be enabled while debugging the
+	// clients.
+	SignatureHints bool
+}
+
+// DefaultMaxClockSkew is used if MaxClockSkew isn't set.
+const DefaultMaxClockSkew = 5 * time.Minute
+
+func (p NickPolicy) maxClockSkew() time.Duration {
+	if p.MaxClockSkew <= 0 {
+		return DefaultMaxClockSkew
+	}
+	return p.MaxClockSkew
+}
+
+func (p NickPolicy) minLength() int {
+	if p.MinLength <= 0 {
+		return DefaultMinNickLength
+	}
+	return p.MinLength
+}
+
+func (p NickPolicy) maxLength() int {
+	if p.MaxLength <= 0 {
+		return DefaultMaxNickLength
+	}
+	return p.MaxLength
+}
+
+// DefaultNickPolicy returns the default nick policy.
+func DefaultNickPolicy() NickPolicy {
+	return NickPolicy{
+		StrictSeparators: false,
+		Regexp:           nil,
+		MinLength:        DefaultMinNickLength,
+		MaxLength:        DefaultMaxNickLength,
+		MaxClockSkew:     DefaultMaxClockSkew,
+	}
+}
+
+// ValidateNick checks if the nick is valid according to the default policy.
 func ValidateNick(nick string) error {
-	if len(nick) < minNickLength {
-		return errors.Errorf("nick needs to be at least %d characters long", minNickLength)
+	return DefaultNickPolicy().ValidateNick(nick)
+}
+
+// ValidateNick checks if the nick is valid according to this policy.
+func (p NickPolicy) ValidateNick(nick string) error {
+	if min := p.minLength(); len(nick) < min {
+		return errors.Errorf("nick needs to be at least %d characters long", min)
+	}
+	if max := p.maxLength(); len(nick) > max {
+		return errors.Errorf("nick needs to be at most %d characters long", max)
+	}
+	if err := validateNickCharacters(nick); err != nil {
+		return err
 	}
-	if len(nick) > maxNickLength {
-		return errors.Errorf("nick needs to be at most %d characters long", maxNickLength)
+	r := nickRegexp
+	if p.Regexp != nil {
+		r = p.Regexp
 	}
-	if result := nickRegexp.MatchString(nick); !result {
+	if result := r.MatchString(nick); !result {
 		return errors.Errorf("nick does not match the regular expression")
 	}
+	if p.StrictSeparators {
+		if err := validateSeparators(nick); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prefixRegexp is used to validate the prefixes of the nicks used in searches.
+var prefixRegexp = regexp.MustCompile(`^[a-zA-Z0-9]+$`)
+
+// ValidatePrefix checks if the prefix can be used to search for nicks
+// according to the default policy.
+func ValidatePrefix(prefix string) error {
+	return DefaultNickPolicy().ValidatePrefix(prefix)
+}
+
+// ValidatePrefix checks if the prefix can be used to search for nicks. The
+// prefix has to consist of letters and digits only and can't be longer than
+// the longest nick allowed by this policy.
+func (p NickPolicy) ValidatePrefix(prefix string) error {
+	if max := p.maxLength(); len(prefix) > max {
+		return errors.Errorf("prefix needs to be at most %d characters long", max)
+	}
+	if !prefixRegexp.MatchString(prefix) {
+		return errors.New("prefix must consist of letters and digits only")
+	}
+	return nil
+}
+
+// validateNickCharacters rejects the characters which break displaying and
+// indexing the nicks. It is applied regardless of the regular expression so
+// that a permissive expression can't allow them.
+func validateNickCharacters(nick string) error {
+	if !utf8.ValidString(nick) {
+		return errors.New("nick is not valid UTF-8")
+	}
+	for _, r := range nick {
+		if unicode.IsControl(r) || unicode.IsSpace(r) || unicode.Is(unicode.Cf, r) {
+			return errors.New("nick must not contain whitespace, control or format characters")
+		}
+	}
+	return nil
+}
+
+func validateSeparators(nick string) error {
+	for i := 1; i < len(nick); i++ {
+		if isNickSeparator(nick[i-1]) && isNickSeparator(nick[i]) {
+			return errors.New("nick must not contain consecutive separators")
+		}
+	}
+	if last := nick[len(nick)-1]; isNickSeparator(last) && last != ']' {
+		return errors.New("nick must not end with a separator")
+	}
 	return nil
 }
 
+func isNickSeparator(c byte) bool {
+	return strings.IndexByte(nickSeparators, c) >= 0
+}
+
 var InvalidNickDataErr = errors.New("invalid nick data")
 var NewerNickDataPresentErr = errors.New("newer nick data is available")
 var NickConflictErr = errors.New("nick is already taken")
 var InvalidNodeIdErr = errors.New("invalid node id")
 var InvalidNickErr = errors.New("invalid nick")
+var InvalidPrefixErr = errors.New("invalid prefix")
+var ReadOnlyErr = errors.New("repository is read-only")
+var NotFoundErr = errors.New("nick data not found")
+var SameTimeChangeErr = errors.New("nick data with the same time but a different content is present")
+var NickKeyTooLongErr = errors.New("nick is too long to be stored")
+var InvalidSwapErr = errors.New("nick datas don't exchange the nicks of two nodes")
+var NickQuarantinedErr = errors.New("nick was released recently and can't be claimed yet")
+var ReservedNickErr = errors.New("nick is reserved")
 
 const nickDataBucket = "nickdata"
 const nicksBucket = "nicks"
+const historyBucket = "history"
+const releasedBucket = "released"
+const versionsBucket = "versions"
+const firstSeenBucket = "firstseen"
+
+// defaultMaxNickKeyBytes is the default max length of the nick index keys.
+const defaultMaxNickKeyBytes = 255
+
+// Options specifies the behaviour of a repository.
+type Options struct {
+	// NickPolicy specifies the rules which stored nicks have to follow.
+	NickPolicy NickPolicy
+
+	// AllowSameTimeChanges allows nick data with the same time as the
+	// stored nick data but a different content to overwrite it. By default
+	// such nick data is rejected with SameTimeChangeErr as otherwise
+	// anyone able to obtain differently signed nick data with the same time
+	// could change the nick back and forth. The times are compared with a
+	// precision of one second as only that part of the time is signed.
+	// Resubmitting identical nick data always succeeds.
+	AllowSameTimeChanges bool
+
+	// MaxNickKeyBytes is the max length in bytes of the key under which the
+	// nick is stored in the nick index. It is enforced independently of the
+	// nick policy.
+	MaxNickKeyBytes int
+
+	// ValidateOnRead validates the stored entries before returning them.
+	// Invalid entries, eg. written by a bug or tampered with, are treated
+	// as if they didn't exist.
+	ValidateOnRead bool
+
+	// ReadOnly opens the database in read-only mode. The database must
+	// already exist. All writes fail with ReadOnlyErr.
+	ReadOnly bool
+
+	// NegativeCacheSize is the max number of recently not found node ids
+	// which are remembered for NegativeCacheTTL so that looking them up
+	// again doesn't read the database. The ids are forgotten once they are
+	// stored. The cache is disabled if the size is zero.
+	NegativeCacheSize int
+	NegativeCacheTTL  time.Duration
+
+	// CompressValues compresses the stored values if that makes them
+	// smaller. Both compressed and uncompressed values can always be read
+	// so this option can be changed at any time, it only affects the
+	// values written afterwards. The values consist mostly of the public
+	// key and the signature which don't compress at all so typical values
+	// end up stored uncompressed and only the values with long display
+	// names or challenges shrink by about 10%. Compressing a value takes
+	// about 0.2ms and allocates about 1MB, see BenchmarkCompressValue.
+	CompressValues bool
+
+	// NickQuarantine is the period after a nick is released, eg. when its
+	// owner changes it or is deleted, during which no node, including the
+	// previous owner, can claim it. Claiming the nick fails with
+	// NickQuarantinedErr. The quarantine is disabled if the period is zero.
+	NickQuarantine time.Duration
+
+	// MaxVersions is the number of prior versions of the nick data of
+	// each node which are stored together with their signatures so that
+	// the changes can be verified later. The oldest versions are removed
+	// once the limit is reached. The versions aren't stored if it is zero.
+	MaxVersions int
+
+	// ReservedNicks lists the nicks which can't be claimed. Storing nick
+	// data with a reserved nick fails with ReservedNickErr unless the node
+	// already holds the nick, eg. because it held it before the nick was
+	// reserved, or PutOptions.AllowReserved is set. No nicks are reserved
+	// if it is nil.
+	ReservedNicks *ReservedNicks
+}
+
+// DefaultOptions returns the default repository options.
+func DefaultOptions() Options {
+	return Options{
+		NickPolicy:           DefaultNickPolicy(),
+		AllowSameTimeChanges: false,
+		MaxNickKeyBytes:      defaultMaxNickKeyBytes,
+		ValidateOnRead:       true,
+		ReadOnly:             false,
+		NegativeCacheSize:    0,
+		NegativeCacheTTL:     0,
+		CompressValues:       false,
+		NickQuarantine:       0,
+		MaxVersions:          0,
+		ReservedNicks:        nil,
+	}
+}
 
 // NewBoltRepository opens or creates a repository using bolt as an underlying
 // storage.
-func NewBoltRepository(path string) (*BoltRepository, error) {
-	db, err := bolt.Open(path, 0600, nil)
+//
+// Only a single process can write to a database. The writer holds a lock file
+// located next to the database and opening the database for writing in
+// another process fails with DatabaseLockedErr. Read-only repositories can be
+// opened alongside the writer, in that case they serve a consistent snapshot
+// of the database created when they were opened.
+func NewBoltRepository(path string, options Options) (*BoltRepository, error) {
+	if options.ReadOnly {
+		return openReadOnlyBoltRepository(path, options)
+	}
+
+	lock, err := acquireWriterLock(path)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: lockTimeout})
 	if err != nil {
+		lock.Release()
+		if err == bolt.ErrTimeout {
+			return nil, DatabaseLockedErr
+		}
 		return nil, errors.Wrap(err, "could not open the database")
 	}
 
+	if err := db.View(checkSchemaVersion); err != nil {
+		db.Close()
+		lock.Release()
+		return nil, err
+	}
+
 	if err := db.Update(func(tx *bolt.Tx) error {
 		if _, err := tx.CreateBucketIfNotExists([]byte(nickDataBucket)); err != nil {
 			return errors.Wrap(err, "nickDataBucket creation failed")
@@ -123,162 +581,1345 @@ func NewBoltRepository(path string) (*BoltRepository, error) {
 		if _, err := tx.CreateBucketIfNotExists([]byte(nicksBucket)); err != nil {
 			return errors.Wrap(err, "nicksBucket creation failed")
 		}
+		if _, err := tx.CreateBucketIfNotExists([]byte(historyBucket)); err != nil {
+			return errors.Wrap(err, "historyBucket creation failed")
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte(releasedBucket)); err != nil {
+			return errors.Wrap(err, "releasedBucket creation failed")
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte(versionsBucket)); err != nil {
+			return errors.Wrap(err, "versionsBucket creation failed")
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte(firstSeenBucket)); err != nil {
+			return errors.Wrap(err, "firstSeenBucket creation failed")
+		}
 		return nil
 	}); err != nil {
+		db.Close()
+		lock.Release()
 		return nil, errors.Wrap(err, "could not create the bucket")
 	}
 
+	if err := db.Update(migrate); err != nil {
+		db.Close()
+		lock.Release()
+		return nil, errors.Wrap(err, "could not migrate the database")
+	}
+
 	rv := &BoltRepository{
-		db: db,
+		db:            db,
+		options:       options,
+		listChunkSize: listChunkSize,
+		lock:          lock,
+		missing:       newNegativeCache(options.NegativeCacheSize, options.NegativeCacheTTL),
+		now:           time.Now,
 	}
 	return rv, nil
 }
 
-type BoltRepository struct {
-	db *bolt.DB
-}
+func openReadOnlyBoltRepository(path string, options Options) (*BoltRepository, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, errors.Wrap(err, "could not stat the database")
+	}
 
-// List returns a list of all stored entires.
-func (r *BoltRepository) List() ([]NickData, error) {
-	rv := make([]NickData, 0)
-	if err := r.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(nickDataBucket))
-		return b.ForEach(func(k, v []byte) error {
-			nickData, err := r.unmarshalNickData(v)
-			if err != nil {
-				return errors.Wrap(err, "unmarshal failed")
-			}
-			rv = append(rv, *nickData)
-			return nil
-		})
-	}); err != nil {
-		return nil, err
+	snapshot := ""
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: lockTimeout})
+	if err == bolt.ErrTimeout {
+		// The database is held by a writer
+		snapshot, err = snapshotDatabase(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not snapshot the database")
+		}
+		db, err = bolt.Open(snapshot, 0600, &bolt.Options{ReadOnly: true})
+		if err != nil {
+			os.Remove(snapshot)
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open the database")
 	}
-	return rv, nil
-}
 
-// Get returns an entry for a specific node id. If the node id is invalid
-// InvalidNodeIdErr is returned. If the entry doesn't exist nil is returned
-// without an error.
-func (r *BoltRepository) Get(id node.ID) (*NickData, error) {
-	if !node.ValidateId(id) {
-		return nil, InvalidNodeIdErr
+	rv := &BoltRepository{
+		db:            db,
+		options:       options,
+		listChunkSize: listChunkSize,
+		snapshot:      snapshot,
+		missing:       newNegativeCache(options.NegativeCacheSize, options.NegativeCacheTTL),
+		now:           time.Now,
 	}
 
-	var nickData *NickData = nil
-	if err := r.db.View(func(tx *bolt.Tx) error {
-		nd, err := r.getNickData(tx, id)
-		if err != nil {
-			return err
+	if err := db.View(func(tx *bolt.Tx) error {
+		for _, bucket := range []string{nickDataBucket, nicksBucket} {
+			if tx.Bucket([]byte(bucket)) == nil {
+				return errors.Errorf("bucket %s doesn't exist", bucket)
+			}
 		}
-		nickData = nd
 		return nil
 	}); err != nil {
+		rv.Close()
+		return nil, errors.Wrap(err, "database is not initialized")
+	}
+
+	if err := db.View(checkSchemaVersion); err != nil {
+		rv.Close()
 		return nil, err
 	}
-	return nickData, nil
+
+	return rv, nil
 }
 
-// GetByNick returns an entry for a specific node id. If the node id is invalid
-// InvalidNodeIdErr is returned. If the entry doesn't exist nil is returned
-// without an error.
-func (r *BoltRepository) GetByNick(nick string) (*NickData, error) {
-	if err := ValidateNick(nick); err != nil {
-		return nil, InvalidNickErr
+// listChunkSize is the max number of entries read in a single transaction
+// when listing all entries.
+const listChunkSize = 1000
+
+type BoltRepository struct {
+	db            *bolt.DB
+	options       Options
+	listChunkSize int
+	stats         stats
+
+	// lock is held by repositories opened for writing.
+	lock *writerLock
+
+	// snapshot is the path of the temporary copy of the database opened
+	// by read-only repositories if the database was held by a writer.
+	snapshot string
+
+	// missing is nil if the negative cache is disabled.
+	missing *negativeCache
+
+	now func() time.Time
+}
+
+// RepoStats contains the numbers of entries accepted or rejected by a
+// repository since it was opened.
+type RepoStats struct {
+	// Gets is the number of entries retrieved by node id or nick.
+	Gets uint64 `json:"gets"`
+
+	Accepted  uint64 `json:"accepted"`
+	Conflicts uint64 `json:"conflicts"`
+	Stale     uint64 `json:"stale"`
+	Invalid   uint64 `json:"invalid"`
+
+	// InvalidStored is the number of times a stored entry failed the
+	// validation when it was read.
+	InvalidStored uint64 `json:"invalidStored"`
+}
+
+type stats struct {
+	gets          uint64
+	accepted      uint64
+	conflicts     uint64
+	stale         uint64
+	invalid       uint64
+	invalidStored uint64
+}
+
+// record increments the counter corresponding to the result of inserting an
+// entry. Unexpected errors aren't counted.
+func (s *stats) record(err error) {
+	switch {
+	case err == nil:
+		atomic.AddUint64(&s.accepted, 1)
+	case err == NickConflictErr, err == NickQuarantinedErr, err == ReservedNickErr:
+		atomic.AddUint64(&s.conflicts, 1)
+	case err == NewerNickDataPresentErr, err == SameTimeChangeErr:
+		atomic.AddUint64(&s.stale, 1)
+	case errors.Is(err, InvalidNickDataErr), err == NickKeyTooLongErr:
+		atomic.AddUint64(&s.invalid, 1)
 	}
+}
 
-	var nickData *NickData = nil
-	if err := r.db.View(func(tx *bolt.Tx) error {
-		nicksB := tx.Bucket([]byte(nicksBucket))
-		id := nicksB.Get([]byte(nick))
-		if id == nil {
-			return nil
-		}
+func (s *stats) get() RepoStats {
+	return RepoStats{
+		Gets:          atomic.LoadUint64(&s.gets),
+		Accepted:      atomic.LoadUint64(&s.accepted),
+		Conflicts:     atomic.LoadUint64(&s.conflicts),
+		Stale:         atomic.LoadUint64(&s.stale),
+		Invalid:       atomic.LoadUint64(&s.invalid),
+		InvalidStored: atomic.LoadUint64(&s.invalidStored),
+	}
+}
 
-		nd, err := r.getNickData(tx, id)
-		if err != nil {
-			return err
-		}
-		nickData = nd
+// Stats returns the numbers of entries accepted or rejected since the
+// repository was opened.
+func (r *BoltRepository) Stats() RepoStats {
+	return r.stats.get()
+}
+
+// List returns a list of all stored entires. The entries are read in bounded
+// chunks using separate transactions so that listing a large dataset doesn't
+// block writes for a long time. The iteration is aborted if the context is
+// cancelled.
+func (r *BoltRepository) List(ctx context.Context) ([]NickData, error) {
+	n, err := r.countKeys(nickDataBucket)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not count the entries")
+	}
+
+	rv := make([]NickData, 0, n)
+	if err := r.iterate(ctx, func(nickData *NickData) error {
+		rv = append(rv, *nickData)
 		return nil
 	}); err != nil {
 		return nil, err
 	}
-	return nickData, nil
+	return rv, nil
 }
 
-func (r *BoltRepository) getNickData(tx *bolt.Tx, id node.ID) (*NickData, error) {
-	b := tx.Bucket([]byte(nickDataBucket))
-	v := b.Get(id)
-	if v != nil {
-		return r.unmarshalNickData(v)
+// Count returns the number of stored entries.
+func (r *BoltRepository) Count() (int, error) {
+	n, err := r.countKeys(nickDataBucket)
+	if err != nil {
+		return 0, errors.Wrap(err, "view failed")
 	}
-	return nil, nil
+	return n, nil
 }
 
-func (r *BoltRepository) unmarshalNickData(data []byte) (*NickData, error) {
-	nickData := &NickData{}
-	if err := json.Unmarshal(data, nickData); err != nil {
-		return nil, errors.Wrap(err, "json unmarshal failed")
+// ListTimeRange returns all stored entries with times within the provided
+// inclusive range. The times are compared with a precision of one second as
+// only that part of the time is signed.
+func (r *BoltRepository) ListTimeRange(ctx context.Context, from, to time.Time) ([]NickData, error) {
+	rv := make([]NickData, 0)
+	if err := r.iterate(ctx, func(nickData *NickData) error {
+		t := nickData.Time.Unix()
+		if t >= from.Unix() && t <= to.Unix() {
+			rv = append(rv, *nickData)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
 	}
-	return nickData, nil
+	return rv, nil
 }
 
-// Put inserts a new entry. In case of a nick collision with a different node
-// NickConflictErr is returned. In case the entry is invalid InvalidNickDataErr
-// is returned. In case there is a newer nick data available for this node
-// NewerNickDataPresentErr is returned.
-func (r *BoltRepository) Put(nickData *NickData) error {
-	if err := nickData.Validate(); err != nil {
-		return InvalidNickDataErr
+// ListAfter returns at most limit entries with node ids greater than the
+// provided one in the order of the node ids. If after is nil the entries are
+// returned starting with the first one. The returned node id should be passed
+// as after to retrieve the next entries, it is nil if there are no more
+// entries.
+func (r *BoltRepository) ListAfter(after node.ID, limit int) ([]NickData, node.ID, error) {
+	if limit < 1 {
+		return nil, nil, errors.New("limit must be positive")
 	}
 
-	value, err := json.Marshal(nickData)
-	if err != nil {
-		return errors.Wrap(err, "marshaling nick data failed")
-	}
+	rv := make([]NickData, 0)
+	var next node.ID
+	if err := r.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(nickDataBucket)).Cursor()
 
-	if err := r.db.Update(func(tx *bolt.Tx) error {
-		// Confirm that the nick doesn't exist
-		nicksB := tx.Bucket([]byte(nicksBucket))
-		existingId := nicksB.Get([]byte(nickData.Nick))
-		if existingId != nil {
-			if !node.CompareId(existingId, nickData.Id) {
-				return NickConflictErr
+		var k, v []byte
+		if after == nil {
+			k, v = c.First()
+		} else {
+			k, v = c.Seek(after)
+			if k != nil && bytes.Equal(k, after) {
+				k, v = c.Next()
 			}
 		}
 
-		// Confirm that there is no newer nick data
-		previousNickData, err := r.getNickData(tx, nickData.Id)
-		if err != nil {
-			return errors.Wrap(err, "error retrieving the previous nick data")
-		}
-		if previousNickData != nil {
-			if previousNickData.Time.After(nickData.Time) {
-				return NewerNickDataPresentErr
+		for ; k != nil; k, v = c.Next() {
+			if len(rv) == limit {
+				next = append(node.ID(nil), rv[len(rv)-1].Id...)
+				return nil
 			}
+			nickData, err := unmarshalNickData(v)
+			if err != nil {
+				return errors.Wrap(err, "unmarshal failed")
+			}
+			rv = append(rv, *nickData)
 		}
+		return nil
+	}); err != nil {
+		return nil, nil, err
+	}
+	return rv, next, nil
+}
 
-		// Insert new nick
-		if err := nicksB.Put(nickData.Id, value); err != nil {
-			return errors.Wrap(err, "nicks bucket put failed")
+// iterate calls the provided function for each stored entry. Each chunk of at
+// most listChunkSize entries is read in a separate transaction and the context
+// is checked between the chunks.
+func (r *BoltRepository) iterate(ctx context.Context, fn func(nickData *NickData) error) error {
+	return r.iterateBucket(ctx, nickDataBucket, func(k, v []byte) error {
+		nickData, err := unmarshalNickData(v)
+		if err != nil {
+			return errors.Wrap(err, "unmarshal failed")
 		}
+		return fn(nickData)
+	}, nil)
+}
 
-		nickDataB := tx.Bucket([]byte(nickDataBucket))
-		if err := nickDataB.Put(nickData.Id, value); err != nil {
-			return errors.Wrap(err, "nick data bucket put failed")
-		}
+// countKeys returns the number of keys in the bucket. As the entries are
+// listed in multiple transactions the result should only be used as a hint.
+func (r *BoltRepository) countKeys(bucket string) (int, error) {
+	var n int
+	err := r.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket([]byte(bucket)).Stats().KeyN
 		return nil
-	}); err != nil {
-		if err == NickConflictErr || err == NewerNickDataPresentErr {
+	})
+	return n, err
+}
+
+// iterateBucket calls fn for each key in the bucket. The keys are read in
+// chunks of listChunkSize, each chunk in a separate transaction. The keys and
+// the values are valid only until fn returns. If chunkDone is not nil it is
+// called outside of the transaction after each chunk is read.
+func (r *BoltRepository) iterateBucket(ctx context.Context, bucket string, fn func(k, v []byte) error, chunkDone func() error) error {
+	var after []byte
+	for {
+		if err := ctx.Err(); err != nil {
 			return err
 		}
-		return errors.Wrap(err, "update failed")
+
+		n := 0
+		if err := r.db.View(func(tx *bolt.Tx) error {
+			c := tx.Bucket([]byte(bucket)).Cursor()
+
+			var k, v []byte
+			if after == nil {
+				k, v = c.First()
+			} else {
+				k, v = c.Seek(after)
+				if k != nil && bytes.Equal(k, after) {
+					k, v = c.Next()
+				}
+			}
+
+			for ; k != nil && n < r.listChunkSize; k, v = c.Next() {
+				if err := fn(k, v); err != nil {
+					return err
+				}
+				after = append(after[:0], k...)
+				n++
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		if chunkDone != nil {
+			if err := chunkDone(); err != nil {
+				return err
+			}
+		}
+
+		if n < r.listChunkSize {
+			return nil
+		}
+	}
+}
+
+// IndexEntry links a nick with the node which holds it.
+type IndexEntry struct {
+	Nick string  `json:"nick"`
+	Id   node.ID `json:"id"`
+}
+
+// IterateIndex calls fn for each entry in the nick index in the order of the
+// nicks. The index is read in chunks and fn isn't called within a database
+// transaction so it can perform slow operations such as writing to a network
+// connection. The iteration is aborted if the context is cancelled or fn
+// returns an error.
+func (r *BoltRepository) IterateIndex(ctx context.Context, fn func(entry IndexEntry) error) error {
+	var chunk []IndexEntry
+	return r.iterateBucket(ctx, nicksBucket, func(k, v []byte) error {
+		chunk = append(chunk, IndexEntry{
+			Nick: string(k),
+			Id:   append(node.ID(nil), v...),
+		})
+		return nil
+	}, func() error {
+		for _, entry := range chunk {
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+		chunk = chunk[:0]
+		return nil
+	})
+}
+
+// Get returns an entry for a specific node id. If the node id is invalid
+// InvalidNodeIdErr is returned. If the entry doesn't exist or validation on
+// read is enabled and the entry is invalid nil is returned without an error.
+func (r *BoltRepository) Get(id node.ID) (*NickData, error) {
+	if !node.ValidateId(id) {
+		return nil, InvalidNodeIdErr
+	}
+
+	atomic.AddUint64(&r.stats.gets, 1)
+
+	if r.missing.Contains(id) {
+		return nil, nil
+	}
+	generation := r.missing.Generation()
+
+	var nickData *NickData = nil
+	if err := r.db.View(func(tx *bolt.Tx) error {
+		nd, err := r.getNickData(tx, id)
+		if err != nil {
+			return err
+		}
+		nickData = nd
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if nickData == nil {
+		r.missing.Add(id, generation)
+	}
+	return r.checkStored(nickData), nil
+}
+
+// GetByNick returns an entry for a specific node id. If the node id is invalid
+// InvalidNodeIdErr is returned. If the entry doesn't exist or validation on
+// read is enabled and the entry is invalid nil is returned without an error.
+func (r *BoltRepository) GetByNick(nick string) (*NickData, error) {
+	if err := r.options.NickPolicy.ValidateNick(nick); err != nil {
+		return nil, InvalidNickErr
+	}
+
+	atomic.AddUint64(&r.stats.gets, 1)
+
+	var nickData *NickData = nil
+	if err := r.db.View(func(tx *bolt.Tx) error {
+		nicksB := tx.Bucket([]byte(nicksBucket))
+		id := nicksB.Get([]byte(nick))
+		if id == nil {
+			return nil
+		}
+
+		nd, err := r.getNickData(tx, id)
+		if err != nil {
+			return err
+		}
+		nickData = nd
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return r.checkStored(nickData), nil
+}
+
+// ResolveNicks returns the node ids of the nodes which use the provided
+// nicks. The nicks which aren't used by any node are omitted. All nicks are
+// resolved within a single transaction.
+func (r *BoltRepository) ResolveNicks(nicks []string) (map[string]node.ID, error) {
+	for _, nick := range nicks {
+		if err := r.options.NickPolicy.ValidateNick(nick); err != nil {
+			return nil, InvalidNickErr
+		}
+	}
+
+	ids := make(map[string]node.ID)
+	if err := r.db.View(func(tx *bolt.Tx) error {
+		nicksB := tx.Bucket([]byte(nicksBucket))
+		for _, nick := range nicks {
+			id := nicksB.Get([]byte(nick))
+			if id == nil {
+				continue
+			}
+
+			nickData, err := r.getNickData(tx, id)
+			if err != nil {
+				return err
+			}
+			if nickData = r.checkStored(nickData); nickData != nil {
+				ids[nick] = nickData.Id
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// SearchByPrefix returns at most limit entries with nicks starting with the
+// prefix in the order of the nicks. If the prefix is invalid InvalidPrefixErr
+// is returned.
+func (r *BoltRepository) SearchByPrefix(prefix string, limit int) ([]NickData, error) {
+	if err := r.options.NickPolicy.ValidatePrefix(prefix); err != nil {
+		return nil, InvalidPrefixErr
+	}
+
+	var rv []NickData
+	if err := r.db.View(func(tx *bolt.Tx) error {
+		rv = nil
+		c := tx.Bucket([]byte(nicksBucket)).Cursor()
+		for k, id := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)) && len(rv) < limit; k, id = c.Next() {
+			nickData, err := r.getNickData(tx, id)
+			if err != nil {
+				return errors.Wrap(err, "could not get the nick data")
+			}
+			if nickData = r.checkStored(nickData); nickData != nil {
+				rv = append(rv, *nickData)
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+// checkStored returns nil if validation on read is enabled and the stored
+// entry is invalid.
+func (r *BoltRepository) checkStored(nickData *NickData) *NickData {
+	if nickData == nil || !r.options.ValidateOnRead {
+		return nickData
+	}
+	if err := nickData.ValidateWithPolicy(r.options.NickPolicy); err != nil {
+		atomic.AddUint64(&r.stats.invalidStored, 1)
+		log.Warn("stored nick data is invalid", "id", nickData.Id, "nick", nickData.Nick, "err", err)
+		return nil
+	}
+	return nickData
+}
+
+// NickHistory returns all nicks ever held by a specific node id starting with
+// the current one and followed by the previous ones, most recent first. If the
+// node id is invalid InvalidNodeIdErr is returned. If the entry doesn't exist
+// an empty list is returned.
+func (r *BoltRepository) NickHistory(id node.ID) ([]string, error) {
+	if !node.ValidateId(id) {
+		return nil, InvalidNodeIdErr
+	}
+
+	nicks := make([]string, 0)
+	if err := r.db.View(func(tx *bolt.Tx) error {
+		seen := make(map[string]bool)
+		add := func(nick string) {
+			if !seen[nick] {
+				seen[nick] = true
+				nicks = append(nicks, nick)
+			}
+		}
+
+		// Entries stored before the history was tracked have no history
+		nickData, err := r.getNickData(tx, id)
+		if err != nil {
+			return errors.Wrap(err, "error retrieving the nick data")
+		}
+		if nickData != nil {
+			add(nickData.Nick)
+		}
+
+		historyB := tx.Bucket([]byte(historyBucket))
+		if historyB == nil {
+			return nil
+		}
+		idB := historyB.Bucket(id)
+		if idB == nil {
+			return nil
+		}
+		c := idB.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			add(string(v))
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return nicks, nil
+}
+
+// Versions returns the prior versions of the nick data of a specific node id
+// starting with the newest one. The versions are only stored if MaxVersions
+// is set. If the node is unknown an empty list is returned.
+func (r *BoltRepository) Versions(id node.ID) ([]NickData, error) {
+	if !node.ValidateId(id) {
+		return nil, InvalidNodeIdErr
+	}
+
+	versions := make([]NickData, 0)
+	if err := r.db.View(func(tx *bolt.Tx) error {
+		versionsB := tx.Bucket([]byte(versionsBucket))
+		if versionsB == nil {
+			return nil
+		}
+		idB := versionsB.Bucket(id)
+		if idB == nil {
+			return nil
+		}
+		c := idB.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			nickData, err := unmarshalNickData(v)
+			if err != nil {
+				return errors.Wrap(err, "unmarshal failed")
+			}
+			versions = append(versions, *nickData)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// NickHistoryBefore returns at most limit nicks from the history of a specific
+// node id in the same order as NickHistory. If before is nil the nicks are
+// returned starting with the current one. The returned cursor should be passed
+// as before to retrieve the next nicks, it is nil if there are no more nicks.
+// The cursors remain valid when the node changes its nick.
+func (r *BoltRepository) NickHistoryBefore(id node.ID, before []byte, limit int) ([]string, []byte, error) {
+	if limit < 1 {
+		return nil, nil, errors.New("limit must be positive")
+	}
+	if !node.ValidateId(id) {
+		return nil, nil, InvalidNodeIdErr
+	}
+
+	nicks := make([]string, 0)
+	var next []byte
+	if err := r.db.View(func(tx *bolt.Tx) error {
+		var idB *bolt.Bucket
+		if historyB := tx.Bucket([]byte(historyBucket)); historyB != nil {
+			idB = historyB.Bucket(id)
+		}
+
+		// The nicks returned on the previous pages are skipped in the
+		// same way as in NickHistory
+		seen := make(map[string]bool)
+		nickData, err := r.getNickData(tx, id)
+		if err != nil {
+			return errors.Wrap(err, "error retrieving the nick data")
+		}
+		if nickData != nil {
+			seen[nickData.Nick] = true
+			if before == nil {
+				nicks = append(nicks, nickData.Nick)
+			}
+		}
+		if idB == nil {
+			return nil
+		}
+
+		c := idB.Cursor()
+		if before != nil {
+			for k, v := c.Last(); k != nil && bytes.Compare(k, before) >= 0; k, v = c.Prev() {
+				seen[string(v)] = true
+			}
+		}
+
+		for k, v := seekBefore(c, before); k != nil; k, v = c.Prev() {
+			if seen[string(v)] {
+				continue
+			}
+			if len(nicks) == limit {
+				next = nextCursor(c, before)
+				return nil
+			}
+			seen[string(v)] = true
+			nicks = append(nicks, string(v))
+			before = k
+		}
+		return nil
+	}); err != nil {
+		return nil, nil, err
+	}
+	return nicks, next, nil
+}
+
+// VersionsBefore returns at most limit prior versions of the nick data of a
+// specific node id in the same order as Versions. If before is nil the
+// versions are returned starting with the most recent one. The returned cursor
+// should be passed as before to retrieve the next versions, it is nil if there
+// are no more versions. The cursors remain valid when new versions are
+// stored.
+func (r *BoltRepository) VersionsBefore(id node.ID, before []byte, limit int) ([]NickData, []byte, error) {
+	if limit < 1 {
+		return nil, nil, errors.New("limit must be positive")
+	}
+	if !node.ValidateId(id) {
+		return nil, nil, InvalidNodeIdErr
+	}
+
+	versions := make([]NickData, 0)
+	var next []byte
+	if err := r.db.View(func(tx *bolt.Tx) error {
+		versionsB := tx.Bucket([]byte(versionsBucket))
+		if versionsB == nil {
+			return nil
+		}
+		idB := versionsB.Bucket(id)
+		if idB == nil {
+			return nil
+		}
+
+		c := idB.Cursor()
+		for k, v := seekBefore(c, before); k != nil; k, v = c.Prev() {
+			if len(versions) == limit {
+				next = nextCursor(c, before)
+				return nil
+			}
+			nickData, err := unmarshalNickData(v)
+			if err != nil {
+				return errors.Wrap(err, "unmarshal failed")
+			}
+			versions = append(versions, *nickData)
+			before = k
+		}
+		return nil
+	}); err != nil {
+		return nil, nil, err
+	}
+	return versions, next, nil
+}
+
+// seekBefore positions the cursor on the last key lower than before or on the
+// last key if before is nil.
+func seekBefore(c *bolt.Cursor, before []byte) ([]byte, []byte) {
+	if before == nil {
+		return c.Last()
+	}
+	k, _ := c.Seek(before)
+	if k == nil {
+		return c.Last()
+	}
+	return c.Prev()
+}
+
+// nextCursor returns the cursor pointing at the entries lower than the last
+// returned key. If no entries were returned from the bucket, eg. because only
+// the current nick was returned, the cursor points at all of them.
+func nextCursor(c *bolt.Cursor, last []byte) []byte {
+	if last != nil {
+		return append([]byte(nil), last...)
+	}
+	k, _ := c.Last()
+	return append(append([]byte(nil), k...), 0)
+}
+
+func (r *BoltRepository) getNickData(tx *bolt.Tx, id node.ID) (*NickData, error) {
+	b := tx.Bucket([]byte(nickDataBucket))
+	v := b.Get(id)
+	if v != nil {
+		return unmarshalNickData(v)
+	}
+	return nil, nil
+}
+
+// migrateLegacyEncoding rewrites the values stored using the legacy JSON
+// encoding using the binary encoding.
+func migrateLegacyEncoding(tx *bolt.Tx) error {
+	b := tx.Bucket([]byte(nickDataBucket))
+
+	migrated := make(map[string][]byte)
+	if err := b.ForEach(func(k, v []byte) error {
+		if !isLegacyEncoding(v) {
+			return nil
+		}
+		nickData, err := unmarshalNickData(v)
+		if err != nil {
+			return errors.Wrap(err, "unmarshal failed")
+		}
+		value, err := marshalNickData(nickData)
+		if err != nil {
+			return errors.Wrap(err, "marshal failed")
+		}
+		migrated[string(k)] = value
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for k, v := range migrated {
+		if err := b.Put([]byte(k), v); err != nil {
+			return errors.Wrap(err, "put failed")
+		}
+	}
+
+	if len(migrated) > 0 {
+		log.Info("migrated the stored values to the binary encoding", "n", len(migrated))
+	}
+	return nil
+}
+
+// PutResult describes how a successful put changed the stored nick data.
+type PutResult string
+
+const (
+	// PutCreated means that the node didn't have any nick data.
+	PutCreated PutResult = "created"
+
+	// PutUpdated means that the previous nick data of the node was
+	// replaced.
+	PutUpdated PutResult = "updated"
+
+	// PutUnchanged means that the stored nick data was byte-identical to
+	// the inserted one.
+	PutUnchanged PutResult = "unchanged"
+)
+
+// PutOptions changes the behaviour of a single put.
+type PutOptions struct {
+	// AllowReserved permits claiming the reserved nicks. It is used to
+	// store the seed records chosen by the operator.
+	AllowReserved bool
+}
+
+// Put inserts a new entry. In case of a nick collision with a different node
+// NickConflictErr is returned. In case the entry is invalid a *ValidationError
+// matching InvalidNickDataErr is returned. In case there is a newer nick data available for this node
+// NewerNickDataPresentErr is returned. In case the nick exceeds the max length
+// of the index keys NickKeyTooLongErr is returned. In case of strict time
+// ordering SameTimeChangeErr is returned if a different nick data with the same
+// time is present. In case the nick is reserved and not held by the node
+// ReservedNickErr is returned.
+func (r *BoltRepository) Put(nickData *NickData) error {
+	_, err := r.PutResult(nickData)
+	return err
+}
+
+// PutResult inserts a new entry the same as Put and reports whether the entry
+// was created, updated or identical to the stored one.
+func (r *BoltRepository) PutResult(nickData *NickData) (PutResult, error) {
+	return r.PutWithOptions(nickData, PutOptions{})
+}
+
+// PutWithOptions inserts a new entry the same as PutResult using the provided
+// options.
+func (r *BoltRepository) PutWithOptions(nickData *NickData, putOptions PutOptions) (PutResult, error) {
+	result, err := r.doPut(nickData, putOptions)
+	r.stats.record(err)
+	return result, err
+}
+
+func (r *BoltRepository) doPut(nickData *NickData, putOptions PutOptions) (PutResult, error) {
+	if r.options.ReadOnly {
+		return "", ReadOnlyErr
+	}
+
+	if err := validatePut(nickData, r.options); err != nil {
+		return "", err
+	}
+
+	var result PutResult
+	if err := r.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		result, err = r.put(tx, nickData, putOptions)
+		return err
+	}); err != nil {
+		if err == NickConflictErr || err == NickQuarantinedErr || err == ReservedNickErr || err == NewerNickDataPresentErr || err == SameTimeChangeErr {
+			return "", err
+		}
+		return "", errors.Wrap(err, "update failed")
+	}
+	return result, nil
+}
+
+// SwapNicks atomically stores two nick datas exchanging the current nicks of
+// two nodes so that neither nick is ever free. Each nick data has to claim the
+// current nick of the other node, otherwise InvalidSwapErr is returned. Both
+// nick datas are validated and have to follow the same time rules as in the
+// case of Put.
+func (r *BoltRepository) SwapNicks(a, b *NickData) error {
+	err := r.swapNicks(a, b)
+	// Both entries are accepted or rejected together
+	r.stats.record(err)
+	r.stats.record(err)
+	return err
+}
+
+func (r *BoltRepository) swapNicks(a, b *NickData) error {
+	if r.options.ReadOnly {
+		return ReadOnlyErr
+	}
+
+	for _, nickData := range []*NickData{a, b} {
+		if err := validatePut(nickData, r.options); err != nil {
+			return err
+		}
+	}
+
+	if node.CompareId(a.Id, b.Id) {
+		return InvalidSwapErr
+	}
+
+	if err := r.db.Update(func(tx *bolt.Tx) error {
+		previousA, err := r.getNickData(tx, a.Id)
+		if err != nil {
+			return errors.Wrap(err, "error retrieving the previous nick data")
+		}
+		previousB, err := r.getNickData(tx, b.Id)
+		if err != nil {
+			return errors.Wrap(err, "error retrieving the previous nick data")
+		}
+		if previousA == nil || previousB == nil || previousA.Nick != b.Nick || previousB.Nick != a.Nick {
+			return InvalidSwapErr
+		}
+
+		// Free both nicks so that they can be claimed
+		nicksB := tx.Bucket([]byte(nicksBucket))
+		for _, nick := range []string{a.Nick, b.Nick} {
+			if err := nicksB.Delete([]byte(nick)); err != nil {
+				return errors.Wrap(err, "nicks bucket delete failed")
+			}
+		}
+
+		if _, err := r.put(tx, a, PutOptions{}); err != nil {
+			return err
+		}
+		_, err = r.put(tx, b, PutOptions{})
+		return err
+	}); err != nil {
+		if err == InvalidSwapErr || err == NickConflictErr || err == NickQuarantinedErr || err == ReservedNickErr || err == NewerNickDataPresentErr || err == SameTimeChangeErr {
+			return err
+		}
+		return errors.Wrap(err, "update failed")
+	}
+	return nil
+}
+
+// ImportSummary describes the result of an import.
+type ImportSummary struct {
+	// Imported is the number of inserted entries.
+	Imported int
+
+	// Older is the number of entries skipped because newer nick data for
+	// the same node was already present.
+	Older int
+
+	// Conflicts is the number of entries skipped because the nick was
+	// already taken by a different node, is quarantined or is reserved.
+	Conflicts int
+
+	// Invalid is the number of entries skipped because they were invalid.
+	Invalid int
+}
+
+// Import inserts the provided entries using semantics suitable for bulk
+// synchronisation. Entries older than the stored ones are skipped instead of
+// causing an error so that importing a set of entries in any order always
+// converges to the newest entry for each node. Invalid and conflicting entries
+// are skipped as well. All entries are inserted in a single transaction.
+func (r *BoltRepository) Import(nickDatas []NickData) (ImportSummary, error) {
+	if r.options.ReadOnly {
+		return ImportSummary{}, ReadOnlyErr
+	}
+
+	var summary ImportSummary
+	if err := r.db.Update(func(tx *bolt.Tx) error {
+		summary = ImportSummary{}
+		for i := range nickDatas {
+			nickData := &nickDatas[i]
+			if err := validatePut(nickData, r.options); err != nil {
+				summary.Invalid++
+				continue
+			}
+
+			switch _, err := r.put(tx, nickData, PutOptions{}); err {
+			case nil:
+				summary.Imported++
+			case NewerNickDataPresentErr, SameTimeChangeErr:
+				summary.Older++
+			case NickConflictErr, NickQuarantinedErr, ReservedNickErr:
+				summary.Conflicts++
+			default:
+				return errors.Wrapf(err, "could not import entry %d", i)
+			}
+		}
+		return nil
+	}); err != nil {
+		return ImportSummary{}, errors.Wrap(err, "update failed")
+	}
+	return summary, nil
+}
+
+// validatePut confirms that the nick data is valid and that its nick can be
+// used as a key in the nick index. A *ValidationError or NickKeyTooLongErr is
+// returned otherwise.
+func validatePut(nickData *NickData, options Options) error {
+	if err := nickData.ValidateWithPolicy(options.NickPolicy); err != nil {
+		return err
+	}
+	if len(nickData.Nick) > options.MaxNickKeyBytes {
+		return NickKeyTooLongErr
+	}
+	return nil
+}
+
+// checkPut confirms that the nick data can replace the previous nick data of
+// its node. The existing id is the id of the node which currently uses the
+// nick, it is nil if the nick is free. The previous nick data is nil if the
+// node has no nick data. NickConflictErr, ReservedNickErr,
+// NewerNickDataPresentErr or SameTimeChangeErr is returned otherwise.
+func checkPut(existingId node.ID, previous, nickData *NickData, options Options, putOptions PutOptions) error {
+	// Confirm that the nick isn't used by a different node
+	if existingId != nil && !node.CompareId(existingId, nickData.Id) {
+		return NickConflictErr
+	}
+
+	// Confirm that the nick isn't reserved unless the node already holds it
+	if existingId == nil && !putOptions.AllowReserved && options.ReservedNicks.IsReserved(nickData.Nick) {
+		return ReservedNickErr
+	}
+
+	// Confirm that there is no newer nick data
+	if previous != nil {
+		if previous.Time.After(nickData.Time) {
+			return NewerNickDataPresentErr
+		}
+		if !options.AllowSameTimeChanges && isSameTimeChange(previous, nickData) {
+			return SameTimeChangeErr
+		}
+	}
+	return nil
+}
+
+// put inserts a new entry within the transaction. The entry must already be
+// validated. NickConflictErr, NickQuarantinedErr, ReservedNickErr,
+// NewerNickDataPresentErr and SameTimeChangeErr are returned before anything
+// is modified so the transaction can be used further if they occur. The entry
+// is unchanged if the encoded value is identical to the stored one.
+func (r *BoltRepository) put(tx *bolt.Tx, nickData *NickData, putOptions PutOptions) (PutResult, error) {
+	value, err := marshalNickData(nickData)
+	if err != nil {
+		return "", errors.Wrap(err, "marshaling nick data failed")
+	}
+	if r.options.CompressValues {
+		value, err = compressValue(value)
+		if err != nil {
+			return "", errors.Wrap(err, "compressing nick data failed")
+		}
+	}
+
+	nicksB := tx.Bucket([]byte(nicksBucket))
+	existingId := nicksB.Get([]byte(nickData.Nick))
+	previousNickData, err := r.getNickData(tx, nickData.Id)
+	if err != nil {
+		return "", errors.Wrap(err, "error retrieving the previous nick data")
+	}
+	if err := checkPut(existingId, previousNickData, nickData, r.options, putOptions); err != nil {
+		return "", err
+	}
+	if existingId == nil && r.isQuarantined(tx, nickData.Nick) {
+		return "", NickQuarantinedErr
+	}
+
+	// Remove the previous nick of this node unless it was already claimed
+	// by a different node, eg. when swapping nicks
+	if previousNickData != nil && previousNickData.Nick != nickData.Nick && node.CompareId(nicksB.Get([]byte(previousNickData.Nick)), nickData.Id) {
+		if err := nicksB.Delete([]byte(previousNickData.Nick)); err != nil {
+			return "", errors.Wrap(err, "nicks bucket delete failed")
+		}
+		if err := r.release(tx, previousNickData.Nick); err != nil {
+			return "", errors.Wrap(err, "could not release the previous nick")
+		}
+	}
+
+	// Insert new nick, the index maps the nicks to the node ids. The
+	// entries keyed by the node ids stored by the first versions are
+	// removed by migrateNickIndex.
+	if err := nicksB.Put([]byte(nickData.Nick), nickData.Id); err != nil {
+		return "", errors.Wrap(err, "nicks bucket put failed")
+	}
+	if err := tx.Bucket([]byte(releasedBucket)).Delete([]byte(nickData.Nick)); err != nil {
+		return "", errors.Wrap(err, "released bucket delete failed")
+	}
+
+	// Record the nick in the history of this node
+	if previousNickData == nil || previousNickData.Nick != nickData.Nick {
+		if err := appendHistory(tx, nickData.Id, nickData.Nick); err != nil {
+			return "", errors.Wrap(err, "could not append to the history")
+		}
+	}
+
+	if err := r.recordFirstSeen(tx, nickData.Id); err != nil {
+		return "", errors.Wrap(err, "could not record the first seen time")
+	}
+
+	if previousNickData == nil {
+		id := nickData.Id
+		tx.OnCommit(func() {
+			r.missing.Invalidate(id)
+		})
+	}
+
+	result := PutUnchanged
+	nickDataB := tx.Bucket([]byte(nickDataBucket))
+	if previousValue := nickDataB.Get(nickData.Id); !bytes.Equal(previousValue, value) {
+		result = PutUpdated
+		if previousValue == nil {
+			result = PutCreated
+		}
+		if previousValue != nil && r.options.MaxVersions > 0 {
+			if err := appendVersion(tx, nickData.Id, previousValue, r.options.MaxVersions); err != nil {
+				return "", errors.Wrap(err, "could not store the previous version")
+			}
+		}
+		if _, err := nickDataB.NextSequence(); err != nil {
+			return "", errors.Wrap(err, "could not increase the revision")
+		}
+	}
+	if err := nickDataB.Put(nickData.Id, value); err != nil {
+		return "", errors.Wrap(err, "nick data bucket put failed")
+	}
+	return result, nil
+}
+
+// release records the time at which the nick was released if the quarantine
+// is enabled.
+func (r *BoltRepository) release(tx *bolt.Tx, nick string) error {
+	if r.options.NickQuarantine <= 0 {
+		return nil
+	}
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, uint64(r.now().Unix()))
+	return tx.Bucket([]byte(releasedBucket)).Put([]byte(nick), value)
+}
+
+// isQuarantined returns true if the nick was released less than NickQuarantine
+// ago.
+func (r *BoltRepository) isQuarantined(tx *bolt.Tx, nick string) bool {
+	if r.options.NickQuarantine <= 0 {
+		return false
+	}
+	value := tx.Bucket([]byte(releasedBucket)).Get([]byte(nick))
+	if len(value) != 8 {
+		return false
+	}
+	releasedAt := time.Unix(int64(binary.BigEndian.Uint64(value)), 0)
+	return r.now().Before(releasedAt.Add(r.options.NickQuarantine))
+}
+
+// recordFirstSeen records the current time as the time at which the node was
+// first seen unless it was already recorded.
+func (r *BoltRepository) recordFirstSeen(tx *bolt.Tx, id node.ID) error {
+	firstSeenB := tx.Bucket([]byte(firstSeenBucket))
+	if firstSeenB.Get(id) != nil {
+		return nil
+	}
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, uint64(r.now().Unix()))
+	return firstSeenB.Put(id, value)
+}
+
+// appendHistory records that the node held the nick. Entries are keyed by a
+// sequence number so that they are iterated in the order of insertion.
+func appendHistory(tx *bolt.Tx, id node.ID, nick string) error {
+	idB, err := tx.Bucket([]byte(historyBucket)).CreateBucketIfNotExists(id)
+	if err != nil {
+		return errors.Wrap(err, "bucket creation failed")
+	}
+	seq, err := idB.NextSequence()
+	if err != nil {
+		return errors.Wrap(err, "could not get the sequence")
+	}
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return idB.Put(key, []byte(nick))
+}
+
+// appendVersion stores the previous value of the nick data removing the oldest
+// versions so that at most max versions are kept. Entries are keyed by a
+// sequence number so that they are iterated in the order of insertion.
+func appendVersion(tx *bolt.Tx, id node.ID, value []byte, max int) error {
+	idB, err := tx.Bucket([]byte(versionsBucket)).CreateBucketIfNotExists(id)
+	if err != nil {
+		return errors.Wrap(err, "bucket creation failed")
+	}
+	seq, err := idB.NextSequence()
+	if err != nil {
+		return errors.Wrap(err, "could not get the sequence")
+	}
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	// The value belongs to the nick data bucket which is about to be
+	// modified
+	if err := idB.Put(key, append([]byte(nil), value...)); err != nil {
+		return errors.Wrap(err, "put failed")
+	}
+
+	var remove [][]byte
+	n := 0
+	c := idB.Cursor()
+	for k, _ := c.Last(); k != nil; k, _ = c.Prev() {
+		n++
+		if n > max {
+			remove = append(remove, append([]byte(nil), k...))
+		}
+	}
+	for _, k := range remove {
+		if err := idB.Delete(k); err != nil {
+			return errors.Wrap(err, "delete failed")
+		}
 	}
 	return nil
 }
 
+// isSameTimeChange returns true if the nick data have the same signed time but
+// a different content.
+func isSameTimeChange(previous, next *NickData) bool {
+	return previous.Time.Unix() == next.Time.Unix() && !bytes.Equal(previous.ContentHash(), next.ContentHash())
+}
+
+// Delete removes the entry for a specific node id together with its nick, its
+// nick history and its prior versions. If the node id is invalid
+// InvalidNodeIdErr is returned. If the entry doesn't exist NotFoundErr is
+// returned.
+func (r *BoltRepository) Delete(id node.ID) error {
+	return r.delete(id, nil)
+}
+
+// DeleteTombstone removes the entry of the node which created the tombstone,
+// the same as Delete, if the time of the tombstone is newer than the time of
+// the stored nick data. Otherwise NewerNickDataPresentErr is returned. The
+// times are compared in the transaction which removes the entry so that nick
+// data stored concurrently is never removed by an older tombstone.
+func (r *BoltRepository) DeleteTombstone(tombstone *Tombstone) error {
+	return r.delete(tombstone.Id, func(nickData *NickData) error {
+		return checkTombstone(tombstone, nickData)
+	})
+}
+
+// delete removes the entry if check, which is optional, doesn't return an
+// error.
+func (r *BoltRepository) delete(id node.ID, check func(nickData *NickData) error) error {
+	if r.options.ReadOnly {
+		return ReadOnlyErr
+	}
+
+	if !node.ValidateId(id) {
+		return InvalidNodeIdErr
+	}
+
+	if err := r.db.Update(func(tx *bolt.Tx) error {
+		nickData, err := r.getNickData(tx, id)
+		if err != nil {
+			return errors.Wrap(err, "error retrieving the nick data")
+		}
+		if nickData == nil {
+			return NotFoundErr
+		}
+		if check != nil {
+			if err := check(nickData); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Bucket([]byte(nicksBucket)).Delete([]byte(nickData.Nick)); err != nil {
+			return errors.Wrap(err, "nicks bucket delete failed")
+		}
+		if err := r.release(tx, nickData.Nick); err != nil {
+			return errors.Wrap(err, "could not release the nick")
+		}
+		nickDataB := tx.Bucket([]byte(nickDataBucket))
+		if err := nickDataB.Delete(id); err != nil {
+			return errors.Wrap(err, "nick data bucket delete failed")
+		}
+		if _, err := nickDataB.NextSequence(); err != nil {
+			return errors.Wrap(err, "could not increase the revision")
+		}
+		if err := tx.Bucket([]byte(historyBucket)).DeleteBucket(id); err != nil && err != bolt.ErrBucketNotFound {
+			return errors.Wrap(err, "history bucket delete failed")
+		}
+		if err := tx.Bucket([]byte(versionsBucket)).DeleteBucket(id); err != nil && err != bolt.ErrBucketNotFound {
+			return errors.Wrap(err, "versions bucket delete failed")
+		}
+		if err := tx.Bucket([]byte(firstSeenBucket)).Delete(id); err != nil {
+			return errors.Wrap(err, "first seen bucket delete failed")
+		}
+		return nil
+	}); err != nil {
+		if err == NotFoundErr || err == NewerNickDataPresentErr {
+			return err
+		}
+		return errors.Wrap(err, "update failed")
+	}
+	return nil
+}
+
+// checkTombstone returns NewerNickDataPresentErr unless the tombstone is
+// strictly newer than the nick data. The times are compared with the precision
+// of the signed data.
+func checkTombstone(tombstone *Tombstone, nickData *NickData) error {
+	if tombstone.Time.Unix() <= nickData.Time.Unix() {
+		return NewerNickDataPresentErr
+	}
+	return nil
+}
+
+// FirstSeen returns the time at which this server first stored the nick data
+// of a specific node id. Unlike the time of the nick data it is set by the
+// server and can't be chosen by the client. A zero time is returned if the
+// node is unknown or its nick data was stored before the time was recorded.
+func (r *BoltRepository) FirstSeen(id node.ID) (time.Time, error) {
+	if !node.ValidateId(id) {
+		return time.Time{}, InvalidNodeIdErr
+	}
+
+	var firstSeen time.Time
+	if err := r.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket([]byte(firstSeenBucket)).Get(id)
+		if len(value) == 8 {
+			firstSeen = time.Unix(int64(binary.BigEndian.Uint64(value)), 0)
+		}
+		return nil
+	}); err != nil {
+		return time.Time{}, errors.Wrap(err, "view failed")
+	}
+	return firstSeen, nil
+}
+
+// Revision returns a number which is increased every time the stored nick
+// data changes. It can be used to detect that the list of nicks is unchanged
+// without retrieving it.
+func (r *BoltRepository) Revision() (uint64, error) {
+	var revision uint64
+	if err := r.db.View(func(tx *bolt.Tx) error {
+		revision = tx.Bucket([]byte(nickDataBucket)).Sequence()
+		return nil
+	}); err != nil {
+		return 0, errors.Wrap(err, "view failed")
+	}
+	return revision, nil
+}
+
+// Backup atomically replaces the file located at the provided path with a
+// consistent copy of the database. The copy is created within a read-only
+// transaction so writes aren't blocked.
+func (r *BoltRepository) Backup(path string) error {
+	tmp := path + ".tmp"
+	if err := r.db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(tmp, 0600)
+	}); err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(err, "could not copy the database")
+	}
+	return os.Rename(tmp, path)
+}
+
+// Ping confirms that the database can be accessed. Unless the repository is
+// read-only an empty read-write transaction is committed so that a database
+// which can be read but not written is reported as well.
+func (r *BoltRepository) Ping() error {
+	if r.options.ReadOnly {
+		return r.db.View(func(tx *bolt.Tx) error {
+			return nil
+		})
+	}
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return nil
+	})
+}
+
 // Close closes the database.
 func (r *BoltRepository) Close() error {
-	return r.db.Close()
+	err := r.db.Close()
+	if r.lock != nil {
+		if lockErr := r.lock.Release(); lockErr != nil && err == nil {
+			err = lockErr
+		}
+		r.lock = nil
+	}
+	if r.snapshot != "" {
+		if removeErr := os.Remove(r.snapshot); removeErr != nil && err == nil {
+			err = removeErr
+		}
+		r.snapshot = ""
+	}
+	return err
 }
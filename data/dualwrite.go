@@ -0,0 +1,148 @@
+package data
+
+import (
+	"github.com/boreq/starlight/network/node"
+)
+
+// DualWriteRepository writes to two repositories and reads from the primary
+// one. It is used to migrate the data between storage engines without
+// downtime: the secondary repository receives all writes while the primary
+// one keeps serving the reads. Failures of the secondary repository are only
+// logged.
+//
+// The primary repository is embedded so that the optional methods, eg. the
+// history, pagination, backups or consistency checks, are served by it the
+// same as without the secondary repository. All methods which modify the
+// stored data are overridden so that the writes reach both repositories.
+type DualWriteRepository struct {
+	*BoltRepository
+	secondary Repository
+}
+
+// NewDualWriteRepository wraps the repositories.
+func NewDualWriteRepository(primary *BoltRepository, secondary Repository) *DualWriteRepository {
+	return &DualWriteRepository{
+		BoltRepository: primary,
+		secondary:      secondary,
+	}
+}
+
+// Put writes to the secondary repository only if writing to the primary one
+// succeeded so that the secondary repository doesn't receive the data
+// rejected by the primary one.
+func (r *DualWriteRepository) Put(nickData *NickData) error {
+	if err := r.BoltRepository.Put(nickData); err != nil {
+		return err
+	}
+	r.putSecondary(nickData)
+	return nil
+}
+
+// PutResult reports the result of writing to the primary repository.
+func (r *DualWriteRepository) PutResult(nickData *NickData) (PutResult, error) {
+	result, err := r.BoltRepository.PutResult(nickData)
+	if err != nil {
+		return "", err
+	}
+	r.putSecondary(nickData)
+	return result, nil
+}
+
+// PutWithOptions writes to the secondary repository using the same options if
+// it supports them and using Put otherwise.
+func (r *DualWriteRepository) PutWithOptions(nickData *NickData, putOptions PutOptions) (PutResult, error) {
+	result, err := r.BoltRepository.PutWithOptions(nickData, putOptions)
+	if err != nil {
+		return "", err
+	}
+	if secondary, ok := r.secondary.(interface {
+		PutWithOptions(nickData *NickData, putOptions PutOptions) (PutResult, error)
+	}); ok {
+		if _, err := secondary.PutWithOptions(nickData, putOptions); err != nil {
+			log.Error("secondary put failed", "nick", nickData.Nick, "err", err)
+		}
+		return result, nil
+	}
+	r.putSecondary(nickData)
+	return result, nil
+}
+
+// SwapNicks writes both nick datas to the secondary repository using Put if
+// it can't swap the nicks itself.
+func (r *DualWriteRepository) SwapNicks(a, b *NickData) error {
+	if err := r.BoltRepository.SwapNicks(a, b); err != nil {
+		return err
+	}
+	if secondary, ok := r.secondary.(interface {
+		SwapNicks(a, b *NickData) error
+	}); ok {
+		if err := secondary.SwapNicks(a, b); err != nil {
+			log.Error("secondary swap failed", "a", a.Nick, "b", b.Nick, "err", err)
+		}
+		return nil
+	}
+	r.putSecondary(a)
+	r.putSecondary(b)
+	return nil
+}
+
+// Import writes the entries to the secondary repository using Put if it can't
+// import them itself. The summary reported by the primary repository is
+// returned.
+func (r *DualWriteRepository) Import(nickDatas []NickData) (ImportSummary, error) {
+	summary, err := r.BoltRepository.Import(nickDatas)
+	if err != nil {
+		return ImportSummary{}, err
+	}
+	if secondary, ok := r.secondary.(interface {
+		Import(nickDatas []NickData) (ImportSummary, error)
+	}); ok {
+		if _, err := secondary.Import(nickDatas); err != nil {
+			log.Error("secondary import failed", "err", err)
+		}
+		return summary, nil
+	}
+	for i := range nickDatas {
+		if err := r.secondary.Put(&nickDatas[i]); err != nil {
+			log.Debug("secondary import put failed", "nick", nickDatas[i].Nick, "err", err)
+		}
+	}
+	return summary, nil
+}
+
+func (r *DualWriteRepository) Delete(id node.ID) error {
+	if err := r.BoltRepository.Delete(id); err != nil {
+		return err
+	}
+	if err := r.secondary.Delete(id); err != nil {
+		log.Error("secondary delete failed", "id", id, "err", err)
+	}
+	return nil
+}
+
+// DeleteTombstone removes the entry from the secondary repository only if the
+// primary one accepted the tombstone.
+func (r *DualWriteRepository) DeleteTombstone(tombstone *Tombstone) error {
+	if err := r.BoltRepository.DeleteTombstone(tombstone); err != nil {
+		return err
+	}
+	if err := r.secondary.DeleteTombstone(tombstone); err != nil {
+		log.Error("secondary delete failed", "id", tombstone.Id, "err", err)
+	}
+	return nil
+}
+
+// Close closes both repositories and returns the error returned by the
+// primary repository.
+func (r *DualWriteRepository) Close() error {
+	if err := r.secondary.Close(); err != nil {
+		log.Error("secondary close failed", "err", err)
+	}
+	return r.BoltRepository.Close()
+}
+
+func (r *DualWriteRepository) putSecondary(nickData *NickData) {
+	if err := r.secondary.Put(nickData); err != nil {
+		log.Error("secondary put failed", "nick", nickData.Nick, "err", err)
+	}
+}
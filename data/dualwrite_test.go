@@ -0,0 +1,101 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDualWriteRepositoryPut(t *testing.T) {
+	// given
+	primary, cleanupPrimary := makeBoltRepository(t)
+	defer cleanupPrimary()
+
+	secondary, cleanupSecondary := makeBoltRepository(t)
+	defer cleanupSecondary()
+
+	r := NewDualWriteRepository(primary, secondary)
+	nickData := makeValidNickData()
+
+	// when
+	err := r.Put(nickData)
+
+	// then
+	require.NoError(t, err, "put should not fail")
+	for _, b := range []*BoltRepository{primary, secondary} {
+		result, err := b.Get(nickData.Id)
+		require.NoError(t, err, "get should not fail")
+		require.NotNil(t, result, "nick data should be stored in both repositories")
+	}
+}
+
+func TestDualWriteRepositorySecondaryFailure(t *testing.T) {
+	// given
+	primary, cleanupPrimary := makeBoltRepository(t)
+	defer cleanupPrimary()
+
+	secondaryBolt, cleanupSecondary := makeBoltRepository(t)
+	defer cleanupSecondary()
+
+	secondary := NewFaultInjectingRepository(secondaryBolt)
+	secondary.FailNthCall(MethodPut, 1, errors.New("secondary failure"))
+
+	r := NewDualWriteRepository(primary, secondary)
+	nickData := makeValidNickData()
+
+	// when
+	err := r.Put(nickData)
+
+	// then
+	require.NoError(t, err, "secondary failure should not fail the put")
+
+	result, err := r.Get(nickData.Id)
+	require.NoError(t, err, "get should not fail")
+	require.NotNil(t, result, "nick data should be stored in the primary repository")
+}
+
+func TestDualWriteRepositoryPrimaryFailure(t *testing.T) {
+	// given
+	primary, cleanupPrimary := makeBoltRepository(t)
+	defer cleanupPrimary()
+
+	secondary, cleanupSecondary := makeBoltRepository(t)
+	defer cleanupSecondary()
+
+	r := NewDualWriteRepository(primary, secondary)
+	nickData := makeValidNickData()
+	nickData.Nick = "changed"
+
+	// when
+	err := r.Put(nickData)
+
+	// then
+	require.True(t, errors.Is(err, InvalidNickDataErr), "primary failure should be returned")
+
+	result, err := secondary.Get(nickData.Id)
+	require.NoError(t, err, "get should not fail")
+	require.Nil(t, result, "nick data should not be written to the secondary repository")
+}
+
+func TestDualWriteRepositoryImport(t *testing.T) {
+	// given
+	primary, cleanupPrimary := makeBoltRepository(t)
+	defer cleanupPrimary()
+
+	secondary := NewMemoryRepository(DefaultOptions())
+
+	r := NewDualWriteRepository(primary, secondary)
+	nickData := makeValidNickData()
+
+	// when
+	summary, err := r.Import([]NickData{*nickData})
+
+	// then
+	require.NoError(t, err)
+	require.Equal(t, 1, summary.Imported)
+
+	result, err := secondary.Get(nickData.Id)
+	require.NoError(t, err)
+	require.NotNil(t, result, "imported entries should be written to the secondary repository")
+}
@@ -0,0 +1,265 @@
+package data
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// encodingVersionBinary is the first byte of the values encoded using the
+// binary format without the number of fields. Such values are no longer
+// written but can still be decoded. Values stored by older versions of the
+// program are JSON objects and therefore always start with '{'.
+const encodingVersionBinary byte = 1
+
+// encodingVersionCompressed is the first byte of the values which consist of
+// a value encoded using the binary format compressed using DEFLATE, the
+// algorithm used by gzip, without the gzip header.
+const encodingVersionCompressed byte = 2
+
+// encodingVersionFieldCount is the first byte of every value encoded using
+// the binary format. It is followed by the number of fields so that
+// truncated values can be detected.
+const encodingVersionFieldCount byte = 3
+
+// legacyBinaryFields is the number of fields present in every value encoded
+// using encodingVersionBinary. The fields appended later are optional as
+// those values don't store the number of fields.
+const legacyBinaryFields = 6
+
+// maxDecompressedValueBytes limits the size of decompressed values so that
+// a corrupted value can't exhaust the memory.
+const maxDecompressedValueBytes = 1 << 20
+
+// jsonObjectPrefix is the first byte of the values stored using the legacy
+// JSON encoding.
+const jsonObjectPrefix byte = '{'
+
+// marshalNickData encodes the nick data using a compact binary format. The
+// format consists of a version byte and the number of fields followed by a
+// sequence of length-prefixed fields. New fields must always be appended at
+// the end so that the values written by older versions of the program can
+// still be decoded.
+func marshalNickData(nickData *NickData) ([]byte, error) {
+	t, err := nickData.Time.MarshalBinary()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not marshal the time")
+	}
+
+	fields := [][]byte{
+		nickData.Id,
+		[]byte(nickData.Nick),
+		t,
+		nickData.PublicKey,
+		nickData.Signature,
+		encodeUvarint(uint64(nickData.Version)),
+		[]byte(nickData.DisplayName),
+		nickData.Challenge,
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteByte(encodingVersionFieldCount)
+	buf.Write(encodeUvarint(uint64(len(fields))))
+	for _, field := range fields {
+		writeField(buf, field)
+	}
+	return buf.Bytes(), nil
+}
+
+// compressValue compresses the value encoded using marshalNickData. The
+// value is returned unchanged if compressing it doesn't make it smaller which
+// is often the case as most of it consists of the public key and the
+// signature which don't compress well.
+func compressValue(value []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteByte(encodingVersionCompressed)
+	w, err := flate.NewWriter(buf, flate.BestCompression)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create the writer")
+	}
+	if _, err := w.Write(value); err != nil {
+		return nil, errors.Wrap(err, "write failed")
+	}
+	if err := w.Close(); err != nil {
+		return nil, errors.Wrap(err, "close failed")
+	}
+	if buf.Len() >= len(value) {
+		return value, nil
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressValue reverses compressValue.
+func decompressValue(data []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	value, err := ioutil.ReadAll(io.LimitReader(r, maxDecompressedValueBytes+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "read failed")
+	}
+	if len(value) > maxDecompressedValueBytes {
+		return nil, errors.New("decompressed value is too large")
+	}
+	if len(value) == 0 || (value[0] != encodingVersionBinary && value[0] != encodingVersionFieldCount) {
+		return nil, errors.New("compressed value doesn't use the binary encoding")
+	}
+	return value, nil
+}
+
+// unmarshalNickData decodes the nick data encoded using marshalNickData,
+// compressed using compressValue or encoded using the legacy JSON format.
+func unmarshalNickData(data []byte) (*NickData, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty value")
+	}
+
+	switch data[0] {
+	case encodingVersionBinary, encodingVersionFieldCount:
+		return unmarshalBinaryNickData(data)
+	case encodingVersionCompressed:
+		value, err := decompressValue(data[1:])
+		if err != nil {
+			return nil, errors.Wrap(err, "decompression failed")
+		}
+		return unmarshalBinaryNickData(value)
+	case jsonObjectPrefix:
+		nickData := &NickData{}
+		if err := json.Unmarshal(data, nickData); err != nil {
+			return nil, errors.Wrap(err, "json unmarshal failed")
+		}
+		return nickData, nil
+	default:
+		return nil, errors.Errorf("unknown encoding version %d", data[0])
+	}
+}
+
+// unmarshalBinaryNickData decodes a value starting with encodingVersionBinary
+// or encodingVersionFieldCount. All fields stored in the value have to be
+// present, unknown fields appended by newer versions of the program are
+// ignored.
+func unmarshalBinaryNickData(data []byte) (*NickData, error) {
+	r := bytes.NewReader(data[1:])
+	nickData := &NickData{}
+
+	fields := []func(b []byte) error{
+		func(b []byte) error {
+			nickData.Id = b
+			return nil
+		},
+		func(b []byte) error {
+			nickData.Nick = string(b)
+			return nil
+		},
+		func(b []byte) error {
+			return nickData.Time.UnmarshalBinary(b)
+		},
+		func(b []byte) error {
+			nickData.PublicKey = b
+			return nil
+		},
+		func(b []byte) error {
+			nickData.Signature = b
+			return nil
+		},
+		func(b []byte) error {
+			version, err := decodeUvarint(b)
+			if err != nil {
+				return err
+			}
+			nickData.Version = int(version)
+			return nil
+		},
+		func(b []byte) error {
+			nickData.DisplayName = string(b)
+			return nil
+		},
+		func(b []byte) error {
+			if len(b) > 0 {
+				nickData.Challenge = b
+			}
+			return nil
+		},
+	}
+
+	var count uint64
+	if data[0] == encodingVersionFieldCount {
+		c, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read the number of fields")
+		}
+		if c < legacyBinaryFields {
+			return nil, errors.Errorf("expected at least %d fields, got %d", legacyBinaryFields, c)
+		}
+		count = c
+	}
+
+	for i := 0; count == 0 || uint64(i) < count; i++ {
+		b, err := readField(r)
+		if err != nil {
+			if err == io.EOF && count == 0 && i >= legacyBinaryFields {
+				break
+			}
+			return nil, errors.Wrapf(err, "could not read field %d", i)
+		}
+		if i < len(fields) {
+			if err := fields[i](b); err != nil {
+				return nil, errors.Wrapf(err, "could not decode field %d", i)
+			}
+		}
+	}
+
+	if r.Len() != 0 {
+		return nil, errors.New("trailing data after the last field")
+	}
+	return nickData, nil
+}
+
+func writeField(buf *bytes.Buffer, b []byte) {
+	buf.Write(encodeUvarint(uint64(len(b))))
+	buf.Write(b)
+}
+
+func encodeUvarint(v uint64) []byte {
+	b := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(b, v)
+	return b[:n]
+}
+
+func decodeUvarint(b []byte) (uint64, error) {
+	v, n := binary.Uvarint(b)
+	if n <= 0 || n != len(b) {
+		return 0, errors.New("invalid varint")
+	}
+	return v, nil
+}
+
+// readField reads a single length-prefixed field. It returns io.EOF if there
+// are no more fields to read.
+func readField(r *bytes.Reader) ([]byte, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, errors.Wrap(err, "could not read the length")
+	}
+	if length > uint64(r.Len()) {
+		return nil, errors.New("field length exceeds the remaining data")
+	}
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, errors.Wrap(err, "could not read the field")
+	}
+	return b, nil
+}
+
+// isLegacyEncoding returns true if the value was stored using the legacy JSON
+// encoding and has to be migrated.
+func isLegacyEncoding(data []byte) bool {
+	return len(data) > 0 && data[0] == jsonObjectPrefix
+}
@@ -0,0 +1,300 @@
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/boreq/starlight/network/node"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodingRoundTrip(t *testing.T) {
+	// given
+	nickData := makeValidNickData()
+
+	// when
+	value, err := marshalNickData(nickData)
+	require.NoError(t, err, "marshal should not fail")
+
+	result, err := unmarshalNickData(value)
+	require.NoError(t, err, "unmarshal should not fail")
+
+	// then
+	require.Equal(t, encodingVersionFieldCount, value[0], "value should start with the version byte")
+	require.Equal(t, nickData.Id, result.Id)
+	require.Equal(t, nickData.Nick, result.Nick)
+	require.True(t, nickData.Time.Equal(result.Time), "time should be preserved")
+	require.Equal(t, nickData.PublicKey, result.PublicKey)
+	require.Equal(t, nickData.Signature, result.Signature)
+	require.NoError(t, result.Validate(), "decoded data should be valid")
+}
+
+func TestEncodingRoundTripDisplayName(t *testing.T) {
+	// given
+	nickData := makeValidNickData()
+	nickData.Version = VersionDisplayName
+	nickData.DisplayName = "Display Name"
+	nickData = withValidSignature(nickData)
+
+	// when
+	value, err := marshalNickData(nickData)
+	require.NoError(t, err, "marshal should not fail")
+
+	result, err := unmarshalNickData(value)
+	require.NoError(t, err, "unmarshal should not fail")
+
+	// then
+	require.Equal(t, nickData.Version, result.Version)
+	require.Equal(t, nickData.DisplayName, result.DisplayName)
+	require.NoError(t, result.Validate(), "decoded data should be valid")
+}
+
+func TestEncodingRoundTripChallenge(t *testing.T) {
+	// given
+	nickData := makeValidNickData()
+	nickData.Version = VersionChallenge
+	nickData.Challenge = []byte("challenge")
+	nickData = withValidSignature(nickData)
+
+	// when
+	value, err := marshalNickData(nickData)
+	require.NoError(t, err, "marshal should not fail")
+
+	result, err := unmarshalNickData(value)
+	require.NoError(t, err, "unmarshal should not fail")
+
+	// then
+	require.Equal(t, nickData.Challenge, result.Challenge)
+	require.NoError(t, result.Validate(), "decoded data should be valid")
+}
+
+func TestEncodingIsSmallerThanJson(t *testing.T) {
+	// given
+	nickData := makeValidNickData()
+
+	// when
+	binaryValue, err := marshalNickData(nickData)
+	require.NoError(t, err, "marshal should not fail")
+
+	jsonValue, err := json.Marshal(nickData)
+	require.NoError(t, err, "json marshal should not fail")
+
+	// then
+	t.Logf("binary: %d bytes, json: %d bytes", len(binaryValue), len(jsonValue))
+	require.True(t, len(binaryValue) < len(jsonValue)*3/4, "binary encoding should be noticeably smaller")
+}
+
+func TestEncodingDecodesLegacyJson(t *testing.T) {
+	// given
+	nickData := makeValidNickData()
+
+	jsonValue, err := json.Marshal(nickData)
+	require.NoError(t, err, "json marshal should not fail")
+
+	// when
+	result, err := unmarshalNickData(jsonValue)
+
+	// then
+	require.NoError(t, err, "unmarshal should not fail")
+	require.NoError(t, result.Validate(), "decoded data should be valid")
+}
+
+func TestEncodingTruncated(t *testing.T) {
+	// given
+	value, err := marshalNickData(makeValidNickData())
+	require.NoError(t, err, "marshal should not fail")
+
+	// when
+	_, err = unmarshalNickData(value[:len(value)-1])
+
+	// then
+	require.Error(t, err, "truncated values should be rejected")
+}
+
+func TestEncodingDecodesLegacyBinary(t *testing.T) {
+	// given
+	nickData := makeValidNickData()
+
+	// when
+	result, err := unmarshalNickData(marshalLegacyBinaryNickData(t, nickData))
+
+	// then
+	require.NoError(t, err, "unmarshal should not fail")
+	require.Equal(t, nickData.Nick, result.Nick)
+	require.NoError(t, result.Validate(), "decoded data should be valid")
+}
+
+func TestEncodingLegacyBinaryTruncated(t *testing.T) {
+	// given
+	value := marshalLegacyBinaryNickData(t, makeValidNickData())
+
+	// when
+	_, err := unmarshalNickData(value[:len(value)-1])
+
+	// then
+	require.Error(t, err, "values missing the required fields should be rejected")
+}
+
+// marshalLegacyBinaryNickData encodes the nick data the way the first
+// version of the binary encoding did which didn't store the number of fields.
+func marshalLegacyBinaryNickData(t *testing.T, nickData *NickData) []byte {
+	tm, err := nickData.Time.MarshalBinary()
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	buf.WriteByte(encodingVersionBinary)
+	writeField(buf, nickData.Id)
+	writeField(buf, []byte(nickData.Nick))
+	writeField(buf, tm)
+	writeField(buf, nickData.PublicKey)
+	writeField(buf, nickData.Signature)
+	writeField(buf, encodeUvarint(uint64(nickData.Version)))
+	return buf.Bytes()
+}
+
+func TestBoltRepositoryMigratesLegacyEncoding(t *testing.T) {
+	// given
+	b, cleanup := makeBoltRepository(t)
+	defer cleanup()
+
+	nickData := makeValidNickData()
+
+	jsonValue, err := json.Marshal(nickData)
+	require.NoError(t, err, "json marshal should not fail")
+
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		// Databases storing legacy values don't store the schema version
+		if err := tx.DeleteBucket([]byte(metaBucket)); err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(nickDataBucket)).Put(nickData.Id, jsonValue)
+	})
+	require.NoError(t, err, "inserting a legacy value should not fail")
+
+	// when
+	b = reopenBoltRepository(t, b)
+	defer b.Close()
+
+	// then
+	err = b.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(nickDataBucket)).Get(nickData.Id)
+		require.Equal(t, encodingVersionFieldCount, v[0], "value should be migrated")
+		return nil
+	})
+	require.NoError(t, err)
+
+	result, err := b.Get(nickData.Id)
+	require.NoError(t, err, "get should not fail")
+	require.NoError(t, result.Validate(), "migrated data should be valid")
+}
+
+func TestEncodingRoundTripCompressed(t *testing.T) {
+	// given
+	nickData := makeSignedCompressibleNickData(t, makeGeneratedIdentity(t), "nick")
+
+	value, err := marshalNickData(nickData)
+	require.NoError(t, err, "marshal should not fail")
+
+	// when
+	compressed, err := compressValue(value)
+	require.NoError(t, err, "compress should not fail")
+
+	result, err := unmarshalNickData(compressed)
+	require.NoError(t, err, "unmarshal should not fail")
+
+	// then
+	t.Logf("uncompressed: %d bytes, compressed: %d bytes", len(value), len(compressed))
+	require.Equal(t, encodingVersionCompressed, compressed[0], "value should start with the version byte")
+	require.True(t, len(compressed) < len(value), "compressed value should be smaller")
+	require.Equal(t, nickData.DisplayName, result.DisplayName)
+	require.NoError(t, result.Validate(), "decoded data should be valid")
+}
+
+func TestEncodingCompressedRejectsNestedCompression(t *testing.T) {
+	// given
+	value, err := marshalNickData(makeSignedCompressibleNickData(t, makeGeneratedIdentity(t), "nick"))
+	require.NoError(t, err, "marshal should not fail")
+
+	compressed, err := compressValue(value)
+	require.NoError(t, err, "compress should not fail")
+
+	nested, err := compressValue(append(compressed, bytes.Repeat([]byte{0}, 100)...))
+	require.NoError(t, err, "compress should not fail")
+	require.Equal(t, encodingVersionCompressed, nested[0], "value should be compressed")
+
+	// when
+	_, err = unmarshalNickData(nested)
+
+	// then
+	require.Error(t, err, "only binary values should be accepted after decompression")
+}
+
+func TestBoltRepositoryReadsMixedCompressedAndUncompressedValues(t *testing.T) {
+	// given
+	b, cleanup := makeBoltRepository(t)
+	defer cleanup()
+
+	uncompressed := makeSignedCompressibleNickData(t, makeGeneratedIdentity(t), "nick1")
+	require.NoError(t, b.Put(uncompressed), "put should not fail")
+
+	b.options.CompressValues = true
+	b = reopenBoltRepository(t, b)
+	defer b.Close()
+
+	compressed := makeSignedCompressibleNickData(t, makeGeneratedIdentity(t), "nick2")
+	require.NoError(t, b.Put(compressed), "put should not fail")
+
+	// when
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(nickDataBucket))
+		require.Equal(t, encodingVersionFieldCount, bucket.Get(uncompressed.Id)[0], "old value should not be rewritten")
+		require.Equal(t, encodingVersionCompressed, bucket.Get(compressed.Id)[0], "new value should be compressed")
+		return nil
+	})
+	require.NoError(t, err)
+
+	// then
+	for _, nickData := range []*NickData{uncompressed, compressed} {
+		result, err := b.Get(nickData.Id)
+		require.NoError(t, err, "get should not fail")
+		require.Equal(t, nickData.DisplayName, result.DisplayName)
+	}
+}
+
+func BenchmarkCompressValue(b *testing.B) {
+	value, err := marshalNickData(makeValidNickData())
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	compressed, err := compressValue(value)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Logf("uncompressed: %d bytes, compressed: %d bytes", len(value), len(compressed))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := compressValue(value); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// makeSignedCompressibleNickData returns nick data with a display name and a
+// challenge which make compressing it worthwhile.
+func makeSignedCompressibleNickData(t *testing.T, iden *node.Identity, nick string) *NickData {
+	nickData := makeSignedNickData(t, iden, nick, time.Now())
+	nickData.Version = VersionChallenge
+	nickData.DisplayName = strings.Repeat("a", maxDisplayNameLength)
+	nickData.Challenge = make([]byte, maxChallengeLength)
+
+	signature, err := iden.PrivKey.Sign(nickData.GetDataToSign(), SigningHash)
+	require.NoError(t, err)
+	nickData.Signature = signature
+	return nickData
+}
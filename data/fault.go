@@ -0,0 +1,171 @@
+package data
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/boreq/starlight/network/node"
+)
+
+// Names of the methods of Repository in which faults can be injected.
+const (
+	MethodList      = "List"
+	MethodGet       = "Get"
+	MethodGetByNick = "GetByNick"
+	MethodPut       = "Put"
+	MethodDelete    = "Delete"
+	MethodPing      = "Ping"
+	MethodClose     = "Close"
+)
+
+// FaultInjectingRepository delegates to the underlying repository but can be
+// configured to fail or delay the calls. It is meant to be used in tests which
+// exercise the handling of errors which are hard to trigger using a real
+// database. Only the methods of Repository are wrapped, the optional methods
+// implemented by the underlying repository are hidden.
+type FaultInjectingRepository struct {
+	repository Repository
+
+	mutex         sync.Mutex
+	calls         map[string]int
+	nthCallFaults map[string]map[int]error
+	intermittent  map[string]intermittentFault
+	latency       map[string]time.Duration
+	rand          *rand.Rand
+}
+
+type intermittentFault struct {
+	probability float64
+	err         error
+}
+
+// NewFaultInjectingRepository wraps the repository. No faults are injected
+// until they are configured.
+func NewFaultInjectingRepository(repository Repository) *FaultInjectingRepository {
+	return &FaultInjectingRepository{
+		repository:    repository,
+		calls:         make(map[string]int),
+		nthCallFaults: make(map[string]map[int]error),
+		intermittent:  make(map[string]intermittentFault),
+		latency:       make(map[string]time.Duration),
+		rand:          rand.New(rand.NewSource(1)),
+	}
+}
+
+// FailNthCall makes the nth call of the method, counting from one, return the
+// error without calling the underlying repository.
+func (r *FaultInjectingRepository) FailNthCall(method string, n int, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.nthCallFaults[method] == nil {
+		r.nthCallFaults[method] = make(map[int]error)
+	}
+	r.nthCallFaults[method][n] = err
+}
+
+// FailIntermittently makes the calls of the method return the error with the
+// given probability. The pseudo-random sequence is seeded with a constant so
+// that the tests are repeatable. A probability of zero disables the fault.
+func (r *FaultInjectingRepository) FailIntermittently(method string, probability float64, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if probability <= 0 {
+		delete(r.intermittent, method)
+		return
+	}
+	r.intermittent[method] = intermittentFault{probability: probability, err: err}
+}
+
+// SetLatency delays all calls of the method by the given duration. A duration
+// of zero disables the delay.
+func (r *FaultInjectingRepository) SetLatency(method string, latency time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.latency[method] = latency
+}
+
+// Calls returns the number of times the method was called, including the calls
+// which failed due to injected faults.
+func (r *FaultInjectingRepository) Calls(method string) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.calls[method]
+}
+
+// inject records the call and returns the fault which should be returned
+// instead of calling the underlying repository, if any.
+func (r *FaultInjectingRepository) inject(method string) error {
+	r.mutex.Lock()
+	r.calls[method]++
+	err := r.nthCallFaults[method][r.calls[method]]
+	if fault, ok := r.intermittent[method]; ok && err == nil {
+		if r.rand.Float64() < fault.probability {
+			err = fault.err
+		}
+	}
+	latency := r.latency[method]
+	r.mutex.Unlock()
+
+	if latency > 0 {
+		<-time.After(latency)
+	}
+	return err
+}
+
+func (r *FaultInjectingRepository) List(ctx context.Context) ([]NickData, error) {
+	if err := r.inject(MethodList); err != nil {
+		return nil, err
+	}
+	return r.repository.List(ctx)
+}
+
+func (r *FaultInjectingRepository) Get(id node.ID) (*NickData, error) {
+	if err := r.inject(MethodGet); err != nil {
+		return nil, err
+	}
+	return r.repository.Get(id)
+}
+
+func (r *FaultInjectingRepository) GetByNick(nick string) (*NickData, error) {
+	if err := r.inject(MethodGetByNick); err != nil {
+		return nil, err
+	}
+	return r.repository.GetByNick(nick)
+}
+
+func (r *FaultInjectingRepository) Put(nickData *NickData) error {
+	if err := r.inject(MethodPut); err != nil {
+		return err
+	}
+	return r.repository.Put(nickData)
+}
+
+func (r *FaultInjectingRepository) Delete(id node.ID) error {
+	if err := r.inject(MethodDelete); err != nil {
+		return err
+	}
+	return r.repository.Delete(id)
+}
+
+func (r *FaultInjectingRepository) DeleteTombstone(tombstone *Tombstone) error {
+	if err := r.inject(MethodDelete); err != nil {
+		return err
+	}
+	return r.repository.DeleteTombstone(tombstone)
+}
+
+func (r *FaultInjectingRepository) Ping() error {
+	if err := r.inject(MethodPing); err != nil {
+		return err
+	}
+	return r.repository.Ping()
+}
+
+func (r *FaultInjectingRepository) Close() error {
+	if err := r.inject(MethodClose); err != nil {
+		return err
+	}
+	return r.repository.Close()
+}
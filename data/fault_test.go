@@ -0,0 +1,77 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaultInjectingRepositoryFailNthCall(t *testing.T) {
+	// given
+	b, cleanup := makeBoltRepository(t)
+	defer cleanup()
+
+	r := NewFaultInjectingRepository(b)
+	transientErr := errors.New("transient error")
+	r.FailNthCall(MethodPut, 1, transientErr)
+
+	nickData := makeValidNickData()
+
+	// when
+	firstErr := r.Put(nickData)
+	secondErr := r.Put(nickData)
+
+	// then
+	require.Equal(t, transientErr, firstErr, "first put should fail")
+	require.NoError(t, secondErr, "retried put should succeed")
+	require.Equal(t, 2, r.Calls(MethodPut))
+
+	result, err := r.Get(nickData.Id)
+	require.NoError(t, err, "get should not fail")
+	require.NotNil(t, result, "retried put should be stored")
+}
+
+func TestFaultInjectingRepositoryFailIntermittently(t *testing.T) {
+	// given
+	b, cleanup := makeBoltRepository(t)
+	defer cleanup()
+
+	r := NewFaultInjectingRepository(b)
+	someErr := errors.New("some error")
+	r.FailIntermittently(MethodGet, 0.5, someErr)
+
+	// when
+	failures := 0
+	for i := 0; i < 100; i++ {
+		if _, err := r.Get(makeValidNickData().Id); err != nil {
+			require.Equal(t, someErr, err)
+			failures++
+		}
+	}
+
+	// then
+	require.True(t, failures > 0 && failures < 100, "some calls should fail, failed: %d", failures)
+
+	r.FailIntermittently(MethodGet, 0, nil)
+	_, err := r.Get(makeValidNickData().Id)
+	require.NoError(t, err, "fault should be disabled")
+}
+
+func TestFaultInjectingRepositoryLatency(t *testing.T) {
+	// given
+	b, cleanup := makeBoltRepository(t)
+	defer cleanup()
+
+	r := NewFaultInjectingRepository(b)
+	r.SetLatency(MethodGetByNick, 50*time.Millisecond)
+
+	// when
+	start := time.Now()
+	_, err := r.GetByNick("nick")
+
+	// then
+	require.NoError(t, err, "get by nick should not fail")
+	require.True(t, time.Since(start) >= 50*time.Millisecond, "call should be delayed")
+}
@@ -0,0 +1,263 @@
+package data
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/boreq/starlight/network/node"
+	"github.com/pkg/errors"
+)
+
+// Follower is a read-only repository serving a local copy of a database which
+// is periodically reloaded from a source, eg. a backup created by the primary.
+// All writes fail with ReadOnlyErr.
+type Follower struct {
+	source  string
+	path    string
+	options Options
+
+	lock       sync.RWMutex
+	repository *BoltRepository
+}
+
+// NewFollower copies the database located at the source path to the provided
+// path and opens it in read-only mode.
+func NewFollower(source, path string, options Options) (*Follower, error) {
+	options.ReadOnly = true
+
+	f := &Follower{
+		source:  source,
+		path:    path,
+		options: options,
+	}
+
+	if err := f.Reload(); err != nil {
+		return nil, errors.Wrap(err, "initial reload failed")
+	}
+	return f, nil
+}
+
+// Run reloads the database in the provided interval until the context is
+// cancelled.
+func (f *Follower) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := f.Reload(); err != nil {
+				log.Error("follower reload failed", "err", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Reload replaces the local copy of the database with the current version of
+// the source database.
+func (f *Follower) Reload() error {
+	if err := copyFile(f.source, f.path); err != nil {
+		return errors.Wrap(err, "could not copy the database")
+	}
+
+	repository, err := NewBoltRepository(f.path, f.options)
+	if err != nil {
+		return errors.Wrap(err, "could not open the database")
+	}
+
+	f.lock.Lock()
+	previous := f.repository
+	f.repository = repository
+	f.lock.Unlock()
+
+	if previous != nil {
+		if err := previous.Close(); err != nil {
+			log.Error("could not close the previous database", "err", err)
+		}
+	}
+
+	log.Debug("follower reloaded the database", "source", f.source)
+	return nil
+}
+
+// List returns a list of all stored entries.
+func (f *Follower) List(ctx context.Context) ([]NickData, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.repository.List(ctx)
+}
+
+// ListAfter returns at most limit entries with node ids greater than the
+// provided one.
+func (f *Follower) ListAfter(after node.ID, limit int) ([]NickData, node.ID, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.repository.ListAfter(after, limit)
+}
+
+// ListTimeRange returns all stored entries with times within the provided
+// inclusive range.
+func (f *Follower) ListTimeRange(ctx context.Context, from, to time.Time) ([]NickData, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.repository.ListTimeRange(ctx, from, to)
+}
+
+// Get returns an entry for a specific node id.
+func (f *Follower) Get(id node.ID) (*NickData, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.repository.Get(id)
+}
+
+// GetByNick returns an entry for a specific nick.
+func (f *Follower) GetByNick(nick string) (*NickData, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.repository.GetByNick(nick)
+}
+
+// NickHistory returns all nicks ever held by a specific node id.
+func (f *Follower) NickHistory(id node.ID) ([]string, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.repository.NickHistory(id)
+}
+
+// Versions returns the prior versions of the nick data of a specific node id.
+func (f *Follower) Versions(id node.ID) ([]NickData, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.repository.Versions(id)
+}
+
+// NickHistoryBefore returns a page of the nicks held by a specific node id.
+func (f *Follower) NickHistoryBefore(id node.ID, before []byte, limit int) ([]string, []byte, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.repository.NickHistoryBefore(id, before, limit)
+}
+
+// VersionsBefore returns a page of the prior versions of the nick data of a
+// specific node id.
+func (f *Follower) VersionsBefore(id node.ID, before []byte, limit int) ([]NickData, []byte, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.repository.VersionsBefore(id, before, limit)
+}
+
+// ResolveNicks returns the node ids of the nodes which use the provided
+// nicks.
+func (f *Follower) ResolveNicks(nicks []string) (map[string]node.ID, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.repository.ResolveNicks(nicks)
+}
+
+// Count returns the number of stored entries.
+func (f *Follower) Count() (int, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.repository.Count()
+}
+
+// Revision returns a number which is increased every time the stored nick
+// data changes.
+func (f *Follower) Revision() (uint64, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.repository.Revision()
+}
+
+// Schema returns the version of the layout of the stored data.
+func (f *Follower) Schema() (SchemaInfo, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.repository.Schema()
+}
+
+// VerifyConsistency checks that the nick index agrees with the stored nick
+// data.
+func (f *Follower) VerifyConsistency() ([]Discrepancy, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.repository.VerifyConsistency()
+}
+
+// VerifyConsistencyInChunks checks that the nick index agrees with the stored
+// nick data using short read transactions.
+func (f *Follower) VerifyConsistencyInChunks(ctx context.Context) ([]Discrepancy, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.repository.VerifyConsistencyInChunks(ctx)
+}
+
+// IterateIndex calls fn for each entry in the nick index.
+func (f *Follower) IterateIndex(ctx context.Context, fn func(entry IndexEntry) error) error {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.repository.IterateIndex(ctx, fn)
+}
+
+// Put always returns ReadOnlyErr.
+func (f *Follower) Put(nickData *NickData) error {
+	return ReadOnlyErr
+}
+
+// Delete always returns ReadOnlyErr.
+func (f *Follower) Delete(id node.ID) error {
+	return ReadOnlyErr
+}
+
+// DeleteTombstone always returns ReadOnlyErr.
+func (f *Follower) DeleteTombstone(tombstone *Tombstone) error {
+	return ReadOnlyErr
+}
+
+// Ping confirms that the local copy of the database can be accessed.
+func (f *Follower) Ping() error {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.repository.Ping()
+}
+
+// Close closes the database.
+func (f *Follower) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.repository.Close()
+}
+
+// copyFile atomically replaces the destination file with a copy of the source
+// file.
+func copyFile(source, destination string) error {
+	src, err := os.Open(source)
+	if err != nil {
+		return errors.Wrap(err, "could not open the source")
+	}
+	defer src.Close()
+
+	tmp := destination + ".tmp"
+	dst, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.Wrap(err, "could not create a temporary file")
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return errors.Wrap(err, "copy failed")
+	}
+
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(err, "could not close the temporary file")
+	}
+
+	return os.Rename(tmp, destination)
+}
@@ -0,0 +1,85 @@
+package data
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFollower(t *testing.T) {
+	// given
+	b, cleanup := makeBoltRepository(t)
+	defer cleanup()
+
+	nickData := makeValidNickData()
+	nickData.Time = time.Date(1990, 1, 1, 1, 1, 1, 0, time.UTC)
+	nickData = withValidSignature(nickData)
+	require.NoError(t, b.Put(nickData))
+
+	source := b.db.Path()
+	require.NoError(t, b.Close())
+
+	path := filepath.Join(filepath.Dir(source), "follower.bolt")
+
+	// when
+	f, err := NewFollower(source, path, DefaultOptions())
+	require.NoError(t, err)
+	defer f.Close()
+
+	// then
+	result, err := f.Get(nickData.Id)
+	require.NoError(t, err, "get should not fail")
+	require.NotNil(t, result, "entry should be served from the copy")
+
+	list, err := f.List(context.Background())
+	require.NoError(t, err, "list should not fail")
+	require.Equal(t, 1, len(list))
+
+	require.Equal(t, ReadOnlyErr, f.Put(makeValidNickData()), "writes should be rejected")
+}
+
+func TestFollowerReload(t *testing.T) {
+	// given
+	b, cleanup := makeBoltRepository(t)
+	defer cleanup()
+
+	source := b.db.Path()
+	path := filepath.Join(filepath.Dir(source), "follower.bolt")
+	require.NoError(t, b.Close())
+
+	f, err := NewFollower(source, path, DefaultOptions())
+	require.NoError(t, err)
+	defer f.Close()
+
+	nickData := makeValidNickData()
+
+	b, err = NewBoltRepository(source, DefaultOptions())
+	require.NoError(t, err)
+	require.NoError(t, b.Put(nickData))
+	require.NoError(t, b.Close())
+
+	result, err := f.Get(nickData.Id)
+	require.NoError(t, err)
+	require.Nil(t, result, "entry shouldn't be present before reloading")
+
+	// when
+	err = f.Reload()
+
+	// then
+	require.NoError(t, err, "reload should not fail")
+
+	result, err = f.Get(nickData.Id)
+	require.NoError(t, err)
+	require.NotNil(t, result, "entry should be present after reloading")
+}
+
+func TestBoltRepositoryReadOnlyRequiresExistingDatabase(t *testing.T) {
+	options := DefaultOptions()
+	options.ReadOnly = true
+
+	_, err := NewBoltRepository(filepath.Join(t.TempDir(), "missing.bolt"), options)
+	require.Error(t, err)
+}
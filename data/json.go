@@ -0,0 +1,136 @@
+package data
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// Max lengths of the decoded binary fields of the nick data. They are checked
+// before the fields are decoded so that crafted input can't cause large
+// allocations before the nick data is validated.
+const (
+	// maxIdLength is the size of the largest supported hash.
+	maxIdLength = 64
+
+	// maxPublicKeyLength fits a DER encoded 16384-bit RSA key.
+	maxPublicKeyLength = 4096
+
+	// maxSignatureLength fits a signature created using a 16384-bit RSA
+	// key.
+	maxSignatureLength = 4096
+)
+
+var FieldTooLongErr = errors.New("field is too long")
+
+// MarshalJSON encodes the nick data in its canonical form. The order of the
+// fields and the formatting are fixed and don't depend on the definition of
+// the struct so that hashes computed over the encoded data remain stable.
+// The optional fields are omitted if they are empty. The public key is
+// omitted if it is empty as the server can omit it on request.
+func (n NickData) MarshalJSON() ([]byte, error) {
+	fields := []struct {
+		Name  string
+		Value interface{}
+		Omit  bool
+	}{
+		{"id", hexBytes(n.Id), false},
+		{"nick", n.Nick, false},
+		{"time", n.Time, false},
+		{"publicKey", n.PublicKey, len(n.PublicKey) == 0},
+		{"signature", n.Signature, false},
+		{"version", n.Version, n.Version == 0},
+		{"displayName", n.DisplayName, n.DisplayName == ""},
+		{"challenge", n.Challenge, len(n.Challenge) == 0},
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteByte('{')
+	for _, field := range fields {
+		if field.Omit {
+			continue
+		}
+		value, err := json.Marshal(field.Value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not encode %s", field.Name)
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(`"` + field.Name + `":`)
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON decodes the nick data rejecting binary fields which exceed
+// their max lengths with FieldTooLongErr.
+func (n *NickData) UnmarshalJSON(b []byte) error {
+	type nickData NickData
+	aux := struct {
+		Id        json.RawMessage `json:"id"`
+		PublicKey json.RawMessage `json:"publicKey"`
+		Signature json.RawMessage `json:"signature"`
+		Challenge json.RawMessage `json:"challenge"`
+		*nickData
+	}{
+		nickData: (*nickData)(n),
+	}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+
+	fields := []struct {
+		Name        string
+		Raw         json.RawMessage
+		MaxEncoded  int
+		Destination interface{}
+	}{
+		{"id", aux.Id, 2 * maxIdLength, (*hexBytes)(&n.Id)},
+		{"publicKey", aux.PublicKey, base64.StdEncoding.EncodedLen(maxPublicKeyLength), &n.PublicKey},
+		{"signature", aux.Signature, base64.StdEncoding.EncodedLen(maxSignatureLength), &n.Signature},
+		{"challenge", aux.Challenge, base64.StdEncoding.EncodedLen(maxChallengeLength), &n.Challenge},
+	}
+
+	for _, field := range fields {
+		if field.Raw == nil {
+			continue
+		}
+		// Two additional bytes for the quotes
+		if len(field.Raw) > field.MaxEncoded+2 {
+			return errors.Wrap(FieldTooLongErr, field.Name)
+		}
+		if err := json.Unmarshal(field.Raw, field.Destination); err != nil {
+			return errors.Wrapf(err, "could not decode %s", field.Name)
+		}
+	}
+	return nil
+}
+
+// hexBytes is encoded as a hex string, the same way the node ids are encoded
+// in the paths of the routes.
+type hexBytes []byte
+
+func (h hexBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(h))
+}
+
+func (h *hexBytes) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	decoded, err := hex.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	*h = decoded
+	return nil
+}
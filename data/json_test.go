@@ -0,0 +1,136 @@
+package data
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boreq/starlight/network/node"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNickDataUnmarshalJSON(t *testing.T) {
+	nickData := makeValidNickData()
+
+	j, err := json.Marshal(nickData)
+	require.NoError(t, err)
+
+	var result NickData
+	err = json.Unmarshal(j, &result)
+	require.NoError(t, err)
+	require.NoError(t, result.Validate())
+	require.Equal(t, nickData.Id, result.Id)
+	require.Equal(t, nickData.PublicKey, result.PublicKey)
+	require.Equal(t, nickData.Signature, result.Signature)
+	require.Equal(t, nickData.Nick, result.Nick)
+	require.True(t, nickData.Time.Equal(result.Time))
+}
+
+func TestNickDataMarshalJSONCanonical(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		NickData NickData
+		Expected string
+	}{
+		{
+			Name: "initial_version",
+			NickData: NickData{
+				Id:        []byte("id"),
+				Nick:      "nick",
+				Time:      time.Date(1990, 1, 1, 1, 1, 1, 1, time.UTC),
+				PublicKey: []byte("public key"),
+				Signature: []byte("signature"),
+			},
+			Expected: `{"id":"6964","nick":"nick","time":"1990-01-01T01:01:01.000000001Z","publicKey":"cHVibGljIGtleQ==","signature":"c2lnbmF0dXJl"}`,
+		},
+		{
+			Name: "all_fields",
+			NickData: NickData{
+				Id:          []byte("id"),
+				Nick:        "nick",
+				Time:        time.Date(1990, 1, 1, 1, 1, 1, 0, time.UTC),
+				PublicKey:   []byte("public key"),
+				Signature:   []byte("signature"),
+				Version:     VersionChallenge,
+				DisplayName: "Nick <3",
+				Challenge:   []byte("challenge"),
+			},
+			Expected: `{"id":"6964","nick":"nick","time":"1990-01-01T01:01:01Z","publicKey":"cHVibGljIGtleQ==","signature":"c2lnbmF0dXJl","version":2,"displayName":"Nick \u003c3","challenge":"Y2hhbGxlbmdl"}`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			j, err := json.Marshal(testCase.NickData)
+			require.NoError(t, err)
+			require.Equal(t, testCase.Expected, string(j))
+
+			j, err = json.Marshal(&testCase.NickData)
+			require.NoError(t, err)
+			require.Equal(t, testCase.Expected, string(j), "pointers should be encoded the same way")
+		})
+	}
+}
+
+func TestNickDataJSONBinaryId(t *testing.T) {
+	// given
+	nickData := makeValidNickData()
+	nickData.Id = node.ID{0x00, 0x01, 0x7f, 0x80, 0xfe, 0xff}
+
+	// when
+	j, err := json.Marshal(nickData)
+	require.NoError(t, err)
+
+	var result NickData
+	err = json.Unmarshal(j, &result)
+
+	// then
+	require.NoError(t, err)
+	require.Contains(t, string(j), `"id":"00017f80feff"`, "id should be encoded as hex")
+	require.Equal(t, nickData.Id, result.Id, "id should round-trip")
+}
+
+func TestNickDataUnmarshalJSONInvalidId(t *testing.T) {
+	var result NickData
+	err := json.Unmarshal([]byte(`{"id": "not hex"}`), &result)
+	require.Error(t, err)
+}
+
+func TestNickDataUnmarshalJSONMissingFields(t *testing.T) {
+	var result NickData
+	err := json.Unmarshal([]byte(`{"nick": "nick"}`), &result)
+	require.NoError(t, err)
+	require.Equal(t, "nick", result.Nick)
+	require.Nil(t, result.Id)
+	require.Nil(t, result.PublicKey)
+}
+
+func TestNickDataUnmarshalJSONFieldTooLong(t *testing.T) {
+	testCases := []struct {
+		Name string
+		JSON string
+	}{
+		{
+			Name: "id",
+			JSON: `{"id": "` + strings.Repeat("ab", maxIdLength+1) + `"}`,
+		},
+		{
+			Name: "publicKey",
+			JSON: `{"publicKey": "` + strings.Repeat("AAAA", maxPublicKeyLength) + `"}`,
+		},
+		{
+			Name: "signature",
+			JSON: `{"signature": "` + strings.Repeat("AAAA", maxSignatureLength) + `"}`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			var result NickData
+			err := json.Unmarshal([]byte(testCase.JSON), &result)
+			require.Equal(t, FieldTooLongErr, errors.Cause(err))
+		})
+	}
+}
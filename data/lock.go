@@ -0,0 +1,74 @@
+package data
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DatabaseLockedErr is returned when a database is opened for writing while
+// another process, eg. a running server, is already writing to it.
+var DatabaseLockedErr = errors.New("database is locked by another writer")
+
+// lockTimeout is the max time spent waiting for the lock held by bolt on the
+// database file.
+const lockTimeout = 100 * time.Millisecond
+
+// errLockHeld is returned by lockFile if the lock is held by someone else.
+var errLockHeld = errors.New("lock is held")
+
+// writerLock ensures that only a single process writes to a database. It is
+// held in a lock file located next to the database which stores the pid of
+// the process holding it so that the error returned to other writers can
+// point to that process. The lock file isn't removed when the lock is
+// released.
+type writerLock struct {
+	file *os.File
+}
+
+func acquireWriterLock(databasePath string) (*writerLock, error) {
+	path := databasePath + ".lock"
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open the lock file")
+	}
+
+	if err := lockFile(file); err != nil {
+		defer file.Close()
+		if err == errLockHeld {
+			pid, _ := ioutil.ReadAll(file)
+			return nil, errors.Wrapf(DatabaseLockedErr, "lock file %s is held by pid %s", path, strings.TrimSpace(string(pid)))
+		}
+		return nil, errors.Wrap(err, "could not lock the lock file")
+	}
+
+	if err := file.Truncate(0); err != nil {
+		unlockFile(file)
+		file.Close()
+		return nil, errors.Wrap(err, "could not truncate the lock file")
+	}
+	if _, err := file.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0); err != nil {
+		unlockFile(file)
+		file.Close()
+		return nil, errors.Wrap(err, "could not write the lock file")
+	}
+
+	return &writerLock{file: file}, nil
+}
+
+// Release releases the lock.
+func (l *writerLock) Release() error {
+	if err := l.file.Truncate(0); err != nil {
+		log.Warn("could not truncate the lock file", "err", err)
+	}
+	if err := unlockFile(l.file); err != nil {
+		l.file.Close()
+		return errors.Wrap(err, "could not unlock the lock file")
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,18 @@
+//go:build windows || plan9
+
+package data
+
+import (
+	"os"
+)
+
+// On these platforms only the lock held by bolt on the database file prevents
+// multiple writers.
+
+func lockFile(file *os.File) error {
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
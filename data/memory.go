@@ -0,0 +1,230 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/boreq/starlight/network/node"
+	"github.com/pkg/errors"
+)
+
+// MemoryRepository stores the nick data in memory. It follows the same rules
+// as BoltRepository but the data is lost once the process exits so it is
+// meant to be used in tests and ephemeral deployments. The nick quarantine,
+// nick history and prior versions aren't supported and the related options
+// are ignored.
+type MemoryRepository struct {
+	options Options
+
+	mutex    sync.RWMutex
+	nickData map[string]NickData
+	nicks    map[string]node.ID
+}
+
+// NewMemoryRepository creates an empty repository.
+func NewMemoryRepository(options Options) *MemoryRepository {
+	return &MemoryRepository{
+		options:  options,
+		nickData: make(map[string]NickData),
+		nicks:    make(map[string]node.ID),
+	}
+}
+
+// List returns all stored entries in the order of the node ids. The iteration
+// is aborted if the context is cancelled.
+func (r *MemoryRepository) List(ctx context.Context) ([]NickData, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	rv := make([]NickData, 0, len(r.nickData))
+	for _, nickData := range r.nickData {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		rv = append(rv, nickData)
+	}
+	sort.Slice(rv, func(i, j int) bool {
+		return bytes.Compare(rv[i].Id, rv[j].Id) < 0
+	})
+	return rv, nil
+}
+
+// Get returns an entry for a specific node id. If the node id is invalid
+// InvalidNodeIdErr is returned. If the entry doesn't exist nil is returned
+// without an error.
+func (r *MemoryRepository) Get(id node.ID) (*NickData, error) {
+	if !node.ValidateId(id) {
+		return nil, InvalidNodeIdErr
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.get(id), nil
+}
+
+// GetByNick returns an entry for a specific nick. If the nick is invalid
+// InvalidNickErr is returned. If the entry doesn't exist nil is returned
+// without an error.
+func (r *MemoryRepository) GetByNick(nick string) (*NickData, error) {
+	if err := r.options.NickPolicy.ValidateNick(nick); err != nil {
+		return nil, InvalidNickErr
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	id, ok := r.nicks[nick]
+	if !ok {
+		return nil, nil
+	}
+	return r.get(id), nil
+}
+
+// SearchByPrefix returns at most limit entries with nicks starting with the
+// prefix in the order of the nicks. If the prefix is invalid InvalidPrefixErr
+// is returned.
+func (r *MemoryRepository) SearchByPrefix(prefix string, limit int) ([]NickData, error) {
+	if err := r.options.NickPolicy.ValidatePrefix(prefix); err != nil {
+		return nil, InvalidPrefixErr
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	var nicks []string
+	for nick := range r.nicks {
+		if strings.HasPrefix(nick, prefix) {
+			nicks = append(nicks, nick)
+		}
+	}
+	sort.Strings(nicks)
+
+	var rv []NickData
+	for _, nick := range nicks {
+		if len(rv) >= limit {
+			break
+		}
+		rv = append(rv, *r.get(r.nicks[nick]))
+	}
+	return rv, nil
+}
+
+// Put inserts a new entry returning the same errors as BoltRepository.Put.
+func (r *MemoryRepository) Put(nickData *NickData) error {
+	_, err := r.PutResult(nickData)
+	return err
+}
+
+// PutResult inserts a new entry the same as Put and reports whether the entry
+// was created, updated or identical to the stored one.
+func (r *MemoryRepository) PutResult(nickData *NickData) (PutResult, error) {
+	return r.PutWithOptions(nickData, PutOptions{})
+}
+
+// PutWithOptions inserts a new entry the same as PutResult using the provided
+// options.
+func (r *MemoryRepository) PutWithOptions(nickData *NickData, putOptions PutOptions) (PutResult, error) {
+	if r.options.ReadOnly {
+		return "", ReadOnlyErr
+	}
+
+	if err := validatePut(nickData, r.options); err != nil {
+		return "", err
+	}
+
+	value, err := marshalNickData(nickData)
+	if err != nil {
+		return "", errors.Wrap(err, "marshaling nick data failed")
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	previous := r.get(nickData.Id)
+	if err := checkPut(r.nicks[nickData.Nick], previous, nickData, r.options, putOptions); err != nil {
+		return "", err
+	}
+
+	result := PutCreated
+	if previous != nil {
+		previousValue, err := marshalNickData(previous)
+		if err != nil {
+			return "", errors.Wrap(err, "marshaling previous nick data failed")
+		}
+		result = PutUpdated
+		if bytes.Equal(previousValue, value) {
+			result = PutUnchanged
+		}
+	}
+
+	if previous != nil && previous.Nick != nickData.Nick {
+		delete(r.nicks, previous.Nick)
+	}
+	r.nicks[nickData.Nick] = nickData.Id
+	r.nickData[string(nickData.Id)] = *nickData
+	return result, nil
+}
+
+// Delete removes the entry for a specific node id together with its nick. If
+// the node id is invalid InvalidNodeIdErr is returned. If the entry doesn't
+// exist NotFoundErr is returned.
+func (r *MemoryRepository) Delete(id node.ID) error {
+	return r.delete(id, nil)
+}
+
+// DeleteTombstone removes the entry of the node which created the tombstone
+// if the tombstone is newer than the stored nick data. Otherwise
+// NewerNickDataPresentErr is returned.
+func (r *MemoryRepository) DeleteTombstone(tombstone *Tombstone) error {
+	return r.delete(tombstone.Id, func(nickData *NickData) error {
+		return checkTombstone(tombstone, nickData)
+	})
+}
+
+func (r *MemoryRepository) delete(id node.ID, check func(nickData *NickData) error) error {
+	if r.options.ReadOnly {
+		return ReadOnlyErr
+	}
+
+	if !node.ValidateId(id) {
+		return InvalidNodeIdErr
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	nickData := r.get(id)
+	if nickData == nil {
+		return NotFoundErr
+	}
+	if check != nil {
+		if err := check(nickData); err != nil {
+			return err
+		}
+	}
+	delete(r.nicks, nickData.Nick)
+	delete(r.nickData, string(id))
+	return nil
+}
+
+// Ping always succeeds as the data is stored in memory.
+func (r *MemoryRepository) Ping() error {
+	return nil
+}
+
+// Close does nothing as the repository doesn't hold any resources.
+func (r *MemoryRepository) Close() error {
+	return nil
+}
+
+// get returns a copy of the stored entry or nil if it doesn't exist. The
+// mutex must be held.
+func (r *MemoryRepository) get(id node.ID) *NickData {
+	nickData, ok := r.nickData[string(id)]
+	if !ok {
+		return nil
+	}
+	return &nickData
+}
@@ -0,0 +1,127 @@
+package data
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/boreq/starlight/network/node"
+)
+
+// negativeCache remembers the node ids which recently weren't found so that
+// repeated lookups of nonexistent ids don't have to read the database. The
+// least recently used entries are evicted once the cache is full.
+type negativeCache struct {
+	size int
+	ttl  time.Duration
+	now  func() time.Time
+
+	lock       sync.Mutex
+	entries    map[string]*list.Element
+	order      *list.List
+	generation uint64
+}
+
+type negativeCacheEntry struct {
+	key     string
+	expires time.Time
+}
+
+// newNegativeCache returns nil if the size isn't positive. All methods of a
+// nil cache are no-ops.
+func newNegativeCache(size int, ttl time.Duration) *negativeCache {
+	if size <= 0 {
+		return nil
+	}
+	return &negativeCache{
+		size:    size,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Contains returns true if the id was recently not found.
+func (c *negativeCache) Contains(id node.ID) bool {
+	if c == nil {
+		return false
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element, ok := c.entries[string(id)]
+	if !ok {
+		return false
+	}
+	if c.now().After(element.Value.(*negativeCacheEntry).expires) {
+		c.remove(element)
+		return false
+	}
+	c.order.MoveToFront(element)
+	return true
+}
+
+// Generation returns a value which has to be passed to Add. It should be
+// retrieved before reading the database.
+func (c *negativeCache) Generation() uint64 {
+	if c == nil {
+		return 0
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.generation
+}
+
+// Add records that the id wasn't found. The id isn't recorded if the cache was
+// invalidated since the generation was retrieved as the read could have
+// happened before a write of that id.
+func (c *negativeCache) Add(id node.ID, generation uint64) {
+	if c == nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	entry := &negativeCacheEntry{
+		key:     string(id),
+		expires: c.now().Add(c.ttl),
+	}
+	if element, ok := c.entries[entry.key]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[entry.key] = c.order.PushFront(entry)
+	if c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// Invalidate removes the id from the cache. It should be called after the id
+// is written.
+func (c *negativeCache) Invalidate(id node.ID) {
+	if c == nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.generation++
+	if element, ok := c.entries[string(id)]; ok {
+		c.remove(element)
+	}
+}
+
+func (c *negativeCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*negativeCacheEntry).key)
+}
@@ -0,0 +1,174 @@
+package data
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// ProtobufContentType is the content type of the nick data encoded using
+// Protocol Buffers. The messages are defined in nickdata.proto.
+const ProtobufContentType = "application/protobuf"
+
+// Numbers of the fields of the NickData message.
+const (
+	protobufFieldId          = 1
+	protobufFieldNick        = 2
+	protobufFieldTime        = 3
+	protobufFieldPublicKey   = 4
+	protobufFieldSignature   = 5
+	protobufFieldVersion     = 6
+	protobufFieldDisplayName = 7
+	protobufFieldChallenge   = 8
+)
+
+// Numbers of the fields of the google.protobuf.Timestamp message.
+const (
+	protobufFieldSeconds = 1
+	protobufFieldNanos   = 2
+)
+
+// Number of the field of the NickDataList message.
+const protobufFieldItems = 1
+
+// MarshalProtobuf encodes the nick data as the NickData message. Following
+// proto3 the fields with zero values are omitted.
+func (n NickData) MarshalProtobuf() []byte {
+	var b []byte
+	b = appendProtobufBytes(b, protobufFieldId, n.Id)
+	b = appendProtobufBytes(b, protobufFieldNick, []byte(n.Nick))
+
+	var t []byte
+	if seconds := n.Time.Unix(); seconds != 0 {
+		t = protowire.AppendTag(t, protobufFieldSeconds, protowire.VarintType)
+		t = protowire.AppendVarint(t, uint64(seconds))
+	}
+	if nanos := n.Time.Nanosecond(); nanos != 0 {
+		t = protowire.AppendTag(t, protobufFieldNanos, protowire.VarintType)
+		t = protowire.AppendVarint(t, uint64(nanos))
+	}
+	b = protowire.AppendTag(b, protobufFieldTime, protowire.BytesType)
+	b = protowire.AppendBytes(b, t)
+
+	b = appendProtobufBytes(b, protobufFieldPublicKey, n.PublicKey)
+	b = appendProtobufBytes(b, protobufFieldSignature, n.Signature)
+	if n.Version != 0 {
+		b = protowire.AppendTag(b, protobufFieldVersion, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(n.Version))
+	}
+	b = appendProtobufBytes(b, protobufFieldDisplayName, []byte(n.DisplayName))
+	b = appendProtobufBytes(b, protobufFieldChallenge, n.Challenge)
+	return b
+}
+
+// MarshalProtobufList encodes the nick datas as the NickDataList message.
+func MarshalProtobufList(nickDatas []NickData) []byte {
+	var b []byte
+	for _, nickData := range nickDatas {
+		b = protowire.AppendTag(b, protobufFieldItems, protowire.BytesType)
+		b = protowire.AppendBytes(b, nickData.MarshalProtobuf())
+	}
+	return b
+}
+
+// UnmarshalProtobuf decodes the NickData message. Unknown fields are skipped.
+func UnmarshalProtobuf(b []byte) (*NickData, error) {
+	n := &NickData{}
+	var seconds, nanos int64
+	err := consumeProtobufFields(b, func(field protowire.Number, typ protowire.Type, value []byte, varint uint64) error {
+		switch {
+		case field == protobufFieldId && typ == protowire.BytesType:
+			n.Id = append([]byte(nil), value...)
+		case field == protobufFieldNick && typ == protowire.BytesType:
+			n.Nick = string(value)
+		case field == protobufFieldTime && typ == protowire.BytesType:
+			return consumeProtobufFields(value, func(field protowire.Number, typ protowire.Type, value []byte, varint uint64) error {
+				switch {
+				case field == protobufFieldSeconds && typ == protowire.VarintType:
+					seconds = int64(varint)
+				case field == protobufFieldNanos && typ == protowire.VarintType:
+					nanos = int64(int32(varint))
+				}
+				return nil
+			})
+		case field == protobufFieldPublicKey && typ == protowire.BytesType:
+			n.PublicKey = append([]byte(nil), value...)
+		case field == protobufFieldSignature && typ == protowire.BytesType:
+			n.Signature = append([]byte(nil), value...)
+		case field == protobufFieldVersion && typ == protowire.VarintType:
+			n.Version = int(int32(varint))
+		case field == protobufFieldDisplayName && typ == protowire.BytesType:
+			n.DisplayName = string(value)
+		case field == protobufFieldChallenge && typ == protowire.BytesType:
+			n.Challenge = append([]byte(nil), value...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	n.Time = time.Unix(seconds, nanos).UTC()
+	return n, nil
+}
+
+// UnmarshalProtobufList decodes the NickDataList message.
+func UnmarshalProtobufList(b []byte) ([]NickData, error) {
+	rv := make([]NickData, 0)
+	err := consumeProtobufFields(b, func(field protowire.Number, typ protowire.Type, value []byte, varint uint64) error {
+		if field != protobufFieldItems || typ != protowire.BytesType {
+			return nil
+		}
+		nickData, err := UnmarshalProtobuf(value)
+		if err != nil {
+			return errors.Wrap(err, "could not decode an item")
+		}
+		rv = append(rv, *nickData)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+// consumeProtobufFields calls fn for each field of the message. The value is
+// set for the fields of the bytes type and varint for the fields of the
+// varint type.
+func consumeProtobufFields(b []byte, fn func(field protowire.Number, typ protowire.Type, value []byte, varint uint64) error) error {
+	for len(b) > 0 {
+		field, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return errors.Wrap(protowire.ParseError(n), "could not decode a tag")
+		}
+		b = b[n:]
+
+		var value []byte
+		var varint uint64
+		switch typ {
+		case protowire.BytesType:
+			value, n = protowire.ConsumeBytes(b)
+		case protowire.VarintType:
+			varint, n = protowire.ConsumeVarint(b)
+		default:
+			n = protowire.ConsumeFieldValue(field, typ, b)
+		}
+		if n < 0 {
+			return errors.Wrapf(protowire.ParseError(n), "could not decode field %d", field)
+		}
+		b = b[n:]
+
+		if err := fn(field, typ, value, varint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func appendProtobufBytes(b []byte, field protowire.Number, value []byte) []byte {
+	if len(value) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, field, protowire.BytesType)
+	return protowire.AppendBytes(b, value)
+}
@@ -0,0 +1,61 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProtobufRoundTrip(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		NickData NickData
+	}{
+		{
+			Name:     "initial_version",
+			NickData: *makeValidNickData(),
+		},
+		{
+			Name: "all_fields",
+			NickData: NickData{
+				Id:          []byte("id"),
+				Nick:        "nick",
+				Time:        time.Date(1990, 1, 1, 1, 1, 1, 1, time.UTC),
+				PublicKey:   []byte("public key"),
+				Signature:   []byte("signature"),
+				Version:     VersionChallenge,
+				DisplayName: "Nick",
+				Challenge:   []byte("challenge"),
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			result, err := UnmarshalProtobuf(testCase.NickData.MarshalProtobuf())
+			require.NoError(t, err)
+			require.True(t, testCase.NickData.Time.Equal(result.Time), "time should be equal")
+			result.Time = testCase.NickData.Time
+			require.Equal(t, testCase.NickData, *result)
+		})
+	}
+}
+
+func TestProtobufListRoundTrip(t *testing.T) {
+	nickDatas := []NickData{*makeValidNickData(), *makeValidNickData()}
+	nickDatas[1].Nick = "other"
+
+	result, err := UnmarshalProtobufList(MarshalProtobufList(nickDatas))
+	require.NoError(t, err)
+	require.Len(t, result, 2)
+	require.Equal(t, "nick", result[0].Nick)
+	require.Equal(t, "other", result[1].Nick)
+}
+
+func TestProtobufTruncated(t *testing.T) {
+	b := makeValidNickData().MarshalProtobuf()
+
+	_, err := UnmarshalProtobuf(b[:len(b)-1])
+	require.Error(t, err, "truncated message should be rejected")
+}
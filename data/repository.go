@@ -0,0 +1,87 @@
+package data
+
+import (
+	"context"
+
+	"github.com/boreq/starlight-nick-server/config"
+	"github.com/boreq/starlight/network/node"
+	"github.com/pkg/errors"
+)
+
+var UnsupportedStorageEngineErr = errors.New("storage engine is not supported")
+
+// Repository stores the nick data.
+type Repository interface {
+	// List returns all stored entries. The iteration is aborted if the
+	// context is cancelled.
+	List(ctx context.Context) ([]NickData, error)
+
+	// Get returns an entry for a specific node id. If the entry doesn't
+	// exist nil is returned without an error.
+	Get(id node.ID) (*NickData, error)
+
+	// GetByNick returns an entry for a specific nick. If the entry doesn't
+	// exist nil is returned without an error.
+	GetByNick(nick string) (*NickData, error)
+
+	// Put inserts a new entry.
+	Put(nickData *NickData) error
+
+	// Delete removes the entry for a specific node id.
+	Delete(id node.ID) error
+
+	// DeleteTombstone removes the entry of the node which created the
+	// tombstone if the tombstone is newer than the entry. The tombstone
+	// has to be validated by the caller.
+	DeleteTombstone(tombstone *Tombstone) error
+
+	// Ping returns an error if the underlying storage can't be accessed.
+	Ping() error
+
+	// Close releases the resources held by the repository.
+	Close() error
+}
+
+// NewRepository creates a repository using the storage engine specified in
+// the config. The bolt engine is used if the storage engine is not set.
+// UnsupportedStorageEngineErr is returned if the engine is known but not
+// available in this build. If a secondary database is configured the writes
+// are duplicated to it using DualWriteRepository.
+func NewRepository(conf *config.Config, options Options) (Repository, error) {
+	primary, err := newRepository(conf.StorageEngine, conf.DatabasePath, options)
+	if err != nil {
+		return nil, err
+	}
+	if conf.SecondaryDatabasePath == "" {
+		return primary, nil
+	}
+
+	boltPrimary, ok := primary.(*BoltRepository)
+	if !ok {
+		primary.Close()
+		return nil, errors.New("secondary database requires the bolt storage engine for the primary database")
+	}
+
+	secondary, err := newRepository(conf.SecondaryStorageEngine, conf.SecondaryDatabasePath, options)
+	if err != nil {
+		primary.Close()
+		return nil, errors.Wrap(err, "could not create the secondary repository")
+	}
+	return NewDualWriteRepository(boltPrimary, secondary), nil
+}
+
+func newRepository(engine, path string, options Options) (Repository, error) {
+	switch engine {
+	case config.StorageEngineBolt, "":
+		if path == "" {
+			return nil, errors.New("bolt storage engine requires the database path")
+		}
+		return NewBoltRepository(path, options)
+	case config.StorageEngineMemory:
+		return NewMemoryRepository(options), nil
+	case config.StorageEngineSQLite:
+		return nil, errors.Wrap(UnsupportedStorageEngineErr, engine)
+	default:
+		return nil, errors.Errorf("unknown storage engine: %s", engine)
+	}
+}
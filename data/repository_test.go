@@ -0,0 +1,492 @@
+package data
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boreq/starlight-nick-server/config"
+	"github.com/boreq/starlight/network/node"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRepository(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nick_server_repository_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, engine := range []string{"", config.StorageEngineBolt} {
+		conf := config.Default()
+		conf.StorageEngine = engine
+		conf.DatabasePath = filepath.Join(dir, "database"+engine+".bolt")
+
+		repository, err := NewRepository(conf, DefaultOptions())
+		require.NoError(t, err, "engine: %q", engine)
+		require.IsType(t, &BoltRepository{}, repository, "engine: %q", engine)
+		require.NoError(t, repository.Close())
+	}
+}
+
+func TestNewRepositoryUnsupported(t *testing.T) {
+	conf := config.Default()
+	conf.StorageEngine = config.StorageEngineSQLite
+
+	_, err := NewRepository(conf, DefaultOptions())
+	require.Equal(t, UnsupportedStorageEngineErr, errors.Cause(err))
+}
+
+func TestNewRepositoryMemory(t *testing.T) {
+	conf := config.Default()
+	conf.StorageEngine = config.StorageEngineMemory
+	conf.DatabasePath = ""
+
+	repository, err := NewRepository(conf, DefaultOptions())
+	require.NoError(t, err)
+	require.IsType(t, &MemoryRepository{}, repository)
+	require.NoError(t, repository.Close())
+}
+
+func TestNewRepositoryInvalid(t *testing.T) {
+	conf := config.Default()
+	conf.StorageEngine = "unknown"
+
+	_, err := NewRepository(conf, DefaultOptions())
+	require.EqualError(t, err, "unknown storage engine: unknown")
+}
+
+func TestNewRepositoryBoltWithoutPath(t *testing.T) {
+	conf := config.Default()
+	conf.StorageEngine = config.StorageEngineBolt
+	conf.DatabasePath = ""
+
+	_, err := NewRepository(conf, DefaultOptions())
+	require.Error(t, err)
+}
+
+func TestNewRepositoryDualWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nick_server_repository_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := config.Default()
+	conf.DatabasePath = filepath.Join(dir, "primary.bolt")
+	conf.SecondaryDatabasePath = filepath.Join(dir, "secondary.bolt")
+
+	repository, err := NewRepository(conf, DefaultOptions())
+	require.NoError(t, err)
+	require.IsType(t, &DualWriteRepository{}, repository)
+	require.NoError(t, repository.Close())
+}
+
+// repositoryConstructors create the repositories against which the shared
+// test suite is run.
+var repositoryConstructors = map[string]func(t *testing.T) (Repository, cleanupFunc){
+	"bolt": func(t *testing.T) (Repository, cleanupFunc) {
+		return makeBoltRepository(t)
+	},
+	"memory": func(t *testing.T) (Repository, cleanupFunc) {
+		return NewMemoryRepository(DefaultOptions()), func() {}
+	},
+	"dualwrite": func(t *testing.T) (Repository, cleanupFunc) {
+		primary, cleanup := makeBoltRepository(t)
+		return NewDualWriteRepository(primary, NewMemoryRepository(DefaultOptions())), cleanup
+	},
+}
+
+// testRepositories runs the test against every implementation of Repository.
+func testRepositories(t *testing.T, test func(t *testing.T, r Repository)) {
+	for name, constructor := range repositoryConstructors {
+		t.Run(name, func(t *testing.T) {
+			r, cleanup := constructor(t)
+			defer cleanup()
+			test(t, r)
+		})
+	}
+}
+
+func TestRepositoryPutGet(t *testing.T) {
+	testRepositories(t, func(t *testing.T, r Repository) {
+		// given
+		nickData := makeValidNickData()
+
+		// when
+		err := r.Put(nickData)
+
+		// then
+		require.NoError(t, err)
+
+		byId, err := r.Get(nickData.Id)
+		require.NoError(t, err)
+		require.Equal(t, nickData.Nick, byId.Nick)
+
+		byNick, err := r.GetByNick(nickData.Nick)
+		require.NoError(t, err)
+		require.Equal(t, nickData.Id, byNick.Id)
+	})
+}
+
+func TestRepositoryGetMissing(t *testing.T) {
+	testRepositories(t, func(t *testing.T, r Repository) {
+		nickData, err := r.Get(makeIdentity().Id)
+		require.NoError(t, err)
+		require.Nil(t, nickData)
+
+		nickData, err = r.GetByNick("missing")
+		require.NoError(t, err)
+		require.Nil(t, nickData)
+
+		_, err = r.Get(node.ID{0x01})
+		require.Equal(t, InvalidNodeIdErr, err)
+	})
+}
+
+func TestRepositoryPutInvalid(t *testing.T) {
+	testRepositories(t, func(t *testing.T, r Repository) {
+		// given
+		nickData := makeValidNickData()
+		nickData.Nick = "changed"
+
+		// when
+		err := r.Put(nickData)
+
+		// then
+		var validationErr *ValidationError
+		require.True(t, errors.As(err, &validationErr), "error should be a validation error")
+		require.Equal(t, ReasonSignature, validationErr.Reason)
+	})
+}
+
+func TestRepositoryPutNickConflict(t *testing.T) {
+	testRepositories(t, func(t *testing.T, r Repository) {
+		// given
+		base := time.Date(1990, 1, 1, 1, 1, 1, 0, time.UTC)
+		require.NoError(t, r.Put(makeSignedNickData(t, makeIdentity(), "nick", base)))
+
+		// when
+		err := r.Put(makeSignedNickData(t, makeGeneratedIdentity(t), "nick", base))
+
+		// then
+		require.Equal(t, NickConflictErr, err)
+	})
+}
+
+func TestRepositoryPutNewerNickDataPresent(t *testing.T) {
+	testRepositories(t, func(t *testing.T, r Repository) {
+		// given
+		iden := makeIdentity()
+		base := time.Date(1990, 1, 1, 1, 1, 1, 0, time.UTC)
+		require.NoError(t, r.Put(makeSignedNickData(t, iden, "newer", base.Add(time.Minute))))
+
+		// when
+		err := r.Put(makeSignedNickData(t, iden, "older", base))
+
+		// then
+		require.Equal(t, NewerNickDataPresentErr, err)
+	})
+}
+
+func TestRepositoryPutSameTimeChange(t *testing.T) {
+	testRepositories(t, func(t *testing.T, r Repository) {
+		// given
+		iden := makeIdentity()
+		base := time.Date(1990, 1, 1, 1, 1, 1, 0, time.UTC)
+		require.NoError(t, r.Put(makeSignedNickData(t, iden, "first", base)))
+
+		// when
+		err := r.Put(makeSignedNickData(t, iden, "second", base))
+
+		// then
+		require.Equal(t, SameTimeChangeErr, err)
+		require.NoError(t, r.Put(makeSignedNickData(t, iden, "first", base)))
+	})
+}
+
+func TestRepositoryPutReservedNick(t *testing.T) {
+	reserved, err := NewReservedNicks([]string{"admin*"})
+	require.NoError(t, err)
+
+	options := DefaultOptions()
+	options.ReservedNicks = reserved
+
+	constructors := map[string]func(t *testing.T) (Repository, cleanupFunc){
+		"bolt": func(t *testing.T) (Repository, cleanupFunc) {
+			return makeBoltRepositoryWithOptions(t, options)
+		},
+		"memory": func(t *testing.T) (Repository, cleanupFunc) {
+			return NewMemoryRepository(options), func() {}
+		},
+	}
+
+	for name, constructor := range constructors {
+		t.Run(name, func(t *testing.T) {
+			r, cleanup := constructor(t)
+			defer cleanup()
+
+			base := time.Date(1990, 1, 1, 1, 1, 1, 0, time.UTC)
+			require.Equal(t, ReservedNickErr, r.Put(makeSignedNickData(t, makeIdentity(), "Administrator", base)))
+			require.NoError(t, r.Put(makeSignedNickData(t, makeIdentity(), "nick", base)))
+		})
+	}
+}
+
+func TestRepositoryPutReservedNickHeldByNode(t *testing.T) {
+	reserved, err := NewReservedNicks([]string{"admin*"})
+	require.NoError(t, err)
+
+	options := DefaultOptions()
+	options.ReservedNicks = reserved
+
+	constructors := map[string]func(t *testing.T) (Repository, cleanupFunc){
+		"bolt": func(t *testing.T) (Repository, cleanupFunc) {
+			return makeBoltRepositoryWithOptions(t, options)
+		},
+		"memory": func(t *testing.T) (Repository, cleanupFunc) {
+			return NewMemoryRepository(options), func() {}
+		},
+	}
+
+	for name, constructor := range constructors {
+		t.Run(name, func(t *testing.T) {
+			// given
+			r, cleanup := constructor(t)
+			defer cleanup()
+
+			iden := makeGeneratedIdentity(t)
+			base := time.Date(1990, 1, 1, 1, 1, 1, 0, time.UTC)
+
+			seedable, ok := r.(interface {
+				PutWithOptions(nickData *NickData, putOptions PutOptions) (PutResult, error)
+			})
+			require.True(t, ok)
+			_, err := seedable.PutWithOptions(makeSignedNickData(t, iden, "admin", base), PutOptions{AllowReserved: true})
+			require.NoError(t, err, "reserved nicks should be allowed explicitly")
+
+			// when
+			err = r.Put(makeSignedNickData(t, iden, "admin", base.Add(time.Minute)))
+
+			// then
+			require.NoError(t, err, "node holding the nick should be able to update it")
+			require.Equal(t, NickConflictErr, r.Put(makeSignedNickData(t, makeGeneratedIdentity(t), "admin", base)))
+			require.Equal(t, ReservedNickErr, r.Put(makeSignedNickData(t, iden, "administrator", base.Add(2*time.Minute))), "node should not claim other reserved nicks")
+		})
+	}
+}
+
+func TestRepositoryPutChangeNickFreesPreviousNick(t *testing.T) {
+	testRepositories(t, func(t *testing.T, r Repository) {
+		// given
+		iden := makeIdentity()
+		base := time.Date(1990, 1, 1, 1, 1, 1, 0, time.UTC)
+		require.NoError(t, r.Put(makeSignedNickData(t, iden, "first", base)))
+
+		// when
+		require.NoError(t, r.Put(makeSignedNickData(t, iden, "second", base.Add(time.Minute))))
+
+		// then
+		nickData, err := r.GetByNick("first")
+		require.NoError(t, err)
+		require.Nil(t, nickData)
+
+		require.NoError(t, r.Put(makeSignedNickData(t, makeGeneratedIdentity(t), "first", base)))
+	})
+}
+
+func TestRepositoryPutResult(t *testing.T) {
+	testRepositories(t, func(t *testing.T, r Repository) {
+		// given
+		repository, ok := r.(interface {
+			PutResult(nickData *NickData) (PutResult, error)
+		})
+		require.True(t, ok, "repository should report the put result")
+
+		iden := makeIdentity()
+		base := time.Date(1990, 1, 1, 1, 1, 1, 0, time.UTC)
+		first := makeSignedNickData(t, iden, "first", base)
+		second := makeSignedNickData(t, iden, "second", base.Add(time.Minute))
+
+		// when
+		created, err := repository.PutResult(first)
+		require.NoError(t, err)
+
+		updated, err := repository.PutResult(second)
+		require.NoError(t, err)
+
+		unchanged, err := repository.PutResult(second)
+		require.NoError(t, err)
+
+		// then
+		require.Equal(t, PutCreated, created)
+		require.Equal(t, PutUpdated, updated)
+		require.Equal(t, PutUnchanged, unchanged)
+	})
+}
+
+func TestRepositoryPutResultErr(t *testing.T) {
+	testRepositories(t, func(t *testing.T, r Repository) {
+		// given
+		repository := r.(interface {
+			PutResult(nickData *NickData) (PutResult, error)
+		})
+
+		iden := makeIdentity()
+		base := time.Date(1990, 1, 1, 1, 1, 1, 0, time.UTC)
+		require.NoError(t, r.Put(makeSignedNickData(t, iden, "newer", base.Add(time.Minute))))
+
+		// when
+		result, err := repository.PutResult(makeSignedNickData(t, iden, "older", base))
+
+		// then
+		require.Equal(t, NewerNickDataPresentErr, err)
+		require.Empty(t, result)
+	})
+}
+
+func TestRepositorySearchByPrefix(t *testing.T) {
+	testRepositories(t, func(t *testing.T, r Repository) {
+		// given
+		repository, ok := r.(interface {
+			SearchByPrefix(prefix string, limit int) ([]NickData, error)
+		})
+		require.True(t, ok, "repository should support searching")
+
+		base := time.Date(1990, 1, 1, 1, 1, 1, 0, time.UTC)
+		for _, nick := range []string{"bob", "alice", "Alex", "alfred", "ale", "albert"} {
+			require.NoError(t, r.Put(makeSignedNickData(t, makeGeneratedIdentity(t), nick, base)))
+		}
+
+		testCases := []struct {
+			Prefix        string
+			Limit         int
+			ExpectedNicks []string
+		}{
+			{"al", 10, []string{"albert", "ale", "alfred", "alice"}},
+			{"al", 2, []string{"albert", "ale"}},
+			{"ale", 10, []string{"ale"}},
+			{"Al", 10, []string{"Alex"}},
+			{"ali", 10, []string{"alice"}},
+			{"carol", 10, nil},
+		}
+
+		for _, testCase := range testCases {
+			// when
+			nickDatas, err := repository.SearchByPrefix(testCase.Prefix, testCase.Limit)
+
+			// then
+			require.NoError(t, err)
+
+			var nicks []string
+			for _, nickData := range nickDatas {
+				nicks = append(nicks, nickData.Nick)
+			}
+			require.Equal(t, testCase.ExpectedNicks, nicks, "prefix %q, limit %d", testCase.Prefix, testCase.Limit)
+		}
+	})
+}
+
+func TestRepositorySearchByPrefixInvalid(t *testing.T) {
+	testRepositories(t, func(t *testing.T, r Repository) {
+		repository := r.(interface {
+			SearchByPrefix(prefix string, limit int) ([]NickData, error)
+		})
+
+		for _, prefix := range []string{"", "a_b", "a b", strings.Repeat("a", 21)} {
+			_, err := repository.SearchByPrefix(prefix, 10)
+			require.Equal(t, InvalidPrefixErr, err, "prefix %q", prefix)
+		}
+	})
+}
+
+func TestRepositoryList(t *testing.T) {
+	testRepositories(t, func(t *testing.T, r Repository) {
+		// given
+		base := time.Date(1990, 1, 1, 1, 1, 1, 0, time.UTC)
+		require.NoError(t, r.Put(makeSignedNickData(t, makeIdentity(), "first", base)))
+		require.NoError(t, r.Put(makeSignedNickData(t, makeGeneratedIdentity(t), "second", base)))
+
+		// when
+		list, err := r.List(context.Background())
+
+		// then
+		require.NoError(t, err)
+		require.Len(t, list, 2)
+		require.True(t, string(list[0].Id) < string(list[1].Id))
+	})
+}
+
+func TestRepositoryDelete(t *testing.T) {
+	testRepositories(t, func(t *testing.T, r Repository) {
+		// given
+		nickData := makeValidNickData()
+		require.NoError(t, r.Put(nickData))
+
+		// when
+		err := r.Delete(nickData.Id)
+
+		// then
+		require.NoError(t, err)
+
+		stored, err := r.Get(nickData.Id)
+		require.NoError(t, err)
+		require.Nil(t, stored)
+
+		stored, err = r.GetByNick(nickData.Nick)
+		require.NoError(t, err)
+		require.Nil(t, stored)
+
+		require.Equal(t, NotFoundErr, r.Delete(nickData.Id))
+	})
+}
+
+func TestRepositoryDeleteTombstone(t *testing.T) {
+	testCases := []struct {
+		Name        string
+		Offset      time.Duration
+		ExpectedErr error
+	}{
+		{
+			Name:        "newer",
+			Offset:      time.Second,
+			ExpectedErr: nil,
+		},
+		{
+			Name:        "same_second",
+			Offset:      0,
+			ExpectedErr: NewerNickDataPresentErr,
+		},
+		{
+			Name:        "older",
+			Offset:      -time.Second,
+			ExpectedErr: NewerNickDataPresentErr,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			testRepositories(t, func(t *testing.T, r Repository) {
+				// given
+				nickData := makeValidNickData()
+				require.NoError(t, r.Put(nickData))
+
+				tombstone := &Tombstone{
+					Id:   nickData.Id,
+					Time: nickData.Time.Add(testCase.Offset),
+				}
+
+				// when
+				err := r.DeleteTombstone(tombstone)
+
+				// then
+				require.Equal(t, testCase.ExpectedErr, err)
+
+				stored, err := r.Get(nickData.Id)
+				require.NoError(t, err)
+				require.Equal(t, testCase.ExpectedErr != nil, stored != nil, "entry should be removed only by newer tombstones")
+			})
+		})
+	}
+}
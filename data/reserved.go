@@ -0,0 +1,56 @@
+package data
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ReservedNicks is a set of nicks which can't be registered. The nicks are
+// compared case-insensitively.
+type ReservedNicks struct {
+	nicks    map[string]bool
+	prefixes []string
+}
+
+// NewReservedNicks creates a set from a list of nicks. An entry ending with an
+// asterisk reserves all nicks starting with the part before the asterisk, eg.
+// "admin*" reserves "admin" and "Administrator". The asterisk isn't allowed
+// anywhere else as the nicks can contain brackets which would make other
+// pattern syntaxes ambiguous.
+func NewReservedNicks(entries []string) (*ReservedNicks, error) {
+	r := &ReservedNicks{
+		nicks: make(map[string]bool),
+	}
+	for _, entry := range entries {
+		nick := strings.ToLower(entry)
+		prefix := strings.TrimSuffix(nick, "*")
+		if prefix == "" || strings.Contains(prefix, "*") {
+			return nil, errors.Errorf("invalid reserved nick %q", entry)
+		}
+		if prefix != nick {
+			r.prefixes = append(r.prefixes, prefix)
+		} else {
+			r.nicks[nick] = true
+		}
+	}
+	return r, nil
+}
+
+// IsReserved returns true if the nick matches one of the entries. A nil set
+// doesn't reserve any nicks.
+func (r *ReservedNicks) IsReserved(nick string) bool {
+	if r == nil {
+		return false
+	}
+	nick = strings.ToLower(nick)
+	if r.nicks[nick] {
+		return true
+	}
+	for _, prefix := range r.prefixes {
+		if strings.HasPrefix(nick, prefix) {
+			return true
+		}
+	}
+	return false
+}
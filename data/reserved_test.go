@@ -0,0 +1,45 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReservedNicks(t *testing.T) {
+	reserved, err := NewReservedNicks([]string{"admin", "Root", "official*"})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		Nick       string
+		IsReserved bool
+	}{
+		{"admin", true},
+		{"ADMIN", true},
+		{"Admin", true},
+		{"root", true},
+		{"admins", false},
+		{"xadmin", false},
+		{"official", true},
+		{"Official_node", true},
+		{"OFFICIAL[1]", true},
+		{"unofficial", false},
+		{"nick", false},
+	}
+
+	for _, testCase := range testCases {
+		require.Equal(t, testCase.IsReserved, reserved.IsReserved(testCase.Nick), "nick %q", testCase.Nick)
+	}
+}
+
+func TestReservedNicksInvalid(t *testing.T) {
+	for _, entry := range []string{"", "*", "ad*min", "**"} {
+		_, err := NewReservedNicks([]string{"admin", entry})
+		require.EqualError(t, err, "invalid reserved nick \""+entry+"\"")
+	}
+}
+
+func TestReservedNicksNil(t *testing.T) {
+	var reserved *ReservedNicks
+	require.False(t, reserved.IsReserved("admin"))
+}
@@ -0,0 +1,216 @@
+package data
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/boreq/starlight/network/node"
+	"github.com/pkg/errors"
+)
+
+// Versions of the layout of the stored data.
+const (
+	// SchemaVersionInitial stores the values using the JSON encoding.
+	SchemaVersionInitial = 0
+
+	// SchemaVersionBinary stores the values using the binary encoding and
+	// tracks the history of the nicks.
+	SchemaVersionBinary = 1
+
+	// SchemaVersionNickIndex guarantees that the nick index maps the nicks
+	// to the node ids. Older versions could contain entries mapping the
+	// node ids to the nick data.
+	SchemaVersionNickIndex = 2
+
+	// SchemaVersionCompression may store the values compressed. Older
+	// versions can't decode such values.
+	SchemaVersionCompression = 3
+
+	// SupportedSchemaVersion is the latest version supported by this
+	// binary. Databases with newer versions are refused as they could be
+	// corrupted by writes which don't follow their layout.
+	SupportedSchemaVersion = SchemaVersionCompression
+)
+
+// migrations upgrade the database to the version equal to their index plus
+// one.
+var migrations = []func(tx *bolt.Tx) error{
+	migrateLegacyEncoding,
+	migrateNickIndex,
+	migrateCompression,
+}
+
+const metaBucket = "meta"
+
+var schemaVersionKey = []byte("schemaVersion")
+
+var UnsupportedSchemaVersionErr = errors.New("database schema version is not supported")
+
+// SchemaInfo describes the version of the layout of the stored data.
+type SchemaInfo struct {
+	Version          uint64 `json:"version"`
+	SupportedVersion uint64 `json:"supportedVersion"`
+}
+
+// readSchemaVersion returns the version of the database. Databases created
+// before the version was stored have the initial version.
+func readSchemaVersion(tx *bolt.Tx) uint64 {
+	b := tx.Bucket([]byte(metaBucket))
+	if b == nil {
+		return SchemaVersionInitial
+	}
+	v := b.Get(schemaVersionKey)
+	if len(v) != 8 {
+		return SchemaVersionInitial
+	}
+	return binary.BigEndian.Uint64(v)
+}
+
+func writeSchemaVersion(tx *bolt.Tx, version uint64) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(metaBucket))
+	if err != nil {
+		return errors.Wrap(err, "bucket creation failed")
+	}
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, version)
+	return b.Put(schemaVersionKey, v)
+}
+
+// checkSchemaVersion returns an error if the database is newer than
+// supported.
+func checkSchemaVersion(tx *bolt.Tx) error {
+	version := readSchemaVersion(tx)
+	if version > SupportedSchemaVersion {
+		return errors.Wrapf(UnsupportedSchemaVersionErr, "database version is %d, supported version is %d", version, SupportedSchemaVersion)
+	}
+	return nil
+}
+
+// migrate migrates the database to the supported schema version.
+func migrate(tx *bolt.Tx) error {
+	version := readSchemaVersion(tx)
+	if version == SupportedSchemaVersion {
+		return nil
+	}
+	for ; version < SupportedSchemaVersion; version++ {
+		if err := migrations[version](tx); err != nil {
+			return errors.Wrapf(err, "could not migrate to version %d", version+1)
+		}
+	}
+	if err := writeSchemaVersion(tx, SupportedSchemaVersion); err != nil {
+		return errors.Wrap(err, "could not write the schema version")
+	}
+	return nil
+}
+
+// migrateCompression doesn't change the stored data. Raising the version
+// prevents the older versions, which can't decode the compressed values, from
+// opening the database once compressed values may be stored in it.
+func migrateCompression(tx *bolt.Tx) error {
+	return nil
+}
+
+// migrateNickIndex removes the entries of the nick index which map the node
+// ids to the nick data, stored by the versions which incorrectly keyed the
+// index by the node ids, and indexes the nicks of the stored nick data. The
+// versions which keyed the index by the node ids didn't detect conflicts so
+// many nodes can hold the same nick. In that case the node with the earliest
+// nick data keeps the nick and the other nodes are logged.
+func migrateNickIndex(tx *bolt.Tx) error {
+	nickDataB := tx.Bucket([]byte(nickDataBucket))
+	nicksB := tx.Bucket([]byte(nicksBucket))
+
+	var legacy [][]byte
+	if err := nicksB.ForEach(func(k, v []byte) error {
+		if isLegacyIndexEntry(k, v) {
+			legacy = append(legacy, append([]byte(nil), k...))
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for _, k := range legacy {
+		if err := nicksB.Delete(k); err != nil {
+			return errors.Wrap(err, "delete failed")
+		}
+	}
+
+	owners := make(map[string]nickIndexOwner)
+	if err := nickDataB.ForEach(func(k, v []byte) error {
+		nickData, err := unmarshalNickData(v)
+		if err != nil {
+			return errors.Wrap(err, "unmarshal failed")
+		}
+		candidate := nickIndexOwner{id: append(node.ID(nil), k...), time: nickData.Time}
+		owner, ok := owners[nickData.Nick]
+		if !ok {
+			owners[nickData.Nick] = candidate
+			return nil
+		}
+		if candidate.isEarlier(owner) {
+			owner, candidate = candidate, owner
+			owners[nickData.Nick] = owner
+		}
+		log.Warn("nick held by many nodes, keeping the earliest one", "nick", nickData.Nick, "kept", owner.id, "dropped", candidate.id)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	indexed := 0
+	for nick, owner := range owners {
+		if bytes.Equal(nicksB.Get([]byte(nick)), owner.id) {
+			continue
+		}
+		indexed++
+		if err := nicksB.Put([]byte(nick), owner.id); err != nil {
+			return errors.Wrap(err, "put failed")
+		}
+	}
+
+	if len(legacy) > 0 || indexed > 0 {
+		log.Info("rebuilt the nick index", "removed", len(legacy), "indexed", indexed)
+	}
+	return nil
+}
+
+// nickIndexOwner is a node which holds a nick during the migration of the nick
+// index.
+type nickIndexOwner struct {
+	id   node.ID
+	time time.Time
+}
+
+// isEarlier returns true if the nick data of this node is older than the nick
+// data of the other node. The ids are compared if the times are equal so that
+// the result doesn't depend on the order of iteration.
+func (o nickIndexOwner) isEarlier(other nickIndexOwner) bool {
+	if !o.time.Equal(other.time) {
+		return o.time.Before(other.time)
+	}
+	return bytes.Compare(o.id, other.id) < 0
+}
+
+// isLegacyIndexEntry returns true if the entry of the nick index maps a node
+// id to the nick data of that node.
+func isLegacyIndexEntry(k, v []byte) bool {
+	nickData, err := unmarshalNickData(v)
+	return err == nil && bytes.Equal(nickData.Id, k)
+}
+
+// Schema returns the version of the layout of the stored data.
+func (r *BoltRepository) Schema() (SchemaInfo, error) {
+	info := SchemaInfo{
+		SupportedVersion: SupportedSchemaVersion,
+	}
+	if err := r.db.View(func(tx *bolt.Tx) error {
+		info.Version = readSchemaVersion(tx)
+		return nil
+	}); err != nil {
+		return SchemaInfo{}, errors.Wrap(err, "view failed")
+	}
+	return info, nil
+}
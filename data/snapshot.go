@@ -0,0 +1,83 @@
+package data
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// snapshotAttempts is the max number of times copying the database is retried
+// if it was modified during the copy.
+const snapshotAttempts = 10
+
+// snapshotHeaderSize is the size of the beginning of the database file which
+// is compared before and after copying it. It covers both meta pages of the
+// database for all page sizes used by bolt.
+const snapshotHeaderSize = 128 * 1024
+
+// snapshotDatabase creates a consistent copy of a database which is locked by
+// a writer in a temporary file and returns its path. Bolt never overwrites
+// the pages referenced by the last committed meta page, therefore the copy is
+// consistent if the meta pages didn't change while it was created.
+func snapshotDatabase(path string) (string, error) {
+	for i := 0; i < snapshotAttempts; i++ {
+		snapshot, consistent, err := copyDatabase(path)
+		if err != nil {
+			return "", err
+		}
+		if consistent {
+			return snapshot, nil
+		}
+		os.Remove(snapshot)
+	}
+	return "", errors.New("database kept changing while it was copied")
+}
+
+func copyDatabase(path string) (string, bool, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return "", false, errors.Wrap(err, "could not open the database")
+	}
+	defer src.Close()
+
+	before, err := readHeader(src)
+	if err != nil {
+		return "", false, errors.Wrap(err, "could not read the header")
+	}
+
+	dst, err := ioutil.TempFile("", "starlight-nick-server-snapshot-")
+	if err != nil {
+		return "", false, errors.Wrap(err, "could not create a temporary file")
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(dst.Name())
+		return "", false, errors.Wrap(err, "copy failed")
+	}
+
+	if err := dst.Close(); err != nil {
+		os.Remove(dst.Name())
+		return "", false, errors.Wrap(err, "could not close the temporary file")
+	}
+
+	after, err := readHeader(src)
+	if err != nil {
+		os.Remove(dst.Name())
+		return "", false, errors.Wrap(err, "could not read the header")
+	}
+
+	return dst.Name(), bytes.Equal(before, after), nil
+}
+
+func readHeader(file *os.File) ([]byte, error) {
+	buf := make([]byte, snapshotHeaderSize)
+	n, err := file.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf[:n], nil
+}
@@ -0,0 +1,114 @@
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	scrypto "github.com/boreq/starlight/crypto"
+	"github.com/boreq/starlight/network/node"
+	"github.com/pkg/errors"
+)
+
+// tombstonePrefix is signed together with the tombstone so that its signature
+// can never be mistaken for the signature of nick data.
+const tombstonePrefix = "delete"
+
+// Tombstone is a signed request to remove the nick data of a node. It has to
+// be signed by the node the same way as the nick data.
+type Tombstone struct {
+	Id        node.ID
+	Time      time.Time
+	PublicKey []byte
+	Signature []byte
+}
+
+// GetDataToSign returns the data which should be signed to produce the
+// signature.
+func (t Tombstone) GetDataToSign() []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString(tombstonePrefix)
+	buf.WriteByte(0)
+	buf.WriteString(fmt.Sprintf("%d", t.Time.Unix()))
+	buf.Write(t.Id)
+	return buf.Bytes()
+}
+
+// Validate checks if the tombstone was signed by the node using the default
+// nick policy.
+func (t Tombstone) Validate() error {
+	return t.ValidateWithPolicy(DefaultNickPolicy())
+}
+
+// ValidateWithPolicy checks if the tombstone was signed by the node. The time
+// can't be further in the future than the max clock skew of the policy, the
+// same as in the case of the nick data, as otherwise a tombstone created in
+// advance could be replayed to remove the nick data stored until that time.
+// The returned errors are of type *ValidationError.
+func (t Tombstone) ValidateWithPolicy(policy NickPolicy) error {
+	if len(t.PublicKey) == 0 {
+		return newValidationError(ReasonPublicKey, errors.New("could not read the public key: public key is empty"))
+	}
+	publicKey, err := scrypto.NewPublicKey(t.PublicKey)
+	if err != nil {
+		return newValidationError(ReasonPublicKey, errors.Wrap(err, "could not read the public key"))
+	}
+
+	if !node.ValidateId(t.Id) {
+		return newValidationError(ReasonId, errors.New("id is invalid"))
+	}
+	id, err := publicKey.Hash()
+	if err != nil {
+		return newValidationError(ReasonId, errors.Wrap(err, "could not hash the public key"))
+	}
+	if !node.CompareId(id, t.Id) {
+		return newValidationError(ReasonId, errors.New("id does not match the public key"))
+	}
+
+	if t.Time.IsZero() {
+		return newValidationError(ReasonTime, errors.New("time is zero"))
+	}
+	if maxClockSkew := policy.maxClockSkew(); t.Time.After(time.Now().Add(maxClockSkew)) {
+		return newValidationError(ReasonTime, errors.Errorf("time is more than %s in the future", maxClockSkew))
+	}
+
+	if len(t.Signature) == 0 {
+		return newValidationError(ReasonSignature, errors.New("could not validate the signature: signature is empty"))
+	}
+	data := t.GetDataToSign()
+	if err := publicKey.Validate(data, t.Signature, SigningHash); err != nil {
+		return newValidationError(ReasonSignature, errors.Wrap(err, policy.signatureHint(publicKey, data, t.Signature)))
+	}
+	return nil
+}
+
+type tombstoneJSON struct {
+	Id        hexBytes  `json:"id"`
+	Time      time.Time `json:"time"`
+	PublicKey []byte    `json:"publicKey"`
+	Signature []byte    `json:"signature"`
+}
+
+// MarshalJSON encodes the node id as a hex string, the same way as in the
+// nick data.
+func (t Tombstone) MarshalJSON() ([]byte, error) {
+	return json.Marshal(tombstoneJSON{
+		Id:        hexBytes(t.Id),
+		Time:      t.Time,
+		PublicKey: t.PublicKey,
+		Signature: t.Signature,
+	})
+}
+
+func (t *Tombstone) UnmarshalJSON(b []byte) error {
+	aux := tombstoneJSON{}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+	t.Id = node.ID(aux.Id)
+	t.Time = aux.Time
+	t.PublicKey = aux.PublicKey
+	t.Signature = aux.Signature
+	return nil
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/boreq/starlight-nick-server/config"
+	"github.com/boreq/starlight-nick-server/server/api"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+)
+
+var writeForbiddenError = api.Forbidden.WithMessage("Writes are not allowed from this address.")
+
+// ipNets is a list of IP ranges.
+type ipNets []*net.IPNet
+
+// parseIPNets parses a list of CIDR ranges. Single addresses are treated as
+// ranges containing only that address.
+func parseIPNets(values []string) (ipNets, error) {
+	var rv ipNets
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, errors.Errorf("invalid address %q", value)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			rv = append(rv, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid range %q", value)
+		}
+		rv = append(rv, ipNet)
+	}
+	return rv, nil
+}
+
+func (n ipNets) Contains(ip net.IP) bool {
+	for _, ipNet := range n {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIPResolver determines the address of the client which sent the
+// request. The X-Forwarded-For header is only taken into account if the
+// request was sent by one of the trusted proxies as otherwise it can be set
+// by the clients to any value.
+type clientIPResolver struct {
+	trustedProxies ipNets
+}
+
+// ClientIP returns nil if the address can't be determined. The addresses in
+// X-Forwarded-For are checked starting with the last one, the first address
+// which doesn't belong to a trusted proxy is the address of the client.
+func (c clientIPResolver) ClientIP(r *http.Request) net.IP {
+	ip := net.ParseIP(clientIP(r))
+	if ip == nil || !c.trustedProxies.Contains(ip) {
+		return ip
+	}
+
+	var forwarded []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(header, ",")...)
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		forwardedIP := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if forwardedIP == nil {
+			return nil
+		}
+		ip = forwardedIP
+		if !c.trustedProxies.Contains(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// writeAccess rejects the writes sent by the clients which aren't on the
+// allowlist, if it isn't empty, or are on the denylist.
+type writeAccess struct {
+	allow    ipNets
+	deny     ipNets
+	resolver clientIPResolver
+}
+
+// newWriteAccess returns nil if both lists are empty.
+func newWriteAccess(conf *config.Config) (*writeAccess, error) {
+	allow, err := parseIPNets(conf.WriteAllowlist)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid write allowlist")
+	}
+	deny, err := parseIPNets(conf.WriteDenylist)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid write denylist")
+	}
+	trustedProxies, err := parseIPNets(conf.TrustedProxies)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid trusted proxies")
+	}
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil, nil
+	}
+	return &writeAccess{
+		allow:    allow,
+		deny:     deny,
+		resolver: clientIPResolver{trustedProxies: trustedProxies},
+	}, nil
+}
+
+// Wrap rejects the requests which aren't allowed with Forbidden. The handle
+// isn't wrapped if the access isn't restricted.
+func (a *writeAccess) Wrap(handle api.Handle) api.Handle {
+	if a == nil {
+		return handle
+	}
+
+	return func(r *http.Request, ps httprouter.Params) (interface{}, api.Error) {
+		if apiErr := a.Check(a.resolver.ClientIP(r)); apiErr != nil {
+			return nil, apiErr
+		}
+		return handle(r, ps)
+	}
+}
+
+// Check returns Forbidden if the writes sent from the address aren't allowed.
+// All writes are allowed if the access is nil.
+func (a *writeAccess) Check(ip net.IP) api.Error {
+	if a == nil || a.allowed(ip) {
+		return nil
+	}
+	return writeForbiddenError
+}
+
+func (a *writeAccess) allowed(ip net.IP) bool {
+	if ip == nil {
+		return len(a.allow) == 0
+	}
+	if a.deny.Contains(ip) {
+		return false
+	}
+	return len(a.allow) == 0 || a.allow.Contains(ip)
+}
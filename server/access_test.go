@@ -0,0 +1,137 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/boreq/starlight-nick-server/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteAccess(t *testing.T) {
+	testCases := []struct {
+		Name          string
+		Allowlist     []string
+		Denylist      []string
+		Proxies       []string
+		RemoteAddr    string
+		ForwardedFor  string
+		ExpectedCode  int
+		ExpectedWrite bool
+	}{
+		{
+			Name:          "allowed",
+			Allowlist:     []string{"192.0.2.10"},
+			RemoteAddr:    "192.0.2.10:1234",
+			ExpectedCode:  http.StatusOK,
+			ExpectedWrite: true,
+		},
+		{
+			Name:         "not_on_allowlist",
+			Allowlist:    []string{"192.0.2.10"},
+			RemoteAddr:   "192.0.2.11:1234",
+			ExpectedCode: http.StatusForbidden,
+		},
+		{
+			Name:         "denied_cidr",
+			Denylist:     []string{"198.51.100.0/24"},
+			RemoteAddr:   "198.51.100.7:1234",
+			ExpectedCode: http.StatusForbidden,
+		},
+		{
+			Name:          "not_on_denylist",
+			Denylist:      []string{"198.51.100.0/24"},
+			RemoteAddr:    "198.51.101.7:1234",
+			ExpectedCode:  http.StatusOK,
+			ExpectedWrite: true,
+		},
+		{
+			Name:         "denylist_overrides_allowlist",
+			Allowlist:    []string{"198.51.100.0/24"},
+			Denylist:     []string{"198.51.100.7"},
+			RemoteAddr:   "198.51.100.7:1234",
+			ExpectedCode: http.StatusForbidden,
+		},
+		{
+			Name:         "denied_behind_trusted_proxy",
+			Denylist:     []string{"198.51.100.0/24"},
+			Proxies:      []string{"10.0.0.0/8"},
+			RemoteAddr:   "10.0.0.1:1234",
+			ForwardedFor: "203.0.113.1, 198.51.100.7, 10.0.0.2",
+			ExpectedCode: http.StatusForbidden,
+		},
+		{
+			Name:          "allowed_behind_trusted_proxy",
+			Allowlist:     []string{"192.0.2.10"},
+			Proxies:       []string{"10.0.0.0/8"},
+			RemoteAddr:    "10.0.0.1:1234",
+			ForwardedFor:  "192.0.2.10",
+			ExpectedCode:  http.StatusOK,
+			ExpectedWrite: true,
+		},
+		{
+			Name:         "forwarded_for_from_untrusted_client",
+			Allowlist:    []string{"192.0.2.10"},
+			RemoteAddr:   "192.0.2.11:1234",
+			ForwardedFor: "192.0.2.10",
+			ExpectedCode: http.StatusForbidden,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			// given
+			conf := config.Default()
+			conf.WriteAllowlist = testCase.Allowlist
+			conf.WriteDenylist = testCase.Denylist
+			conf.TrustedProxies = testCase.Proxies
+
+			repo, h, rr := makeComponentsWithConfig(t, conf)
+
+			req, err := http.NewRequest("PUT", "/nicks", bytes.NewBuffer(makeJsonNickData(t)))
+			require.NoError(t, err)
+			req.RemoteAddr = testCase.RemoteAddr
+			if testCase.ForwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", testCase.ForwardedFor)
+			}
+
+			// when
+			h.ServeHTTP(rr, req)
+
+			// then
+			require.Equal(t, testCase.ExpectedCode, rr.Code)
+			require.Equal(t, testCase.ExpectedWrite, repo.putArgument != nil)
+		})
+	}
+}
+
+func TestWriteAccessReads(t *testing.T) {
+	// given
+	conf := config.Default()
+	conf.WriteAllowlist = []string{"192.0.2.10"}
+
+	_, h, rr := makeComponentsWithConfig(t, conf)
+
+	req, err := http.NewRequest("GET", "/nicks", nil)
+	require.NoError(t, err)
+	req.RemoteAddr = "192.0.2.11:1234"
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, http.StatusOK, rr.Code, "reads should not be restricted")
+}
+
+func TestWriteAccessInvalidConfig(t *testing.T) {
+	// given
+	conf := config.Default()
+	conf.WriteDenylist = []string{"198.51.100.0/33"}
+
+	// when
+	_, err := newHandler(&repositoryMock{}, conf)
+
+	// then
+	require.Error(t, err)
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/boreq/starlight-nick-server/config"
+	"github.com/boreq/starlight-nick-server/data"
+	"github.com/boreq/starlight-nick-server/server/api"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+)
+
+// newAdminServer creates the server used by the admin listener. The admin
+// routes are served on a separate listener so that access to them can be
+// restricted independently of the public routes.
+func newAdminServer(repository Repository, conf *config.Config) (*http.Server, error) {
+	handler, err := newAdminHandler(repository, conf)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create the handler")
+	}
+
+	tlsConfig, err := newAdminTLSConfig(conf)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create the tls config")
+	}
+
+	server := &http.Server{
+		Addr:      conf.AdminServeAddress,
+		Handler:   handler,
+		TLSConfig: tlsConfig,
+	}
+	return server, nil
+}
+
+func serveAdmin(server *http.Server) error {
+	log.Info("starting admin listening", "address", server.Addr, "tls", server.TLSConfig != nil)
+	if server.TLSConfig != nil {
+		return server.ListenAndServeTLS("", "")
+	}
+	return server.ListenAndServe()
+}
+
+// statsRepository is implemented by the repositories which collect
+// statistics.
+type statsRepository interface {
+	Stats() data.RepoStats
+}
+
+// schemaRepository is implemented by the repositories which store the version
+// of the layout of the stored data.
+type schemaRepository interface {
+	Schema() (data.SchemaInfo, error)
+}
+
+func newAdminHandler(repository Repository, conf *config.Config) (http.Handler, error) {
+	h := &handler{
+		repository: repository,
+		conf:       conf,
+	}
+
+	router := httprouter.New()
+	if _, ok := repository.(statsRepository); ok {
+		router.GET("/admin/repo-stats", api.Wrap(h.GetRepoStats))
+	}
+	if _, ok := repository.(schemaRepository); ok {
+		router.GET("/admin/schema", api.Wrap(h.GetSchema))
+	}
+	if _, ok := repository.(importRepository); ok {
+		router.POST("/admin/import", api.Wrap(h.PostImport))
+	}
+	return router, nil
+}
+
+func (h *handler) GetRepoStats(r *http.Request, ps httprouter.Params) (interface{}, api.Error) {
+	return h.repository.(statsRepository).Stats(), nil
+}
+
+func (h *handler) GetSchema(r *http.Request, ps httprouter.Params) (interface{}, api.Error) {
+	schema, err := h.repository.(schemaRepository).Schema()
+	if err != nil {
+		log.Error("schema failed", "err", err)
+		return nil, api.InternalServerError
+	}
+	return schema, nil
+}
+
+// newAdminTLSConfig returns the tls config for the admin listener or nil if
+// the admin listener should use plaintext. If a client CA is configured then
+// the clients are required to present a certificate signed by that CA.
+func newAdminTLSConfig(conf *config.Config) (*tls.Config, error) {
+	if conf.AdminTLSCertPath == "" && conf.AdminTLSKeyPath == "" {
+		if conf.AdminClientCAPath != "" {
+			return nil, errors.New("client certificate authentication requires the admin tls certificate and key")
+		}
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(conf.AdminTLSCertPath, conf.AdminTLSKeyPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not load the certificate")
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if conf.AdminClientCAPath != "" {
+		pem, err := ioutil.ReadFile(conf.AdminClientCAPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read the client CA")
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("client CA file doesn't contain any certificates")
+		}
+
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,355 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/boreq/starlight-nick-server/config"
+	"github.com/boreq/starlight-nick-server/data"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+type testCertificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func (c testCertificate) tlsCertificate() tls.Certificate {
+	return tls.Certificate{
+		Certificate: [][]byte{c.der},
+		PrivateKey:  c.key,
+	}
+}
+
+func makeTestCertificate(t *testing.T, template *x509.Certificate, parent *testCertificate) testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	parentCert := template
+	parentKey := key
+	if parent != nil {
+		parentCert = parent.cert
+		parentKey = parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return testCertificate{cert: cert, key: key, der: der}
+}
+
+func writePem(t *testing.T, path string, blockType string, b []byte) {
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, pem.Encode(f, &pem.Block{Type: blockType, Bytes: b}))
+}
+
+// makeAdminTLSConfig creates a CA, a server certificate and a client
+// certificate. It returns a config pointing to the created files.
+func makeAdminTLSConfig(t *testing.T, dir string) (*config.Config, testCertificate, testCertificate) {
+	ca := makeTestCertificate(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+
+	serverCert := makeTestCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "server"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, &ca)
+
+	clientCert := makeTestCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "client"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, &ca)
+
+	serverKey, err := x509.MarshalECPrivateKey(serverCert.key)
+	require.NoError(t, err)
+
+	conf := config.Default()
+	conf.AdminTLSCertPath = filepath.Join(dir, "server.crt")
+	conf.AdminTLSKeyPath = filepath.Join(dir, "server.key")
+	conf.AdminClientCAPath = filepath.Join(dir, "ca.crt")
+
+	writePem(t, conf.AdminTLSCertPath, "CERTIFICATE", serverCert.der)
+	writePem(t, conf.AdminTLSKeyPath, "EC PRIVATE KEY", serverKey)
+	writePem(t, conf.AdminClientCAPath, "CERTIFICATE", ca.der)
+
+	return conf, ca, clientCert
+}
+
+func makeAdminTLSServer(t *testing.T, conf *config.Config) *httptest.Server {
+	handler, err := newAdminHandler(&repositoryMock{}, conf)
+	require.NoError(t, err)
+
+	tlsConfig, err := newAdminTLSConfig(conf)
+	require.NoError(t, err)
+
+	s := httptest.NewUnstartedServer(handler)
+	s.TLS = tlsConfig
+	s.StartTLS()
+	return s
+}
+
+func TestAdminClientCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conf, ca, clientCert := makeAdminTLSConfig(t, dir)
+	s := makeAdminTLSServer(t, conf)
+	defer s.Close()
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(ca.cert)
+
+	t.Run("with a valid client certificate", func(t *testing.T) {
+		client := &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					RootCAs:      rootCAs,
+					Certificates: []tls.Certificate{clientCert.tlsCertificate()},
+				},
+			},
+		}
+
+		resp, err := client.Get(s.URL + "/admin")
+		require.NoError(t, err, "request should succeed")
+		resp.Body.Close()
+	})
+
+	t.Run("without a client certificate", func(t *testing.T) {
+		client := &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					RootCAs: rootCAs,
+				},
+			},
+		}
+
+		_, err := client.Get(s.URL + "/admin")
+		require.Error(t, err, "request should be rejected")
+	})
+
+	t.Run("with a client certificate signed by a different CA", func(t *testing.T) {
+		otherCA := makeTestCertificate(t, &x509.Certificate{
+			SerialNumber:          big.NewInt(4),
+			Subject:               pkix.Name{CommonName: "other ca"},
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageCertSign,
+		}, nil)
+
+		otherClientCert := makeTestCertificate(t, &x509.Certificate{
+			SerialNumber: big.NewInt(5),
+			Subject:      pkix.Name{CommonName: "other client"},
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, &otherCA)
+
+		client := &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					RootCAs:      rootCAs,
+					Certificates: []tls.Certificate{otherClientCert.tlsCertificate()},
+				},
+			},
+		}
+
+		_, err := client.Get(s.URL + "/admin")
+		require.Error(t, err, "request should be rejected")
+	})
+}
+
+func TestAdminClientCAWithoutCertificate(t *testing.T) {
+	conf := config.Default()
+	conf.AdminClientCAPath = "ca.crt"
+
+	_, err := newAdminTLSConfig(conf)
+	require.Error(t, err, "client CA without a server certificate should be rejected")
+}
+
+func TestAdminRepoStats(t *testing.T) {
+	// given
+	repo := &repositoryMock{}
+	repo.statsReturn = data.RepoStats{Accepted: 1, Conflicts: 2, Stale: 3, Invalid: 4, InvalidStored: 5}
+
+	h, err := newAdminHandler(repo, config.Default())
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/admin/repo-stats", nil)
+	require.NoError(t, err)
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, 200, rr.Code, "http status should be OK")
+	require.Equal(t, `{"gets":0,"accepted":1,"conflicts":2,"stale":3,"invalid":4,"invalidStored":5}`, rr.Body.String())
+}
+
+func TestAdminSchema(t *testing.T) {
+	// given
+	repo := &repositoryMock{}
+	repo.schemaReturn = data.SchemaInfo{Version: 1, SupportedVersion: 2}
+
+	h, err := newAdminHandler(repo, config.Default())
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/admin/schema", nil)
+	require.NoError(t, err)
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, 200, rr.Code, "http status should be OK")
+	require.Equal(t, `{"version":1,"supportedVersion":2}`, rr.Body.String())
+}
+
+func TestAdminImportSkip(t *testing.T) {
+	// given
+	repo := &repositoryMock{}
+	repo.importReturn = data.ImportSummary{Imported: 1, Invalid: 1}
+
+	h, err := newAdminHandler(repo, config.Default())
+	require.NoError(t, err)
+
+	var lines []string
+	for _, nick := range []string{"nick1", "nick2", "nick3"} {
+		nickData := makeNickData()
+		nickData.Nick = nick
+		j, err := json.Marshal(nickData)
+		require.NoError(t, err)
+		lines = append(lines, string(j))
+	}
+	lines = append(lines, "malformed")
+	body := strings.Join(lines, "\n")
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/admin/import?skip=1", strings.NewReader(body))
+	require.NoError(t, err)
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, 200, rr.Code, "http status should be OK")
+	require.Equal(t, `{"imported":1,"older":0,"conflicts":0,"invalid":2,"processed":4}`, rr.Body.String())
+
+	require.Len(t, repo.importArgument, 2, "skipped lines should not be imported")
+	require.Equal(t, "nick2", repo.importArgument[0].Nick)
+	require.Equal(t, "nick3", repo.importArgument[1].Nick)
+}
+
+func TestAdminImportInterruptedBody(t *testing.T) {
+	// given
+	repo := &repositoryMock{}
+
+	h, err := newAdminHandler(repo, config.Default())
+	require.NoError(t, err)
+
+	body := makeImportBody(t, importBatchSize+10)
+	reader := io.MultiReader(strings.NewReader(body), iotest.ErrReader(errors.New("connection reset")))
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/admin/import", reader)
+	require.NoError(t, err)
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, 400, rr.Code, "http status should be Bad Request")
+	require.Equal(t, fmt.Sprintf(`{"imported":0,"older":0,"conflicts":0,"invalid":0,"processed":%d,"error":"Could not read the body."}`, importBatchSize+10), rr.Body.String())
+	require.Len(t, repo.importArgument, importBatchSize+10, "lines read before the failure should be imported")
+}
+
+func TestAdminImportStoreError(t *testing.T) {
+	// given
+	repo := &repositoryMock{}
+	repo.importErr = errors.New("some error")
+
+	h, err := newAdminHandler(repo, config.Default())
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/admin/import?skip=2", strings.NewReader(makeImportBody(t, 5)))
+	require.NoError(t, err)
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, 500, rr.Code, "http status should be Internal Server Error")
+	require.Equal(t, `{"imported":0,"older":0,"conflicts":0,"invalid":0,"processed":2,"error":"Internal server error."}`, rr.Body.String())
+}
+
+func makeImportBody(t *testing.T, n int) string {
+	var lines []string
+	for i := 0; i < n; i++ {
+		nickData := makeNickData()
+		nickData.Nick = fmt.Sprintf("nick%d", i)
+		j, err := json.Marshal(nickData)
+		require.NoError(t, err)
+		lines = append(lines, string(j))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func TestAdminImportInvalidSkip(t *testing.T) {
+	for _, skip := range []string{"-1", "a"} {
+		t.Run(skip, func(t *testing.T) {
+			// given
+			repo := &repositoryMock{}
+
+			h, err := newAdminHandler(repo, config.Default())
+			require.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			req, err := http.NewRequest("POST", "/admin/import?skip="+skip, strings.NewReader(""))
+			require.NoError(t, err)
+
+			// when
+			h.ServeHTTP(rr, req)
+
+			// then
+			require.Equal(t, 400, rr.Code, "http status should be Bad Request")
+			require.Nil(t, repo.importArgument, "nothing should be imported")
+		})
+	}
+}
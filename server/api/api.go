@@ -1,35 +1,61 @@
-// Package api implements a framework for creating a JSON API.
+// Package api implements a framework for creating a JSON API. The clients can
+// also request the responses to be encoded using CBOR.
 package api
 
 import (
 	"bytes"
 	"encoding/json"
+	"mime"
 	"net/http"
+	"strings"
 
+	"github.com/boreq/starlight-nick-server/data"
 	"github.com/boreq/starlight-nick-server/logging"
+	"github.com/fxamacker/cbor/v2"
 	"github.com/julienschmidt/httprouter"
 )
 
+// CBORContentType is the content type of the bodies encoded using CBOR.
+const CBORContentType = data.CBORContentType
+
 var log = logging.New("api")
 
 var InternalServerError = NewError(500, "Internal server error.")
 var BadRequest = NewError(400, "Bad request.")
+var Forbidden = NewError(403, "Forbidden.")
 var NotFound = NewError(404, "Not found.")
+var RequestEntityTooLarge = NewError(413, "Request entity too large.")
+var TooManyRequests = NewError(429, "Too many requests.")
 var NotImplemented = NewError(501, "Not implemented.")
+var ServiceUnavailable = NewError(503, "Service unavailable.")
 
 type Error interface {
 	GetCode() int
 	Error() string
 	WithMessage(message string) Error
+
+	// WithReason returns a copy of the error which additionally contains a
+	// machine-readable reason and a detail refining it.
+	WithReason(reason, detail string) Error
+
+	// WithHeader returns a copy of the error which causes the specified
+	// header to be set in the response.
+	WithHeader(key, value string) Error
+
+	// GetHeaders returns the headers which should be set in the response.
+	GetHeaders() http.Header
 }
 
 func NewError(code int, message string) Error {
-	return apiError{code, message}
+	return apiError{Code: code, Message: message}
 }
 
 type apiError struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
+	Reason  string `json:"reason,omitempty"`
+	Detail  string `json:"detail,omitempty"`
+	headers http.Header
 }
 
 func (err apiError) GetCode() int {
@@ -41,30 +67,169 @@ func (err apiError) Error() string {
 }
 
 func (err apiError) WithMessage(message string) Error {
-	return apiError{Code: err.Code, Message: message}
+	err.Message = message
+	return err
+}
+
+func (err apiError) WithReason(reason, detail string) Error {
+	err.Reason = reason
+	err.Detail = detail
+	return err
+}
+
+func (err apiError) WithHeader(key, value string) Error {
+	headers := err.headers.Clone()
+	if headers == nil {
+		headers = make(http.Header)
+	}
+	headers.Set(key, value)
+	err.headers = headers
+	return err
+}
+
+func (err apiError) GetHeaders() http.Header {
+	return err.headers
+}
+
+// Response can be returned by a handler to send a successful response with a
+// status code other than 200 or with additional headers.
+type Response struct {
+	Code    int
+	Body    interface{}
+	Headers http.Header
+
+	// ContentType is set if the body is already encoded, in that case the
+	// body is a []byte sent as is instead of being encoded as JSON.
+	ContentType string
+}
+
+func NewResponse(code int, body interface{}) Response {
+	return Response{Code: code, Body: body}
+}
+
+// NewRawResponse returns a response with a body which isn't encoded as JSON.
+func NewRawResponse(code int, contentType string, body []byte) Response {
+	return Response{Code: code, Body: body, ContentType: contentType}
+}
+
+// WithHeader returns a copy of the response which causes the specified header
+// to be set.
+func (r Response) WithHeader(key, value string) Response {
+	headers := r.Headers.Clone()
+	if headers == nil {
+		headers = make(http.Header)
+	}
+	headers.Set(key, value)
+	return Response{Code: r.Code, Body: r.Body, Headers: headers, ContentType: r.ContentType}
 }
 
 type Handle func(r *http.Request, p httprouter.Params) (interface{}, Error)
 
 func Call(w http.ResponseWriter, r *http.Request, p httprouter.Params, handle Handle) error {
 	code := 200
+	contentType := ""
 	response, apiErr := handle(r, p)
+	if resp, ok := response.(Response); ok {
+		response = resp.Body
+		code = resp.Code
+		contentType = resp.ContentType
+		for key, values := range resp.Headers {
+			w.Header()[key] = values
+		}
+	}
+	addVary(w.Header(), "Accept")
+	if apiErr == nil && contentType != "" {
+		body, _ := response.([]byte)
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(code)
+		_, err := w.Write(body)
+		return err
+	}
 	if apiErr != nil {
-		response = apiError{apiErr.GetCode(), apiErr.Error()}
+		if e, ok := apiErr.(apiError); ok {
+			response = e
+		} else {
+			response = apiError{Code: apiErr.GetCode(), Message: apiErr.Error()}
+		}
 		code = apiErr.GetCode()
+		for key, values := range apiErr.GetHeaders() {
+			w.Header()[key] = values
+		}
+	}
+	if code == http.StatusNotModified {
+		w.WriteHeader(code)
+		return nil
 	}
-	j, err := json.Marshal(response)
+	enc := selectEncoder(r)
+	j, err := enc.Marshal(response)
 	if err != nil {
 		log.Error("marshal error", "err", err)
-		j, _ = json.Marshal(InternalServerError)
+		j, _ = enc.Marshal(InternalServerError)
 		code = InternalServerError.GetCode()
 	}
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", enc.ContentType)
 	w.WriteHeader(code)
 	_, err = bytes.NewBuffer(j).WriteTo(w)
 	return err
 }
 
+// Unmarshal decodes the body of the request using CBOR if it is indicated by
+// the Content-Type header and JSON otherwise.
+func Unmarshal(r *http.Request, body []byte, v interface{}) error {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err == nil && mediaType == CBORContentType {
+		return cbor.Unmarshal(body, v)
+	}
+	return json.Unmarshal(body, v)
+}
+
+type encoder struct {
+	ContentType string
+	Marshal     func(v interface{}) ([]byte, error)
+}
+
+var jsonEncoder = encoder{ContentType: "application/json", Marshal: json.Marshal}
+
+// cborEncoder encodes the time as an RFC 3339 string with the nanoseconds,
+// the same as in the case of JSON.
+var cborEncoder = encoder{ContentType: CBORContentType, Marshal: data.CBOREncMode.Marshal}
+
+// ContentType returns the content type which will be used to encode the
+// response to the request.
+func ContentType(r *http.Request) string {
+	return selectEncoder(r).ContentType
+}
+
+// selectEncoder returns the encoder of the first supported media type listed
+// in the Accept header. JSON is used by default.
+func selectEncoder(r *http.Request) encoder {
+	for _, value := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(value))
+		if err != nil || params["q"] == "0" {
+			continue
+		}
+		switch mediaType {
+		case "application/json":
+			return jsonEncoder
+		case CBORContentType:
+			return cborEncoder
+		}
+	}
+	return jsonEncoder
+}
+
+// addVary adds the value to the Vary header unless it is already listed.
+func addVary(header http.Header, value string) {
+	for _, values := range header.Values("Vary") {
+		for _, v := range strings.Split(values, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), value) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", value)
+}
+
 func Wrap(handle Handle) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 		Call(w, r, p, handle)
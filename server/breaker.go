@@ -0,0 +1,171 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/boreq/starlight-nick-server/config"
+	"github.com/boreq/starlight-nick-server/server/api"
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// defaultBreakerCooldown is used if CircuitBreakerCooldown isn't set.
+const defaultBreakerCooldown = 10 * time.Second
+
+// States of the circuit breaker reported by the metric.
+const (
+	breakerClosed   = 0
+	breakerOpen     = 1
+	breakerHalfOpen = 2
+)
+
+// circuitBreaker rejects the requests accessing the repository after too
+// many consecutive requests failed with Internal Server Error, which is
+// returned when the repository fails. Once the cool-down period passes a
+// single request is let through to probe the repository. The breaker closes
+// if it succeeds and opens again otherwise.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mutex    sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+}
+
+// newCircuitBreaker returns nil if the breaker is disabled.
+func newCircuitBreaker(conf *config.Config, registry *prometheus.Registry) *circuitBreaker {
+	if conf.CircuitBreakerThreshold <= 0 {
+		return nil
+	}
+
+	b := &circuitBreaker{
+		threshold: conf.CircuitBreakerThreshold,
+		cooldown:  time.Duration(conf.CircuitBreakerCooldown),
+		now:       time.Now,
+	}
+	if b.cooldown <= 0 {
+		b.cooldown = defaultBreakerCooldown
+	}
+
+	registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "repository_circuit_breaker_state",
+			Help:      "State of the circuit breaker protecting the repository: 0 closed, 1 open, 2 half-open.",
+		}, func() float64 {
+			b.mutex.Lock()
+			defer b.mutex.Unlock()
+			return float64(b.state)
+		}),
+	)
+	return b
+}
+
+// Wrap rejects the requests with Service Unavailable while the breaker is
+// open. The handle isn't wrapped if the breaker is nil.
+func (b *circuitBreaker) Wrap(handle api.Handle) api.Handle {
+	if b == nil {
+		return handle
+	}
+
+	return func(r *http.Request, ps httprouter.Params) (interface{}, api.Error) {
+		if apiErr := b.Acquire(); apiErr != nil {
+			return nil, apiErr
+		}
+		response, apiErr := handle(r, ps)
+		b.Record(apiErr == nil || apiErr.GetCode() != http.StatusInternalServerError)
+		return response, apiErr
+	}
+}
+
+// Acquire returns Service Unavailable while the breaker is open. If nil is
+// returned then Record must be called with the result of the request. A nil
+// breaker lets all requests through.
+func (b *circuitBreaker) Acquire() api.Error {
+	if b == nil {
+		return nil
+	}
+	retryAfter, ok := b.allow()
+	if !ok {
+		return api.ServiceUnavailable.
+			WithMessage("Storage is temporarily unavailable.").
+			WithHeader("Retry-After", strconv.Itoa(retryAfter))
+	}
+	return nil
+}
+
+// Record reports if the request let through by Acquire succeeded.
+func (b *circuitBreaker) Record(success bool) {
+	if b == nil {
+		return
+	}
+	b.record(success)
+}
+
+// allow returns true if the request can be executed. Otherwise it returns the
+// number of seconds after which the request should be retried.
+func (b *circuitBreaker) allow() (int, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		remaining := b.cooldown - b.now().Sub(b.openedAt)
+		if remaining > 0 {
+			return int((remaining + time.Second - 1) / time.Second), false
+		}
+		b.state = breakerHalfOpen
+		return 0, true
+	case breakerHalfOpen:
+		return 1, false
+	default:
+		return 0, true
+	}
+}
+
+func (b *circuitBreaker) record(success bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if success {
+		if b.state != breakerClosed {
+			log.Info("circuit breaker closed")
+		}
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= b.threshold) {
+		log.Error("circuit breaker opened", "failures", b.failures)
+		b.state = breakerOpen
+		b.openedAt = b.now()
+	}
+}
+
+// State returns the name of the current state of the breaker or "disabled" if
+// the breaker is nil.
+func (b *circuitBreaker) State() string {
+	if b == nil {
+		return "disabled"
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/boreq/starlight-nick-server/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	// given
+	conf := config.Default()
+	conf.CircuitBreakerThreshold = 2
+	conf.CircuitBreakerCooldown = config.Duration(50 * time.Millisecond)
+	repo, h, _ := makeComponentsWithConfig(t, conf)
+
+	get := func() *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/nicks/abcd", nil)
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	repo.getErr = errors.New("disk failure")
+
+	// when
+	require.Equal(t, 500, get().Code, "first failure should be returned")
+	require.Equal(t, 500, get().Code, "second failure should be returned")
+
+	repo.getArgument = nil
+	rr := get()
+
+	// then
+	require.Equal(t, 503, rr.Code, "breaker should be open")
+	require.NotEmpty(t, rr.Header().Get("Retry-After"))
+	require.Nil(t, repo.getArgument, "repository should not be called while the breaker is open")
+
+	// when
+	time.Sleep(60 * time.Millisecond)
+	repo.getErr = nil
+	repo.getReturn = makeNickData()
+
+	// then
+	require.Equal(t, 200, get().Code, "probe should be let through after the cool-down")
+	require.Equal(t, 200, get().Code, "breaker should be closed after a successful probe")
+}
+
+func TestCircuitBreakerReopensAfterFailedProbe(t *testing.T) {
+	// given
+	conf := config.Default()
+	conf.CircuitBreakerThreshold = 1
+	conf.CircuitBreakerCooldown = config.Duration(time.Minute)
+	b := newCircuitBreaker(conf, prometheus.NewRegistry())
+
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	b.record(false)
+	_, ok := b.allow()
+	require.False(t, ok, "breaker should be open")
+
+	// when
+	now = now.Add(time.Minute)
+	_, ok = b.allow()
+	require.True(t, ok, "probe should be allowed")
+
+	_, ok = b.allow()
+	require.False(t, ok, "only a single probe should be allowed")
+
+	b.record(false)
+
+	// then
+	retryAfter, ok := b.allow()
+	require.False(t, ok, "breaker should open again")
+	require.Equal(t, 60, retryAfter)
+}
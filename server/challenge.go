@@ -0,0 +1,89 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	challengeTimeLength   = 8
+	challengeRandomLength = 16
+	challengeMACLength    = 16
+	challengeLength       = challengeTimeLength + challengeRandomLength + challengeMACLength
+)
+
+// challenger issues and verifies the challenges which the clients include in
+// the signed nick data to bind it to this server. A challenge consists of the
+// time at which it was issued, random bytes and a MAC of both computed using a
+// secret known only to this server. The challenges are therefore stateless,
+// expire after the configured time and challenges issued by other servers are
+// rejected.
+type challenger struct {
+	secret []byte
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+func newChallenger(secret []byte, ttl time.Duration) (*challenger, error) {
+	if ttl <= 0 {
+		return nil, errors.New("challenge ttl must be positive")
+	}
+
+	if len(secret) == 0 {
+		secret = make([]byte, sha256.Size)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, errors.Wrap(err, "could not generate the secret")
+		}
+	}
+
+	c := &challenger{
+		secret: secret,
+		ttl:    ttl,
+		now:    time.Now,
+	}
+	return c, nil
+}
+
+// Issue returns a new challenge and the time at which it expires.
+func (c *challenger) Issue() ([]byte, time.Time, error) {
+	now := c.now()
+
+	challenge := make([]byte, challengeTimeLength+challengeRandomLength, challengeLength)
+	binary.BigEndian.PutUint64(challenge, uint64(now.Unix()))
+	if _, err := rand.Read(challenge[challengeTimeLength:]); err != nil {
+		return nil, time.Time{}, errors.Wrap(err, "could not generate the random bytes")
+	}
+	challenge = append(challenge, c.mac(challenge)...)
+
+	return challenge, now.Add(c.ttl), nil
+}
+
+// Verify returns an error if the challenge wasn't issued by this server or
+// expired.
+func (c *challenger) Verify(challenge []byte) error {
+	if len(challenge) != challengeLength {
+		return errors.New("invalid length")
+	}
+
+	payload := challenge[:challengeTimeLength+challengeRandomLength]
+	if !hmac.Equal(c.mac(payload), challenge[len(payload):]) {
+		return errors.New("invalid mac")
+	}
+
+	issued := time.Unix(int64(binary.BigEndian.Uint64(challenge)), 0)
+	if c.now().Sub(issued) > c.ttl {
+		return errors.New("challenge expired")
+	}
+	return nil
+}
+
+func (c *challenger) mac(payload []byte) []byte {
+	h := hmac.New(sha256.New, c.secret)
+	h.Write(payload)
+	return h.Sum(nil)[:challengeMACLength]
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChallengerVerify(t *testing.T) {
+	c, err := newChallenger(nil, time.Minute)
+	require.NoError(t, err)
+
+	challenge, expires, err := c.Issue()
+	require.NoError(t, err)
+	require.True(t, expires.After(time.Now()), "challenge should expire in the future")
+
+	require.NoError(t, c.Verify(challenge))
+}
+
+func TestChallengerVerifyStale(t *testing.T) {
+	c, err := newChallenger(nil, time.Minute)
+	require.NoError(t, err)
+
+	challenge, _, err := c.Issue()
+	require.NoError(t, err)
+
+	c.now = func() time.Time {
+		return time.Now().Add(2 * time.Minute)
+	}
+
+	require.Error(t, c.Verify(challenge), "stale challenge should be rejected")
+}
+
+func TestChallengerVerifyForeign(t *testing.T) {
+	c, err := newChallenger([]byte("secret"), time.Minute)
+	require.NoError(t, err)
+
+	foreign, err := newChallenger([]byte("other secret"), time.Minute)
+	require.NoError(t, err)
+
+	challenge, _, err := foreign.Issue()
+	require.NoError(t, err)
+
+	require.Error(t, c.Verify(challenge), "challenge issued by a different server should be rejected")
+}
+
+func TestChallengerVerifyTampered(t *testing.T) {
+	c, err := newChallenger(nil, time.Minute)
+	require.NoError(t, err)
+
+	challenge, _, err := c.Issue()
+	require.NoError(t, err)
+
+	// Try to extend the lifetime of the challenge
+	challenge[challengeTimeLength-1]++
+
+	require.Error(t, c.Verify(challenge))
+	require.Error(t, c.Verify(challenge[:challengeLength-1]))
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/boreq/starlight-nick-server/config"
+	"github.com/boreq/starlight-nick-server/data"
+	"github.com/boreq/starlight-nick-server/server/api"
+	"github.com/boreq/starlight/network/node"
+	"github.com/julienschmidt/httprouter"
+)
+
+// deleteRepository is implemented by the repositories which can remove the
+// stored entries.
+type deleteRepository interface {
+	DeleteTombstone(tombstone *data.Tombstone) error
+}
+
+// DeleteNick removes the nick data of a node. The body has to contain a
+// tombstone signed by the node with a time newer than the time of the stored
+// nick data so that tombstones can't be replayed to remove newer nick data.
+// The times are compared by the repository when the entry is removed.
+func (h *handler) DeleteNick(r *http.Request, ps httprouter.Params) (interface{}, api.Error) {
+	if h.conf.Mode == config.ModeFollower {
+		return nil, readOnlyError
+	}
+
+	nodeId, apiErr := getNodeIdParam(ps)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	if r.Body == nil {
+		return nil, api.BadRequest
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, maxPutBodySize))
+	if err != nil {
+		return nil, api.BadRequest
+	}
+
+	tombstone := &data.Tombstone{}
+	if err := json.Unmarshal(body, tombstone); err != nil {
+		return nil, api.BadRequest
+	}
+
+	if !node.CompareId(tombstone.Id, nodeId) {
+		return nil, api.BadRequest.WithMessage("Tombstone was created for a different node.")
+	}
+
+	if !h.verification.Acquire(r.Context()) {
+		return nil, tooManyWritesError
+	}
+	defer h.verification.Release()
+
+	if err := tombstone.ValidateWithPolicy(h.nickPolicy()); err != nil {
+		return nil, api.BadRequest.WithMessage(err.Error())
+	}
+
+	if err := h.repository.(deleteRepository).DeleteTombstone(tombstone); err != nil {
+		switch {
+		case err == data.NotFoundErr:
+			return nil, api.NotFound
+		case err == data.ReadOnlyErr:
+			return nil, readOnlyError
+		case isClientError(err):
+			return nil, api.BadRequest.WithMessage(err.Error())
+		default:
+			log.Error("delete nick failed", "err", err)
+			return nil, api.InternalServerError
+		}
+	}
+
+	return nil, nil
+}
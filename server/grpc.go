@@ -0,0 +1,402 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/boreq/starlight-nick-server/config"
+	"github.com/boreq/starlight-nick-server/data"
+	"github.com/boreq/starlight-nick-server/server/api"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// grpcServiceName is the full name of the service defined in
+// nickserver.proto.
+const grpcServiceName = "starlight.nick.NickServer"
+
+// ServeGRPC serves the gRPC API defined in nickserver.proto at GRPCAddress
+// until the context is cancelled. The requests being processed are given
+// ShutdownTimeout to finish. The repository isn't closed. Serve calls this
+// function if GRPCAddress is set so it only has to be called directly when
+// the gRPC API is served without the HTTP API.
+func ServeGRPC(ctx context.Context, repository Repository, conf *config.Config) error {
+	guards, err := newWriteGuards(conf, newReadiness(true), prometheus.NewRegistry())
+	if err != nil {
+		return errors.Wrap(err, "invalid config")
+	}
+	server, err := newGRPCServer(repository, conf, guards)
+	if err != nil {
+		return err
+	}
+	return serveGRPC(ctx, server, conf)
+}
+
+// serveGRPC serves the server at GRPCAddress until the context is cancelled.
+func serveGRPC(ctx context.Context, server *grpc.Server, conf *config.Config) error {
+	listener, err := net.Listen("tcp", conf.GRPCAddress)
+	if err != nil {
+		return errors.Wrap(err, "could not listen")
+	}
+
+	errC := make(chan error, 1)
+	go func() {
+		log.Info("starting grpc listening", "address", conf.GRPCAddress)
+		errC <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-errC:
+		return err
+	case <-ctx.Done():
+		stopGRPC(server, conf)
+		return nil
+	}
+}
+
+// stopGRPC stops the server waiting for the requests being processed to
+// finish for at most ShutdownTimeout.
+func stopGRPC(server *grpc.Server, conf *config.Config) {
+	timeout := time.Duration(conf.ShutdownTimeout)
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		server.Stop()
+	}
+}
+
+// newGRPCServer creates a gRPC server exposing the repository. The messages
+// are encoded using the same functions as the responses of the HTTP API sent
+// to the clients which accept application/protobuf. The writes are subject to
+// the same guards as the writes sent over the HTTP API.
+func newGRPCServer(repository Repository, conf *config.Config, guards *writeGuards) (*grpc.Server, error) {
+	if err := conf.ValidateSettings(); err != nil {
+		return nil, errors.Wrap(err, "invalid config")
+	}
+
+	server := grpc.NewServer(grpc.ForceServerCodec(protobufCodec{}))
+	server.RegisterService(&nickServerDesc, &nickServer{
+		repository: repository,
+		conf:       conf,
+		policy:     newNickPolicy(conf),
+		guards:     guards,
+	})
+	return server, nil
+}
+
+// nickServerService lists the methods of the service, it is used to confirm
+// that the registered implementation is complete.
+type nickServerService interface {
+	GetNick(ctx context.Context, req *getNickRequest) (*data.NickData, error)
+	GetByNick(ctx context.Context, req *getByNickRequest) (*data.NickData, error)
+	PutNick(ctx context.Context, nickData *data.NickData) (*putNickResponse, error)
+	ListNicks(ctx context.Context, req *listNicksRequest) (*nickDataList, error)
+}
+
+var nickServerDesc = grpc.ServiceDesc{
+	ServiceName: grpcServiceName,
+	HandlerType: (*nickServerService)(nil),
+	Methods: []grpc.MethodDesc{
+		grpcMethod("GetNick", func() interface{} { return &getNickRequest{} }, func(s nickServerService, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.GetNick(ctx, req.(*getNickRequest))
+		}),
+		grpcMethod("GetByNick", func() interface{} { return &getByNickRequest{} }, func(s nickServerService, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.GetByNick(ctx, req.(*getByNickRequest))
+		}),
+		grpcMethod("PutNick", func() interface{} { return &data.NickData{} }, func(s nickServerService, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.PutNick(ctx, req.(*data.NickData))
+		}),
+		grpcMethod("ListNicks", func() interface{} { return &listNicksRequest{} }, func(s nickServerService, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.ListNicks(ctx, req.(*listNicksRequest))
+		}),
+	},
+	Metadata: "nickserver.proto",
+}
+
+// grpcMethod describes a unary method in the same way as the code generated
+// by protoc-gen-go-grpc.
+func grpcMethod(name string, newRequest func() interface{}, call func(s nickServerService, ctx context.Context, req interface{}) (interface{}, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := newRequest()
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return call(srv.(nickServerService), ctx, req)
+			}
+			if interceptor == nil {
+				return handler(ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{
+				Server:     srv,
+				FullMethod: "/" + grpcServiceName + "/" + name,
+			}
+			return interceptor(ctx, req, info, handler)
+		},
+	}
+}
+
+type nickServer struct {
+	repository Repository
+	conf       *config.Config
+	policy     data.NickPolicy
+	guards     *writeGuards
+}
+
+func (s *nickServer) GetNick(ctx context.Context, req *getNickRequest) (*data.NickData, error) {
+	nickData, err := s.repository.Get(req.Id)
+	if err != nil {
+		return nil, grpcError("get nick failed", err)
+	}
+	if nickData == nil {
+		return nil, status.Error(codes.NotFound, "Not found.")
+	}
+	return nickData, nil
+}
+
+func (s *nickServer) GetByNick(ctx context.Context, req *getByNickRequest) (*data.NickData, error) {
+	if err := s.policy.ValidateNick(req.Nick); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Invalid nick.")
+	}
+
+	nickData, err := s.repository.GetByNick(req.Nick)
+	if err != nil {
+		return nil, grpcError("get by nick failed", err)
+	}
+	if nickData == nil {
+		return nil, status.Error(codes.NotFound, "Not found.")
+	}
+	return nickData, nil
+}
+
+// PutNick stores the nick data which is validated by the repository, the same
+// as in the case of the HTTP API. Challenges can't be obtained over gRPC so
+// the writes are rejected if the server requires them.
+func (s *nickServer) PutNick(ctx context.Context, nickData *data.NickData) (*putNickResponse, error) {
+	if s.conf.Mode == config.ModeFollower {
+		return nil, status.Error(codes.Unavailable, readOnlyError.Error())
+	}
+	if s.conf.RequireChallenge {
+		return nil, status.Error(codes.FailedPrecondition, "This server requires a challenge, use the HTTP API.")
+	}
+
+	if apiErr := s.guards.Acquire(ctx, peerIP(ctx)); apiErr != nil {
+		return nil, grpcGuardError(apiErr)
+	}
+
+	if err := s.repository.Put(nickData); err != nil {
+		err = grpcError("put nick failed", err)
+		s.guards.Release(status.Code(err) != codes.Internal)
+		return nil, err
+	}
+	s.guards.Release(true)
+	return &putNickResponse{}, nil
+}
+
+// peerIP returns the address of the client or nil if it can't be determined.
+// Unlike in the case of the HTTP API the proxies aren't taken into account as
+// the address can't be forwarded.
+func peerIP(ctx context.Context) net.IP {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	if addr, ok := p.Addr.(*net.TCPAddr); ok {
+		return addr.IP
+	}
+	return nil
+}
+
+// grpcGuardError converts the errors returned by the write guards to the
+// status codes listed in nickserver.proto.
+func grpcGuardError(apiErr api.Error) error {
+	switch apiErr.GetCode() {
+	case http.StatusForbidden:
+		return status.Error(codes.PermissionDenied, apiErr.Error())
+	case http.StatusTooManyRequests:
+		return status.Error(codes.ResourceExhausted, apiErr.Error())
+	default:
+		return status.Error(codes.Unavailable, apiErr.Error())
+	}
+}
+
+func (s *nickServer) ListNicks(ctx context.Context, req *listNicksRequest) (*nickDataList, error) {
+	if s.conf.DisableList {
+		return nil, status.Error(codes.PermissionDenied, listDisabledError.Error())
+	}
+
+	nickDatas, err := s.repository.List(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+		return nil, grpcError("list failed", err)
+	}
+	return &nickDataList{Items: nickDatas}, nil
+}
+
+// grpcError converts the errors returned by the repository to the status
+// codes listed in nickserver.proto. Unexpected errors are logged.
+func grpcError(msg string, err error) error {
+	switch {
+	case errors.Is(err, data.InvalidNickDataErr):
+		return status.Error(codes.InvalidArgument, invalidNickDataError(err).Error())
+	case err == data.InvalidNodeIdErr, err == data.InvalidNickErr, err == data.NickKeyTooLongErr, err == data.ReservedNickErr:
+		return status.Error(codes.InvalidArgument, err.Error())
+	case err == data.NickConflictErr, err == data.NickQuarantinedErr:
+		return status.Error(codes.AlreadyExists, err.Error())
+	case err == data.NewerNickDataPresentErr, err == data.SameTimeChangeErr:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case err == data.ReadOnlyErr:
+		return status.Error(codes.Unavailable, readOnlyError.Error())
+	default:
+		log.Error(msg, "err", err)
+		return status.Error(codes.Internal, "Internal server error.")
+	}
+}
+
+type getNickRequest struct {
+	Id []byte
+}
+
+type getByNickRequest struct {
+	Nick string
+}
+
+type putNickResponse struct {
+}
+
+type listNicksRequest struct {
+}
+
+type nickDataList struct {
+	Items []data.NickData
+}
+
+// Numbers of the fields of the request messages.
+const (
+	protobufFieldGetNickId     = 1
+	protobufFieldGetByNickNick = 1
+)
+
+// protobufCodec encodes the messages defined in nickserver.proto. It replaces
+// the default codec which only supports generated messages.
+type protobufCodec struct{}
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case *data.NickData:
+		return v.MarshalProtobuf(), nil
+	case *nickDataList:
+		return data.MarshalProtobufList(v.Items), nil
+	case *getNickRequest:
+		return appendProtobufBytesField(nil, protobufFieldGetNickId, v.Id), nil
+	case *getByNickRequest:
+		return appendProtobufBytesField(nil, protobufFieldGetByNickNick, []byte(v.Nick)), nil
+	case *putNickResponse, *listNicksRequest:
+		return nil, nil
+	default:
+		return nil, errors.Errorf("unsupported message %T", v)
+	}
+}
+
+func (protobufCodec) Unmarshal(b []byte, v interface{}) error {
+	switch v := v.(type) {
+	case *data.NickData:
+		nickData, err := data.UnmarshalProtobuf(b)
+		if err != nil {
+			return err
+		}
+		*v = *nickData
+		return nil
+	case *nickDataList:
+		nickDatas, err := data.UnmarshalProtobufList(b)
+		if err != nil {
+			return err
+		}
+		v.Items = nickDatas
+		return nil
+	case *getNickRequest:
+		id, err := consumeProtobufBytesField(b, protobufFieldGetNickId)
+		if err != nil {
+			return err
+		}
+		v.Id = id
+		return nil
+	case *getByNickRequest:
+		nick, err := consumeProtobufBytesField(b, protobufFieldGetByNickNick)
+		if err != nil {
+			return err
+		}
+		v.Nick = string(nick)
+		return nil
+	case *putNickResponse, *listNicksRequest:
+		return nil
+	default:
+		return errors.Errorf("unsupported message %T", v)
+	}
+}
+
+// Name returns the name of the default codec so that the standard clients can
+// be used.
+func (protobufCodec) Name() string {
+	return "proto"
+}
+
+// appendProtobufBytesField appends the field unless the value is empty.
+func appendProtobufBytesField(b []byte, field protowire.Number, value []byte) []byte {
+	if len(value) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, field, protowire.BytesType)
+	return protowire.AppendBytes(b, value)
+}
+
+// consumeProtobufBytesField returns the value of the last occurrence of the
+// field skipping all other fields.
+func consumeProtobufBytesField(b []byte, field protowire.Number) ([]byte, error) {
+	var rv []byte
+	for len(b) > 0 {
+		number, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if number == field && typ == protowire.BytesType {
+			value, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			rv = append([]byte(nil), value...)
+			b = b[n:]
+			continue
+		}
+
+		n = protowire.ConsumeFieldValue(number, typ, b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return rv, nil
+}
@@ -0,0 +1,240 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/boreq/starlight-nick-server/config"
+	"github.com/boreq/starlight-nick-server/data"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestGRPCPutAndGet(t *testing.T) {
+	// given
+	conn := makeGRPCConn(t, data.NewMemoryRepository(data.DefaultOptions()), config.Default())
+	nickData := makeValidNickData(t)
+
+	// when
+	err := conn.Invoke(context.Background(), grpcMethodName("PutNick"), nickData, &putNickResponse{})
+
+	// then
+	require.NoError(t, err)
+
+	byId := &data.NickData{}
+	err = conn.Invoke(context.Background(), grpcMethodName("GetNick"), &getNickRequest{Id: nickData.Id}, byId)
+	require.NoError(t, err)
+	requireSameJson(t, nickData, byId)
+
+	byNick := &data.NickData{}
+	err = conn.Invoke(context.Background(), grpcMethodName("GetByNick"), &getByNickRequest{Nick: nickData.Nick}, byNick)
+	require.NoError(t, err)
+	requireSameJson(t, nickData, byNick)
+
+	list := &nickDataList{}
+	err = conn.Invoke(context.Background(), grpcMethodName("ListNicks"), &listNicksRequest{}, list)
+	require.NoError(t, err)
+	requireSameJson(t, []data.NickData{*nickData}, list.Items)
+}
+
+func TestGRPCPutInvalid(t *testing.T) {
+	// given
+	conn := makeGRPCConn(t, data.NewMemoryRepository(data.DefaultOptions()), config.Default())
+	nickData := makeValidNickData(t)
+	nickData.Nick = "changed"
+
+	// when
+	err := conn.Invoke(context.Background(), grpcMethodName("PutNick"), nickData, &putNickResponse{})
+
+	// then
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.Contains(t, status.Convert(err).Message(), "invalid nick data")
+}
+
+func TestGRPCPutConflict(t *testing.T) {
+	// given
+	repo := &repositoryMock{putErr: data.NickConflictErr}
+	conn := makeGRPCConn(t, repo, config.Default())
+
+	// when
+	err := conn.Invoke(context.Background(), grpcMethodName("PutNick"), makeValidNickData(t), &putNickResponse{})
+
+	// then
+	require.Equal(t, codes.AlreadyExists, status.Code(err))
+}
+
+func TestGRPCGetNotFound(t *testing.T) {
+	// given
+	conn := makeGRPCConn(t, data.NewMemoryRepository(data.DefaultOptions()), config.Default())
+
+	// when
+	err := conn.Invoke(context.Background(), grpcMethodName("GetNick"), &getNickRequest{Id: makeValidNickData(t).Id}, &data.NickData{})
+
+	// then
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestGRPCGetInvalidId(t *testing.T) {
+	// given
+	conn := makeGRPCConn(t, data.NewMemoryRepository(data.DefaultOptions()), config.Default())
+
+	// when
+	err := conn.Invoke(context.Background(), grpcMethodName("GetNick"), &getNickRequest{Id: []byte("id")}, &data.NickData{})
+
+	// then
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGRPCListDisabled(t *testing.T) {
+	// given
+	conf := config.Default()
+	conf.DisableList = true
+
+	conn := makeGRPCConn(t, data.NewMemoryRepository(data.DefaultOptions()), conf)
+
+	// when
+	err := conn.Invoke(context.Background(), grpcMethodName("ListNicks"), &listNicksRequest{}, &nickDataList{})
+
+	// then
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestGRPCPutFollower(t *testing.T) {
+	// given
+	conf := config.Default()
+	conf.Mode = config.ModeFollower
+
+	repo := &repositoryMock{}
+	conn := makeGRPCConn(t, repo, conf)
+
+	// when
+	err := conn.Invoke(context.Background(), grpcMethodName("PutNick"), makeValidNickData(t), &putNickResponse{})
+
+	// then
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	require.Nil(t, repo.putArgument, "nick data should not be stored")
+}
+
+func TestGRPCPutDenied(t *testing.T) {
+	// given
+	conf := config.Default()
+	conf.WriteAllowlist = []string{"10.0.0.1"}
+
+	repo := &repositoryMock{}
+	conn := makeGRPCConn(t, repo, conf)
+
+	// when
+	err := conn.Invoke(context.Background(), grpcMethodName("PutNick"), makeValidNickData(t), &putNickResponse{})
+
+	// then
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	require.Nil(t, repo.putArgument, "nick data should not be stored")
+}
+
+func TestGRPCPutNotReady(t *testing.T) {
+	// given
+	conf := config.Default()
+	repo := &repositoryMock{}
+	conn := makeGRPCConnWithGuards(t, repo, conf, makeWriteGuards(t, conf, newReadiness(false), prometheus.NewRegistry()))
+
+	// when
+	err := conn.Invoke(context.Background(), grpcMethodName("PutNick"), makeValidNickData(t), &putNickResponse{})
+
+	// then
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	require.Nil(t, repo.putArgument, "nick data should not be stored")
+}
+
+func TestGRPCPutRateLimitSharedWithHTTP(t *testing.T) {
+	// given
+	conf := config.Default()
+	conf.PutRateLimit = 0.001
+	conf.PutRateBurst = 1
+
+	repo := &repositoryMock{}
+	registry := prometheus.NewRegistry()
+	guards := makeWriteGuards(t, conf, newReadiness(true), registry)
+
+	h, err := newHandlerWithGuards(repo, conf, guards, nil, registry)
+	require.NoError(t, err)
+	conn := makeGRPCConnWithGuards(t, repo, conf, guards)
+
+	body, err := json.Marshal(makeValidNickData(t))
+	require.NoError(t, err)
+
+	// The address of the in-memory connection can't be determined so both
+	// requests use the bucket of the unknown addresses
+	req, err := http.NewRequest("PUT", "/nicks", bytes.NewBuffer(body))
+	require.NoError(t, err)
+	req.RemoteAddr = ""
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	require.Equal(t, 200, rr.Code, "http status should be OK")
+
+	// when
+	err = conn.Invoke(context.Background(), grpcMethodName("PutNick"), makeValidNickData(t), &putNickResponse{})
+
+	// then
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestProtobufCodecSkipsUnknownFields(t *testing.T) {
+	// given
+	codec := protobufCodec{}
+	b, err := codec.Marshal(&getByNickRequest{Nick: "nick"})
+	require.NoError(t, err)
+
+	unknown, err := codec.Marshal(&getNickRequest{Id: []byte("id")})
+	require.NoError(t, err)
+	unknown[0] = 2<<3 | unknown[0]&7
+
+	// when
+	req := &getByNickRequest{}
+	err = codec.Unmarshal(append(unknown, b...), req)
+
+	// then
+	require.NoError(t, err)
+	require.Equal(t, "nick", req.Nick)
+}
+
+// makeGRPCConn serves the gRPC API over an in-memory connection and returns
+// a client connected to it.
+func makeGRPCConn(t *testing.T, repository Repository, conf *config.Config) *grpc.ClientConn {
+	return makeGRPCConnWithGuards(t, repository, conf, makeWriteGuards(t, conf, newReadiness(true), prometheus.NewRegistry()))
+}
+
+func makeGRPCConnWithGuards(t *testing.T, repository Repository, conf *config.Config, guards *writeGuards) *grpc.ClientConn {
+	server, err := newGRPCServer(repository, conf, guards)
+	require.NoError(t, err)
+
+	listener := bufconn.Listen(1024 * 1024)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, address string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(protobufCodec{})),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	return conn
+}
+
+func grpcMethodName(name string) string {
+	return "/" + grpcServiceName + "/" + name
+}
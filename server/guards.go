@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"net"
+
+	"github.com/boreq/starlight-nick-server/config"
+	"github.com/boreq/starlight-nick-server/server/api"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// writeGuards are the controls applied to the writes regardless of the API
+// over which they are sent so that the clients can't avoid them by switching
+// to a different transport. Serve shares them between the HTTP and the gRPC
+// API so that, eg. the rate limit of a client is consumed by both.
+type writeGuards struct {
+	ready        *readiness
+	access       *writeAccess
+	limiter      *rateLimiter
+	breaker      *circuitBreaker
+	verification *verificationLimiter
+}
+
+func newWriteGuards(conf *config.Config, ready *readiness, registry *prometheus.Registry) (*writeGuards, error) {
+	if conf.MaxConcurrentVerifications < 0 {
+		return nil, errors.New("max concurrent verifications can't be negative")
+	}
+	if conf.MaxQueuedVerifications < 0 {
+		return nil, errors.New("max queued verifications can't be negative")
+	}
+
+	access, err := newWriteAccess(conf)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create the write access rules")
+	}
+
+	limiter, err := newPutRateLimiter(conf)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create the rate limiter")
+	}
+
+	return &writeGuards{
+		ready:        ready,
+		access:       access,
+		limiter:      limiter,
+		breaker:      newCircuitBreaker(conf, registry),
+		verification: newVerificationLimiter(maxConcurrentVerifications(conf), conf.MaxQueuedVerifications, registry),
+	}, nil
+}
+
+// Acquire checks if the write sent from the address can be processed. The
+// guards are checked in the same order in which the HTTP API applies them. If
+// nil is returned then Release must be called once the write is processed.
+func (g *writeGuards) Acquire(ctx context.Context, ip net.IP) api.Error {
+	if apiErr := g.access.Check(ip); apiErr != nil {
+		return apiErr
+	}
+	if apiErr := g.limiter.Check(ip); apiErr != nil {
+		return apiErr
+	}
+	if apiErr := g.ready.Check(); apiErr != nil {
+		return apiErr
+	}
+	if apiErr := g.breaker.Acquire(); apiErr != nil {
+		return apiErr
+	}
+	if !g.verification.Acquire(ctx) {
+		// Rejecting the write doesn't say anything about the repository
+		g.breaker.Record(true)
+		return tooManyWritesError
+	}
+	return nil
+}
+
+// Release reports if the repository processed the write acquired using
+// Acquire without failing.
+func (g *writeGuards) Release(success bool) {
+	g.verification.Release()
+	g.breaker.Record(success)
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/boreq/starlight-nick-server/server/api"
+	"github.com/julienschmidt/httprouter"
+)
+
+// pingRepository is implemented by repositories which can confirm that the
+// underlying storage can be accessed.
+type pingRepository interface {
+	// Ping returns an error if the storage can't be accessed.
+	Ping() error
+}
+
+type healthResponse struct {
+	Healthy bool `json:"healthy"`
+}
+
+// GetHealth returns 200 if the repository can be accessed and 503 otherwise.
+// Repositories which can't be checked are assumed to be healthy.
+func (h *handler) GetHealth(r *http.Request, ps httprouter.Params) (interface{}, api.Error) {
+	repository, ok := h.repository.(pingRepository)
+	if !ok {
+		return healthResponse{Healthy: true}, nil
+	}
+	if err := repository.Ping(); err != nil {
+		log.Error("health check failed", "err", err)
+		return api.NewResponse(http.StatusServiceUnavailable, healthResponse{Healthy: false}), nil
+	}
+	return healthResponse{Healthy: true}, nil
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/boreq/starlight-nick-server/config"
+	"github.com/boreq/starlight-nick-server/data"
+	"github.com/boreq/starlight-nick-server/server/api"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+)
+
+// maxImportBodySize is the max size of the body of an import request.
+const maxImportBodySize = 64 * 1024 * 1024
+
+// importRepository is implemented by the repositories which can insert many
+// entries at once.
+type importRepository interface {
+	Import(nickDatas []data.NickData) (data.ImportSummary, error)
+}
+
+// importBatchSize is the number of lines after which the decoded entries are
+// stored so that an interrupted import can be resumed.
+const importBatchSize = 1000
+
+type importResponse struct {
+	Imported  int `json:"imported"`
+	Older     int `json:"older"`
+	Conflicts int `json:"conflicts"`
+	Invalid   int `json:"invalid"`
+
+	// Processed is the number of lines processed including the skipped
+	// ones. An interrupted import can be resumed by passing it as skip.
+	Processed int `json:"processed"`
+
+	// Error is set if the import was interrupted. The lines counted as
+	// processed are stored nevertheless.
+	Error string `json:"error,omitempty"`
+}
+
+// PostImport imports the nick data sent as one JSON object per line. The
+// first lines can be skipped using the "skip" parameter so that an import
+// can be resumed after a failure. Lines which can't be decoded are counted as
+// invalid. The lines are stored in batches as they are read. If reading the
+// body or storing a batch fails the response still reports the number of
+// processed lines, all of which are stored, so that the import can be resumed.
+func (h *handler) PostImport(r *http.Request, ps httprouter.Params) (interface{}, api.Error) {
+	if h.conf.Mode == config.ModeFollower {
+		return nil, readOnlyError
+	}
+
+	skip, err := getSkipParam(r)
+	if err != nil {
+		return nil, api.BadRequest.WithMessage("Invalid skip.")
+	}
+
+	if r.Body == nil {
+		return nil, api.BadRequest
+	}
+
+	var response importResponse
+	var nickDatas []data.NickData
+	pending, pendingInvalid := 0, 0
+
+	flush := func() error {
+		if len(nickDatas) > 0 {
+			summary, err := h.repository.(importRepository).Import(nickDatas)
+			if err != nil {
+				return err
+			}
+			response.Imported += summary.Imported
+			response.Older += summary.Older
+			response.Conflicts += summary.Conflicts
+			response.Invalid += summary.Invalid
+		}
+		response.Invalid += pendingInvalid
+		response.Processed += pending
+		nickDatas = nil
+		pending, pendingInvalid = 0, 0
+		return nil
+	}
+
+	scanner := bufio.NewScanner(http.MaxBytesReader(nil, r.Body, maxImportBodySize))
+	scanner.Buffer(nil, maxPutBodySize)
+	for scanner.Scan() {
+		if response.Processed < skip {
+			response.Processed++
+			continue
+		}
+		pending++
+		if len(scanner.Bytes()) != 0 {
+			nickData := data.NickData{}
+			if err := json.Unmarshal(scanner.Bytes(), &nickData); err != nil {
+				pendingInvalid++
+			} else {
+				nickDatas = append(nickDatas, nickData)
+			}
+		}
+		if pending >= importBatchSize {
+			if err := flush(); err != nil {
+				return h.importFailed(response, err)
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return h.importFailed(response, err)
+	}
+	if err := scanner.Err(); err != nil {
+		response.Error = "Could not read the body."
+		return api.NewResponse(http.StatusBadRequest, response), nil
+	}
+	return response, nil
+}
+
+// importFailed reports the lines processed before storing a batch failed.
+func (h *handler) importFailed(response importResponse, err error) (interface{}, api.Error) {
+	if errors.Cause(err) == data.ReadOnlyErr {
+		response.Error = readOnlyError.Error()
+		return api.NewResponse(readOnlyError.GetCode(), response), nil
+	}
+	log.Error("import failed", "err", err)
+	response.Error = api.InternalServerError.Error()
+	return api.NewResponse(http.StatusInternalServerError, response), nil
+}
+
+// getSkipParam returns the number of lines which should be skipped.
+func getSkipParam(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("skip")
+	if value == "" {
+		return 0, nil
+	}
+	skip, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if skip < 0 {
+		return 0, errors.New("skip can't be negative")
+	}
+	return skip, nil
+}
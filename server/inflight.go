@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/boreq/starlight-nick-server/server/api"
+	"github.com/julienschmidt/httprouter"
+)
+
+// inFlightLimiter limits the number of concurrently executed handles.
+type inFlightLimiter struct {
+	semaphore chan struct{}
+}
+
+// newInFlightLimiter returns nil if the limit is zero.
+func newInFlightLimiter(limit int) *inFlightLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	return &inFlightLimiter{
+		semaphore: make(chan struct{}, limit),
+	}
+}
+
+// Wrap limits the number of concurrently executed handles to the limit of
+// the limiter. Requests exceeding the limit are immediately rejected with
+// tooManyWritesError instead of waiting. The handle isn't limited if the
+// limiter is nil.
+func (l *inFlightLimiter) Wrap(handle httprouter.Handle) httprouter.Handle {
+	if l == nil {
+		return handle
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		select {
+		case l.semaphore <- struct{}{}:
+			defer func() { <-l.semaphore }()
+			handle(w, r, ps)
+		default:
+			api.Call(w, r, ps, func(r *http.Request, ps httprouter.Params) (interface{}, api.Error) {
+				return nil, tooManyWritesError
+			})
+		}
+	}
+}
+
+// InFlight returns the number of handles being executed.
+func (l *inFlightLimiter) InFlight() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.semaphore)
+}
+
+// Limit returns the max number of handles which can be executed concurrently,
+// zero if they aren't limited.
+func (l *inFlightLimiter) Limit() int {
+	if l == nil {
+		return 0
+	}
+	return cap(l.semaphore)
+}
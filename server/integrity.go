@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+
+	"github.com/boreq/starlight-nick-server/data"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// integrityRepository is implemented by repositories which can check that the
+// nick index agrees with the stored nick data.
+type integrityRepository interface {
+	// VerifyConsistencyInChunks returns the found discrepancies. It
+	// shouldn't hold long transactions which could block the writes.
+	VerifyConsistencyInChunks(ctx context.Context) ([]data.Discrepancy, error)
+}
+
+// integrityCheck periodically verifies the consistency of the database so that
+// corruption and bugs are noticed early. The discrepancies are logged and
+// counted but not repaired.
+type integrityCheck struct {
+	repository    integrityRepository
+	discrepancies *prometheus.CounterVec
+	last          prometheus.Gauge
+}
+
+func newIntegrityCheck(repository integrityRepository, registry *prometheus.Registry) *integrityCheck {
+	c := &integrityCheck{
+		repository: repository,
+		discrepancies: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "integrity_discrepancies_total",
+			Help:      "Number of discrepancies found by the periodic integrity checks by kind.",
+		}, []string{"kind"}),
+		last: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "integrity_last_discrepancies",
+			Help:      "Number of discrepancies found by the last integrity check.",
+		}),
+	}
+	for _, kind := range []string{data.DiscrepancyOrphanedIndexEntry, data.DiscrepancyStaleIndexEntry, data.DiscrepancyMissingIndexEntry} {
+		c.discrepancies.WithLabelValues(kind)
+	}
+	registry.MustRegister(c.discrepancies, c.last)
+	return c
+}
+
+func (c *integrityCheck) Run(ctx context.Context) error {
+	discrepancies, err := c.repository.VerifyConsistencyInChunks(ctx)
+	if err != nil {
+		return errors.Wrap(err, "could not verify the consistency")
+	}
+
+	c.last.Set(float64(len(discrepancies)))
+	for _, discrepancy := range discrepancies {
+		c.discrepancies.WithLabelValues(discrepancy.Kind).Inc()
+		log.Warn("integrity check found a discrepancy", "kind", discrepancy.Kind, "nick", discrepancy.Nick, "id", discrepancy.Id)
+	}
+	if len(discrepancies) > 0 {
+		log.Error("integrity check found discrepancies", "count", len(discrepancies))
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/boreq/starlight-nick-server/config"
+	"github.com/boreq/starlight-nick-server/server/api"
+	"github.com/julienschmidt/httprouter"
+)
+
+type loadResponse struct {
+	// InFlight is the number of API requests being processed, not
+	// counting the request for the load.
+	InFlight int64 `json:"inFlight"`
+
+	InFlightPuts    int `json:"inFlightPuts"`
+	MaxInFlightPuts int `json:"maxInFlightPuts"`
+
+	VerificationsRunning    int   `json:"verificationsRunning"`
+	VerificationsQueued     int64 `json:"verificationsQueued"`
+	MaxRunningVerifications int   `json:"maxRunningVerifications"`
+	MaxQueuedVerifications  int64 `json:"maxQueuedVerifications"`
+
+	CircuitBreaker string `json:"circuitBreaker"`
+	ReadOnly       bool   `json:"readOnly"`
+	Ready          bool   `json:"ready"`
+}
+
+// countInFlight tracks the number of requests being processed by the handle.
+func (h *handler) countInFlight(handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		atomic.AddInt64(&h.inFlight, 1)
+		defer atomic.AddInt64(&h.inFlight, -1)
+		handle(w, r, ps)
+	}
+}
+
+// GetLoad reports the current load of the server and the state of the
+// mechanisms which reject the requests when it is overloaded so that the
+// clients can slow down before their requests are rejected.
+func (h *handler) GetLoad(r *http.Request, ps httprouter.Params) (interface{}, api.Error) {
+	rv := loadResponse{
+		InFlight:                atomic.LoadInt64(&h.inFlight),
+		InFlightPuts:            h.puts.InFlight(),
+		MaxInFlightPuts:         h.puts.Limit(),
+		VerificationsRunning:    h.verification.Running(),
+		VerificationsQueued:     h.verification.Queued(),
+		MaxRunningVerifications: h.verification.MaxRunning(),
+		MaxQueuedVerifications:  h.verification.maxQueued,
+		CircuitBreaker:          h.breaker.State(),
+		ReadOnly:                h.conf.Mode == config.ModeFollower,
+		Ready:                   h.ready.Ready(),
+	}
+	return rv, nil
+}
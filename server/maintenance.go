@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/boreq/starlight-nick-server/config"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// backupRepository is implemented by repositories which can create backups.
+type backupRepository interface {
+	// Backup atomically replaces the file located at the provided path
+	// with a copy of the database.
+	Backup(path string) error
+}
+
+// clock abstracts the passage of time so that the scheduler can be tested.
+type clock interface {
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+type maintenanceTask func(ctx context.Context) error
+
+type scheduledTask struct {
+	name     string
+	interval time.Duration
+	run      maintenanceTask
+}
+
+// scheduler runs the maintenance tasks in their intervals. Each task runs in
+// its own goroutine and the next run of a task is scheduled after the previous
+// one finishes so a single task never runs concurrently with itself.
+type scheduler struct {
+	clock clock
+	tasks []scheduledTask
+}
+
+func newScheduler(clock clock) *scheduler {
+	return &scheduler{
+		clock: clock,
+	}
+}
+
+// newMaintenanceScheduler creates a scheduler running the maintenance tasks
+// specified in the config.
+func newMaintenanceScheduler(repository Repository, conf *config.Config, registry *prometheus.Registry) (*scheduler, error) {
+	s := newScheduler(realClock{})
+	for name, interval := range conf.Maintenance.Tasks {
+		if interval <= 0 {
+			return nil, errors.Errorf("interval of the task %s must be positive", name)
+		}
+
+		task, err := newMaintenanceTask(name, repository, conf, registry)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not create the task %s", name)
+		}
+
+		s.Add(name, time.Duration(interval), task)
+	}
+	return s, nil
+}
+
+func newMaintenanceTask(name string, repository Repository, conf *config.Config, registry *prometheus.Registry) (maintenanceTask, error) {
+	switch name {
+	case config.MaintenanceTaskBackup:
+		r, ok := repository.(backupRepository)
+		if !ok {
+			return nil, errors.New("repository doesn't support backups")
+		}
+		if conf.Maintenance.BackupPath == "" {
+			return nil, errors.New("backup path is not set")
+		}
+		return func(ctx context.Context) error {
+			return r.Backup(conf.Maintenance.BackupPath)
+		}, nil
+	case config.MaintenanceTaskIntegrity:
+		r, ok := repository.(integrityRepository)
+		if !ok {
+			return nil, errors.New("repository doesn't support integrity checks")
+		}
+		return newIntegrityCheck(r, registry).Run, nil
+	default:
+		return nil, errors.New("unknown task")
+	}
+}
+
+// Add schedules a task. It must not be called after Run.
+func (s *scheduler) Add(name string, interval time.Duration, task maintenanceTask) {
+	s.tasks = append(s.tasks, scheduledTask{
+		name:     name,
+		interval: interval,
+		run:      task,
+	})
+}
+
+// Run runs the tasks until the context is cancelled and the running tasks
+// return.
+func (s *scheduler) Run(ctx context.Context) {
+	wg := &sync.WaitGroup{}
+	for _, task := range s.tasks {
+		wg.Add(1)
+		go func(task scheduledTask) {
+			defer wg.Done()
+			s.runTask(ctx, task)
+		}(task)
+	}
+	wg.Wait()
+}
+
+func (s *scheduler) runTask(ctx context.Context, task scheduledTask) {
+	for {
+		select {
+		case <-s.clock.After(task.interval):
+			start := time.Now()
+			if err := task.run(ctx); err != nil {
+				log.Error("maintenance task failed", "task", task.name, "err", err)
+			} else {
+				log.Debug("maintenance task finished", "task", task.name, "duration", time.Since(start))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/boreq/starlight-nick-server/config"
+	"github.com/boreq/starlight-nick-server/data"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	durations chan time.Duration
+	ticks     chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		durations: make(chan time.Duration, 10),
+		ticks:     make(chan time.Time),
+	}
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.durations <- d
+	return c.ticks
+}
+
+func TestSchedulerRunsTaskInInterval(t *testing.T) {
+	// given
+	clock := newFakeClock()
+	s := newScheduler(clock)
+
+	runs := make(chan struct{})
+	s.Add("task", 5*time.Minute, func(ctx context.Context) error {
+		runs <- struct{}{}
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Run(ctx)
+	}()
+
+	// when
+	for i := 0; i < 3; i++ {
+		require.Equal(t, 5*time.Minute, <-clock.durations, "task should be scheduled in its interval")
+		select {
+		case <-runs:
+			t.Fatal("task should not run before the interval passes")
+		default:
+		}
+
+		clock.ticks <- time.Now()
+
+		// then
+		select {
+		case <-runs:
+		case <-time.After(time.Second):
+			t.Fatal("task should run after the interval passes")
+		}
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("scheduler should shut down")
+	}
+}
+
+func TestMaintenanceSchedulerUnknownTask(t *testing.T) {
+	conf := config.Default()
+	conf.Maintenance.Tasks = map[string]config.Duration{
+		"unknown": config.Duration(time.Minute),
+	}
+
+	_, err := newMaintenanceScheduler(&repositoryMock{}, conf, prometheus.NewRegistry())
+	require.Error(t, err)
+}
+
+func TestMaintenanceSchedulerBackupRequiresPath(t *testing.T) {
+	conf := config.Default()
+	conf.Maintenance.Tasks = map[string]config.Duration{
+		config.MaintenanceTaskBackup: config.Duration(time.Minute),
+	}
+
+	_, err := newMaintenanceScheduler(&backupRepositoryMock{}, conf, prometheus.NewRegistry())
+	require.Error(t, err)
+
+	conf.Maintenance.BackupPath = "path"
+	_, err = newMaintenanceScheduler(&backupRepositoryMock{}, conf, prometheus.NewRegistry())
+	require.NoError(t, err)
+}
+
+type backupRepositoryMock struct {
+	repositoryMock
+}
+
+func (r *backupRepositoryMock) Backup(path string) error {
+	return nil
+}
+
+type integrityRepositoryMock struct {
+	repositoryMock
+	discrepancies []data.Discrepancy
+}
+
+func (r *integrityRepositoryMock) VerifyConsistencyInChunks(ctx context.Context) ([]data.Discrepancy, error) {
+	return r.discrepancies, nil
+}
+
+func TestMaintenanceIntegrityCheck(t *testing.T) {
+	// given
+	conf := config.Default()
+	registry := prometheus.NewRegistry()
+	repo := &integrityRepositoryMock{}
+
+	task, err := newMaintenanceTask(config.MaintenanceTaskIntegrity, repo, conf, registry)
+	require.NoError(t, err)
+
+	require.NoError(t, task(context.Background()))
+	require.Equal(t, 0.0, getIntegrityDiscrepancies(t, registry, data.DiscrepancyMissingIndexEntry))
+
+	repo.discrepancies = []data.Discrepancy{
+		{Kind: data.DiscrepancyMissingIndexEntry, Nick: "nick", Id: []byte("id")},
+	}
+
+	// when
+	err = task(context.Background())
+
+	// then
+	require.NoError(t, err)
+	require.Equal(t, 1.0, getIntegrityDiscrepancies(t, registry, data.DiscrepancyMissingIndexEntry), "discrepancy should be counted")
+	require.Equal(t, 0.0, getIntegrityDiscrepancies(t, registry, data.DiscrepancyOrphanedIndexEntry))
+}
+
+func TestMaintenanceIntegrityCheckUnsupported(t *testing.T) {
+	conf := config.Default()
+	conf.Maintenance.Tasks = map[string]config.Duration{
+		config.MaintenanceTaskIntegrity: config.Duration(time.Minute),
+	}
+
+	_, err := newMaintenanceScheduler(&repositoryMock{}, conf, prometheus.NewRegistry())
+	require.Error(t, err)
+}
+
+func getIntegrityDiscrepancies(t *testing.T, registry *prometheus.Registry, kind string) float64 {
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != metricsNamespace+"_integrity_discrepancies_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "kind" && label.GetValue() == kind {
+					return metric.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	t.Fatalf("metric for %s not found", kind)
+	return 0
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/boreq/starlight-nick-server/config"
+	"github.com/boreq/starlight-nick-server/data"
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsNamespace prefixes the names of all metrics.
+const metricsNamespace = "starlight_nick_server"
+
+// newMetricsHandler returns a handler exposing the metrics collected by the
+// registry.
+func newMetricsHandler(registry *prometheus.Registry) http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// sloMetrics counts the requests served within and above the latency budgets
+// of the routes.
+type sloMetrics struct {
+	defaultBudget time.Duration
+	routeBudgets  map[string]time.Duration
+	requests      *prometheus.CounterVec
+}
+
+func newSLOMetrics(conf *config.Config, registry *prometheus.Registry) *sloMetrics {
+	m := &sloMetrics{
+		defaultBudget: time.Duration(conf.LatencyBudget),
+		routeBudgets:  make(map[string]time.Duration),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_slo_total",
+			Help:      "Number of requests served within and above the latency budget of the route.",
+		}, []string{"route", "within_slo"}),
+	}
+	for route, budget := range conf.RouteLatencyBudgets {
+		m.routeBudgets[route] = time.Duration(budget)
+	}
+	registry.MustRegister(m.requests)
+	return m
+}
+
+// Wrap instruments the handle of the route. Routes without a latency budget
+// aren't instrumented.
+func (m *sloMetrics) Wrap(method, path string, handle httprouter.Handle) httprouter.Handle {
+	route := method + " " + path
+
+	budget, ok := m.routeBudgets[route]
+	if !ok {
+		budget = m.defaultBudget
+	}
+	if budget <= 0 {
+		return handle
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		start := time.Now()
+		handle(w, r, ps)
+		withinSLO := time.Since(start) <= budget
+		m.requests.WithLabelValues(route, strconv.FormatBool(withinSLO)).Inc()
+	}
+}
+
+// requestMetrics counts the requests and measures their latency. The requests
+// are labelled with the path of the route and not the requested path so that
+// the number of label values is bounded.
+type requestMetrics struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+func newRequestMetrics(registry *prometheus.Registry) *requestMetrics {
+	m := &requestMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "Number of requests by route, method and status code.",
+		}, []string{"route", "method", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of the requests by route and method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+	}
+	registry.MustRegister(m.requests, m.duration)
+	return m
+}
+
+// Wrap instruments the handle of the route.
+func (m *requestMetrics) Wrap(path string, handle httprouter.Handle) httprouter.Handle {
+	route := prometheus.Labels{"route": path}
+	instrumented := promhttp.InstrumentHandlerDuration(
+		m.duration.MustCurryWith(route),
+		promhttp.InstrumentHandlerCounter(
+			m.requests.MustCurryWith(route),
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handle(w, r, httprouter.ParamsFromContext(r.Context()))
+			}),
+		),
+	)
+
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		ctx := context.WithValue(r.Context(), httprouter.ParamsKey, ps)
+		instrumented.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+// registerRepositoryMetrics exports the counters collected by the repository
+// if it collects them.
+func registerRepositoryMetrics(repository Repository, registry *prometheus.Registry) {
+	r, ok := repository.(statsRepository)
+	if !ok {
+		return
+	}
+
+	counter := func(name, help string, value func(stats data.RepoStats) uint64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      name,
+			Help:      help,
+		}, func() float64 {
+			return float64(value(r.Stats()))
+		})
+	}
+
+	registry.MustRegister(
+		counter("repository_gets_total", "Number of entries retrieved from the repository by node id or nick.", func(stats data.RepoStats) uint64 {
+			return stats.Gets
+		}),
+		counter("repository_puts_total", "Number of entries accepted by the repository.", func(stats data.RepoStats) uint64 {
+			return stats.Accepted
+		}),
+		counter("repository_conflicts_total", "Number of entries rejected by the repository as the nick is taken or quarantined.", func(stats data.RepoStats) uint64 {
+			return stats.Conflicts
+		}),
+		counter("repository_stale_total", "Number of entries rejected by the repository as newer nick data is present.", func(stats data.RepoStats) uint64 {
+			return stats.Stale
+		}),
+	)
+}
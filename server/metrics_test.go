@@ -0,0 +1,83 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/boreq/starlight-nick-server/config"
+	"github.com/boreq/starlight-nick-server/data"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestMetrics(t *testing.T) {
+	// given
+	repo := &repositoryMock{}
+	repo.getReturn = makeValidNickData(t)
+	repo.statsReturn = data.RepoStats{Gets: 3}
+
+	registry := prometheus.NewRegistry()
+	h, err := newHandlerWithGuards(repo, config.Default(), makeWriteGuards(t, config.Default(), newReadiness(true), registry), nil, registry)
+	require.NoError(t, err)
+
+	for _, path := range []string{"/nicks/abcd", "/nicks/abcd", "/nicks/zz"} {
+		req, err := http.NewRequest("GET", path, nil)
+		require.NoError(t, err)
+
+		// when
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// then
+	ok := findMetric(t, registry, "http_requests_total", map[string]string{"route": "/nicks/:id", "method": "get", "code": "200"})
+	require.Equal(t, float64(2), ok.GetCounter().GetValue())
+
+	invalid := findMetric(t, registry, "http_requests_total", map[string]string{"route": "/nicks/:id", "method": "get", "code": "400"})
+	require.Equal(t, float64(1), invalid.GetCounter().GetValue())
+
+	duration := findMetric(t, registry, "http_request_duration_seconds", map[string]string{"route": "/nicks/:id", "method": "get"})
+	require.Equal(t, uint64(3), duration.GetHistogram().GetSampleCount())
+
+	gets := findMetric(t, registry, "repository_gets_total", nil)
+	require.Equal(t, float64(3), gets.GetCounter().GetValue())
+}
+
+func TestMetricsDisabled(t *testing.T) {
+	// given
+	conf := config.Default()
+	conf.DisableMetrics = true
+	_, h, rr := makeComponentsWithConfig(t, conf)
+
+	req, err := http.NewRequest("GET", "/metrics", nil)
+	require.NoError(t, err)
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, http.StatusNotFound, rr.Code, "http status should be Not Found")
+}
+
+// findMetric returns the metric with the provided name and labels.
+func findMetric(t *testing.T, registry *prometheus.Registry, name string, labels map[string]string) *dto.Metric {
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != metricsNamespace+"_"+name {
+			continue
+		}
+	metrics:
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if value, ok := labels[label.GetName()]; ok && value != label.GetValue() {
+					continue metrics
+				}
+			}
+			return metric
+		}
+	}
+	t.Fatalf("metric %s %v not found", name, labels)
+	return nil
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/boreq/starlight-nick-server/server/api"
+	"github.com/julienschmidt/httprouter"
+)
+
+// defaultRobotsTxt disallows crawling all routes.
+const defaultRobotsTxt = "User-agent: *\nDisallow: /\n"
+
+// scannerPathFragments appear in the paths commonly requested by
+// vulnerability scanners.
+var scannerPathFragments = []string{
+	".php",
+	".asp",
+	".env",
+	".git",
+	"wp-",
+	"cgi-bin",
+	"phpmyadmin",
+	"/admin",
+	"/login",
+	"/.well-known",
+}
+
+func (h *handler) GetFavicon(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) GetRobotsTxt(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	robotsTxt := h.conf.RobotsTxt
+	if robotsTxt == "" {
+		robotsTxt = defaultRobotsTxt
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(robotsTxt))
+}
+
+// NotFound responds to the requests which don't match any route with a JSON
+// error.
+func (h *handler) NotFound(w http.ResponseWriter, r *http.Request) {
+	if h.conf.QuietScannerNotFound && isScannerPath(r.URL.Path) {
+		log.Debug("route not found", "method", r.Method, "path", r.URL.Path)
+	} else {
+		log.Info("route not found", "method", r.Method, "path", r.URL.Path)
+	}
+	api.Call(w, r, nil, func(r *http.Request, ps httprouter.Params) (interface{}, api.Error) {
+		return nil, api.NotFound
+	})
+}
+
+// isScannerPath returns true if the path looks like it was requested by a
+// vulnerability scanner.
+func isScannerPath(path string) bool {
+	path = strings.ToLower(path)
+	for _, fragment := range scannerPathFragments {
+		if strings.Contains(path, fragment) {
+			return true
+		}
+	}
+	return false
+}
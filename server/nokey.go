@@ -0,0 +1,72 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/boreq/starlight-nick-server/data"
+	"github.com/boreq/starlight-nick-server/server/api"
+	"github.com/julienschmidt/httprouter"
+)
+
+// omitPublicKeys removes the public keys from the returned nick data if the
+// client requested that using "?nokey=true". This shrinks the responses for
+// the clients which already know the public keys, without the public key the
+// nick data can't be verified. The ETag of the response is removed as it
+// identifies the complete representation.
+func omitPublicKeys(handle api.Handle) api.Handle {
+	return func(r *http.Request, ps httprouter.Params) (interface{}, api.Error) {
+		noKey, err := isNoKey(r)
+		if err != nil {
+			return nil, api.BadRequest.WithMessage("Invalid nokey parameter.")
+		}
+
+		response, apiErr := handle(r, ps)
+		if apiErr != nil || !noKey {
+			return response, apiErr
+		}
+
+		if resp, ok := response.(api.Response); ok {
+			resp.Body = withoutPublicKeys(resp.Body)
+			resp.Headers = resp.Headers.Clone()
+			resp.Headers.Del("ETag")
+			return resp, nil
+		}
+		return withoutPublicKeys(response), nil
+	}
+}
+
+// withoutPublicKeys returns a copy of the nick data contained in the body
+// without the public keys.
+func withoutPublicKeys(body interface{}) interface{} {
+	switch v := body.(type) {
+	case *data.NickData:
+		nickData := *v
+		nickData.PublicKey = nil
+		return &nickData
+	case annotatedNickData:
+		v.NickData = withoutPublicKeys(v.NickData).(*data.NickData)
+		return v
+	case []data.NickData:
+		nickDatas := make([]data.NickData, len(v))
+		for i := range v {
+			nickDatas[i] = v[i]
+			nickDatas[i].PublicKey = nil
+		}
+		return nickDatas
+	case page:
+		v.Items = withoutPublicKeys(v.Items)
+		return v
+	default:
+		return body
+	}
+}
+
+// isNoKey returns true if the client requested omitting the public keys.
+func isNoKey(r *http.Request) (bool, error) {
+	value := r.URL.Query().Get("nokey")
+	if value == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strconv"
+
+	"github.com/boreq/starlight-nick-server/server/api"
+)
+
+const (
+	// defaultPageLimit is the number of items in a page if the limit
+	// isn't specified.
+	defaultPageLimit = 100
+
+	// maxPageLimit is the max number of items in a page.
+	maxPageLimit = 1000
+)
+
+// Names of the query parameters carrying the cursor. The lists ordered from
+// the oldest entries use "after" and the lists ordered from the newest entries
+// use "before".
+const (
+	cursorAfter  = "after"
+	cursorBefore = "before"
+)
+
+// totalCountHeader carries the total number of items in the paginated list.
+const totalCountHeader = "X-Total-Count"
+
+// pageParams specify which page of a list should be returned.
+type pageParams struct {
+	Limit  int
+	Cursor []byte
+}
+
+// page is returned by the paginated list routes. Next is null on the last
+// page, otherwise it should be passed in the cursor parameter of the route to
+// retrieve the next page.
+type page struct {
+	Items interface{} `json:"items"`
+	Next  *string     `json:"next"`
+}
+
+func newPage(items interface{}, next []byte) page {
+	p := page{Items: items}
+	if next != nil {
+		cursor := hex.EncodeToString(next)
+		p.Next = &cursor
+	}
+	return p
+}
+
+// getPageParams parses the "limit" query parameter and the cursor parameter
+// with the provided name. It returns false if none of them is present in which
+// case the route should return the unpaginated list.
+func getPageParams(r *http.Request, cursorName string) (pageParams, bool, api.Error) {
+	query := r.URL.Query()
+	if _, ok := query["limit"]; !ok {
+		if _, ok := query[cursorName]; !ok {
+			return pageParams{}, false, nil
+		}
+	}
+
+	params := pageParams{
+		Limit: defaultPageLimit,
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return pageParams{}, false, api.BadRequest.WithMessage("Invalid limit.")
+		}
+		params.Limit = limit
+	}
+
+	if value := query.Get(cursorName); value != "" {
+		cursor, err := hex.DecodeString(value)
+		if err != nil {
+			return pageParams{}, false, api.BadRequest.WithMessage("Invalid cursor.")
+		}
+		params.Cursor = cursor
+	}
+
+	return params, true, nil
+}
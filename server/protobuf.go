@@ -0,0 +1,73 @@
+package server
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/boreq/starlight-nick-server/data"
+	"github.com/boreq/starlight-nick-server/server/api"
+	"github.com/julienschmidt/httprouter"
+)
+
+// protobufContentTypes are the media types which select the responses
+// encoded using Protocol Buffers.
+var protobufContentTypes = []string{data.ProtobufContentType, "application/x-protobuf"}
+
+// negotiateProtobuf encodes the nick data returned by the handle using
+// Protocol Buffers if the client prefers it over JSON. Other responses and
+// errors are always encoded as JSON.
+func negotiateProtobuf(handle api.Handle) api.Handle {
+	return func(r *http.Request, ps httprouter.Params) (interface{}, api.Error) {
+		response, apiErr := handle(r, ps)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+
+		resp, ok := response.(api.Response)
+		if !ok {
+			resp = api.NewResponse(http.StatusOK, response)
+		}
+		resp = resp.WithHeader("Vary", "Accept")
+
+		if !prefersProtobuf(r) {
+			return resp, nil
+		}
+
+		var body []byte
+		switch v := resp.Body.(type) {
+		case *data.NickData:
+			body = v.MarshalProtobuf()
+		case annotatedNickData:
+			body = v.MarshalProtobuf()
+		case []data.NickData:
+			body = data.MarshalProtobufList(v)
+		default:
+			return resp, nil
+		}
+
+		rv := api.NewRawResponse(resp.Code, data.ProtobufContentType, body)
+		rv.Headers = resp.Headers
+		return rv, nil
+	}
+}
+
+// prefersProtobuf returns true if Protocol Buffers are listed in the Accept
+// header before JSON.
+func prefersProtobuf(r *http.Request) bool {
+	for _, value := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(value))
+		if err != nil || params["q"] == "0" {
+			continue
+		}
+		if mediaType == "application/json" {
+			return false
+		}
+		for _, contentType := range protobufContentTypes {
+			if mediaType == contentType {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/boreq/starlight-nick-server/server/api"
+	"github.com/julienschmidt/httprouter"
+)
+
+// queryParams lists the query parameters accepted by the routes. The routes
+// are identified by the method and the path pattern.
+var queryParams = map[string][]string{
+	"GET /nicks": {"limit", "after", "from", "to", "nokey"},
+	"PUT /nicks": {"async"},
+
+	"GET /nicks/:id":          {"include", "nokey"},
+	"GET /nicks/:id/nicks":    {"limit", "before"},
+	"GET /nicks/:id/versions": {"limit", "before"},
+	"GET /nicks/search":       {"prefix", "limit", "nokey"},
+	"GET /ids/:nick":          {"nokey"},
+}
+
+// rejectUnknownQueryParams rejects the requests containing query parameters
+// which aren't listed as allowed with a bad request error naming the first
+// unknown parameter.
+func rejectUnknownQueryParams(allowed []string, handle httprouter.Handle) httprouter.Handle {
+	known := make(map[string]bool)
+	for _, name := range allowed {
+		known[name] = true
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		var unknown []string
+		for name := range r.URL.Query() {
+			if !known[name] {
+				unknown = append(unknown, name)
+			}
+		}
+		if len(unknown) == 0 {
+			handle(w, r, ps)
+			return
+		}
+
+		sort.Strings(unknown)
+		api.Call(w, r, ps, func(r *http.Request, ps httprouter.Params) (interface{}, api.Error) {
+			return nil, api.BadRequest.WithMessage(fmt.Sprintf("Unknown query parameter: %s.", unknown[0]))
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"container/list"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/boreq/starlight-nick-server/config"
+	"github.com/boreq/starlight-nick-server/server/api"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+)
+
+// defaultRateLimitedClients is used if RateLimitedClients isn't set.
+const defaultRateLimitedClients = 10000
+
+// rateLimiter limits the rate of the requests sent by each client address
+// using a token bucket. Only the buckets of the most recently seen addresses
+// are kept, the least recently used buckets are evicted once the limit is
+// reached. The requests whose address can't be determined share a bucket.
+type rateLimiter struct {
+	limit    rate.Limit
+	burst    int
+	size     int
+	resolver clientIPResolver
+
+	lock    sync.Mutex
+	buckets map[string]*list.Element
+	order   *list.List
+}
+
+type rateLimiterBucket struct {
+	key     string
+	limiter *rate.Limiter
+}
+
+// newPutRateLimiter returns nil if the writes aren't limited.
+func newPutRateLimiter(conf *config.Config) (*rateLimiter, error) {
+	if conf.PutRateLimit <= 0 {
+		return nil, nil
+	}
+	if conf.PutRateBurst < 1 {
+		return nil, errors.New("put rate burst must be at least 1")
+	}
+	trustedProxies, err := parseIPNets(conf.TrustedProxies)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid trusted proxies")
+	}
+
+	size := conf.RateLimitedClients
+	if size <= 0 {
+		size = defaultRateLimitedClients
+	}
+	return &rateLimiter{
+		limit:    rate.Limit(conf.PutRateLimit),
+		burst:    conf.PutRateBurst,
+		size:     size,
+		resolver: clientIPResolver{trustedProxies: trustedProxies},
+		buckets:  make(map[string]*list.Element),
+		order:    list.New(),
+	}, nil
+}
+
+// Wrap rejects the requests exceeding the limit with Too Many Requests. The
+// handle isn't wrapped if the limiter is nil.
+func (l *rateLimiter) Wrap(handle api.Handle) api.Handle {
+	if l == nil {
+		return handle
+	}
+
+	return func(r *http.Request, ps httprouter.Params) (interface{}, api.Error) {
+		if apiErr := l.Check(l.resolver.ClientIP(r)); apiErr != nil {
+			return nil, apiErr
+		}
+		return handle(r, ps)
+	}
+}
+
+// Check returns Too Many Requests if the client with the address exceeded the
+// limit. Nothing is limited if the limiter is nil.
+func (l *rateLimiter) Check(ip net.IP) api.Error {
+	if l == nil || l.allow(ip.String()) {
+		return nil
+	}
+	retryAfter := strconv.Itoa(int(math.Ceil(1 / float64(l.limit))))
+	return api.TooManyRequests.
+		WithMessage("Too many writes, slow down.").
+		WithHeader("Retry-After", retryAfter)
+}
+
+func (l *rateLimiter) allow(key string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	element, ok := l.buckets[key]
+	if ok {
+		l.order.MoveToFront(element)
+	} else {
+		if l.order.Len() >= l.size {
+			oldest := l.order.Back()
+			l.order.Remove(oldest)
+			delete(l.buckets, oldest.Value.(*rateLimiterBucket).key)
+		}
+		element = l.order.PushFront(&rateLimiterBucket{
+			key:     key,
+			limiter: rate.NewLimiter(l.limit, l.burst),
+		})
+		l.buckets[key] = element
+	}
+	return element.Value.(*rateLimiterBucket).limiter.Allow()
+}
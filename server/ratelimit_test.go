@@ -0,0 +1,134 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/boreq/starlight-nick-server/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPutRateLimit(t *testing.T) {
+	// given
+	conf := config.Default()
+	conf.PutRateLimit = 0.001
+	conf.PutRateBurst = 3
+	conf.TrustedProxies = []string{"10.0.0.0/8"}
+
+	_, h, _ := makeComponentsWithConfig(t, conf)
+
+	put := func(remoteAddr, forwardedFor string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("PUT", "/nicks", bytes.NewBuffer(makeJsonNickData(t)))
+		require.NoError(t, err)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// when
+	for i := 0; i < conf.PutRateBurst; i++ {
+		rr := put("192.0.2.10:1234", "")
+		require.Equal(t, http.StatusOK, rr.Code, "request %d should be allowed", i)
+	}
+	rr := put("192.0.2.10:1234", "")
+
+	// then
+	require.Equal(t, http.StatusTooManyRequests, rr.Code, "http status should be Too Many Requests")
+	require.Equal(t, "1000", rr.Header().Get("Retry-After"))
+	require.Equal(t, "Too many writes, slow down.", getErrorMessage(t, rr))
+
+	rr = put("192.0.2.11:1234", "")
+	require.Equal(t, http.StatusOK, rr.Code, "other clients should not be limited")
+
+	rr = put("10.0.0.1:1234", "192.0.2.10")
+	require.Equal(t, http.StatusTooManyRequests, rr.Code, "client behind a trusted proxy should be limited")
+}
+
+func TestPutRateLimitMinimalConfig(t *testing.T) {
+	// given
+	dir, err := ioutil.TempDir("", "ratelimit_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.json")
+	content := fmt.Sprintf(`{"DatabasePath": %q}`, filepath.Join(dir, "database.bolt"))
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+
+	conf, err := config.Load(path)
+	require.NoError(t, err)
+
+	_, h, _ := makeComponentsWithConfig(t, conf)
+
+	put := func() *httptest.ResponseRecorder {
+		req, err := http.NewRequest("PUT", "/nicks", bytes.NewBuffer(makeJsonNickData(t)))
+		require.NoError(t, err)
+		req.RemoteAddr = "192.0.2.10:1234"
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// when
+	for i := 0; i < conf.PutRateBurst; i++ {
+		put()
+	}
+	rr := put()
+
+	// then
+	require.Equal(t, http.StatusTooManyRequests, rr.Code, "writes should be limited by default")
+}
+
+func TestPutRateLimitReads(t *testing.T) {
+	// given
+	conf := config.Default()
+	conf.PutRateLimit = 0.001
+	conf.PutRateBurst = 1
+
+	_, h, _ := makeComponentsWithConfig(t, conf)
+
+	for i := 0; i < 3; i++ {
+		req, err := http.NewRequest("GET", "/nicks", nil)
+		require.NoError(t, err)
+		req.RemoteAddr = "192.0.2.10:1234"
+		rr := httptest.NewRecorder()
+
+		// when
+		h.ServeHTTP(rr, req)
+
+		// then
+		require.Equal(t, http.StatusOK, rr.Code, "reads should not be limited")
+	}
+}
+
+func TestRateLimiterEvictsLeastRecentlyUsed(t *testing.T) {
+	// given
+	conf := config.Default()
+	conf.PutRateLimit = 0.001
+	conf.PutRateBurst = 1
+	conf.RateLimitedClients = 2
+
+	l, err := newPutRateLimiter(conf)
+	require.NoError(t, err)
+
+	require.True(t, l.allow("a"))
+	require.True(t, l.allow("b"))
+	require.False(t, l.allow("a"))
+
+	// when
+	require.True(t, l.allow("c"))
+
+	// then
+	require.Equal(t, 2, l.order.Len())
+	require.True(t, l.allow("b"), "least recently used bucket should be evicted")
+	require.False(t, l.allow("c"))
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/boreq/starlight-nick-server/server/api"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+)
+
+var notReadyError = api.ServiceUnavailable.WithMessage("The server is starting.").WithHeader("Retry-After", "1")
+
+// StartupTask is executed by Serve after the listeners are started, eg. the
+// consistency check of the database. Until all startup tasks finish the
+// server reports that it isn't ready at /readyz and rejects the writes.
+type StartupTask func() error
+
+// readiness tracks if the startup tasks finished.
+type readiness struct {
+	ready int32
+}
+
+func newReadiness(ready bool) *readiness {
+	r := &readiness{}
+	if ready {
+		r.setReady()
+	}
+	return r
+}
+
+func (r *readiness) Ready() bool {
+	return atomic.LoadInt32(&r.ready) == 1
+}
+
+func (r *readiness) setReady() {
+	atomic.StoreInt32(&r.ready, 1)
+}
+
+// Wrap rejects the requests with Service Unavailable until the server is
+// ready.
+func (r *readiness) Wrap(handle api.Handle) api.Handle {
+	return func(req *http.Request, ps httprouter.Params) (interface{}, api.Error) {
+		if apiErr := r.Check(); apiErr != nil {
+			return nil, apiErr
+		}
+		return handle(req, ps)
+	}
+}
+
+// Check returns Service Unavailable until the server is ready.
+func (r *readiness) Check() api.Error {
+	if !r.Ready() {
+		return notReadyError
+	}
+	return nil
+}
+
+// runStartupTasks executes the tasks one by one and marks the server as ready
+// once all of them succeed.
+func runStartupTasks(r *readiness, tasks []StartupTask) error {
+	for i, task := range tasks {
+		if err := task(); err != nil {
+			return errors.Wrapf(err, "startup task %d failed", i)
+		}
+	}
+	r.setReady()
+	log.Info("server is ready")
+	return nil
+}
+
+type readyzResponse struct {
+	Ready bool `json:"ready"`
+}
+
+func (h *handler) GetReadyz(r *http.Request, ps httprouter.Params) (interface{}, api.Error) {
+	if !h.ready.Ready() {
+		return api.NewResponse(http.StatusServiceUnavailable, readyzResponse{Ready: false}), nil
+	}
+	return readyzResponse{Ready: true}, nil
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/boreq/starlight-nick-server/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadiness(t *testing.T) {
+	// given
+	repo := &repositoryMock{}
+	ready := newReadiness(false)
+
+	registry := prometheus.NewRegistry()
+	h, err := newHandlerWithGuards(repo, config.Default(), makeWriteGuards(t, config.Default(), ready, registry), nil, registry)
+	require.NoError(t, err)
+
+	body, err := json.Marshal(makeValidNickData(t))
+	require.NoError(t, err)
+
+	serve := func(method, path string, body []byte) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, bytes.NewReader(body))
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- runStartupTasks(ready, []StartupTask{
+			func() error {
+				<-release
+				return nil
+			},
+		})
+	}()
+
+	// when
+	readyz := serve("GET", "/readyz", nil)
+	put := serve("PUT", "/nicks", body)
+
+	// then
+	require.Equal(t, 503, readyz.Code, "server should not be ready")
+	require.JSONEq(t, `{"ready":false}`, readyz.Body.String())
+	require.Equal(t, 503, put.Code, "writes should be rejected")
+	require.Nil(t, repo.putArgument, "nothing should be written")
+
+	// when
+	close(release)
+	require.NoError(t, <-done)
+
+	readyz = serve("GET", "/readyz", nil)
+	put = serve("PUT", "/nicks", body)
+
+	// then
+	require.Equal(t, 200, readyz.Code, "server should be ready")
+	require.JSONEq(t, `{"ready":true}`, readyz.Body.String())
+	require.Equal(t, 200, put.Code, "writes should be accepted")
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/boreq/starlight-nick-server/server/api"
+	"github.com/boreq/starlight/network/node"
+	"github.com/julienschmidt/httprouter"
+)
+
+// defaultMaxBatchSize is used if MaxBatchSize isn't set.
+const defaultMaxBatchSize = 100
+
+// resolveRepository is implemented by the repositories which can resolve many
+// nicks at once.
+type resolveRepository interface {
+	ResolveNicks(nicks []string) (map[string]node.ID, error)
+}
+
+// maxBatchSize returns the max number of elements accepted by the routes
+// which accept lists.
+func (h *handler) maxBatchSize() int {
+	if h.conf.MaxBatchSize > 0 {
+		return h.conf.MaxBatchSize
+	}
+	return defaultMaxBatchSize
+}
+
+// checkBatchSize returns an error if the list received by a route contains
+// too many elements.
+func (h *handler) checkBatchSize(n int) api.Error {
+	if limit := h.maxBatchSize(); n > limit {
+		return api.BadRequest.WithMessage(fmt.Sprintf("Too many elements, the limit is %d.", limit))
+	}
+	return nil
+}
+
+// PostResolve resolves the nicks sent as a JSON array to the node ids. The
+// response maps the nicks to the hex encoded node ids, unknown nicks are
+// omitted.
+func (h *handler) PostResolve(r *http.Request, ps httprouter.Params) (interface{}, api.Error) {
+	if r.Body == nil {
+		return nil, api.BadRequest
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, maxPutBodySize))
+	if err != nil {
+		return nil, api.BadRequest
+	}
+
+	var nicks []string
+	if err := json.Unmarshal(body, &nicks); err != nil {
+		return nil, api.BadRequest
+	}
+
+	if apiErr := h.checkBatchSize(len(nicks)); apiErr != nil {
+		return nil, apiErr
+	}
+
+	policy := h.nickPolicy()
+	for _, nick := range nicks {
+		if err := policy.ValidateNick(nick); err != nil {
+			return nil, api.BadRequest.WithMessage(fmt.Sprintf("Invalid nick: %q.", nick))
+		}
+	}
+
+	ids, err := h.repository.(resolveRepository).ResolveNicks(nicks)
+	if err != nil {
+		if isClientError(err) {
+			return nil, api.BadRequest.WithMessage(err.Error())
+		}
+		log.Error("resolve failed", "err", err)
+		return nil, api.InternalServerError
+	}
+
+	rv := make(map[string]string, len(ids))
+	for nick, id := range ids {
+		rv[nick] = hex.EncodeToString(id)
+	}
+	return rv, nil
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/boreq/starlight-nick-server/data"
+	"github.com/boreq/starlight-nick-server/server/api"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// defaultSearchLimit is the number of returned entries if the limit
+	// isn't specified.
+	defaultSearchLimit = 20
+
+	// maxSearchLimit is the max number of returned entries, larger limits
+	// are lowered to it.
+	maxSearchLimit = 100
+)
+
+// searchId is the value of the id parameter of /nicks/:id which selects the
+// search. httprouter doesn't allow registering /nicks/search next to
+// /nicks/:id but the node ids are hex encoded so they never collide with it.
+const searchId = "search"
+
+// searchRepository is implemented by repositories which can search for nicks
+// starting with a prefix.
+type searchRepository interface {
+	// SearchByPrefix returns at most limit entries with nicks starting
+	// with the prefix in the order of the nicks.
+	SearchByPrefix(prefix string, limit int) ([]data.NickData, error)
+}
+
+// routeSearch dispatches the requests for /nicks/search to the search handle
+// and all other requests to the handle of /nicks/:id.
+func routeSearch(search, get httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if ps.ByName("id") == searchId {
+			search(w, r, ps)
+			return
+		}
+		get(w, r, ps)
+	}
+}
+
+// SearchNicks returns the nick data of the nicks starting with the prefix
+// specified using "?prefix=". The number of returned entries can be lowered
+// using "?limit=". An empty list is returned if no nicks match.
+func (h *handler) SearchNicks(r *http.Request, ps httprouter.Params) (interface{}, api.Error) {
+	if h.conf.DisableList {
+		return nil, listDisabledError
+	}
+
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	if err := h.nickPolicy().ValidatePrefix(prefix); err != nil {
+		return nil, api.BadRequest.WithMessage("Invalid prefix, " + err.Error() + ".")
+	}
+
+	limit := defaultSearchLimit
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return nil, api.BadRequest.WithMessage("Invalid limit.")
+		}
+		limit = n
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+
+	nicks, err := h.repository.(searchRepository).SearchByPrefix(prefix, limit)
+	if err != nil {
+		log.Error("search failed", "err", err)
+		return nil, api.InternalServerError
+	}
+	if nicks == nil {
+		nicks = make([]data.NickData, 0)
+	}
+	return nicks, nil
+}
@@ -1,26 +1,52 @@
 package server
 
 import (
+	"bytes"
+	"context"
 	"encoding/hex"
 	"encoding/json"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/NYTimes/gziphandler"
+	"github.com/boreq/starlight-nick-server/config"
 	"github.com/boreq/starlight-nick-server/data"
 	"github.com/boreq/starlight-nick-server/logging"
 	"github.com/boreq/starlight-nick-server/server/api"
 	"github.com/boreq/starlight/network/node"
+	"github.com/fxamacker/cbor/v2"
 	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/cors"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
 )
 
 var log = logging.New("server")
 
+// maxPutBodySize is the max size of the body of the requests carrying a
+// single entry. It is used as the max size of the body of a put request if
+// MaxBodyBytes isn't set.
+const maxPutBodySize = 64 * 1024
+
+var readOnlyError = api.ServiceUnavailable.WithMessage("This server is read-only.")
+var tooManyWritesError = api.ServiceUnavailable.WithMessage("Too many pending writes.").WithHeader("Retry-After", "1")
+var listDisabledError = api.Forbidden.WithMessage("Listing the nicks is disabled on this server.")
+var challengeRequiredError = api.BadRequest.WithMessage("This server requires a challenge, see /challenge.")
+var invalidChallengeError = api.BadRequest.WithMessage("Invalid or expired challenge.")
+var asyncWritesDisabledError = api.NotImplemented.WithMessage("Asynchronous writes are disabled.")
+var reservedNickError = api.BadRequest.WithMessage("This nick is reserved and can't be registered.")
+
 type Repository interface {
-	// List returns a list of all previously stored nick datas.
-	List() ([]data.NickData, error)
+	// List returns a list of all previously stored nick datas. The
+	// iteration should be aborted if the context is cancelled.
+	List(ctx context.Context) ([]data.NickData, error)
 
 	// Put stores nick data which can later be retrieved using the Get
 	// method.
@@ -35,52 +61,969 @@ type Repository interface {
 	GetByNick(nick string) (*data.NickData, error)
 }
 
-func Serve(repository Repository, address string) error {
-	handler, err := newHandler(repository)
-	if err != nil {
+// historyRepository is implemented by repositories which track the history of
+// the nicks.
+type historyRepository interface {
+	// NickHistory returns all nicks ever held by a node starting with the
+	// current one. If the node is unknown an empty list is returned.
+	NickHistory(node.ID) ([]string, error)
+}
+
+// versionsRepository is implemented by repositories which store the prior
+// versions of the nick data.
+type firstSeenRepository interface {
+	// FirstSeen returns the time at which the server first stored the nick
+	// data of a node. A zero time is returned if it is unknown.
+	FirstSeen(node.ID) (time.Time, error)
+}
+
+type versionsRepository interface {
+	// Versions returns the prior versions of the nick data of a node
+	// starting with the newest one. If the node is unknown an empty list
+	// is returned.
+	Versions(node.ID) ([]data.NickData, error)
+}
+
+// indexRepository is implemented by repositories which can stream the nick
+// index.
+type indexRepository interface {
+	// IterateIndex calls fn for each entry in the nick index. The
+	// iteration should be aborted if the context is cancelled or fn
+	// returns an error.
+	IterateIndex(ctx context.Context, fn func(entry data.IndexEntry) error) error
+}
+
+// historyPageRepository is implemented by repositories which can return the
+// history of the nicks in pages.
+type historyPageRepository interface {
+	// NickHistoryBefore returns at most limit nicks from the history of a
+	// node older than the cursor and the cursor which should be used to
+	// retrieve the next nicks. The returned cursor is nil if there are no
+	// more nicks.
+	NickHistoryBefore(id node.ID, before []byte, limit int) ([]string, []byte, error)
+}
+
+// versionsPageRepository is implemented by repositories which can return the
+// prior versions of the nick data in pages.
+type versionsPageRepository interface {
+	// VersionsBefore returns at most limit versions of the nick data of a
+	// node older than the cursor and the cursor which should be used to
+	// retrieve the next versions. The returned cursor is nil if there are
+	// no more versions.
+	VersionsBefore(id node.ID, before []byte, limit int) ([]data.NickData, []byte, error)
+}
+
+// pagedRepository is implemented by repositories which can list the entries in
+// pages.
+type pagedRepository interface {
+	// ListAfter returns at most limit entries with node ids greater than
+	// the provided one and the node id which should be used to retrieve
+	// the next entries. The returned node id is nil if there are no more
+	// entries.
+	ListAfter(after node.ID, limit int) ([]data.NickData, node.ID, error)
+}
+
+// timeRangeRepository is implemented by repositories which can filter the
+// entries by time.
+type timeRangeRepository interface {
+	// ListTimeRange returns all entries with times within the provided
+	// inclusive range. The iteration should be aborted if the context is
+	// cancelled.
+	ListTimeRange(ctx context.Context, from, to time.Time) ([]data.NickData, error)
+}
+
+// countRepository is implemented by repositories which can count the stored
+// entries without listing them.
+type countRepository interface {
+	// Count returns the number of stored entries.
+	Count() (int, error)
+}
+
+// putResultRepository is implemented by repositories which can report how
+// the stored nick data was changed by a put.
+type putResultRepository interface {
+	// PutResult stores nick data the same as Put and reports whether it
+	// was created, updated or identical to the stored nick data.
+	PutResult(*data.NickData) (data.PutResult, error)
+}
+
+// revisionRepository is implemented by repositories which can detect that the
+// stored nick data didn't change.
+type revisionRepository interface {
+	// Revision returns a number which is increased every time the stored
+	// nick data changes.
+	Revision() (uint64, error)
+}
+
+// defaultShutdownTimeout is used if ShutdownTimeout isn't set.
+const defaultShutdownTimeout = 10 * time.Second
+
+// Serve starts the listeners and then executes the startup tasks. The
+// migrations of the database aren't startup tasks as they are executed when
+// the repository is opened. Once the context is cancelled the listeners stop
+// accepting new requests, the requests being processed are given
+// ShutdownTimeout to finish and the repository is closed. Nil is returned if
+// the server was shut down this way. The repository is also closed if Serve
+// returns an error.
+func Serve(ctx context.Context, repository Repository, conf *config.Config, tasks ...StartupTask) error {
+	var writes *asyncWriter
+	var servers []*http.Server
+	var stops []func()
+
+	// cleanup stops everything which was created or started, stores the
+	// queued writes and closes the repository. It is used by every return
+	// so that an error doesn't leave any listeners or goroutines running.
+	cleanup := func(err error) error {
+		writes.Stop()
+		if shutdownErr := shutdown(servers, conf); shutdownErr != nil && err == nil {
+			err = shutdownErr
+		}
+		// The queued writes are stored before the repository is closed
+		writes.Close()
+		for _, stop := range stops {
+			stop()
+		}
+		if closer, ok := repository.(io.Closer); ok {
+			if closeErr := closer.Close(); closeErr != nil && err == nil {
+				err = errors.Wrap(closeErr, "could not close the repository")
+			}
+		}
 		return err
 	}
 
+	tlsConfig, err := newPublicTLSConfig(conf)
+	if err != nil {
+		return cleanup(errors.Wrap(err, "could not create the tls config"))
+	}
+
+	ready := newReadiness(len(tasks) == 0)
+	registry := prometheus.NewRegistry()
+	writes, err = newAsyncWriterFromConfig(repository, conf, registry)
+	if err != nil {
+		return cleanup(errors.Wrap(err, "invalid config"))
+	}
+	guards, err := newWriteGuards(conf, ready, registry)
+	if err != nil {
+		return cleanup(errors.Wrap(err, "invalid config"))
+	}
+	handler, err := newPublicHandler(repository, conf, guards, writes, registry)
+	if err != nil {
+		return cleanup(err)
+	}
+
+	var grpcServer *grpc.Server
+	if conf.GRPCAddress != "" {
+		grpcServer, err = newGRPCServer(repository, conf, guards)
+		if err != nil {
+			return cleanup(errors.Wrap(err, "could not create the grpc server"))
+		}
+	}
+
+	maintenance, err := newMaintenanceScheduler(repository, conf, registry)
+	if err != nil {
+		return cleanup(errors.Wrap(err, "could not create the maintenance scheduler"))
+	}
+
+	publicServer := &http.Server{
+		Addr:      conf.ServeAddress,
+		Handler:   handler,
+		TLSConfig: tlsConfig,
+	}
+
+	var redirectServer *http.Server
+	if tlsConfig != nil && conf.TLSRedirectAddress != "" {
+		redirectHandler, err := newTLSRedirectHandler(conf)
+		if err != nil {
+			return cleanup(errors.Wrap(err, "could not create the redirect handler"))
+		}
+		redirectServer = &http.Server{
+			Addr:    conf.TLSRedirectAddress,
+			Handler: redirectHandler,
+		}
+	}
+
+	var adminServer *http.Server
+	if conf.AdminServeAddress != "" {
+		adminServer, err = newAdminServer(repository, conf)
+		if err != nil {
+			return cleanup(errors.Wrap(err, "could not create the admin server"))
+		}
+	}
+
+	// Nothing is started before all servers are created
+	maintenanceCtx, cancelMaintenance := context.WithCancel(context.Background())
+	maintenanceDone := make(chan struct{})
+	go func() {
+		defer close(maintenanceDone)
+		maintenance.Run(maintenanceCtx)
+	}()
+	stops = append(stops, func() {
+		cancelMaintenance()
+		<-maintenanceDone
+	})
+
+	errC := make(chan error, 5)
+	if grpcServer != nil {
+		grpcCtx, cancelGRPC := context.WithCancel(context.Background())
+		grpcDone := make(chan struct{})
+		go func() {
+			defer close(grpcDone)
+			if err := serveGRPC(grpcCtx, grpcServer, conf); err != nil {
+				errC <- errors.Wrap(err, "grpc listener failed")
+			}
+		}()
+		stops = append(stops, func() {
+			cancelGRPC()
+			<-grpcDone
+		})
+	}
+
+	servers = append(servers, publicServer)
+	go func() {
+		log.Info("starting listening", "address", conf.ServeAddress, "tls", tlsConfig != nil)
+		if err := listenAndServe(publicServer); err != http.ErrServerClosed {
+			errC <- err
+		}
+	}()
+
+	if redirectServer != nil {
+		servers = append(servers, redirectServer)
+		go func() {
+			log.Info("starting redirect listening", "address", redirectServer.Addr)
+			if err := redirectServer.ListenAndServe(); err != http.ErrServerClosed {
+				errC <- errors.Wrap(err, "redirect listener failed")
+			}
+		}()
+	}
+
+	if adminServer != nil {
+		servers = append(servers, adminServer)
+		go func() {
+			if err := serveAdmin(adminServer); err != http.ErrServerClosed {
+				errC <- errors.Wrap(err, "admin listener failed")
+			}
+		}()
+	}
+
+	go func() {
+		if err := runStartupTasks(ready, tasks); err != nil {
+			errC <- err
+		}
+	}()
+
+	select {
+	case err := <-errC:
+		return cleanup(err)
+	case <-ctx.Done():
+		log.Info("shutting down")
+		return cleanup(nil)
+	}
+}
+
+// listenAndServe uses TLS if the server has a TLS config.
+func listenAndServe(server *http.Server) error {
+	if server.TLSConfig != nil {
+		return server.ListenAndServeTLS("", "")
+	}
+	return server.ListenAndServe()
+}
+
+// shutdown stops the servers waiting for the requests being processed to
+// finish for at most ShutdownTimeout.
+func shutdown(servers []*http.Server, conf *config.Config) error {
+	timeout := time.Duration(conf.ShutdownTimeout)
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var rv error
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil && rv == nil {
+			rv = errors.Wrapf(err, "could not shut down the listener at %s", server.Addr)
+		}
+	}
+	return rv
+}
+
+// newPublicHandler creates the API handler wrapped in the middlewares used by
+// the public listener.
+func newPublicHandler(repository Repository, conf *config.Config, guards *writeGuards, writes *asyncWriter, registry *prometheus.Registry) (http.Handler, error) {
+	handler, err := newHandlerWithGuards(repository, conf, guards, writes, registry)
+	if err != nil {
+		return nil, err
+	}
+
 	// Add CORS middleware
 	handler = cors.AllowAll().Handler(handler)
 
 	// Add GZIP middleware
 	handler = gziphandler.GzipHandler(handler)
 
-	log.Info("starting listening", "address", address)
-	return http.ListenAndServe(address, handler)
+	// Add HTTP/2 cleartext support
+	if conf.EnableH2C {
+		handler = h2c.NewHandler(handler, &http2.Server{})
+	}
+
+	return handler, nil
 }
 
-func newHandler(repository Repository) (http.Handler, error) {
+// newHandler creates the API handler. All read routes accept an optional
+// ".json" suffix, eg. "/nicks.json" is equivalent to "/nicks". The suffix is
+// treated as a content type hint and the responses are always encoded as JSON.
+func newHandler(repository Repository, conf *config.Config) (http.Handler, error) {
+	registry := prometheus.NewRegistry()
+	writes, err := newAsyncWriterFromConfig(repository, conf, registry)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid config")
+	}
+	guards, err := newWriteGuards(conf, newReadiness(true), registry)
+	if err != nil {
+		writes.Close()
+		return nil, errors.Wrap(err, "invalid config")
+	}
+	h, err := newHandlerWithGuards(repository, conf, guards, writes, registry)
+	if err != nil {
+		writes.Close()
+		return nil, err
+	}
+	return h, nil
+}
+
+// newHandlerWithGuards creates the API handler which applies the write guards,
+// eg. rejects the writes until the server is ready. The metrics are registered
+// in the registry which is served at /metrics. The asynchronous writes are
+// disabled if writes is nil, the caller is responsible for closing it.
+func newHandlerWithGuards(repository Repository, conf *config.Config, guards *writeGuards, writes *asyncWriter, registry *prometheus.Registry) (http.Handler, error) {
+	if err := conf.ValidateSettings(); err != nil {
+		return nil, errors.Wrap(err, "invalid config")
+	}
+	if conf.MaxConcurrentPuts < 0 {
+		return nil, errors.New("max concurrent puts can't be negative")
+	}
+
 	h := &handler{
-		repository: repository,
+		repository:   repository,
+		conf:         conf,
+		ready:        guards.ready,
+		verification: guards.verification,
+		breaker:      guards.breaker,
+		validation:   newValidationMetrics(registry),
+		writes:       writes,
 	}
+	reserved, err := data.NewReservedNicks(conf.ReservedNicks)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create the reserved nicks")
+	}
+	h.reserved = reserved
+	if conf.RequireChallenge {
+		c, err := newChallenger([]byte(conf.ChallengeSecret), time.Duration(conf.ChallengeTTL))
+		if err != nil {
+			return nil, errors.Wrap(err, "could not create the challenger")
+		}
+		h.challenger = c
+	}
+
+	ready := guards.ready
+	access := guards.access
+	limiter := guards.limiter
+	breaker := guards.breaker
+
+	slo := newSLOMetrics(conf, registry)
+	requests := newRequestMetrics(registry)
+	registerRepositoryMetrics(repository, registry)
+	h.puts = newInFlightLimiter(conf.MaxConcurrentPuts)
 
 	router := httprouter.New()
-	router.GET("/nicks", api.Wrap(h.GetNicks))
-	router.PUT("/nicks", api.Wrap(h.PutNick))
-	router.GET("/nicks/:id", api.Wrap(h.GetNick))
-	router.GET("/ids/:nick", api.Wrap(h.GetId))
-	return router, nil
+	wrap := func(method, path string, handle httprouter.Handle) httprouter.Handle {
+		if conf.StrictQueryParams {
+			handle = rejectUnknownQueryParams(queryParams[method+" "+path], handle)
+		}
+		return h.countInFlight(requests.Wrap(path, slo.Wrap(method, path, handle)))
+	}
+	register := func(method, path string, handle httprouter.Handle) {
+		router.Handle(method, path, wrap(method, path, handle))
+	}
+	uniform := newUniformErrors(conf)
+	wrapApi := func(method, path string, fn api.Handle) httprouter.Handle {
+		return wrap(method, path, api.Wrap(uniform.Wrap(method, fn)))
+	}
+	handle := func(method, path string, fn api.Handle) {
+		router.Handle(method, path, wrapApi(method, path, fn))
+	}
+
+	handle(http.MethodGet, "/nicks", negotiateProtobuf(omitPublicKeys(breaker.Wrap(h.GetNicks))))
+	register(http.MethodPut, "/nicks", h.puts.Wrap(api.Wrap(uniform.Wrap(http.MethodPut, access.Wrap(limiter.Wrap(ready.Wrap(breaker.Wrap(h.PutNick))))))))
+	search := notImplemented("Searching the nicks is not supported by this server.")
+	if _, ok := repository.(searchRepository); ok {
+		search = negotiateProtobuf(omitPublicKeys(breaker.Wrap(h.SearchNicks)))
+	}
+	router.Handle(http.MethodGet, "/nicks/:id", routeSearch(
+		wrapApi(http.MethodGet, "/nicks/search", search),
+		wrapApi(http.MethodGet, "/nicks/:id", negotiateProtobuf(omitPublicKeys(breaker.Wrap(h.GetNick)))),
+	))
+	if _, ok := repository.(deleteRepository); ok {
+		handle(http.MethodDelete, "/nicks/:id", access.Wrap(ready.Wrap(breaker.Wrap(h.DeleteNick))))
+	} else {
+		handle(http.MethodDelete, "/nicks/:id", notImplemented("Deleting nicks is not supported by this server."))
+	}
+	handle(http.MethodGet, "/nicks/:id/bundle", breaker.Wrap(h.GetBundle))
+	if _, ok := repository.(historyRepository); ok {
+		handle(http.MethodGet, "/nicks/:id/nicks", breaker.Wrap(h.GetNickHistory))
+	} else {
+		handle(http.MethodGet, "/nicks/:id/nicks", notImplemented("Nick history is not supported by this server."))
+	}
+	if _, ok := repository.(versionsRepository); ok && conf.MaxStoredVersions > 0 {
+		handle(http.MethodGet, "/nicks/:id/versions", breaker.Wrap(h.GetNickVersions))
+	} else {
+		handle(http.MethodGet, "/nicks/:id/versions", notImplemented("Storing the versions of the nick data is disabled on this server."))
+	}
+	handle(http.MethodGet, "/ids/:nick", negotiateProtobuf(omitPublicKeys(breaker.Wrap(h.GetId))))
+	handle(http.MethodGet, "/display/:id", breaker.Wrap(h.GetDisplay))
+	if _, ok := repository.(resolveRepository); ok {
+		handle(http.MethodPost, "/resolve", breaker.Wrap(h.PostResolve))
+	} else {
+		handle(http.MethodPost, "/resolve", notImplemented("Resolving many nicks is not supported by this server."))
+	}
+	handle(http.MethodGet, "/capabilities", h.GetCapabilities)
+	handle(http.MethodGet, "/readyz", h.GetReadyz)
+	if h.challenger != nil {
+		handle(http.MethodGet, "/challenge", h.GetChallenge)
+	} else {
+		handle(http.MethodGet, "/challenge", notImplemented("Challenges are disabled on this server."))
+	}
+	if _, ok := repository.(indexRepository); ok {
+		register(http.MethodGet, "/index", h.GetIndex)
+	} else {
+		handle(http.MethodGet, "/index", notImplemented("Streaming the index is not supported by this server."))
+	}
+	// The routes used for monitoring aren't counted or limited as the API
+	// routes
+	router.Handle(http.MethodGet, "/load", api.Wrap(h.GetLoad))
+	router.Handle(http.MethodGet, "/health", api.Wrap(h.GetHealth))
+	if !conf.DisableMetrics {
+		router.Handler(http.MethodGet, "/metrics", newMetricsHandler(registry))
+	}
+	router.GET("/favicon.ico", h.GetFavicon)
+	router.GET("/robots.txt", h.GetRobotsTxt)
+	router.NotFound = http.HandlerFunc(h.NotFound)
+	return stripJsonSuffix(router), nil
+}
+
+// defaultMaxConcurrentVerifications is used if MaxConcurrentVerifications
+// isn't set, eg. in the config files created before it was introduced.
+const defaultMaxConcurrentVerifications = 8
+
+func maxConcurrentVerifications(conf *config.Config) int {
+	if conf.MaxConcurrentVerifications <= 0 {
+		return defaultMaxConcurrentVerifications
+	}
+	return conf.MaxConcurrentVerifications
+}
+
+// notImplemented returns a handle for the known routes of the features which
+// aren't available on this server so that the clients can tell them apart
+// from unknown routes.
+func notImplemented(message string) api.Handle {
+	err := api.NotImplemented.WithMessage(message)
+	return func(r *http.Request, ps httprouter.Params) (interface{}, api.Error) {
+		return nil, err
+	}
+}
+
+// jsonSuffix is the optional suffix accepted by all read routes.
+const jsonSuffix = ".json"
+
+// stripJsonSuffix removes the optional ".json" suffix from the paths of the
+// read requests before they are routed.
+func stripJsonSuffix(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isReadRequest(r) && strings.HasSuffix(r.URL.Path, jsonSuffix) {
+			r = r.Clone(r.Context())
+			r.URL.Path = strings.TrimSuffix(r.URL.Path, jsonSuffix)
+			r.URL.RawPath = ""
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isReadRequest(r *http.Request) bool {
+	return r.Method == http.MethodGet || r.Method == http.MethodHead
 }
 
 type handler struct {
-	repository Repository
+	repository   Repository
+	conf         *config.Config
+	ready        *readiness
+	verification *verificationLimiter
+	validation   *validationMetrics
+	writes       *asyncWriter
+	challenger   *challenger
+	reserved     *data.ReservedNicks
+	breaker      *circuitBreaker
+	puts         *inFlightLimiter
+
+	// inFlight is the number of API requests being processed.
+	inFlight int64
 }
 
+// GetNicks returns all nicks as a JSON array, an empty array if there are no
+// nicks. If the "limit" or "after" parameters are present a page of nicks is
+// returned instead, an empty page contains an empty array and a null cursor.
+// The total number of nicks is returned in the X-Total-Count header of the
+// pages if the repository can count them. If the "from" and "to" parameters are present only the nicks with times
+// within that range are returned. If listing the nicks is disabled 403 is
+// returned.
 func (h *handler) GetNicks(r *http.Request, ps httprouter.Params) (interface{}, api.Error) {
-	nicks, err := h.repository.List()
+	if h.conf.DisableList {
+		return nil, listDisabledError
+	}
+
+	params, paged, apiErr := getPageParams(r, cursorAfter)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	timeRange, ranged, apiErr := getTimeRangeParams(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	if ranged {
+		if paged {
+			return nil, api.BadRequest.WithMessage("Time range can't be combined with pagination.")
+		}
+		return h.getNicksInTimeRange(r.Context(), timeRange)
+	}
+	if paged {
+		return h.getNicksPage(params)
+	}
+
+	// The revision is retrieved before the list so that a write performed
+	// in between results in a stale ETag and not in missed changes.
+	etag, apiErr := h.getListETag(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if etag != "" && etagMatches(r.Header.Get("If-None-Match"), etag) {
+		return api.NewResponse(http.StatusNotModified, nil).WithHeader("ETag", etag), nil
+	}
+
+	nicks, err := h.repository.List(r.Context())
 	if err != nil {
-		log.Error("list failed", "err", err)
+		if r.Context().Err() == nil {
+			log.Error("list failed", "err", err)
+		}
 		return nil, api.InternalServerError
 	}
+	if nicks == nil {
+		nicks = make([]data.NickData, 0)
+	}
+	if etag == "" {
+		return nicks, nil
+	}
+	return api.NewResponse(http.StatusOK, nicks).WithHeader("ETag", etag), nil
+}
+
+// getListETag returns the ETag of the list of all nicks or an empty string if
+// the repository can't detect changes.
+func (h *handler) getListETag(r *http.Request) (string, api.Error) {
+	repository, ok := h.repository.(revisionRepository)
+	if !ok {
+		return "", nil
+	}
+	revision, err := repository.Revision()
+	if err != nil {
+		log.Error("revision failed", "err", err)
+		return "", api.InternalServerError
+	}
+	return strconv.Quote(strconv.FormatUint(revision, 10) + representationSuffix(r)), nil
+}
+
+// etagMatches checks if the value of the If-None-Match header matches the
+// ETag.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		candidate = strings.TrimPrefix(candidate, "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *handler) getNicksPage(params pageParams) (interface{}, api.Error) {
+	repository, ok := h.repository.(pagedRepository)
+	if !ok {
+		return nil, api.NotImplemented.WithMessage("Pagination is not supported by this server.")
+	}
+
+	nicks, next, err := repository.ListAfter(params.Cursor, params.Limit)
+	if err != nil {
+		log.Error("list after failed", "err", err)
+		return nil, api.InternalServerError
+	}
+	if nicks == nil {
+		nicks = make([]data.NickData, 0)
+	}
+
+	counter, ok := h.repository.(countRepository)
+	if !ok {
+		return newPage(nicks, next), nil
+	}
+	total, err := counter.Count()
+	if err != nil {
+		log.Error("count failed", "err", err)
+		return nil, api.InternalServerError
+	}
+	return api.NewResponse(http.StatusOK, newPage(nicks, next)).WithHeader(totalCountHeader, strconv.Itoa(total)), nil
+}
+
+func (h *handler) getNicksInTimeRange(ctx context.Context, params timeRange) (interface{}, api.Error) {
+	repository, ok := h.repository.(timeRangeRepository)
+	if !ok {
+		return nil, api.NotImplemented.WithMessage("Filtering by time is not supported by this server.")
+	}
+
+	nicks, err := repository.ListTimeRange(ctx, params.From, params.To)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Error("list time range failed", "err", err)
+		}
+		return nil, api.InternalServerError
+	}
+	if nicks == nil {
+		nicks = make([]data.NickData, 0)
+	}
 	return nicks, nil
 }
 
+// GetIndex streams the nick index as newline-delimited JSON objects each
+// containing a nick and the id of the node holding it.
+func (h *handler) GetIndex(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if h.conf.DisableList {
+		api.Call(w, r, ps, func(r *http.Request, ps httprouter.Params) (interface{}, api.Error) {
+			return nil, listDisabledError
+		})
+		return
+	}
+
+	written := false
+	writeHeader := func() {
+		if !written {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.WriteHeader(http.StatusOK)
+			written = true
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	if err := h.repository.(indexRepository).IterateIndex(r.Context(), func(entry data.IndexEntry) error {
+		writeHeader()
+		return encoder.Encode(entry)
+	}); err != nil {
+		if r.Context().Err() == nil {
+			log.Error("index failed", "err", err)
+		}
+		if !written {
+			api.Call(w, r, ps, func(r *http.Request, ps httprouter.Params) (interface{}, api.Error) {
+				return nil, api.InternalServerError
+			})
+		}
+		return
+	}
+	writeHeader()
+}
+
 func (h *handler) GetNick(r *http.Request, ps httprouter.Params) (interface{}, api.Error) {
-	nodeId, err := hex.DecodeString(getParamString(ps, "id"))
+	includeTrust, apiErr := getIncludeTrustParam(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	nickData, apiErr := h.getNickData(ps)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	rv := annotatedNickData{NickData: nickData}
+	if h.isStale(nickData) {
+		if h.conf.WithholdStale {
+			return nil, api.NotFound
+		}
+		rv.Stale = true
+	}
+	if includeTrust {
+		trust, err := h.computeTrust(nickData)
+		if err != nil {
+			log.Error("computing the trust score failed", "err", err)
+			return nil, api.InternalServerError
+		}
+		rv.Trust = &trust
+	}
+
+	// The trust score changes independently of the nick data so the
+	// responses which include it can't be validated.
+	if rv.Trust != nil {
+		return rv, nil
+	}
+
+	// The stale flag appears once enough time passes without changing the
+	// time of the nick data so stale responses have no Last-Modified date.
+	etag := nickDataETag(r, nickData, rv.Stale)
+	var lastModified time.Time
+	if !rv.Stale {
+		lastModified = nickData.Time.UTC()
+	}
+
+	var resp api.Response
+	switch {
+	case isNotModified(r, etag, lastModified):
+		resp = api.NewResponse(http.StatusNotModified, nil)
+	case rv.Stale:
+		resp = api.NewResponse(http.StatusOK, rv)
+	default:
+		resp = api.NewResponse(http.StatusOK, nickData)
+	}
+	resp = resp.WithHeader("ETag", etag)
+	if !lastModified.IsZero() {
+		resp = resp.WithHeader("Last-Modified", lastModified.Format(http.TimeFormat))
+	}
+	return resp, nil
+}
+
+// isNotModified evaluates the conditional headers of the request. Following
+// RFC 7232 If-Modified-Since is ignored if If-None-Match is present. The last
+// modification time is compared with a granularity of one second as the header
+// can't express fractions. A zero time never matches.
+func isNotModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		return etagMatches(ifNoneMatch, etag)
+	}
+
+	if lastModified.IsZero() {
+		return false
+	}
+	ifModifiedSince, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	return !lastModified.Truncate(time.Second).After(ifModifiedSince)
+}
+
+// nickDataETag returns the ETag of the nick data. It is computed from the
+// content hash as every update changes the signed data. The stale flag and the
+// encoding are included as they change the representation.
+func nickDataETag(r *http.Request, nickData *data.NickData, stale bool) string {
+	etag := hex.EncodeToString(nickData.ContentHash())
+	if stale {
+		etag += "-stale"
+	}
+	return strconv.Quote(etag + representationSuffix(r))
+}
+
+// representationSuffix returns the suffix added to the ETags of the responses
+// which aren't encoded as JSON so that the caches don't confuse the
+// encodings. The ETags of the JSON responses don't change.
+func representationSuffix(r *http.Request) string {
+	switch {
+	case prefersProtobuf(r):
+		return "-protobuf"
+	case api.ContentType(r) == api.CBORContentType:
+		return "-cbor"
+	default:
+		return ""
+	}
+}
+
+// cborMapHeader is the first byte of the CBOR encoded maps with no pairs.
+const cborMapHeader = 0xa0
+
+// annotatedNickData is the nick data encoded with additional fields computed
+// by the server which aren't covered by the signature.
+type annotatedNickData struct {
+	*data.NickData
+	Stale bool
+	Trust *float64
+}
+
+func (a annotatedNickData) MarshalJSON() ([]byte, error) {
+	b, err := a.NickData.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.NewBuffer(b[:len(b)-1])
+	if a.Stale {
+		buf.WriteString(`,"stale":true`)
+	}
+	if a.Trust != nil {
+		trust, err := json.Marshal(*a.Trust)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(`,"trust":`)
+		buf.Write(trust)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (a annotatedNickData) MarshalCBOR() ([]byte, error) {
+	b, err := a.NickData.MarshalCBOR()
+	if err != nil {
+		return nil, err
+	}
+
+	annotations, err := cbor.Marshal(struct {
+		Stale bool     `cbor:"stale,omitempty"`
+		Trust *float64 `cbor:"trust,omitempty"`
+	}{a.Stale, a.Trust})
 	if err != nil {
-		return nil, api.BadRequest.WithMessage("Invalid node ID.")
+		return nil, err
+	}
+
+	// Both maps have less than 24 pairs so their lengths are encoded in
+	// the first bytes together with the major type.
+	n := int(b[0]) - cborMapHeader + int(annotations[0]) - cborMapHeader
+	if n >= 24 {
+		return nil, errors.New("too many pairs")
+	}
+	buf := bytes.NewBuffer([]byte{byte(cborMapHeader + n)})
+	buf.Write(b[1:])
+	buf.Write(annotations[1:])
+	return buf.Bytes(), nil
+}
+
+// isStale returns true if the nick data is older than MaxServedAge.
+func (h *handler) isStale(nickData *data.NickData) bool {
+	if h.conf.MaxServedAge <= 0 {
+		return false
+	}
+	return time.Since(nickData.Time) > time.Duration(h.conf.MaxServedAge)
+}
+
+// bundle contains everything needed to verify the nick data offline.
+type bundle struct {
+	NickData    *data.NickData `json:"nickData"`
+	SignedData  []byte         `json:"signedData"`
+	SigningHash string         `json:"signingHash"`
+}
+
+func (h *handler) GetBundle(r *http.Request, ps httprouter.Params) (interface{}, api.Error) {
+	nickData, apiErr := h.getNickData(ps)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	rv := bundle{
+		NickData:    nickData,
+		SignedData:  nickData.GetDataToSign(),
+		SigningHash: data.SigningHash.String(),
+	}
+	return rv, nil
+}
+
+// GetNickHistory returns the nicks held by a node starting with the current
+// one. If the "limit" or "before" parameters are present a page of nicks is
+// returned instead.
+func (h *handler) GetNickHistory(r *http.Request, ps httprouter.Params) (interface{}, api.Error) {
+	nodeId, apiErr := getNodeIdParam(ps)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	params, paged, apiErr := getPageParams(r, cursorBefore)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if paged {
+		return h.getNickHistoryPage(nodeId, params)
+	}
+	nicks, err := h.repository.(historyRepository).NickHistory(nodeId)
+	if err != nil {
+		if isClientError(err) {
+			return nil, api.BadRequest.WithMessage(err.Error())
+		} else {
+			log.Error("get nick history failed", "err", err)
+			return nil, api.InternalServerError
+		}
+	}
+	return nicks, nil
+}
+
+// GetNickVersions returns the prior versions of the nick data together with
+// their signatures so that each of them can be verified. If the "limit" or
+// "before" parameters are present a page of versions is returned instead.
+func (h *handler) GetNickVersions(r *http.Request, ps httprouter.Params) (interface{}, api.Error) {
+	nodeId, apiErr := getNodeIdParam(ps)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	params, paged, apiErr := getPageParams(r, cursorBefore)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if paged {
+		return h.getNickVersionsPage(nodeId, params)
+	}
+	versions, err := h.repository.(versionsRepository).Versions(nodeId)
+	if err != nil {
+		if isClientError(err) {
+			return nil, api.BadRequest.WithMessage(err.Error())
+		} else {
+			log.Error("get nick versions failed", "err", err)
+			return nil, api.InternalServerError
+		}
+	}
+	return versions, nil
+}
+
+func (h *handler) getNickHistoryPage(nodeId node.ID, params pageParams) (interface{}, api.Error) {
+	repository, ok := h.repository.(historyPageRepository)
+	if !ok {
+		return nil, api.NotImplemented.WithMessage("Pagination is not supported by this server.")
+	}
+
+	nicks, next, err := repository.NickHistoryBefore(nodeId, params.Cursor, params.Limit)
+	if err != nil {
+		if isClientError(err) {
+			return nil, api.BadRequest.WithMessage(err.Error())
+		}
+		log.Error("get nick history page failed", "err", err)
+		return nil, api.InternalServerError
+	}
+	if nicks == nil {
+		nicks = make([]string, 0)
+	}
+	return newPage(nicks, next), nil
+}
+
+func (h *handler) getNickVersionsPage(nodeId node.ID, params pageParams) (interface{}, api.Error) {
+	repository, ok := h.repository.(versionsPageRepository)
+	if !ok {
+		return nil, api.NotImplemented.WithMessage("Pagination is not supported by this server.")
+	}
+
+	versions, next, err := repository.VersionsBefore(nodeId, params.Cursor, params.Limit)
+	if err != nil {
+		if isClientError(err) {
+			return nil, api.BadRequest.WithMessage(err.Error())
+		}
+		log.Error("get nick versions page failed", "err", err)
+		return nil, api.InternalServerError
+	}
+	if versions == nil {
+		versions = make([]data.NickData, 0)
+	}
+	return newPage(versions, next), nil
+}
+
+func (h *handler) getNickData(ps httprouter.Params) (*data.NickData, api.Error) {
+	nodeId, apiErr := getNodeIdParam(ps)
+	if apiErr != nil {
+		return nil, apiErr
 	}
 	nickData, err := h.repository.Get(nodeId)
 	if err != nil {
@@ -99,7 +1042,7 @@ func (h *handler) GetNick(r *http.Request, ps httprouter.Params) (interface{}, a
 
 func (h *handler) GetId(r *http.Request, ps httprouter.Params) (interface{}, api.Error) {
 	nick := getParamString(ps, "nick")
-	if err := data.ValidateNick(nick); err != nil {
+	if err := h.nickPolicy().ValidateNick(nick); err != nil {
 		return nil, api.BadRequest.WithMessage("Invalid nick.")
 	}
 
@@ -118,22 +1061,111 @@ func (h *handler) GetId(r *http.Request, ps httprouter.Params) (interface{}, api
 	return nickData, nil
 }
 
+// displayFallbackLength is the number of hex characters of the node id used
+// as the display name of the nodes without a nick.
+const displayFallbackLength = 8
+
+type display struct {
+	Id         node.ID `json:"id"`
+	Display    string  `json:"display"`
+	Registered bool    `json:"registered"`
+}
+
+// GetDisplay returns the nick of the node or a prefix of its id if the node
+// doesn't have a nick.
+func (h *handler) GetDisplay(r *http.Request, ps httprouter.Params) (interface{}, api.Error) {
+	nickData, apiErr := h.getNickData(ps)
+	if apiErr != nil && apiErr.GetCode() != http.StatusNotFound {
+		return nil, apiErr
+	}
+
+	nodeId, _ := getNodeIdParam(ps)
+	rv := display{
+		Id: nodeId,
+	}
+	if nickData != nil {
+		rv.Display = nickData.Nick
+		rv.Registered = true
+	} else {
+		rv.Display = hex.EncodeToString(nodeId)
+		if len(rv.Display) > displayFallbackLength {
+			rv.Display = rv.Display[:displayFallbackLength]
+		}
+	}
+	return rv, nil
+}
+
 func (h *handler) PutNick(r *http.Request, ps httprouter.Params) (interface{}, api.Error) {
+	if h.conf.Mode == config.ModeFollower {
+		return nil, readOnlyError
+	}
+
 	if r.Body == nil {
 		return nil, api.BadRequest
 	}
 
-	body, err := ioutil.ReadAll(r.Body)
+	maxBodyBytes := h.conf.MaxBodyBytes
+	if maxBodyBytes <= 0 {
+		maxBodyBytes = maxPutBodySize
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, maxBodyBytes))
 	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, api.RequestEntityTooLarge.WithMessage("Body can't be larger than " + strconv.FormatInt(maxBodyBytes, 10) + " bytes.")
+		}
 		return nil, api.BadRequest
 	}
 
 	nickData := &data.NickData{}
-	if err := json.Unmarshal(body, nickData); err != nil {
+	if err := api.Unmarshal(r, body, nickData); err != nil {
+		if errors.Cause(err) == data.FieldTooLongErr {
+			return nil, api.BadRequest.WithMessage(err.Error())
+		}
 		return nil, api.BadRequest
 	}
 
-	if err := h.repository.Put(nickData); err != nil {
+	if h.challenger != nil {
+		if nickData.Version < data.VersionChallenge {
+			return nil, challengeRequiredError
+		}
+		if err := h.challenger.Verify(nickData.Challenge); err != nil {
+			return nil, invalidChallengeError
+		}
+	}
+
+	async, err := isAsync(r)
+	if err != nil {
+		return nil, api.BadRequest.WithMessage("Invalid async parameter.")
+	}
+	if async && h.writes == nil {
+		return nil, asyncWritesDisabledError
+	}
+
+	if !h.verification.Acquire(r.Context()) {
+		return nil, tooManyWritesError
+	}
+	defer h.verification.Release()
+
+	if async {
+		return h.putAsync(r, nickData)
+	}
+
+	result, err := h.put(nickData)
+	if err != nil {
+		if err == data.ReadOnlyErr {
+			return nil, readOnlyError
+		}
+		if errors.Is(err, data.InvalidNickDataErr) {
+			h.validation.Record(r, err)
+			return nil, invalidNickDataError(err)
+		}
+		if err == data.NickKeyTooLongErr {
+			return nil, api.BadRequest.WithMessage(err.Error()).WithReason(reasonInvalidNickData, string(data.ReasonNick))
+		}
+		if err == data.ReservedNickErr {
+			return nil, reservedNickError
+		}
 		if isClientError(err) {
 			return nil, api.BadRequest.WithMessage(err.Error())
 		} else {
@@ -142,16 +1174,175 @@ func (h *handler) PutNick(r *http.Request, ps httprouter.Params) (interface{}, a
 		}
 	}
 
-	return nil, nil
+	code := http.StatusOK
+	if result == data.PutCreated {
+		code = http.StatusCreated
+	}
+	return api.NewResponse(code, nil).WithHeader(putResultHeader, string(result)), nil
+}
+
+// putResultHeader reports whether the nick data was created, updated or
+// identical to the stored nick data.
+const putResultHeader = "X-Put-Result"
+
+// put stores the nick data. If the repository can't report the result the
+// nick data is assumed to be updated.
+func (h *handler) put(nickData *data.NickData) (data.PutResult, error) {
+	if repository, ok := h.repository.(putResultRepository); ok {
+		return repository.PutResult(nickData)
+	}
+	if err := h.repository.Put(nickData); err != nil {
+		return "", err
+	}
+	return data.PutUpdated, nil
+}
+
+type challengeResponse struct {
+	Challenge []byte    `json:"challenge"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Version   int       `json:"version"`
+}
+
+// GetChallenge issues a challenge which has to be included in the signed nick
+// data using the returned version.
+func (h *handler) GetChallenge(r *http.Request, ps httprouter.Params) (interface{}, api.Error) {
+	challenge, expiresAt, err := h.challenger.Issue()
+	if err != nil {
+		log.Error("could not issue a challenge", "err", err)
+		return nil, api.InternalServerError
+	}
+
+	rv := challengeResponse{
+		Challenge: challenge,
+		ExpiresAt: expiresAt,
+		Version:   data.VersionChallenge,
+	}
+	return rv, nil
+}
+
+// putAsync validates the nick data and queues it to be stored. Only the
+// validation errors and reserved nicks are reported to the client.
+func (h *handler) putAsync(r *http.Request, nickData *data.NickData) (interface{}, api.Error) {
+	if err := nickData.ValidateWithPolicy(h.nickPolicy()); err != nil {
+		h.validation.Record(r, err)
+		return nil, invalidNickDataError(err)
+	}
+	reserved, err := h.isReserved(nickData)
+	if err != nil {
+		log.Error("checking the reserved nick failed", "err", err)
+		return nil, api.InternalServerError
+	}
+	if reserved {
+		return nil, reservedNickError
+	}
+
+	if !h.writes.Enqueue(nickData) {
+		return nil, tooManyWritesError
+	}
+
+	return api.NewResponse(http.StatusAccepted, nil), nil
+}
+
+// isReserved returns true if the nick is reserved and isn't held by the node
+// which submitted the nick data. The repository performs the same check when
+// the nick data is stored.
+func (h *handler) isReserved(nickData *data.NickData) (bool, error) {
+	if !h.reserved.IsReserved(nickData.Nick) {
+		return false, nil
+	}
+	holder, err := h.repository.GetByNick(nickData.Nick)
+	if err != nil {
+		return false, err
+	}
+	return holder == nil || !node.CompareId(holder.Id, nickData.Id), nil
+}
+
+// nickPolicy returns the nick policy used by the repository.
+func (h *handler) nickPolicy() data.NickPolicy {
+	// The config is validated when the handler is created
+	return newNickPolicy(h.conf)
+}
+
+// newNickPolicy returns the nick policy used by the repository. The config
+// has to be validated first.
+func newNickPolicy(conf *config.Config) data.NickPolicy {
+	r, _ := conf.CompiledNickRegexp()
+	return data.NickPolicy{
+		StrictSeparators: conf.StrictNickSeparators,
+		Regexp:           r,
+		MinLength:        conf.MinNickLength,
+		MaxLength:        conf.MaxNickLength,
+		MaxClockSkew:     time.Duration(conf.MaxClockSkew),
+		SignatureHints:   conf.SignatureHints,
+	}
+}
+
+// isAsync returns true if the client requested an asynchronous write.
+func isAsync(r *http.Request) (bool, error) {
+	value := r.URL.Query().Get("async")
+	if value == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+type capabilities struct {
+	SupportedKeyTypes []string         `json:"supportedKeyTypes"`
+	ListEnabled       bool             `json:"listEnabled"`
+	ChallengeRequired bool             `json:"challengeRequired"`
+	SigningHash       string           `json:"signingHash"`
+	NickPolicy        nickCapabilities `json:"nickPolicy"`
+	MaxBatchSize      int              `json:"maxBatchSize"`
+}
+
+type nickCapabilities struct {
+	StrictSeparators bool `json:"strictSeparators"`
+}
+
+func (h *handler) GetCapabilities(r *http.Request, ps httprouter.Params) (interface{}, api.Error) {
+	rv := capabilities{
+		SupportedKeyTypes: []string{"rsa"},
+		ListEnabled:       !h.conf.DisableList,
+		ChallengeRequired: h.conf.RequireChallenge,
+		SigningHash:       data.SigningHash.String(),
+		NickPolicy: nickCapabilities{
+			StrictSeparators: h.conf.StrictNickSeparators,
+		},
+		MaxBatchSize: h.maxBatchSize(),
+	}
+	return rv, nil
 }
 
 func isClientError(err error) bool {
-	return err == data.InvalidNickDataErr ||
+	return errors.Is(err, data.InvalidNickDataErr) ||
 		err == data.NewerNickDataPresentErr ||
 		err == data.NickConflictErr ||
+		err == data.NickQuarantinedErr ||
+		err == data.SameTimeChangeErr ||
+		err == data.NickKeyTooLongErr ||
+		err == data.ReservedNickErr ||
 		err == data.InvalidNodeIdErr
 }
 
+// maxNodeIdParamLength is the max length of the hex encoded node id accepted
+// in the paths. It is well above the length of the valid node ids and only
+// prevents decoding arbitrarily long strings.
+const maxNodeIdParamLength = 128
+
+// getNodeIdParam decodes the hex encoded node id from the path. The returned
+// errors specify why the parameter is invalid.
+func getNodeIdParam(ps httprouter.Params) (node.ID, api.Error) {
+	value := getParamString(ps, "id")
+	if len(value) > maxNodeIdParamLength {
+		return nil, api.BadRequest.WithMessage("Invalid node ID.").WithReason("invalid_node_id", "too_long")
+	}
+	nodeId, err := hex.DecodeString(value)
+	if err != nil {
+		return nil, api.BadRequest.WithMessage("Invalid node ID.").WithReason("invalid_node_id", "bad_hex")
+	}
+	return nodeId, nil
+}
+
 func getParamString(ps httprouter.Params, name string) string {
-	return strings.TrimSuffix(ps.ByName(name), ".json")
+	return ps.ByName(name)
 }
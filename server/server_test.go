@@ -2,24 +2,49 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/boreq/starlight-nick-server/config"
 	"github.com/boreq/starlight-nick-server/data"
+	scrypto "github.com/boreq/starlight/crypto"
 	"github.com/boreq/starlight/network/node"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/inconshreveable/log15"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
 )
 
 type repositoryMock struct {
 	listReturn []data.NickData
 	listErr    error
 
+	putLock     sync.Mutex
 	putArgument *data.NickData
 	putErr      error
+	putResult   data.PutResult
+	putBlock    chan struct{}
+	putStarted  chan struct{}
 
 	getArgument *node.ID
 	getReturn   *data.NickData
@@ -28,17 +53,118 @@ type repositoryMock struct {
 	getByNickArgument *string
 	getByNickReturn   *data.NickData
 	getByNickErr      error
+
+	indexReturn []data.IndexEntry
+	indexErr    error
+
+	nickHistoryReturn []string
+	nickHistoryErr    error
+
+	firstSeenReturn time.Time
+	firstSeenErr    error
+
+	listAfterArgumentAfter node.ID
+	listAfterArgumentLimit int
+	listAfterReturn        []data.NickData
+	listAfterReturnNext    node.ID
+	listAfterErr           error
+
+	countReturn int
+	countErr    error
+
+	statsReturn data.RepoStats
+
+	revision    uint64
+	revisionErr error
+
+	schemaReturn data.SchemaInfo
+	schemaErr    error
+
+	listTimeRangeArgumentFrom time.Time
+	listTimeRangeArgumentTo   time.Time
+	listTimeRangeReturn       []data.NickData
+	listTimeRangeErr          error
+
+	resolveNicksArgument []string
+	resolveNicksReturn   map[string]node.ID
+	resolveNicksErr      error
+
+	searchArgumentPrefix string
+	searchArgumentLimit  int
+	searchReturn         []data.NickData
+	searchErr            error
+
+	importArgument []data.NickData
+	importReturn   data.ImportSummary
+	importErr      error
+
+	deleteArgument *node.ID
+	deleteErr      error
+
+	versionsReturn []data.NickData
+	versionsErr    error
+
+	pingErr error
+
+	closed bool
 }
 
-func (r *repositoryMock) List() ([]data.NickData, error) {
+func (r *repositoryMock) List(ctx context.Context) ([]data.NickData, error) {
 	return r.listReturn, r.listErr
 }
 
+func (r *repositoryMock) ListAfter(after node.ID, limit int) ([]data.NickData, node.ID, error) {
+	r.listAfterArgumentAfter = after
+	r.listAfterArgumentLimit = limit
+	return r.listAfterReturn, r.listAfterReturnNext, r.listAfterErr
+}
+
+func (r *repositoryMock) Count() (int, error) {
+	return r.countReturn, r.countErr
+}
+
+func (r *repositoryMock) ListTimeRange(ctx context.Context, from, to time.Time) ([]data.NickData, error) {
+	r.listTimeRangeArgumentFrom = from
+	r.listTimeRangeArgumentTo = to
+	return r.listTimeRangeReturn, r.listTimeRangeErr
+}
+
 func (r *repositoryMock) Put(nickData *data.NickData) error {
+	if r.putStarted != nil {
+		r.putStarted <- struct{}{}
+	}
+	if r.putBlock != nil {
+		<-r.putBlock
+	}
+	r.putLock.Lock()
+	defer r.putLock.Unlock()
 	r.putArgument = nickData
+	if r.putErr == nil {
+		r.revision++
+	}
 	return r.putErr
 }
 
+func (r *repositoryMock) PutResult(nickData *data.NickData) (data.PutResult, error) {
+	if err := r.Put(nickData); err != nil {
+		return "", err
+	}
+	if r.putResult == "" {
+		return data.PutUpdated, nil
+	}
+	return r.putResult, nil
+}
+
+func (r *repositoryMock) Delete(nodeId node.ID) error {
+	r.deleteArgument = &nodeId
+	return r.deleteErr
+}
+
+func (r *repositoryMock) DeleteTombstone(tombstone *data.Tombstone) error {
+	r.deleteArgument = &tombstone.Id
+	return r.deleteErr
+}
+
 func (r *repositoryMock) Get(nodeId node.ID) (*data.NickData, error) {
 	r.getArgument = &nodeId
 	return r.getReturn, r.getErr
@@ -49,10 +175,74 @@ func (r *repositoryMock) GetByNick(nick string) (*data.NickData, error) {
 	return r.getByNickReturn, r.getByNickErr
 }
 
+func (r *repositoryMock) IterateIndex(ctx context.Context, fn func(entry data.IndexEntry) error) error {
+	for _, entry := range r.indexReturn {
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return r.indexErr
+}
+
+func (r *repositoryMock) NickHistory(nodeId node.ID) ([]string, error) {
+	return r.nickHistoryReturn, r.nickHistoryErr
+}
+
+func (r *repositoryMock) FirstSeen(nodeId node.ID) (time.Time, error) {
+	return r.firstSeenReturn, r.firstSeenErr
+}
+
+func (r *repositoryMock) Versions(nodeId node.ID) ([]data.NickData, error) {
+	return r.versionsReturn, r.versionsErr
+}
+
+func (r *repositoryMock) Close() error {
+	r.closed = true
+	return nil
+}
+
+func (r *repositoryMock) Ping() error {
+	return r.pingErr
+}
+
+func (r *repositoryMock) Revision() (uint64, error) {
+	r.putLock.Lock()
+	defer r.putLock.Unlock()
+	return r.revision, r.revisionErr
+}
+
+func (r *repositoryMock) Schema() (data.SchemaInfo, error) {
+	return r.schemaReturn, r.schemaErr
+}
+
+func (r *repositoryMock) ResolveNicks(nicks []string) (map[string]node.ID, error) {
+	r.resolveNicksArgument = nicks
+	return r.resolveNicksReturn, r.resolveNicksErr
+}
+
+func (r *repositoryMock) SearchByPrefix(prefix string, limit int) ([]data.NickData, error) {
+	r.searchArgumentPrefix = prefix
+	r.searchArgumentLimit = limit
+	return r.searchReturn, r.searchErr
+}
+
+func (r *repositoryMock) Import(nickDatas []data.NickData) (data.ImportSummary, error) {
+	r.importArgument = append(r.importArgument, nickDatas...)
+	return r.importReturn, r.importErr
+}
+
+func (r *repositoryMock) Stats() data.RepoStats {
+	return r.statsReturn
+}
+
 func makeComponents(t *testing.T) (*repositoryMock, http.Handler, *httptest.ResponseRecorder) {
+	return makeComponentsWithConfig(t, config.Default())
+}
+
+func makeComponentsWithConfig(t *testing.T, conf *config.Config) (*repositoryMock, http.Handler, *httptest.ResponseRecorder) {
 	repo := &repositoryMock{}
 
-	h, err := newHandler(repo)
+	h, err := newHandler(repo, conf)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -62,6 +252,14 @@ func makeComponents(t *testing.T) (*repositoryMock, http.Handler, *httptest.Resp
 	return repo, h, rr
 }
 
+// makeWriteGuards creates the write guards shared by the handler and the gRPC
+// server.
+func makeWriteGuards(t *testing.T, conf *config.Config, ready *readiness, registry *prometheus.Registry) *writeGuards {
+	guards, err := newWriteGuards(conf, ready, registry)
+	require.NoError(t, err)
+	return guards
+}
+
 func makeNickData() *data.NickData {
 	return &data.NickData{
 		Id:        []byte("id"),
@@ -72,6 +270,65 @@ func makeNickData() *data.NickData {
 	}
 }
 
+var (
+	testIdentity     *node.Identity
+	testIdentityOnce sync.Once
+)
+
+// makeIdentity returns an identity generated once per test run.
+func makeIdentity(t *testing.T) *node.Identity {
+	testIdentityOnce.Do(func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		block := &pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key),
+		}
+
+		testIdentity, err = node.LoadIdentity(pem.EncodeToMemory(block))
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	return testIdentity
+}
+
+// makeValidNickData returns nick data with a valid signature.
+func makeValidNickData(t *testing.T) *data.NickData {
+	return makeValidNickDataWithChallenge(t, nil)
+}
+
+// makeValidNickDataWithChallenge returns nick data with a valid signature
+// which includes the challenge if it is not nil.
+func makeValidNickDataWithChallenge(t *testing.T, challenge []byte) *data.NickData {
+	iden := makeIdentity(t)
+
+	publicKey, err := iden.PubKey.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nickData := &data.NickData{
+		Id:        iden.Id,
+		Nick:      "nick",
+		Time:      time.Date(1990, 1, 1, 1, 1, 1, 0, time.UTC),
+		PublicKey: publicKey,
+	}
+	if challenge != nil {
+		nickData.Version = data.VersionChallenge
+		nickData.Challenge = challenge
+	}
+
+	nickData.Signature, err = iden.PrivKey.Sign(nickData.GetDataToSign(), data.SigningHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nickData
+}
+
 func makeJsonNickData(t *testing.T) []byte {
 	nickData := makeNickData()
 	j, err := json.Marshal(nickData)
@@ -97,6 +354,36 @@ func TestGetInvalidNodeId(t *testing.T) {
 	require.Equal(t, 400, rr.Code, "http status should be Bad Request")
 }
 
+func TestGetInvalidNodeIdReason(t *testing.T) {
+	testCases := []struct {
+		Name           string
+		Id             string
+		ExpectedDetail string
+	}{
+		{"non_hex", "jfka", "bad_hex"},
+		{"odd_length", "abc", "bad_hex"},
+		{"too_long", strings.Repeat("ab", maxNodeIdParamLength), "too_long"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			// given
+			repo, h, rr := makeComponents(t)
+
+			req, err := http.NewRequest("GET", "/nicks/"+testCase.Id, nil)
+			require.NoError(t, err)
+
+			// when
+			h.ServeHTTP(rr, req)
+
+			// then
+			require.Equal(t, 400, rr.Code, "http status should be Bad Request")
+			require.JSONEq(t, `{"code":400,"message":"Invalid node ID.","reason":"invalid_node_id","detail":"`+testCase.ExpectedDetail+`"}`, rr.Body.String())
+			require.Nil(t, repo.getArgument, "repository should not be called")
+		})
+	}
+}
+
 func TestGetNonexistent(t *testing.T) {
 	// given
 	_, h, rr := makeComponents(t)
@@ -133,154 +420,2947 @@ func TestGet(t *testing.T) {
 	require.Equal(t, expectedBody, rr.Body.String(), "body should contain json formatted nick data")
 }
 
-func TestGetInvalidNodeIdError(t *testing.T) {
+func getNickWithETag(t *testing.T, h http.Handler, etag string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", "/nicks/abcd", nil)
+	require.NoError(t, err)
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestGetETag(t *testing.T) {
 	// given
-	repo, h, rr := makeComponents(t)
+	repo, h, _ := makeComponents(t)
 
-	repo.getErr = data.InvalidNickDataErr
+	repo.getReturn = makeNickData()
 
-	req, err := http.NewRequest("GET", "/nicks/abcd", nil)
-	if err != nil {
-		t.Fatal(err)
-	}
+	// when
+	rr := getNickWithETag(t, h, "")
+
+	// then
+	require.Equal(t, 200, rr.Code, "http status should be OK")
+	require.Equal(t, strconv.Quote(hex.EncodeToString(repo.getReturn.ContentHash())), rr.Header().Get("ETag"), "etag should be set")
+}
+
+func TestGetETagNotModified(t *testing.T) {
+	// given
+	repo, h, _ := makeComponents(t)
+
+	repo.getReturn = makeNickData()
+
+	first := getNickWithETag(t, h, "")
+	etag := first.Header().Get("ETag")
+	require.NotEmpty(t, etag, "etag should be set")
 
 	// when
-	h.ServeHTTP(rr, req)
+	second := getNickWithETag(t, h, etag)
 
 	// then
-	require.Equal(t, 400, rr.Code, "http status should be Bad Request")
+	require.Equal(t, http.StatusNotModified, second.Code, "http status should be Not Modified")
+	require.Equal(t, etag, second.Header().Get("ETag"), "etag should be repeated")
+	require.Empty(t, second.Body.String(), "body should be empty")
 }
 
-func TestPut(t *testing.T) {
+func TestGetETagChangedByUpdate(t *testing.T) {
 	// given
-	_, h, rr := makeComponents(t)
+	repo, h, _ := makeComponents(t)
 
-	buf := bytes.NewBuffer(makeJsonNickData(t))
+	repo.getReturn = makeNickData()
 
-	req, err := http.NewRequest("PUT", "/nicks", buf)
-	if err != nil {
-		t.Fatal(err)
-	}
+	first := getNickWithETag(t, h, "")
+	etag := first.Header().Get("ETag")
+
+	repo.getReturn = makeNickData()
+	repo.getReturn.Time = repo.getReturn.Time.Add(time.Second)
 
 	// when
+	second := getNickWithETag(t, h, etag)
+
+	// then
+	require.Equal(t, 200, second.Code, "http status should be OK")
+	require.NotEqual(t, etag, second.Header().Get("ETag"), "etag should change")
+}
+
+func getNickIfModifiedSince(t *testing.T, h http.Handler, since time.Time) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", "/nicks/abcd", nil)
+	require.NoError(t, err)
+	req.Header.Set("If-Modified-Since", since.UTC().Format(http.TimeFormat))
+	rr := httptest.NewRecorder()
 	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestGetLastModified(t *testing.T) {
+	// given
+	repo, h, _ := makeComponents(t)
+
+	repo.getReturn = makeNickData()
+
+	// when
+	rr := getNickWithETag(t, h, "")
 
 	// then
 	require.Equal(t, 200, rr.Code, "http status should be OK")
+	require.Equal(t, "Mon, 01 Jan 1990 01:01:01 GMT", rr.Header().Get("Last-Modified"))
 }
 
-func TestPutMalformedJson(t *testing.T) {
-	// given
-	_, h, rr := makeComponents(t)
+func TestGetIfModifiedSince(t *testing.T) {
+	testCases := []struct {
+		Name         string
+		Since        time.Time
+		ExpectedCode int
+	}{
+		{
+			Name:         "before",
+			Since:        time.Date(1990, 1, 1, 1, 1, 0, 0, time.UTC),
+			ExpectedCode: http.StatusOK,
+		},
+		{
+			Name:         "same_second",
+			Since:        time.Date(1990, 1, 1, 1, 1, 1, 0, time.UTC),
+			ExpectedCode: http.StatusNotModified,
+		},
+		{
+			Name:         "after",
+			Since:        time.Date(1990, 1, 1, 1, 1, 2, 0, time.UTC),
+			ExpectedCode: http.StatusNotModified,
+		},
+	}
 
-	buf := &bytes.Buffer{}
-	buf.WriteString("[]")
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			// given
+			repo, h, _ := makeComponents(t)
 
-	req, err := http.NewRequest("PUT", "/nicks", buf)
-	if err != nil {
-		t.Fatal(err)
+			repo.getReturn = makeNickData()
+
+			// when
+			rr := getNickIfModifiedSince(t, h, testCase.Since)
+
+			// then
+			require.Equal(t, testCase.ExpectedCode, rr.Code)
+			require.NotEmpty(t, rr.Header().Get("Last-Modified"), "last modified should be set")
+			require.NotEmpty(t, rr.Header().Get("ETag"), "etag should be set")
+			if testCase.ExpectedCode == http.StatusNotModified {
+				require.Empty(t, rr.Body.String(), "body should be empty")
+			}
+		})
 	}
+}
+
+func TestGetIfNoneMatchTakesPrecedence(t *testing.T) {
+	// given
+	repo, h, _ := makeComponents(t)
+
+	repo.getReturn = makeNickData()
+
+	req, err := http.NewRequest("GET", "/nicks/abcd", nil)
+	require.NoError(t, err)
+	req.Header.Set("If-None-Match", `"outdated"`)
+	req.Header.Set("If-Modified-Since", time.Date(2000, 1, 1, 1, 1, 1, 0, time.UTC).Format(http.TimeFormat))
+	rr := httptest.NewRecorder()
 
 	// when
 	h.ServeHTTP(rr, req)
 
 	// then
-	require.Equal(t, 400, rr.Code, "http status should be Bad Request")
+	require.Equal(t, 200, rr.Code, "If-Modified-Since should be ignored")
 }
 
-func TestPutNoBody(t *testing.T) {
+func TestGetETagGzip(t *testing.T) {
 	// given
-	_, h, rr := makeComponents(t)
+	repo := &repositoryMock{getReturn: makeNickData()}
+	repo.getReturn.PublicKey = bytes.Repeat([]byte("public key"), 200)
 
-	req, err := http.NewRequest("PUT", "/nicks", nil)
-	if err != nil {
-		t.Fatal(err)
-	}
+	registry := prometheus.NewRegistry()
+	h, err := newPublicHandler(repo, config.Default(), makeWriteGuards(t, config.Default(), newReadiness(true), registry), nil, registry)
+	require.NoError(t, err)
+
+	req, err := http.NewRequest("GET", "/nicks/abcd", nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
 
 	// when
 	h.ServeHTTP(rr, req)
 
 	// then
-	require.Equal(t, 400, rr.Code, "http status should be Bad Request")
+	require.Equal(t, 200, rr.Code, "http status should be OK")
+	require.Equal(t, "gzip", rr.Header().Get("Content-Encoding"), "response should be compressed")
+	require.NotEmpty(t, rr.Header().Get("ETag"), "etag should be set")
 }
 
-func TestPutClientErr(t *testing.T) {
-	for _, err := range []error{data.InvalidNickDataErr, data.NewerNickDataPresentErr, data.NickConflictErr} {
-		// given
-		repo, h, rr := makeComponents(t)
+func TestGetStale(t *testing.T) {
+	testCases := []struct {
+		Name          string
+		Time          time.Time
+		Withhold      bool
+		ExpectedCode  int
+		ExpectedStale bool
+	}{
+		{"fresh", time.Now().Add(-time.Minute), false, 200, false},
+		{"old", time.Now().Add(-2 * time.Hour), false, 200, true},
+		{"old_withheld", time.Now().Add(-2 * time.Hour), true, 404, false},
+	}
 
-		buf := bytes.NewBuffer(makeJsonNickData(t))
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			// given
+			conf := config.Default()
+			conf.MaxServedAge = config.Duration(time.Hour)
+			conf.WithholdStale = testCase.Withhold
+			repo, h, rr := makeComponentsWithConfig(t, conf)
+
+			repo.getReturn = makeNickData()
+			repo.getReturn.Time = testCase.Time
+
+			req, err := http.NewRequest("GET", "/nicks/abcd", nil)
+			require.NoError(t, err)
+
+			// when
+			h.ServeHTTP(rr, req)
+
+			// then
+			require.Equal(t, testCase.ExpectedCode, rr.Code)
+			if testCase.ExpectedCode != 200 {
+				return
+			}
+
+			var response map[string]interface{}
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+			require.Equal(t, "nick", response["nick"])
+			if testCase.ExpectedStale {
+				require.Equal(t, true, response["stale"], "nick data should be marked as stale")
+			} else {
+				require.NotContains(t, response, "stale", "nick data should not be marked as stale")
+			}
+		})
+	}
+}
 
-		repo.putErr = err
+func TestGetTrust(t *testing.T) {
+	getTrust := func(t *testing.T, tm, firstSeen time.Time, history []string) float64 {
+		repo, h, rr := makeComponents(t)
 
-		req, err := http.NewRequest("PUT", "/nicks", buf)
-		if err != nil {
-			t.Fatal(err)
-		}
+		repo.getReturn = makeNickData()
+		repo.getReturn.Time = tm
+		repo.firstSeenReturn = firstSeen
+		repo.nickHistoryReturn = history
+
+		req, err := http.NewRequest("GET", "/nicks/abcd?include=trust", nil)
+		require.NoError(t, err)
 
-		// when
 		h.ServeHTTP(rr, req)
+		require.Equal(t, 200, rr.Code, "http status should be OK")
 
-		// then
-		require.Equal(t, 400, rr.Code, "http status should be Bad Request for %s", err)
+		var response struct {
+			Nick  string   `json:"nick"`
+			Trust *float64 `json:"trust"`
+		}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		require.Equal(t, "nick", response.Nick)
+		require.NotNil(t, response.Trust, "trust should be included")
+		return *response.Trust
 	}
+
+	// when
+	yearAgo := time.Now().Add(-365 * 24 * time.Hour)
+	fresh := getTrust(t, time.Now(), time.Now(), []string{"nick", "previous", "first"})
+	established := getTrust(t, time.Now(), yearAgo, []string{"nick"})
+	backdated := getTrust(t, yearAgo, time.Now(), []string{"nick"})
+	unknown := getTrust(t, yearAgo, time.Time{}, []string{"nick"})
+
+	// then
+	require.Equal(t, 0.17, fresh)
+	require.Equal(t, 1.0, established)
+	require.Equal(t, 0.5, backdated, "signed time should not affect the score")
+	require.Equal(t, 0.5, unknown, "unknown first seen time should not increase the score")
 }
 
-func TestPutErr(t *testing.T) {
+func TestGetTrustNotRequested(t *testing.T) {
 	// given
 	repo, h, rr := makeComponents(t)
+	repo.getReturn = makeNickData()
 
-	buf := bytes.NewBuffer(makeJsonNickData(t))
-
-	repo.putErr = errors.New("some error")
-
-	req, err := http.NewRequest("PUT", "/nicks", buf)
-	if err != nil {
-		t.Fatal(err)
-	}
+	req, err := http.NewRequest("GET", "/nicks/abcd", nil)
+	require.NoError(t, err)
 
 	// when
 	h.ServeHTTP(rr, req)
 
 	// then
-	require.Equal(t, 500, rr.Code, "http status should be Internal Server Error")
+	require.Equal(t, 200, rr.Code, "http status should be OK")
+	require.NotContains(t, rr.Body.String(), "trust")
 }
 
-func TestList(t *testing.T) {
+func TestGetInvalidInclude(t *testing.T) {
 	// given
 	repo, h, rr := makeComponents(t)
+	repo.getReturn = makeNickData()
 
-	repo.listReturn = make([]data.NickData, 0)
+	req, err := http.NewRequest("GET", "/nicks/abcd?include=other", nil)
+	require.NoError(t, err)
 
-	req, err := http.NewRequest("GET", "/nicks", nil)
-	if err != nil {
-		t.Fatal(err)
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, 400, rr.Code, "http status should be Bad Request")
+}
+
+func TestGetNoKey(t *testing.T) {
+	testCases := []struct {
+		Name              string
+		Path              string
+		ExpectedPublicKey bool
+	}{
+		{"default", "/nicks/abcd", true},
+		{"nokey", "/nicks/abcd?nokey=true", false},
+		{"nokey_false", "/nicks/abcd?nokey=false", true},
 	}
 
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			// given
+			repo, h, rr := makeComponents(t)
+			repo.getReturn = makeNickData()
+
+			req, err := http.NewRequest("GET", testCase.Path, nil)
+			require.NoError(t, err)
+
+			// when
+			h.ServeHTTP(rr, req)
+
+			// then
+			require.Equal(t, 200, rr.Code, "http status should be OK")
+
+			var response map[string]interface{}
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+			for _, field := range []string{"id", "nick", "time", "signature"} {
+				require.Contains(t, response, field)
+			}
+			if testCase.ExpectedPublicKey {
+				require.Contains(t, response, "publicKey")
+			} else {
+				require.NotContains(t, response, "publicKey")
+			}
+			require.NotNil(t, repo.getReturn.PublicKey, "stored nick data should not be modified")
+		})
+	}
+}
+
+func TestListNoKey(t *testing.T) {
+	// given
+	repo, h, rr := makeComponents(t)
+	repo.listReturn = []data.NickData{*makeNickData()}
+
+	req, err := http.NewRequest("GET", "/nicks?nokey=true", nil)
+	require.NoError(t, err)
+
 	// when
 	h.ServeHTTP(rr, req)
 
 	// then
 	require.Equal(t, 200, rr.Code, "http status should be OK")
-	require.Equal(t, "[]", rr.Body.String(), "body should contain an empty json array")
+	require.Empty(t, rr.Header().Get("ETag"), "ETag of the complete list should not be returned")
+
+	var response []map[string]interface{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	require.Len(t, response, 1)
+	require.NotContains(t, response[0], "publicKey")
+	require.NotNil(t, repo.listReturn[0].PublicKey, "stored nick data should not be modified")
 }
 
-func TestListOneElement(t *testing.T) {
+func TestGetIdRoundTrip(t *testing.T) {
 	// given
 	repo, h, rr := makeComponents(t)
 
-	repo.listReturn = []data.NickData{*makeNickData()}
+	repo.getReturn = makeNickData()
+	repo.getReturn.Id = node.ID{0x00, 0x01, 0x7f, 0x80, 0xfe, 0xff}
 
-	req, err := http.NewRequest("GET", "/nicks", nil)
-	if err != nil {
-		t.Fatal(err)
-	}
+	req, err := http.NewRequest("GET", "/nicks/00017f80feff", nil)
+	require.NoError(t, err)
 
 	// when
 	h.ServeHTTP(rr, req)
 
 	// then
-	expectedBody := `[{"id":"6964","nick":"nick","time":"1990-01-01T01:01:01.000000001Z","publicKey":"cHVibGljIGtleQ==","signature":"c2lnbmF0dXJl"}]`
 	require.Equal(t, 200, rr.Code, "http status should be OK")
-	require.Equal(t, expectedBody, rr.Body.String(), "body should contain a json array with one nick data")
+
+	var result data.NickData
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+	require.Equal(t, *repo.getArgument, result.Id, "id in the response should match the id in the path")
+}
+
+func TestGetIdNickLength(t *testing.T) {
+	testCases := []struct {
+		Nick         string
+		ExpectedCode int
+	}{
+		{"nick", http.StatusBadRequest},
+		{"nicks", http.StatusNotFound},
+		{"nicknick", http.StatusNotFound},
+		{"nicknicks", http.StatusBadRequest},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Nick, func(t *testing.T) {
+			// given
+			conf := config.Default()
+			conf.MinNickLength = 5
+			conf.MaxNickLength = 8
+			_, h, rr := makeComponentsWithConfig(t, conf)
+
+			req, err := http.NewRequest("GET", "/ids/"+testCase.Nick, nil)
+			require.NoError(t, err)
+
+			// when
+			h.ServeHTTP(rr, req)
+
+			// then
+			require.Equal(t, testCase.ExpectedCode, rr.Code)
+		})
+	}
+}
+
+func TestGetIdBoltRepository(t *testing.T) {
+	// given
+	dir, err := ioutil.TempDir("", "test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	b, err := data.NewBoltRepository(filepath.Join(dir, "database.bolt"), data.DefaultOptions())
+	require.NoError(t, err)
+	defer b.Close()
+
+	nickData := makeValidNickData(t)
+	require.NoError(t, b.Put(nickData))
+
+	h, err := newHandler(b, config.Default())
+	require.NoError(t, err)
+
+	testCases := []struct {
+		Name         string
+		Nick         string
+		ExpectedCode int
+	}{
+		{
+			Name:         "known",
+			Nick:         nickData.Nick,
+			ExpectedCode: http.StatusOK,
+		},
+		{
+			Name:         "unknown",
+			Nick:         "unknown",
+			ExpectedCode: http.StatusNotFound,
+		},
+		{
+			Name:         "invalid",
+			Nick:         "a",
+			ExpectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", "/ids/"+testCase.Nick, nil)
+			require.NoError(t, err)
+			rr := httptest.NewRecorder()
+
+			// when
+			h.ServeHTTP(rr, req)
+
+			// then
+			require.Equal(t, testCase.ExpectedCode, rr.Code)
+			if testCase.ExpectedCode == http.StatusOK {
+				var result data.NickData
+				require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+				require.Equal(t, nickData.Id, result.Id, "nick should resolve to the node id")
+			}
+		})
+	}
+}
+
+func TestGetDisplay(t *testing.T) {
+	testCases := []struct {
+		Name         string
+		GetReturn    *data.NickData
+		ExpectedBody string
+	}{
+		{
+			Name:         "registered",
+			GetReturn:    makeNickData(),
+			ExpectedBody: `{"id":"00017f80feff","display":"nick","registered":true}`,
+		},
+		{
+			Name:         "unregistered",
+			GetReturn:    nil,
+			ExpectedBody: `{"id":"00017f80feff","display":"00017f80","registered":false}`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			// given
+			repo, h, rr := makeComponents(t)
+			repo.getReturn = testCase.GetReturn
+
+			req, err := http.NewRequest("GET", "/display/00017f80feff", nil)
+			require.NoError(t, err)
+
+			// when
+			h.ServeHTTP(rr, req)
+
+			// then
+			require.Equal(t, 200, rr.Code, "http status should be OK")
+			require.Equal(t, testCase.ExpectedBody, rr.Body.String())
+		})
+	}
+}
+
+func TestGetDisplayInvalidNodeId(t *testing.T) {
+	_, h, rr := makeComponents(t)
+
+	req, err := http.NewRequest("GET", "/display/invalid", nil)
+	require.NoError(t, err)
+
+	h.ServeHTTP(rr, req)
+
+	require.Equal(t, 400, rr.Code, "http status should be Bad Request")
+}
+
+func TestGetProtobuf(t *testing.T) {
+	testCases := []struct {
+		Name   string
+		Accept string
+	}{
+		{"protobuf", "application/protobuf"},
+		{"x_protobuf", "application/x-protobuf"},
+		{"preferred_over_json", "application/protobuf, application/json"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			// given
+			repo, h, rr := makeComponents(t)
+			repo.getReturn = makeNickData()
+
+			req, err := http.NewRequest("GET", "/nicks/6964", nil)
+			require.NoError(t, err)
+			req.Header.Set("Accept", testCase.Accept)
+
+			// when
+			h.ServeHTTP(rr, req)
+
+			// then
+			require.Equal(t, 200, rr.Code, "http status should be OK")
+			require.Equal(t, data.ProtobufContentType, rr.Header().Get("Content-Type"))
+
+			result, err := data.UnmarshalProtobuf(rr.Body.Bytes())
+			require.NoError(t, err)
+			requireSameJson(t, repo.getReturn, result)
+		})
+	}
+}
+
+func TestGetJsonPreferred(t *testing.T) {
+	// given
+	repo, h, rr := makeComponents(t)
+	repo.getReturn = makeNickData()
+
+	req, err := http.NewRequest("GET", "/nicks/6964", nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "application/json, application/protobuf")
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, 200, rr.Code, "http status should be OK")
+	require.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	require.Equal(t, "Accept", rr.Header().Get("Vary"))
+}
+
+func TestListProtobuf(t *testing.T) {
+	// given
+	repo, h, rr := makeComponents(t)
+	repo.listReturn = []data.NickData{*makeNickData()}
+
+	req, err := http.NewRequest("GET", "/nicks", nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "application/protobuf")
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, 200, rr.Code, "http status should be OK")
+	require.Equal(t, data.ProtobufContentType, rr.Header().Get("Content-Type"))
+
+	result, err := data.UnmarshalProtobufList(rr.Body.Bytes())
+	require.NoError(t, err)
+	requireSameJson(t, repo.listReturn, result)
+}
+
+func TestCBORRoundTrip(t *testing.T) {
+	// given
+	h, err := newHandler(data.NewMemoryRepository(data.DefaultOptions()), config.Default())
+	require.NoError(t, err)
+
+	nickData := makeValidNickData(t)
+	body, err := cbor.Marshal(nickData)
+	require.NoError(t, err)
+
+	req, err := http.NewRequest("PUT", "/nicks", bytes.NewBuffer(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/cbor")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusCreated, rr.Code, "http status should be Created")
+
+	req, err = http.NewRequest("GET", "/nicks/"+hex.EncodeToString(nickData.Id), nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "application/cbor")
+	rr = httptest.NewRecorder()
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, 200, rr.Code, "http status should be OK")
+	require.Equal(t, "application/cbor", rr.Header().Get("Content-Type"))
+	require.Equal(t, "Accept", rr.Header().Get("Vary"))
+
+	var result data.NickData
+	require.NoError(t, cbor.Unmarshal(rr.Body.Bytes(), &result))
+	requireSameJson(t, nickData, result)
+}
+
+func TestCBORStale(t *testing.T) {
+	// given
+	conf := config.Default()
+	conf.MaxServedAge = config.Duration(time.Hour)
+
+	repo, h, rr := makeComponentsWithConfig(t, conf)
+	repo.getReturn = makeNickData()
+
+	req, err := http.NewRequest("GET", "/nicks/6964", nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "application/cbor")
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, 200, rr.Code, "http status should be OK")
+
+	var result map[string]interface{}
+	require.NoError(t, cbor.Unmarshal(rr.Body.Bytes(), &result))
+	require.Equal(t, "nick", result["nick"])
+	require.Equal(t, true, result["stale"], "nick data should be marked as stale")
+}
+
+func TestCBORError(t *testing.T) {
+	// given
+	_, h, rr := makeComponents(t)
+
+	req, err := http.NewRequest("PUT", "/nicks", bytes.NewBufferString("not cbor"))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/cbor")
+	req.Header.Set("Accept", "application/cbor")
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, 400, rr.Code, "http status should be Bad Request")
+	require.Equal(t, "application/cbor", rr.Header().Get("Content-Type"))
+
+	var result map[string]interface{}
+	require.NoError(t, cbor.Unmarshal(rr.Body.Bytes(), &result))
+	require.EqualValues(t, 400, result["code"])
+}
+
+func TestETagDependsOnRepresentation(t *testing.T) {
+	for _, path := range []string{"/nicks/6964", "/nicks"} {
+		t.Run(path, func(t *testing.T) {
+			// given
+			repo, h, _ := makeComponents(t)
+			repo.getReturn = makeNickData()
+			repo.listReturn = []data.NickData{*makeNickData()}
+
+			etags := make(map[string]bool)
+			for _, accept := range []string{"application/json", "application/cbor", "application/protobuf"} {
+				req, err := http.NewRequest("GET", path, nil)
+				require.NoError(t, err)
+				req.Header.Set("Accept", accept)
+				rr := httptest.NewRecorder()
+
+				// when
+				h.ServeHTTP(rr, req)
+
+				// then
+				require.Equal(t, 200, rr.Code, "http status should be OK")
+				require.Equal(t, "Accept", rr.Header().Get("Vary"), accept)
+				etag := rr.Header().Get("ETag")
+				require.NotEmpty(t, etag, accept)
+				require.False(t, etags[etag], "ETag of %s should differ from the other encodings", accept)
+				etags[etag] = true
+			}
+		})
+	}
+}
+
+func TestETagNotModifiedVary(t *testing.T) {
+	// given
+	repo, h, _ := makeComponents(t)
+	repo.getReturn = makeNickData()
+
+	req, err := http.NewRequest("GET", "/nicks/6964", nil)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	etag := rr.Header().Get("ETag")
+
+	serve := func(accept string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/nicks/6964", nil)
+		require.NoError(t, err)
+		req.Header.Set("Accept", accept)
+		req.Header.Set("If-None-Match", etag)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// when
+	notModified := serve("application/json")
+	otherEncoding := serve("application/cbor")
+
+	// then
+	require.Equal(t, 304, notModified.Code, "http status should be Not Modified")
+	require.Equal(t, "Accept", notModified.Header().Get("Vary"))
+
+	require.Equal(t, 200, otherEncoding.Code, "ETag of JSON should not match CBOR")
+	require.Equal(t, "application/cbor", otherEncoding.Header().Get("Content-Type"))
+}
+
+// requireSameJson checks that both values have the same JSON representation.
+func requireSameJson(t *testing.T, expected, actual interface{}) {
+	expectedJson, err := json.Marshal(expected)
+	require.NoError(t, err)
+	actualJson, err := json.Marshal(actual)
+	require.NoError(t, err)
+	require.Equal(t, string(expectedJson), string(actualJson))
+}
+
+func TestFavicon(t *testing.T) {
+	_, h, rr := makeComponents(t)
+
+	req, err := http.NewRequest("GET", "/favicon.ico", nil)
+	require.NoError(t, err)
+
+	h.ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusNoContent, rr.Code)
+	require.Empty(t, rr.Body.String())
+}
+
+func TestRobotsTxt(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Config   string
+		Expected string
+	}{
+		{"default", "", "User-agent: *\nDisallow: /\n"},
+		{"custom", "User-agent: *\nAllow: /\n", "User-agent: *\nAllow: /\n"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			conf := config.Default()
+			conf.RobotsTxt = testCase.Config
+			_, h, rr := makeComponentsWithConfig(t, conf)
+
+			req, err := http.NewRequest("GET", "/robots.txt", nil)
+			require.NoError(t, err)
+
+			h.ServeHTTP(rr, req)
+
+			require.Equal(t, 200, rr.Code)
+			require.Equal(t, testCase.Expected, rr.Body.String())
+		})
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	testCases := []struct {
+		Name          string
+		Quiet         bool
+		Path          string
+		ExpectedLevel log15.Lvl
+	}{
+		{"unknown", false, "/some/random/path", log15.LvlInfo},
+		{"scanner", false, "/wp-login.php", log15.LvlInfo},
+		{"quiet_unknown", true, "/some/random/path", log15.LvlInfo},
+		{"quiet_scanner", true, "/wp-login.php", log15.LvlDebug},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			conf := config.Default()
+			conf.QuietScannerNotFound = testCase.Quiet
+			_, h, rr := makeComponentsWithConfig(t, conf)
+
+			var levels []log15.Lvl
+			handler := log.GetHandler()
+			defer log.SetHandler(handler)
+			log.SetHandler(log15.FuncHandler(func(r *log15.Record) error {
+				levels = append(levels, r.Lvl)
+				return nil
+			}))
+
+			req, err := http.NewRequest("GET", testCase.Path, nil)
+			require.NoError(t, err)
+
+			h.ServeHTTP(rr, req)
+
+			require.Equal(t, 404, rr.Code)
+			require.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+			require.Equal(t, `{"code":404,"message":"Not found."}`, rr.Body.String())
+			require.Equal(t, []log15.Lvl{testCase.ExpectedLevel}, levels)
+		})
+	}
+}
+
+func TestDisabledFeature(t *testing.T) {
+	// given
+	conf := config.Default()
+	conf.RequireChallenge = false
+	_, h, rr := makeComponentsWithConfig(t, conf)
+
+	req, err := http.NewRequest("GET", "/challenge", nil)
+	require.NoError(t, err)
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, 501, rr.Code, "http status should be Not Implemented")
+	require.Equal(t, `{"code":501,"message":"Challenges are disabled on this server."}`, rr.Body.String())
+}
+
+func TestGetInvalidNodeIdError(t *testing.T) {
+	// given
+	repo, h, rr := makeComponents(t)
+
+	repo.getErr = data.InvalidNickDataErr
+
+	req, err := http.NewRequest("GET", "/nicks/abcd", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, 400, rr.Code, "http status should be Bad Request")
+}
+
+func TestPut(t *testing.T) {
+	// given
+	_, h, rr := makeComponents(t)
+
+	buf := bytes.NewBuffer(makeJsonNickData(t))
+
+	req, err := http.NewRequest("PUT", "/nicks", buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, 200, rr.Code, "http status should be OK")
+}
+
+func TestPutResult(t *testing.T) {
+	testCases := []struct {
+		Name         string
+		Result       data.PutResult
+		ExpectedCode int
+	}{
+		{
+			Name:         "created",
+			Result:       data.PutCreated,
+			ExpectedCode: http.StatusCreated,
+		},
+		{
+			Name:         "updated",
+			Result:       data.PutUpdated,
+			ExpectedCode: http.StatusOK,
+		},
+		{
+			Name:         "unchanged",
+			Result:       data.PutUnchanged,
+			ExpectedCode: http.StatusOK,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			// given
+			repo, h, _ := makeComponents(t)
+			repo.putResult = testCase.Result
+
+			// when
+			rr := putNickData(t, h, makeNickData())
+
+			// then
+			require.Equal(t, testCase.ExpectedCode, rr.Code)
+			require.Equal(t, string(testCase.Result), rr.Header().Get(putResultHeader))
+		})
+	}
+}
+
+func TestPutResultMemoryRepository(t *testing.T) {
+	// given
+	h, err := newHandler(data.NewMemoryRepository(data.DefaultOptions()), config.Default())
+	require.NoError(t, err)
+	nickData := makeValidNickData(t)
+
+	// when
+	first := putNickData(t, h, nickData)
+	retry := putNickData(t, h, nickData)
+
+	// then
+	require.Equal(t, http.StatusCreated, first.Code)
+	require.Equal(t, string(data.PutCreated), first.Header().Get(putResultHeader))
+
+	require.Equal(t, http.StatusOK, retry.Code)
+	require.Equal(t, string(data.PutUnchanged), retry.Header().Get(putResultHeader))
+}
+
+func TestPutResultUnsupported(t *testing.T) {
+	// given
+	repo := &repositoryMock{}
+	h, err := newHandler(struct{ Repository }{repo}, config.Default())
+	require.NoError(t, err)
+
+	// when
+	rr := putNickData(t, h, makeNickData())
+
+	// then
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, string(data.PutUpdated), rr.Header().Get(putResultHeader))
+	require.NotNil(t, repo.putArgument, "nick data should be stored")
+}
+
+func TestPutMalformedJson(t *testing.T) {
+	// given
+	_, h, rr := makeComponents(t)
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("[]")
+
+	req, err := http.NewRequest("PUT", "/nicks", buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, 400, rr.Code, "http status should be Bad Request")
+}
+
+func TestPutOversizedFields(t *testing.T) {
+	for _, field := range []string{"id", "publicKey"} {
+		// given
+		repo, h, rr := makeComponents(t)
+
+		body := `{"` + field + `": "` + strings.Repeat("ab", 4096) + `"}`
+
+		req, err := http.NewRequest("PUT", "/nicks", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// when
+		h.ServeHTTP(rr, req)
+
+		// then
+		require.Equal(t, 400, rr.Code, "http status should be Bad Request (field: %s)", field)
+		require.Contains(t, rr.Body.String(), "field is too long", "field: %s", field)
+		require.Nil(t, repo.putArgument, "put should not be called (field: %s)", field)
+	}
+}
+
+func TestPutOversizedBody(t *testing.T) {
+	// given
+	repo, h, rr := makeComponents(t)
+
+	body := `{"nick": "` + strings.Repeat("a", maxPutBodySize) + `"}`
+
+	req, err := http.NewRequest("PUT", "/nicks", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, http.StatusRequestEntityTooLarge, rr.Code, "http status should be Request Entity Too Large")
+	require.Nil(t, repo.putArgument, "put should not be called")
+}
+
+func TestPutBodySizeLimit(t *testing.T) {
+	testCases := []struct {
+		Name         string
+		Size         int64
+		ExpectedCode int
+	}{
+		{
+			Name:         "at_limit",
+			Size:         config.Default().MaxBodyBytes,
+			ExpectedCode: http.StatusOK,
+		},
+		{
+			Name:         "over_limit",
+			Size:         config.Default().MaxBodyBytes + 1,
+			ExpectedCode: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			// given
+			_, h, rr := makeComponents(t)
+
+			body := makeJsonNickData(t)
+			body = append(body, bytes.Repeat([]byte(" "), int(testCase.Size)-len(body))...)
+
+			req, err := http.NewRequest("PUT", "/nicks", bytes.NewReader(body))
+			require.NoError(t, err)
+
+			// when
+			h.ServeHTTP(rr, req)
+
+			// then
+			require.Equal(t, testCase.ExpectedCode, rr.Code)
+		})
+	}
+}
+
+func TestPutNoBody(t *testing.T) {
+	// given
+	_, h, rr := makeComponents(t)
+
+	req, err := http.NewRequest("PUT", "/nicks", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, 400, rr.Code, "http status should be Bad Request")
+}
+
+func TestPutClientErr(t *testing.T) {
+	for _, err := range []error{data.InvalidNickDataErr, data.NewerNickDataPresentErr, data.NickConflictErr, data.SameTimeChangeErr, data.NickKeyTooLongErr} {
+		// given
+		repo, h, rr := makeComponents(t)
+
+		buf := bytes.NewBuffer(makeJsonNickData(t))
+
+		repo.putErr = err
+
+		req, err := http.NewRequest("PUT", "/nicks", buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// when
+		h.ServeHTTP(rr, req)
+
+		// then
+		require.Equal(t, 400, rr.Code, "http status should be Bad Request for %s", err)
+	}
+}
+
+func TestPutErr(t *testing.T) {
+	// given
+	repo, h, rr := makeComponents(t)
+
+	buf := bytes.NewBuffer(makeJsonNickData(t))
+
+	repo.putErr = errors.New("some error")
+
+	req, err := http.NewRequest("PUT", "/nicks", buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, 500, rr.Code, "http status should be Internal Server Error")
+}
+
+func TestList(t *testing.T) {
+	// given
+	repo, h, rr := makeComponents(t)
+
+	repo.listReturn = make([]data.NickData, 0)
+
+	req, err := http.NewRequest("GET", "/nicks", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, 200, rr.Code, "http status should be OK")
+	require.Equal(t, "[]", rr.Body.String(), "body should contain an empty json array")
+}
+
+func TestListOneElement(t *testing.T) {
+	// given
+	repo, h, rr := makeComponents(t)
+
+	repo.listReturn = []data.NickData{*makeNickData()}
+
+	req, err := http.NewRequest("GET", "/nicks", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	expectedBody := `[{"id":"6964","nick":"nick","time":"1990-01-01T01:01:01.000000001Z","publicKey":"cHVibGljIGtleQ==","signature":"c2lnbmF0dXJl"}]`
+	require.Equal(t, 200, rr.Code, "http status should be OK")
+	require.Equal(t, expectedBody, rr.Body.String(), "body should contain a json array with one nick data")
+}
+
+func getNicksWithETag(t *testing.T, h http.Handler, etag string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", "/nicks", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestListETag(t *testing.T) {
+	// given
+	repo, h, _ := makeComponents(t)
+
+	repo.listReturn = []data.NickData{*makeNickData()}
+
+	first := getNicksWithETag(t, h, "")
+	require.Equal(t, 200, first.Code, "http status should be OK")
+	etag := first.Header().Get("ETag")
+	require.NotEmpty(t, etag, "etag should be set")
+
+	// when
+	second := getNicksWithETag(t, h, etag)
+
+	// then
+	require.Equal(t, http.StatusNotModified, second.Code, "http status should be Not Modified")
+	require.Equal(t, etag, second.Header().Get("ETag"), "etag should be repeated")
+	require.Empty(t, second.Body.String(), "body should be empty")
+}
+
+func TestListETagInvalidatedByWrite(t *testing.T) {
+	// given
+	repo, h, _ := makeComponents(t)
+
+	repo.listReturn = []data.NickData{*makeNickData()}
+
+	first := getNicksWithETag(t, h, "")
+	require.Equal(t, 200, first.Code, "http status should be OK")
+	etag := first.Header().Get("ETag")
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("PUT", "/nicks", bytes.NewBuffer(makeJsonNickData(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.ServeHTTP(rr, req)
+	require.Equal(t, 200, rr.Code, "put should succeed")
+
+	// when
+	second := getNicksWithETag(t, h, etag)
+
+	// then
+	require.Equal(t, 200, second.Code, "http status should be OK")
+	require.NotEqual(t, etag, second.Header().Get("ETag"), "etag should change")
+	require.NotEmpty(t, second.Body.String(), "body should contain the list")
+}
+
+func TestListResponses(t *testing.T) {
+	testCases := []struct {
+		Name         string
+		Path         string
+		DisableList  bool
+		ExpectedCode int
+		ExpectedBody string
+	}{
+		{
+			Name:         "empty repository",
+			Path:         "/nicks",
+			ExpectedCode: 200,
+			ExpectedBody: `[]`,
+		},
+		{
+			Name:         "empty page",
+			Path:         "/nicks?limit=10",
+			ExpectedCode: 200,
+			ExpectedBody: `{"items":[],"next":null}`,
+		},
+		{
+			Name:         "list disabled",
+			Path:         "/nicks",
+			DisableList:  true,
+			ExpectedCode: 403,
+			ExpectedBody: `{"code":403,"message":"Listing the nicks is disabled on this server."}`,
+		},
+		{
+			Name:         "page with list disabled",
+			Path:         "/nicks?limit=10",
+			DisableList:  true,
+			ExpectedCode: 403,
+			ExpectedBody: `{"code":403,"message":"Listing the nicks is disabled on this server."}`,
+		},
+		{
+			Name:         "empty index",
+			Path:         "/index",
+			ExpectedCode: 200,
+			ExpectedBody: ``,
+		},
+		{
+			Name:         "index with list disabled",
+			Path:         "/index",
+			DisableList:  true,
+			ExpectedCode: 403,
+			ExpectedBody: `{"code":403,"message":"Listing the nicks is disabled on this server."}`,
+		},
+		{
+			Name:         "invalid limit",
+			Path:         "/nicks?limit=0",
+			ExpectedCode: 400,
+			ExpectedBody: `{"code":400,"message":"Invalid limit."}`,
+		},
+		{
+			Name:         "invalid cursor",
+			Path:         "/nicks?after=xyz",
+			ExpectedCode: 400,
+			ExpectedBody: `{"code":400,"message":"Invalid cursor."}`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			// given
+			conf := config.Default()
+			conf.DisableList = testCase.DisableList
+			_, h, rr := makeComponentsWithConfig(t, conf)
+
+			req, err := http.NewRequest("GET", testCase.Path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// when
+			h.ServeHTTP(rr, req)
+
+			// then
+			require.Equal(t, testCase.ExpectedCode, rr.Code)
+			require.Equal(t, testCase.ExpectedBody, rr.Body.String())
+		})
+	}
+}
+
+func TestUnknownQueryParams(t *testing.T) {
+	testCases := []struct {
+		Name         string
+		Strict       bool
+		Path         string
+		ExpectedCode int
+		ExpectedBody string
+	}{
+		{
+			Name:         "lenient",
+			Strict:       false,
+			Path:         "/nicks?bogus=1",
+			ExpectedCode: 200,
+		},
+		{
+			Name:         "strict",
+			Strict:       true,
+			Path:         "/nicks?bogus=1",
+			ExpectedCode: 400,
+			ExpectedBody: `{"code":400,"message":"Unknown query parameter: bogus."}`,
+		},
+		{
+			Name:         "strict_known",
+			Strict:       true,
+			Path:         "/nicks?limit=10",
+			ExpectedCode: 200,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			conf := config.Default()
+			conf.StrictQueryParams = testCase.Strict
+			repo, h, rr := makeComponentsWithConfig(t, conf)
+			repo.listReturn = make([]data.NickData, 0)
+
+			req, err := http.NewRequest("GET", testCase.Path, nil)
+			require.NoError(t, err)
+
+			h.ServeHTTP(rr, req)
+
+			require.Equal(t, testCase.ExpectedCode, rr.Code)
+			if testCase.ExpectedBody != "" {
+				require.Equal(t, testCase.ExpectedBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestListTimeRange(t *testing.T) {
+	// given
+	repo, h, rr := makeComponents(t)
+
+	repo.listTimeRangeReturn = []data.NickData{*makeNickData()}
+
+	req, err := http.NewRequest("GET", "/nicks?from=100&to=200", nil)
+	require.NoError(t, err)
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, 200, rr.Code, "http status should be OK")
+	require.Equal(t, int64(100), repo.listTimeRangeArgumentFrom.Unix())
+	require.Equal(t, int64(200), repo.listTimeRangeArgumentTo.Unix())
+	require.Equal(t, `[{"id":"6964","nick":"nick","time":"1990-01-01T01:01:01.000000001Z","publicKey":"cHVibGljIGtleQ==","signature":"c2lnbmF0dXJl"}]`, rr.Body.String())
+}
+
+func TestListTimeRangeInvalid(t *testing.T) {
+	for _, query := range []string{
+		"from=100",
+		"to=100",
+		"from=a&to=100",
+		"from=100&to=b",
+		"from=200&to=100",
+		"from=100&to=200&limit=10",
+	} {
+		t.Run(query, func(t *testing.T) {
+			_, h, rr := makeComponents(t)
+
+			req, err := http.NewRequest("GET", "/nicks?"+query, nil)
+			require.NoError(t, err)
+
+			h.ServeHTTP(rr, req)
+
+			require.Equal(t, 400, rr.Code, "http status should be Bad Request")
+		})
+	}
+}
+
+func TestListPage(t *testing.T) {
+	// given
+	repo, h, rr := makeComponents(t)
+
+	repo.listAfterReturn = []data.NickData{*makeNickData()}
+	repo.listAfterReturnNext = node.ID{0x12, 0x34}
+	repo.countReturn = 5
+
+	req, err := http.NewRequest("GET", "/nicks?limit=1&after=abcd", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, 200, rr.Code, "http status should be OK")
+	require.Equal(t, node.ID{0xab, 0xcd}, repo.listAfterArgumentAfter)
+	require.Equal(t, 1, repo.listAfterArgumentLimit)
+
+	var p struct {
+		Items []data.NickData
+		Next  *string
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &p))
+	require.Len(t, p.Items, 1)
+	require.NotNil(t, p.Next)
+	require.Equal(t, "1234", *p.Next)
+	require.Equal(t, "5", rr.Header().Get("X-Total-Count"))
+}
+
+func TestResolve(t *testing.T) {
+	// given
+	repo, h, rr := makeComponents(t)
+
+	repo.resolveNicksReturn = map[string]node.ID{
+		"alice": {0xab, 0xcd},
+		"bob":   {0x12, 0x34},
+	}
+
+	req, err := http.NewRequest("POST", "/resolve", strings.NewReader(`["alice", "bob", "carol"]`))
+	require.NoError(t, err)
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, 200, rr.Code, "http status should be OK")
+	require.Equal(t, []string{"alice", "bob", "carol"}, repo.resolveNicksArgument)
+	require.JSONEq(t, `{"alice":"abcd","bob":"1234"}`, rr.Body.String())
+}
+
+func TestSearchNicks(t *testing.T) {
+	// given
+	repo, h, rr := makeComponents(t)
+	repo.searchReturn = []data.NickData{*makeNickData()}
+
+	req, err := http.NewRequest("GET", "/nicks/search?prefix=ni&limit=5", nil)
+	require.NoError(t, err)
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, http.StatusOK, rr.Code, "http status should be OK")
+	require.Equal(t, "ni", repo.searchArgumentPrefix)
+	require.Equal(t, 5, repo.searchArgumentLimit)
+
+	var nickDatas []data.NickData
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &nickDatas))
+	requireSameJson(t, repo.searchReturn, nickDatas)
+}
+
+func TestSearchNicksNoMatches(t *testing.T) {
+	// given
+	_, h, rr := makeComponents(t)
+
+	req, err := http.NewRequest("GET", "/nicks/search?prefix=missing", nil)
+	require.NoError(t, err)
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, http.StatusOK, rr.Code, "http status should be OK")
+	require.JSONEq(t, `[]`, rr.Body.String())
+}
+
+func TestSearchNicksLimit(t *testing.T) {
+	testCases := []struct {
+		Name          string
+		Query         string
+		ExpectedLimit int
+	}{
+		{"default", "", defaultSearchLimit},
+		{"lower", "&limit=1", 1},
+		{"capped", "&limit=1000", maxSearchLimit},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			// given
+			repo, h, rr := makeComponents(t)
+
+			req, err := http.NewRequest("GET", "/nicks/search?prefix=ni"+testCase.Query, nil)
+			require.NoError(t, err)
+
+			// when
+			h.ServeHTTP(rr, req)
+
+			// then
+			require.Equal(t, http.StatusOK, rr.Code, "http status should be OK")
+			require.Equal(t, testCase.ExpectedLimit, repo.searchArgumentLimit)
+		})
+	}
+}
+
+func TestSearchNicksInvalid(t *testing.T) {
+	testCases := []struct {
+		Name  string
+		Query string
+	}{
+		{"missing_prefix", ""},
+		{"empty_prefix", "prefix="},
+		{"separator", "prefix=a-b"},
+		{"unicode", "prefix=%C5%BC"},
+		{"too_long", "prefix=" + strings.Repeat("a", 21)},
+		{"zero_limit", "prefix=ni&limit=0"},
+		{"malformed_limit", "prefix=ni&limit=x"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			// given
+			repo, h, rr := makeComponents(t)
+
+			req, err := http.NewRequest("GET", "/nicks/search?"+testCase.Query, nil)
+			require.NoError(t, err)
+
+			// when
+			h.ServeHTTP(rr, req)
+
+			// then
+			require.Equal(t, http.StatusBadRequest, rr.Code, "http status should be Bad Request")
+			require.Empty(t, repo.searchArgumentPrefix, "repository should not be searched")
+		})
+	}
+}
+
+func TestSearchNicksListDisabled(t *testing.T) {
+	// given
+	conf := config.Default()
+	conf.DisableList = true
+	_, h, rr := makeComponentsWithConfig(t, conf)
+
+	req, err := http.NewRequest("GET", "/nicks/search?prefix=ni", nil)
+	require.NoError(t, err)
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, http.StatusForbidden, rr.Code, "http status should be Forbidden")
+}
+
+func TestSearchNicksNotSupported(t *testing.T) {
+	// given
+	h, err := newHandler(struct{ Repository }{&repositoryMock{}}, config.Default())
+	require.NoError(t, err)
+
+	req, err := http.NewRequest("GET", "/nicks/search?prefix=ni", nil)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, http.StatusNotImplemented, rr.Code, "http status should be Not Implemented")
+}
+
+func TestResolveInvalid(t *testing.T) {
+	testCases := []struct {
+		Name            string
+		Body            string
+		ExpectedMessage string
+	}{
+		{"malformed", `{"nicks":[]}`, "Bad request."},
+		{"invalid_nick", `["alice", "invalid nick"]`, `Invalid nick: "invalid nick".`},
+		{"too_many", `["a", "b", "c"]`, "Too many elements, the limit is 2."},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			// given
+			conf := config.Default()
+			conf.MaxBatchSize = 2
+			repo, h, rr := makeComponentsWithConfig(t, conf)
+
+			req, err := http.NewRequest("POST", "/resolve", strings.NewReader(testCase.Body))
+			require.NoError(t, err)
+
+			// when
+			h.ServeHTTP(rr, req)
+
+			// then
+			require.Equal(t, 400, rr.Code, "http status should be Bad Request")
+			require.Equal(t, testCase.ExpectedMessage, getErrorMessage(t, rr))
+			require.Nil(t, repo.resolveNicksArgument, "repository should not be called")
+		})
+	}
+}
+
+func getErrorMessage(t *testing.T, rr *httptest.ResponseRecorder) string {
+	var response struct {
+		Message string `json:"message"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	return response.Message
+}
+
+func TestCapabilities(t *testing.T) {
+	// given
+	conf := config.Default()
+	conf.StrictNickSeparators = true
+
+	_, h, rr := makeComponentsWithConfig(t, conf)
+
+	req, err := http.NewRequest("GET", "/capabilities", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	expectedBody := `{"supportedKeyTypes":["rsa"],"listEnabled":true,"challengeRequired":false,"signingHash":"SHA-512","nickPolicy":{"strictSeparators":true},"maxBatchSize":100}`
+	require.Equal(t, 200, rr.Code, "http status should be OK")
+	require.Equal(t, expectedBody, rr.Body.String(), "body should reflect the config")
+}
+
+func TestJsonSuffix(t *testing.T) {
+	for _, path := range []string{"/nicks", "/nicks/abcd"} {
+		// given
+		repo, h, rr := makeComponents(t)
+		repo.listReturn = []data.NickData{*makeNickData()}
+		repo.getReturn = makeNickData()
+
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		suffixRr := httptest.NewRecorder()
+		suffixReq, err := http.NewRequest("GET", path+".json", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// when
+		h.ServeHTTP(rr, req)
+		h.ServeHTTP(suffixRr, suffixReq)
+
+		// then
+		require.Equal(t, 200, rr.Code, "http status should be OK for %s", path)
+		require.Equal(t, 200, suffixRr.Code, "http status should be OK for %s.json", path)
+		require.Equal(t, rr.Body.String(), suffixRr.Body.String(), "body should be the same for %s and %s.json", path, path)
+		require.Equal(t, "application/json", suffixRr.Header().Get("Content-Type"))
+	}
+}
+
+func TestPutFollower(t *testing.T) {
+	// given
+	conf := config.Default()
+	conf.Mode = config.ModeFollower
+
+	repo, h, rr := makeComponentsWithConfig(t, conf)
+
+	buf := bytes.NewBuffer(makeJsonNickData(t))
+
+	req, err := http.NewRequest("PUT", "/nicks", buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, 503, rr.Code, "http status should be Service Unavailable")
+	require.Nil(t, repo.putArgument, "put should not be called")
+}
+
+func TestGetFollower(t *testing.T) {
+	// given
+	conf := config.Default()
+	conf.Mode = config.ModeFollower
+
+	repo, h, rr := makeComponentsWithConfig(t, conf)
+	repo.getReturn = makeNickData()
+
+	req, err := http.NewRequest("GET", "/nicks/abcd", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, 200, rr.Code, "http status should be OK")
+}
+
+func TestPutReadOnlyRepository(t *testing.T) {
+	// given
+	repo, h, rr := makeComponents(t)
+	repo.putErr = data.ReadOnlyErr
+
+	buf := bytes.NewBuffer(makeJsonNickData(t))
+
+	req, err := http.NewRequest("PUT", "/nicks", buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, 503, rr.Code, "http status should be Service Unavailable")
+}
+
+func TestPutTransientRepositoryErr(t *testing.T) {
+	// given
+	dir, err := ioutil.TempDir("", "test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	b, err := data.NewBoltRepository(filepath.Join(dir, "database.bolt"), data.DefaultOptions())
+	require.NoError(t, err)
+	defer b.Close()
+
+	repo := data.NewFaultInjectingRepository(b)
+	repo.FailNthCall(data.MethodPut, 1, errors.New("transient error"))
+
+	h, err := newHandler(repo, config.Default())
+	require.NoError(t, err)
+
+	body, err := json.Marshal(makeValidNickData(t))
+	require.NoError(t, err)
+
+	put := func() int {
+		req, err := http.NewRequest("PUT", "/nicks", bytes.NewBuffer(body))
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// when
+	firstCode := put()
+	secondCode := put()
+
+	// then
+	require.Equal(t, 500, firstCode, "transient error should be reported as Internal Server Error")
+	require.Equal(t, 200, secondCode, "retried put should succeed")
+	require.Equal(t, 2, repo.Calls(data.MethodPut))
+}
+
+func TestGetBundle(t *testing.T) {
+	// given
+	repo, h, rr := makeComponents(t)
+
+	repo.getReturn = makeValidNickData(t)
+
+	req, err := http.NewRequest("GET", "/nicks/abcd/bundle", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, 200, rr.Code, "http status should be OK")
+
+	var b bundle
+	err = json.Unmarshal(rr.Body.Bytes(), &b)
+	require.NoError(t, err, "body should contain a bundle")
+
+	require.Equal(t, b.NickData.GetDataToSign(), b.SignedData, "signed data should match the nick data")
+	require.Equal(t, "SHA-512", b.SigningHash)
+
+	publicKey, err := scrypto.NewPublicKey(b.NickData.PublicKey)
+	require.NoError(t, err, "bundle should contain a public key")
+	require.NoError(t, publicKey.Validate(b.SignedData, b.NickData.Signature, data.SigningHash), "bundle should self-verify")
+}
+
+func TestGetBundleNonexistent(t *testing.T) {
+	// given
+	_, h, rr := makeComponents(t)
+
+	req, err := http.NewRequest("GET", "/nicks/abcd/bundle", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, 404, rr.Code, "http status should be Not Found")
+}
+
+func TestGetNickHistory(t *testing.T) {
+	// given
+	repo, h, rr := makeComponents(t)
+
+	repo.nickHistoryReturn = []string{"third", "second", "first"}
+
+	req, err := http.NewRequest("GET", "/nicks/abcd/nicks", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, 200, rr.Code, "http status should be OK")
+	require.JSONEq(t, `["third", "second", "first"]`, rr.Body.String())
+}
+
+func TestGetNickHistoryPaginated(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		t.Run(fmt.Sprintf("strict_%t", strict), func(t *testing.T) {
+			// given
+			conf := config.Default()
+			conf.StrictQueryParams = strict
+
+			dir, err := ioutil.TempDir("", "test")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			b, err := data.NewBoltRepository(filepath.Join(dir, "database.bolt"), data.DefaultOptions())
+			require.NoError(t, err)
+			defer b.Close()
+
+			iden := makeIdentity(t)
+			nickData := makeValidNickData(t)
+			for i, nick := range []string{"first", "second", "third"} {
+				nickData.Nick = nick
+				nickData.Time = nickData.Time.Add(time.Duration(i) * time.Minute)
+				nickData.Signature, err = iden.PrivKey.Sign(nickData.GetDataToSign(), data.SigningHash)
+				require.NoError(t, err)
+				require.NoError(t, b.Put(nickData))
+			}
+
+			h, err := newHandler(b, conf)
+			require.NoError(t, err)
+
+			path := "/nicks/" + hex.EncodeToString(iden.Id) + "/nicks?limit=2"
+
+			// when
+			rr := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", path, nil)
+			require.NoError(t, err)
+			h.ServeHTTP(rr, req)
+
+			// then
+			require.Equal(t, http.StatusOK, rr.Code, "http status should be OK")
+
+			var first struct {
+				Items []string `json:"items"`
+				Next  *string  `json:"next"`
+			}
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &first))
+			require.Equal(t, []string{"third", "second"}, first.Items)
+			require.NotNil(t, first.Next)
+
+			// when
+			rr = httptest.NewRecorder()
+			req, err = http.NewRequest("GET", path+"&before="+*first.Next, nil)
+			require.NoError(t, err)
+			h.ServeHTTP(rr, req)
+
+			// then
+			require.Equal(t, http.StatusOK, rr.Code, "http status should be OK")
+			require.JSONEq(t, `{"items": ["first"], "next": null}`, rr.Body.String())
+		})
+	}
+}
+
+func TestDualWriteRepositoryRoutes(t *testing.T) {
+	// given
+	dir, err := ioutil.TempDir("", "test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := config.Default()
+	conf.MaxStoredVersions = 10
+
+	nickData := makeValidNickData(t)
+	id := hex.EncodeToString(nickData.Id)
+	body, err := json.Marshal(nickData)
+	require.NoError(t, err)
+
+	makeHandler := func(name string, secondary bool) http.Handler {
+		b, err := data.NewBoltRepository(filepath.Join(dir, name+".bolt"), data.DefaultOptions())
+		require.NoError(t, err)
+		t.Cleanup(func() { b.Close() })
+		require.NoError(t, b.Put(nickData))
+
+		var repository Repository = b
+		if secondary {
+			repository = data.NewDualWriteRepository(b, data.NewMemoryRepository(data.DefaultOptions()))
+		}
+		h, err := newHandler(repository, conf)
+		require.NoError(t, err)
+		return h
+	}
+
+	single := makeHandler("single", false)
+	dual := makeHandler("dual", true)
+
+	requests := []struct {
+		Method string
+		Path   string
+		Body   string
+	}{
+		{"GET", "/nicks", ""},
+		{"GET", "/nicks?limit=1", ""},
+		{"GET", "/nicks?from=0&to=4000000000", ""},
+		{"GET", "/nicks/search?prefix=nic", ""},
+		{"GET", "/nicks/" + id + "/nicks", ""},
+		{"GET", "/nicks/" + id + "/nicks?limit=1", ""},
+		{"GET", "/nicks/" + id + "/versions", ""},
+		{"GET", "/index", ""},
+		{"POST", "/resolve", `["nick"]`},
+		{"PUT", "/nicks", string(body)},
+	}
+
+	for _, request := range requests {
+		t.Run(request.Method+" "+request.Path, func(t *testing.T) {
+			serve := func(h http.Handler) *httptest.ResponseRecorder {
+				req, err := http.NewRequest(request.Method, request.Path, strings.NewReader(request.Body))
+				require.NoError(t, err)
+				rr := httptest.NewRecorder()
+				h.ServeHTTP(rr, req)
+				return rr
+			}
+
+			// when
+			expected := serve(single)
+			actual := serve(dual)
+
+			// then
+			require.NotEqual(t, http.StatusNotImplemented, expected.Code)
+			require.Equal(t, expected.Code, actual.Code, "status should not depend on the secondary repository")
+			require.Equal(t, expected.Header().Get("ETag") != "", actual.Header().Get("ETag") != "", "ETag should not depend on the secondary repository")
+			require.Equal(t, expected.Header().Get(putResultHeader), actual.Header().Get(putResultHeader))
+		})
+	}
+}
+
+func TestGetNickHistoryPaginationUnsupported(t *testing.T) {
+	// given
+	_, h, rr := makeComponents(t)
+
+	req, err := http.NewRequest("GET", "/nicks/abcd/nicks?limit=2", nil)
+	require.NoError(t, err)
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, http.StatusNotImplemented, rr.Code, "http status should be Not Implemented")
+}
+
+func TestGetNickVersions(t *testing.T) {
+	// given
+	conf := config.Default()
+	conf.MaxStoredVersions = 10
+	repo, h, rr := makeComponentsWithConfig(t, conf)
+
+	nickData := makeValidNickData(t)
+	repo.versionsReturn = []data.NickData{*nickData}
+
+	req, err := http.NewRequest("GET", "/nicks/abcd/versions", nil)
+	require.NoError(t, err)
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, http.StatusOK, rr.Code, "http status should be OK")
+
+	var versions []data.NickData
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &versions))
+	require.Equal(t, 1, len(versions))
+	require.NoError(t, versions[0].Validate(), "returned versions should be verifiable")
+}
+
+func TestGetNickVersionsDisabled(t *testing.T) {
+	// given
+	_, h, rr := makeComponents(t)
+
+	req, err := http.NewRequest("GET", "/nicks/abcd/versions", nil)
+	require.NoError(t, err)
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, http.StatusNotImplemented, rr.Code, "http status should be Not Implemented")
+}
+
+func TestGetNickHistoryUnknown(t *testing.T) {
+	// given
+	repo, h, rr := makeComponents(t)
+
+	repo.nickHistoryReturn = []string{}
+
+	req, err := http.NewRequest("GET", "/nicks/abcd/nicks", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, 200, rr.Code, "http status should be OK")
+	require.JSONEq(t, `[]`, rr.Body.String())
+}
+
+func TestPutAsync(t *testing.T) {
+	// given
+	repo, h, rr := makeComponents(t)
+
+	nickData := makeValidNickData(t)
+	body, err := json.Marshal(nickData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("PUT", "/nicks?async=true", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, 202, rr.Code, "http status should be Accepted")
+
+	require.Eventually(t, func() bool {
+		repo.putLock.Lock()
+		defer repo.putLock.Unlock()
+		return repo.putArgument != nil
+	}, time.Second, time.Millisecond, "write should be stored in the background")
+}
+
+func TestPutAsyncInvalid(t *testing.T) {
+	// given
+	repo, h, rr := makeComponents(t)
+
+	req, err := http.NewRequest("PUT", "/nicks?async=true", bytes.NewBuffer(makeJsonNickData(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, 400, rr.Code, "http status should be Bad Request")
+
+	repo.putLock.Lock()
+	defer repo.putLock.Unlock()
+	require.Nil(t, repo.putArgument, "invalid write should not be queued")
+}
+
+func TestPutAsyncDisabled(t *testing.T) {
+	// given
+	conf := config.Default()
+	conf.AsyncWriteQueueSize = 0
+	_, h, rr := makeComponentsWithConfig(t, conf)
+
+	req, err := http.NewRequest("PUT", "/nicks?async=true", bytes.NewBuffer(makeJsonNickData(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, 501, rr.Code, "http status should be Not Implemented")
+}
+
+func TestPutInFlightLimit(t *testing.T) {
+	// given
+	conf := config.Default()
+	conf.MaxConcurrentPuts = 2
+
+	repo, h, _ := makeComponentsWithConfig(t, conf)
+	repo.putBlock = make(chan struct{})
+	repo.putStarted = make(chan struct{})
+
+	results := make(chan *httptest.ResponseRecorder, conf.MaxConcurrentPuts)
+	for i := 0; i < conf.MaxConcurrentPuts; i++ {
+		go func() {
+			req, err := http.NewRequest("PUT", "/nicks", bytes.NewBuffer(makeJsonNickData(t)))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			results <- rr
+		}()
+	}
+	for i := 0; i < conf.MaxConcurrentPuts; i++ {
+		<-repo.putStarted
+	}
+
+	// when
+	putReq, err := http.NewRequest("PUT", "/nicks", bytes.NewBuffer(makeJsonNickData(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	putRR := httptest.NewRecorder()
+	h.ServeHTTP(putRR, putReq)
+
+	getReq, err := http.NewRequest("GET", "/nicks", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	getRR := httptest.NewRecorder()
+	h.ServeHTTP(getRR, getReq)
+
+	// then
+	require.Equal(t, 503, putRR.Code, "http status should be Service Unavailable")
+	require.Equal(t, "1", putRR.Header().Get("Retry-After"), "Retry-After should be set")
+	require.Equal(t, 200, getRR.Code, "GET requests should not be affected")
+
+	close(repo.putBlock)
+	for i := 0; i < conf.MaxConcurrentPuts; i++ {
+		rr := <-results
+		require.Equal(t, 200, rr.Code, "http status should be OK")
+	}
+}
+
+func TestGetHealth(t *testing.T) {
+	testCases := []struct {
+		Name         string
+		PingErr      error
+		ExpectedCode int
+		ExpectedBody string
+	}{
+		{
+			Name:         "healthy",
+			PingErr:      nil,
+			ExpectedCode: http.StatusOK,
+			ExpectedBody: `{"healthy": true}`,
+		},
+		{
+			Name:         "unhealthy",
+			PingErr:      errors.New("database not open"),
+			ExpectedCode: http.StatusServiceUnavailable,
+			ExpectedBody: `{"healthy": false}`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			// given
+			repo, h, rr := makeComponents(t)
+			repo.pingErr = testCase.PingErr
+
+			req, err := http.NewRequest("GET", "/health", nil)
+			require.NoError(t, err)
+
+			// when
+			h.ServeHTTP(rr, req)
+
+			// then
+			require.Equal(t, testCase.ExpectedCode, rr.Code)
+			require.JSONEq(t, testCase.ExpectedBody, rr.Body.String())
+		})
+	}
+}
+
+func TestGetLoad(t *testing.T) {
+	// given
+	conf := config.Default()
+	conf.MaxConcurrentPuts = 2
+	conf.MaxConcurrentVerifications = 1
+	conf.MaxQueuedVerifications = 5
+	conf.CircuitBreakerThreshold = 1
+
+	repo, h, _ := makeComponentsWithConfig(t, conf)
+	repo.putBlock = make(chan struct{})
+	repo.putStarted = make(chan struct{})
+	repo.getErr = errors.New("repository failed")
+
+	results := make(chan *httptest.ResponseRecorder, conf.MaxConcurrentPuts)
+	for i := 0; i < conf.MaxConcurrentPuts; i++ {
+		go func() {
+			req, err := http.NewRequest("PUT", "/nicks", bytes.NewBuffer(makeJsonNickData(t)))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			results <- rr
+		}()
+	}
+	<-repo.putStarted
+
+	// the second write waits for the verification
+	require.Eventually(t, func() bool {
+		req, err := http.NewRequest("GET", "/load", nil)
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return strings.Contains(rr.Body.String(), `"verificationsQueued":1`)
+	}, time.Second, time.Millisecond)
+
+	getReq, err := http.NewRequest("GET", "/nicks/abcd", nil)
+	require.NoError(t, err)
+	h.ServeHTTP(httptest.NewRecorder(), getReq)
+
+	req, err := http.NewRequest("GET", "/load", nil)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, http.StatusOK, rr.Code, "http status should be OK")
+	require.JSONEq(t, `{
+		"inFlight": 2,
+		"inFlightPuts": 2,
+		"maxInFlightPuts": 2,
+		"verificationsRunning": 1,
+		"verificationsQueued": 1,
+		"maxRunningVerifications": 1,
+		"maxQueuedVerifications": 5,
+		"circuitBreaker": "open",
+		"readOnly": false,
+		"ready": true
+	}`, rr.Body.String())
+
+	close(repo.putBlock)
+	go func() { <-repo.putStarted }()
+	for i := 0; i < conf.MaxConcurrentPuts; i++ {
+		<-results
+	}
+}
+
+func TestPutVerificationQueueFull(t *testing.T) {
+	// given
+	conf := config.Default()
+	conf.MaxConcurrentVerifications = 1
+	conf.MaxQueuedVerifications = 1
+
+	repo, h, _ := makeComponentsWithConfig(t, conf)
+	repo.putBlock = make(chan struct{})
+
+	const n = 10
+	results := make(chan *httptest.ResponseRecorder, n)
+
+	// when
+	for i := 0; i < n; i++ {
+		go func() {
+			req, err := http.NewRequest("PUT", "/nicks", bytes.NewBuffer(makeJsonNickData(t)))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			results <- rr
+		}()
+	}
+
+	// then
+	for i := 0; i < n-2; i++ {
+		rr := <-results
+		require.Equal(t, 503, rr.Code, "http status should be Service Unavailable")
+		require.Equal(t, "1", rr.Header().Get("Retry-After"), "Retry-After should be set")
+	}
+
+	close(repo.putBlock)
+
+	for i := 0; i < 2; i++ {
+		rr := <-results
+		require.Equal(t, 200, rr.Code, "http status should be OK")
+	}
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.ServeHTTP(rr, req)
+
+	require.Equal(t, 200, rr.Code, "http status should be OK")
+	require.Contains(t, rr.Body.String(), "starlight_nick_server_verification_rejected_total 8")
+	require.Contains(t, rr.Body.String(), "starlight_nick_server_verification_queue_depth 0")
+}
+
+func TestSLOMetrics(t *testing.T) {
+	// given
+	conf := config.Default()
+	conf.LatencyBudget = config.Duration(time.Hour)
+	conf.RouteLatencyBudgets = map[string]config.Duration{
+		"PUT /nicks": config.Duration(time.Millisecond),
+	}
+	repo, h, _ := makeComponentsWithConfig(t, conf)
+	repo.putBlock = make(chan struct{})
+
+	go func() {
+		<-time.After(20 * time.Millisecond)
+		close(repo.putBlock)
+	}()
+
+	// when
+	for _, request := range []struct {
+		Method string
+		Path   string
+		Body   []byte
+	}{
+		{"GET", "/capabilities", nil},
+		{"GET", "/capabilities", nil},
+		{"PUT", "/nicks", makeJsonNickData(t)},
+	} {
+		req, err := http.NewRequest(request.Method, request.Path, bytes.NewBuffer(request.Body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// then
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.ServeHTTP(rr, req)
+
+	require.Equal(t, 200, rr.Code, "http status should be OK")
+	require.Contains(t, rr.Body.String(), `starlight_nick_server_http_requests_slo_total{route="GET /capabilities",within_slo="true"} 2`)
+	require.Contains(t, rr.Body.String(), `starlight_nick_server_http_requests_slo_total{route="PUT /nicks",within_slo="false"} 1`)
+	require.NotContains(t, rr.Body.String(), `route="GET /capabilities",within_slo="false"`)
+}
+
+func TestGetIndex(t *testing.T) {
+	// given
+	repo, h, rr := makeComponents(t)
+
+	repo.indexReturn = []data.IndexEntry{
+		{Nick: "first", Id: node.ID{0xab, 0xcd}},
+		{Nick: "second", Id: node.ID{0x12, 0x34}},
+	}
+
+	req, err := http.NewRequest("GET", "/index", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, 200, rr.Code, "http status should be OK")
+	require.Equal(t, "application/x-ndjson", rr.Header().Get("Content-Type"))
+	require.Equal(t, "{\"nick\":\"first\",\"id\":\"abcd\"}\n{\"nick\":\"second\",\"id\":\"1234\"}\n", rr.Body.String())
+}
+
+func TestGetIndexEmpty(t *testing.T) {
+	// given
+	_, h, rr := makeComponents(t)
+
+	req, err := http.NewRequest("GET", "/index", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, 200, rr.Code, "http status should be OK")
+	require.Empty(t, rr.Body.String())
+}
+
+func TestGetIndexError(t *testing.T) {
+	// given
+	repo, h, rr := makeComponents(t)
+
+	repo.indexErr = errors.New("error")
+
+	req, err := http.NewRequest("GET", "/index", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, 500, rr.Code, "http status should be Internal Server Error")
+}
+
+func makeH2CClient() *http.Client {
+	return &http.Client{
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			},
+		},
+	}
+}
+
+func TestH2C(t *testing.T) {
+	// given
+	conf := config.Default()
+	conf.EnableH2C = true
+
+	registry := prometheus.NewRegistry()
+	h, err := newPublicHandler(&repositoryMock{}, conf, makeWriteGuards(t, conf, newReadiness(true), registry), nil, registry)
+	require.NoError(t, err)
+
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	// when
+	resp, err := makeH2CClient().Get(s.URL + "/capabilities")
+
+	// then
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, 200, resp.StatusCode, "http status should be OK")
+	require.Equal(t, 2, resp.ProtoMajor, "request should be served over HTTP/2")
+}
+
+func TestH2CDisabled(t *testing.T) {
+	// given
+	registry := prometheus.NewRegistry()
+	h, err := newPublicHandler(&repositoryMock{}, config.Default(), makeWriteGuards(t, config.Default(), newReadiness(true), registry), nil, registry)
+	require.NoError(t, err)
+
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	// when
+	_, err = makeH2CClient().Get(s.URL + "/capabilities")
+
+	// then
+	require.Error(t, err, "HTTP/2 should not be served by default")
+
+	resp, err := http.Get(s.URL + "/capabilities")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, 1, resp.ProtoMajor, "HTTP/1.1 should be served by default")
+}
+
+func makeChallengeConfig() *config.Config {
+	conf := config.Default()
+	conf.RequireChallenge = true
+	conf.ChallengeSecret = "secret"
+	return conf
+}
+
+func putNickData(t *testing.T, h http.Handler, nickData *data.NickData) *httptest.ResponseRecorder {
+	body, err := json.Marshal(nickData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("PUT", "/nicks", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestPutChallenge(t *testing.T) {
+	// given
+	repo, h, rr := makeComponentsWithConfig(t, makeChallengeConfig())
+
+	req, err := http.NewRequest("GET", "/challenge", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.ServeHTTP(rr, req)
+	require.Equal(t, 200, rr.Code, "http status should be OK")
+
+	var response challengeResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	require.Equal(t, data.VersionChallenge, response.Version)
+
+	// when
+	rr = putNickData(t, h, makeValidNickDataWithChallenge(t, response.Challenge))
+
+	// then
+	require.Equal(t, 200, rr.Code, "http status should be OK")
+	require.NotNil(t, repo.putArgument, "nick data should be stored")
+}
+
+func TestPutChallengeForeign(t *testing.T) {
+	// given
+	repo, h, _ := makeComponentsWithConfig(t, makeChallengeConfig())
+
+	foreign, err := newChallenger([]byte("other secret"), time.Minute)
+	require.NoError(t, err)
+
+	challenge, _, err := foreign.Issue()
+	require.NoError(t, err)
+
+	// when
+	rr := putNickData(t, h, makeValidNickDataWithChallenge(t, challenge))
+
+	// then
+	require.Equal(t, 400, rr.Code, "http status should be Bad Request")
+	require.Contains(t, rr.Body.String(), "Invalid or expired challenge.")
+	require.Nil(t, repo.putArgument, "nick data should not be stored")
+}
+
+func TestPutChallengeStale(t *testing.T) {
+	// given
+	conf := makeChallengeConfig()
+	repo, h, _ := makeComponentsWithConfig(t, conf)
+
+	stale, err := newChallenger([]byte(conf.ChallengeSecret), time.Duration(conf.ChallengeTTL))
+	require.NoError(t, err)
+	stale.now = func() time.Time {
+		return time.Now().Add(-2 * time.Duration(conf.ChallengeTTL))
+	}
+
+	challenge, _, err := stale.Issue()
+	require.NoError(t, err)
+
+	// when
+	rr := putNickData(t, h, makeValidNickDataWithChallenge(t, challenge))
+
+	// then
+	require.Equal(t, 400, rr.Code, "http status should be Bad Request")
+	require.Nil(t, repo.putArgument, "nick data should not be stored")
+}
+
+func TestPutChallengeMissing(t *testing.T) {
+	// given
+	repo, h, _ := makeComponentsWithConfig(t, makeChallengeConfig())
+
+	// when
+	rr := putNickData(t, h, makeValidNickData(t))
+
+	// then
+	require.Equal(t, 400, rr.Code, "http status should be Bad Request")
+	require.Nil(t, repo.putArgument, "nick data should not be stored")
+}
+
+func TestValidationFailureMetrics(t *testing.T) {
+	testCases := []struct {
+		Reason string
+		Modify func(nickData *data.NickData)
+	}{
+		{
+			Reason: "public_key",
+			Modify: func(nickData *data.NickData) {
+				nickData.PublicKey = []byte("invalid")
+			},
+		},
+		{
+			Reason: "id",
+			Modify: func(nickData *data.NickData) {
+				nickData.Id = bytes.Repeat([]byte{1}, len(nickData.Id))
+			},
+		},
+		{
+			Reason: "nick",
+			Modify: func(nickData *data.NickData) {
+				nickData.Nick = "a"
+			},
+		},
+		{
+			Reason: "time",
+			Modify: func(nickData *data.NickData) {
+				nickData.Time = time.Time{}
+			},
+		},
+		{
+			Reason: "signature",
+			Modify: func(nickData *data.NickData) {
+				nickData.Signature[0] ^= 0xff
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Reason, func(t *testing.T) {
+			// given
+			repo, h, _ := makeComponents(t)
+
+			nickData := makeValidNickData(t)
+			testCase.Modify(nickData)
+			repo.putErr = nickData.Validate()
+
+			// when
+			rr := putNickData(t, h, nickData)
+
+			// then
+			require.Equal(t, 400, rr.Code, "http status should be Bad Request")
+
+			rr = httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/metrics", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			h.ServeHTTP(rr, req)
+
+			metrics := rr.Body.String()
+			for _, reason := range data.ValidationReasons {
+				expected := 0
+				if string(reason) == testCase.Reason {
+					expected = 1
+				}
+				require.Contains(t, metrics, fmt.Sprintf(`starlight_nick_server_validation_failures_total{reason="%s"} %d`, reason, expected))
+			}
+		})
+	}
+}
+
+func TestPutInvalidReason(t *testing.T) {
+	testCases := []struct {
+		Detail string
+		Modify func(nickData *data.NickData)
+	}{
+		{
+			Detail: "public_key",
+			Modify: func(nickData *data.NickData) {
+				nickData.PublicKey = []byte("invalid")
+			},
+		},
+		{
+			Detail: "id",
+			Modify: func(nickData *data.NickData) {
+				nickData.Id = bytes.Repeat([]byte{1}, len(nickData.Id))
+			},
+		},
+		{
+			Detail: "nick",
+			Modify: func(nickData *data.NickData) {
+				nickData.Nick = "a"
+			},
+		},
+		{
+			Detail: "time",
+			Modify: func(nickData *data.NickData) {
+				nickData.Time = time.Now().Add(time.Hour)
+			},
+		},
+		{
+			Detail: "signature",
+			Modify: func(nickData *data.NickData) {
+				nickData.Signature[0] ^= 0xff
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Detail, func(t *testing.T) {
+			// given
+			h, err := newHandler(data.NewMemoryRepository(data.DefaultOptions()), config.Default())
+			require.NoError(t, err)
+
+			nickData := makeValidNickData(t)
+			testCase.Modify(nickData)
+
+			// when
+			rr := putNickData(t, h, nickData)
+
+			// then
+			require.Equal(t, 400, rr.Code, "http status should be Bad Request")
+
+			var response struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+				Reason  string `json:"reason"`
+				Detail  string `json:"detail"`
+			}
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+			require.Equal(t, 400, response.Code)
+			require.True(t, strings.HasPrefix(response.Message, "invalid nick data: "), "unexpected message: %s", response.Message)
+			require.Equal(t, "invalid_nick_data", response.Reason)
+			require.Equal(t, testCase.Detail, response.Detail)
+		})
+	}
+}
+
+// makeJsonTombstone returns a JSON encoded tombstone signed by the identity.
+func makeJsonTombstone(t *testing.T, iden *node.Identity, tm time.Time) []byte {
+	publicKey, err := iden.PubKey.Bytes()
+	require.NoError(t, err)
+
+	tombstone := data.Tombstone{
+		Id:        iden.Id,
+		Time:      tm,
+		PublicKey: publicKey,
+	}
+	tombstone.Signature, err = iden.PrivKey.Sign(tombstone.GetDataToSign(), data.SigningHash)
+	require.NoError(t, err)
+
+	j, err := json.Marshal(tombstone)
+	require.NoError(t, err)
+	return j
+}
+
+func TestDelete(t *testing.T) {
+	// given
+	repo, h, rr := makeComponents(t)
+
+	nickData := makeValidNickData(t)
+	repo.getReturn = nickData
+
+	body := makeJsonTombstone(t, makeIdentity(t), nickData.Time.Add(time.Second))
+	req, err := http.NewRequest("DELETE", "/nicks/"+hex.EncodeToString(nickData.Id), bytes.NewBuffer(body))
+	require.NoError(t, err)
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, http.StatusOK, rr.Code, "http status should be OK")
+	require.NotNil(t, repo.deleteArgument, "delete should be called")
+	require.Equal(t, nickData.Id, *repo.deleteArgument)
+}
+
+func TestDeleteUnauthorized(t *testing.T) {
+	// given
+	repo, h, rr := makeComponents(t)
+
+	nickData := makeValidNickData(t)
+	repo.getReturn = nickData
+
+	tombstone := data.Tombstone{}
+	require.NoError(t, json.Unmarshal(makeJsonTombstone(t, makeIdentity(t), nickData.Time), &tombstone))
+	tombstone.Time = tombstone.Time.Add(time.Second)
+	body, err := json.Marshal(tombstone)
+	require.NoError(t, err)
+
+	req, err := http.NewRequest("DELETE", "/nicks/"+hex.EncodeToString(nickData.Id), bytes.NewBuffer(body))
+	require.NoError(t, err)
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, http.StatusBadRequest, rr.Code, "http status should be Bad Request")
+	require.Nil(t, repo.deleteArgument, "delete should not be called")
+}
+
+func TestDeleteReplayed(t *testing.T) {
+	// given
+	repo, h, rr := makeComponents(t)
+	repo.deleteErr = data.NewerNickDataPresentErr
+
+	iden := makeIdentity(t)
+	body := makeJsonTombstone(t, iden, time.Now())
+	req, err := http.NewRequest("DELETE", "/nicks/"+hex.EncodeToString(iden.Id), bytes.NewBuffer(body))
+	require.NoError(t, err)
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, http.StatusBadRequest, rr.Code, "tombstones older than the nick data should be rejected")
+	require.JSONEq(t, `{"code":400,"message":"newer nick data is available"}`, rr.Body.String())
+}
+
+func TestDeleteFutureTombstone(t *testing.T) {
+	// given
+	repo, h, rr := makeComponents(t)
+
+	iden := makeIdentity(t)
+	body := makeJsonTombstone(t, iden, time.Now().Add(time.Hour))
+	req, err := http.NewRequest("DELETE", "/nicks/"+hex.EncodeToString(iden.Id), bytes.NewBuffer(body))
+	require.NoError(t, err)
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, http.StatusBadRequest, rr.Code, "tombstones from the future should be rejected")
+	require.Nil(t, repo.deleteArgument, "delete should not be called")
+}
+
+func TestDeleteNotFound(t *testing.T) {
+	// given
+	repo, h, rr := makeComponents(t)
+	repo.deleteErr = data.NotFoundErr
+
+	iden := makeIdentity(t)
+	body := makeJsonTombstone(t, iden, time.Now())
+	req, err := http.NewRequest("DELETE", "/nicks/"+hex.EncodeToString(iden.Id), bytes.NewBuffer(body))
+	require.NoError(t, err)
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, http.StatusNotFound, rr.Code, "http status should be Not Found")
+}
+
+func TestServeShutdown(t *testing.T) {
+	// given
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	conf := config.Default()
+	conf.ServeAddress = address
+
+	repo := &repositoryMock{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errC := make(chan error, 1)
+	go func() {
+		errC <- Serve(ctx, repo, conf)
+	}()
+
+	require.Eventually(t, func() bool {
+		response, err := http.Get("http://" + address + "/health")
+		if err != nil {
+			return false
+		}
+		response.Body.Close()
+		return response.StatusCode == http.StatusOK
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// when
+	cancel()
+
+	// then
+	select {
+	case err := <-errC:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve didn't return")
+	}
+	require.True(t, repo.closed, "repository should be closed")
+
+	listener, err = net.Listen("tcp", address)
+	require.NoError(t, err, "listener should be released")
+	require.NoError(t, listener.Close())
+}
+
+func TestPutReservedNick(t *testing.T) {
+	// given
+	reserved, err := data.NewReservedNicks([]string{"NICK"})
+	require.NoError(t, err)
+
+	options := data.DefaultOptions()
+	options.ReservedNicks = reserved
+
+	h, err := newHandler(data.NewMemoryRepository(options), config.Default())
+	require.NoError(t, err)
+
+	// when
+	rr := putNickData(t, h, makeValidNickData(t))
+
+	// then
+	require.Equal(t, http.StatusBadRequest, rr.Code, "http status should be Bad Request")
+
+	var response struct {
+		Message string `json:"message"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	require.Equal(t, "This nick is reserved and can't be registered.", response.Message)
+}
+
+func TestPutAsyncReservedNick(t *testing.T) {
+	// given
+	conf := config.Default()
+	conf.ReservedNicks = []string{"ni*"}
+	repo, h, _ := makeComponentsWithConfig(t, conf)
+
+	body, err := json.Marshal(makeValidNickData(t))
+	require.NoError(t, err)
+
+	req, err := http.NewRequest("PUT", "/nicks?async=true", bytes.NewBuffer(body))
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, http.StatusBadRequest, rr.Code, "http status should be Bad Request")
+	require.Contains(t, rr.Body.String(), "This nick is reserved")
+
+	repo.putLock.Lock()
+	defer repo.putLock.Unlock()
+	require.Nil(t, repo.putArgument, "reserved nick should not be queued")
+}
+
+func TestPutAsyncReservedNickHeldByNode(t *testing.T) {
+	// given
+	conf := config.Default()
+	conf.ReservedNicks = []string{"ni*"}
+	repo, h, _ := makeComponentsWithConfig(t, conf)
+
+	nickData := makeValidNickData(t)
+	repo.getByNickReturn = nickData
+
+	body, err := json.Marshal(nickData)
+	require.NoError(t, err)
+
+	req, err := http.NewRequest("PUT", "/nicks?async=true", bytes.NewBuffer(body))
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, http.StatusAccepted, rr.Code, "node holding the nick should be able to update it")
+}
+
+func TestInvalidReservedNicks(t *testing.T) {
+	// given
+	conf := config.Default()
+	conf.ReservedNicks = []string{"ad*min"}
+
+	// when
+	_, err := newHandler(&repositoryMock{}, conf)
+
+	// then
+	require.EqualError(t, err, "could not create the reserved nicks: invalid reserved nick \"ad*min\"")
+}
+
+func TestHandlerWithConfigPredatingTheOptions(t *testing.T) {
+	// given
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	content := `{"ServeAddress": "127.0.0.1:8118", "DatabasePath": "` + filepath.Join(dir, "database.bolt") + `"}`
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+
+	conf, err := config.Load(path)
+	require.NoError(t, err)
+
+	// when
+	h, err := newHandler(&repositoryMock{}, conf)
+
+	// then
+	require.NoError(t, err)
+
+	rr := putNickData(t, h, makeNickData())
+	require.Equal(t, http.StatusOK, rr.Code, "http status should be OK")
 }
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/boreq/starlight-nick-server/server/api"
+)
+
+// timeRange specifies the inclusive range of times of the returned entries.
+type timeRange struct {
+	From time.Time
+	To   time.Time
+}
+
+// getTimeRangeParams parses the "from" and "to" query parameters containing
+// unix timestamps. It returns false if none of them is present in which case
+// the entries shouldn't be filtered by time.
+func getTimeRangeParams(r *http.Request) (timeRange, bool, api.Error) {
+	query := r.URL.Query()
+	_, hasFrom := query["from"]
+	_, hasTo := query["to"]
+	if !hasFrom && !hasTo {
+		return timeRange{}, false, nil
+	}
+
+	from, err := strconv.ParseInt(query.Get("from"), 10, 64)
+	if err != nil {
+		return timeRange{}, false, api.BadRequest.WithMessage("Invalid from.")
+	}
+
+	to, err := strconv.ParseInt(query.Get("to"), 10, 64)
+	if err != nil {
+		return timeRange{}, false, api.BadRequest.WithMessage("Invalid to.")
+	}
+
+	if from > to {
+		return timeRange{}, false, api.BadRequest.WithMessage("From must not be after to.")
+	}
+
+	params := timeRange{
+		From: time.Unix(from, 0),
+		To:   time.Unix(to, 0),
+	}
+	return params, true, nil
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"github.com/boreq/starlight-nick-server/config"
+	"github.com/pkg/errors"
+)
+
+// newPublicTLSConfig returns nil if the public listener should use plaintext.
+func newPublicTLSConfig(conf *config.Config) (*tls.Config, error) {
+	if conf.TLSCertPath == "" && conf.TLSKeyPath == "" {
+		return nil, nil
+	}
+	if conf.TLSCertPath == "" || conf.TLSKeyPath == "" {
+		return nil, errors.New("tls requires both the certificate and the key")
+	}
+
+	cert, err := tls.LoadX509KeyPair(conf.TLSCertPath, conf.TLSKeyPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not load the certificate")
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// newTLSRedirectHandler redirects all requests to the same path on the TLS
+// listener.
+func newTLSRedirectHandler(conf *config.Config) (http.Handler, error) {
+	_, port, err := net.SplitHostPort(conf.ServeAddress)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid serve address")
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	}), nil
+}
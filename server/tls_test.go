@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boreq/starlight-nick-server/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeInvalidCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := config.Default()
+	conf.ServeAddress = "127.0.0.1:0"
+	conf.TLSCertPath = filepath.Join(dir, "missing.crt")
+	conf.TLSKeyPath = filepath.Join(dir, "missing.key")
+
+	err = Serve(context.Background(), &repositoryMock{}, conf)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "could not load the certificate")
+}
+
+func TestServeCertificateWithoutKey(t *testing.T) {
+	conf := config.Default()
+	conf.TLSCertPath = "server.crt"
+
+	err := Serve(context.Background(), &repositoryMock{}, conf)
+	require.EqualError(t, err, "could not create the tls config: tls requires both the certificate and the key")
+}
+
+func TestServeInvalidAdminConfig(t *testing.T) {
+	// given
+	dir, err := ioutil.TempDir("", "test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	adminConf, _, _ := makeAdminTLSConfig(t, dir)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	redirectAddress := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	conf := config.Default()
+	conf.ServeAddress = "127.0.0.1:0"
+	conf.TLSCertPath = adminConf.AdminTLSCertPath
+	conf.TLSKeyPath = adminConf.AdminTLSKeyPath
+	conf.TLSRedirectAddress = redirectAddress
+	conf.AdminServeAddress = "127.0.0.1:0"
+	conf.AdminTLSCertPath = adminConf.AdminTLSCertPath
+	conf.AdminTLSKeyPath = adminConf.AdminTLSKeyPath
+	conf.AdminClientCAPath = filepath.Join(dir, "missing.crt")
+
+	repo := &repositoryMock{}
+
+	// when
+	err = Serve(context.Background(), repo, conf)
+
+	// then
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "could not create the admin server")
+	require.True(t, repo.closed, "repository should be closed")
+
+	listener, err = net.Listen("tcp", redirectAddress)
+	require.NoError(t, err, "redirect listener should not be started")
+	require.NoError(t, listener.Close())
+}
+
+func TestServeTLS(t *testing.T) {
+	// given
+	dir, err := ioutil.TempDir("", "test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	adminConf, ca, _ := makeAdminTLSConfig(t, dir)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	conf := config.Default()
+	conf.ServeAddress = address
+	conf.TLSCertPath = adminConf.AdminTLSCertPath
+	conf.TLSKeyPath = adminConf.AdminTLSKeyPath
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errC := make(chan error, 1)
+	go func() {
+		errC <- Serve(ctx, &repositoryMock{}, conf)
+	}()
+	defer func() {
+		cancel()
+		require.NoError(t, <-errC)
+	}()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		},
+	}
+
+	// when
+	var response *http.Response
+	require.Eventually(t, func() bool {
+		response, err = client.Get("https://" + address + "/health")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	defer response.Body.Close()
+
+	// then
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	plaintext, err := http.Get("http://" + address + "/health")
+	require.NoError(t, err)
+	defer plaintext.Body.Close()
+	require.Equal(t, http.StatusBadRequest, plaintext.StatusCode, "plaintext requests should be rejected")
+}
+
+func TestTLSRedirect(t *testing.T) {
+	testCases := []struct {
+		Name         string
+		ServeAddress string
+		Host         string
+		Expected     string
+	}{
+		{
+			Name:         "custom_port",
+			ServeAddress: ":8443",
+			Host:         "example.com",
+			Expected:     "https://example.com:8443/nicks?limit=1",
+		},
+		{
+			Name:         "default_port",
+			ServeAddress: ":443",
+			Host:         "example.com:80",
+			Expected:     "https://example.com/nicks?limit=1",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			// given
+			conf := config.Default()
+			conf.ServeAddress = testCase.ServeAddress
+
+			h, err := newTLSRedirectHandler(conf)
+			require.NoError(t, err)
+
+			req, err := http.NewRequest("GET", "/nicks?limit=1", nil)
+			require.NoError(t, err)
+			req.Host = testCase.Host
+			rr := httptest.NewRecorder()
+
+			// when
+			h.ServeHTTP(rr, req)
+
+			// then
+			require.Equal(t, http.StatusPermanentRedirect, rr.Code)
+			require.Equal(t, testCase.Expected, rr.Header().Get("Location"))
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/boreq/starlight-nick-server/data"
+	"github.com/boreq/starlight-nick-server/server/api"
+)
+
+// includeTrust is the value of the "include" parameter which adds the trust
+// score to the returned nick data.
+const includeTrust = "trust"
+
+// trustAgeHorizon is the time since the node was first seen after which it no
+// longer increases the trust score.
+const trustAgeHorizon = 90 * 24 * time.Hour
+
+// getIncludeTrustParam returns true if the client requested the trust score
+// using "?include=trust".
+func getIncludeTrustParam(r *http.Request) (bool, api.Error) {
+	value := r.URL.Query().Get("include")
+	if value == "" {
+		return false, nil
+	}
+	for _, field := range strings.Split(value, ",") {
+		if field != includeTrust {
+			return false, api.BadRequest.WithMessage("Invalid include.")
+		}
+	}
+	return true, nil
+}
+
+// computeTrust returns a score between 0 and 1 describing how established
+// the nick data is. If the repository records when the nodes were first seen
+// the score grows with the time since then and if the repository tracks the
+// history of the nicks it falls with the number of nicks which the node used.
+// The time of the nick data is signed by the client which can backdate it so
+// it isn't used. The score isn't authoritative and is only meant to be
+// displayed by the clients.
+func (h *handler) computeTrust(nickData *data.NickData) (float64, error) {
+	var scores []float64
+
+	if firstSeenRepository, ok := h.repository.(firstSeenRepository); ok {
+		firstSeen, err := firstSeenRepository.FirstSeen(nickData.Id)
+		if err != nil {
+			return 0, err
+		}
+		ageScore := 0.0
+		if !firstSeen.IsZero() {
+			age := time.Since(firstSeen)
+			ageScore = math.Max(0, math.Min(1, float64(age)/float64(trustAgeHorizon)))
+		}
+		scores = append(scores, ageScore)
+	}
+
+	if history, ok := h.repository.(historyRepository); ok {
+		nicks, err := history.NickHistory(nickData.Id)
+		if err != nil {
+			return 0, err
+		}
+		stabilityScore := 1.0
+		if len(nicks) > 1 {
+			stabilityScore = 1 / float64(len(nicks))
+		}
+		scores = append(scores, stabilityScore)
+	}
+
+	if len(scores) == 0 {
+		return 0, nil
+	}
+	sum := 0.0
+	for _, score := range scores {
+		sum += score
+	}
+	return round(sum / float64(len(scores))), nil
+}
+
+// round rounds the score to two decimal places.
+func round(score float64) float64 {
+	return math.Round(score*100) / 100
+}
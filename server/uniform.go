@@ -0,0 +1,82 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/boreq/starlight-nick-server/config"
+	"github.com/boreq/starlight-nick-server/server/api"
+	"github.com/julienschmidt/httprouter"
+)
+
+// uniformErrors replaces the client errors which could reveal whether a node
+// or a nick exists with generic errors so that the API can't be used to
+// enumerate the stored data. All such errors returned by the reads become Not
+// Found and all such errors returned by the writes become Bad Request. The
+// errors caused by the load of the server are returned unchanged.
+type uniformErrors struct {
+	minWriteDuration time.Duration
+}
+
+// newUniformErrors returns nil if the uniform errors are disabled.
+func newUniformErrors(conf *config.Config) *uniformErrors {
+	if !conf.UniformErrors {
+		return nil
+	}
+	return &uniformErrors{
+		minWriteDuration: time.Duration(conf.UniformWriteDuration),
+	}
+}
+
+// Wrap replaces the errors returned by the handle. The writes are additionally
+// delayed so that they take at least minWriteDuration whether they succeed or
+// not, as otherwise the rejections which don't require verifying the
+// signature could be distinguished by timing. The handle isn't wrapped if
+// the uniform errors are disabled.
+func (u *uniformErrors) Wrap(method string, handle api.Handle) api.Handle {
+	if u == nil {
+		return handle
+	}
+
+	if method == http.MethodGet || method == http.MethodHead {
+		return func(r *http.Request, ps httprouter.Params) (interface{}, api.Error) {
+			response, apiErr := handle(r, ps)
+			if isRevealingError(apiErr, false) {
+				return nil, api.NotFound
+			}
+			return response, apiErr
+		}
+	}
+
+	return func(r *http.Request, ps httprouter.Params) (interface{}, api.Error) {
+		start := time.Now()
+		response, apiErr := handle(r, ps)
+		if remaining := u.minWriteDuration - time.Since(start); remaining > 0 {
+			select {
+			case <-time.After(remaining):
+			case <-r.Context().Done():
+			}
+		}
+		if isRevealingError(apiErr, true) {
+			return nil, api.BadRequest
+		}
+		return response, apiErr
+	}
+}
+
+// isRevealingError returns true if the error can reveal information about the
+// stored data or, in the case of the writes, about the reason for which the
+// write was rejected.
+func isRevealingError(apiErr api.Error, write bool) bool {
+	if apiErr == nil {
+		return false
+	}
+	switch apiErr.GetCode() {
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusGone:
+		return true
+	case http.StatusForbidden, http.StatusConflict:
+		return write
+	default:
+		return false
+	}
+}
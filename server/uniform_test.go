@@ -0,0 +1,141 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/boreq/starlight-nick-server/config"
+	"github.com/boreq/starlight-nick-server/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUniformErrorsReads(t *testing.T) {
+	testCases := []struct {
+		Name string
+		Path string
+	}{
+		{
+			Name: "invalid_node_id",
+			Path: "/nicks/zz",
+		},
+		{
+			Name: "unknown_node_id",
+			Path: "/nicks/abcd",
+		},
+		{
+			Name: "invalid_nick",
+			Path: "/ids/a",
+		},
+		{
+			Name: "unknown_nick",
+			Path: "/ids/unknown",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			// given
+			conf := config.Default()
+			conf.UniformErrors = true
+
+			_, h, rr := makeComponentsWithConfig(t, conf)
+
+			req, err := http.NewRequest("GET", testCase.Path, nil)
+			require.NoError(t, err)
+
+			// when
+			h.ServeHTTP(rr, req)
+
+			// then
+			require.Equal(t, http.StatusNotFound, rr.Code)
+			require.JSONEq(t, `{"code":404,"message":"Not found."}`, rr.Body.String())
+		})
+	}
+}
+
+func TestUniformErrorsWrites(t *testing.T) {
+	testCases := []struct {
+		Name   string
+		Body   []byte
+		PutErr error
+	}{
+		{
+			Name: "malformed",
+			Body: []byte("[]"),
+		},
+		{
+			Name:   "invalid",
+			Body:   makeJsonNickData(t),
+			PutErr: data.InvalidNickDataErr,
+		},
+		{
+			Name:   "conflict",
+			Body:   makeJsonNickData(t),
+			PutErr: data.NickConflictErr,
+		},
+		{
+			Name:   "older",
+			Body:   makeJsonNickData(t),
+			PutErr: data.NewerNickDataPresentErr,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			// given
+			conf := config.Default()
+			conf.UniformErrors = true
+
+			repo, h, rr := makeComponentsWithConfig(t, conf)
+			repo.putErr = testCase.PutErr
+
+			req, err := http.NewRequest("PUT", "/nicks", bytes.NewBuffer(testCase.Body))
+			require.NoError(t, err)
+
+			// when
+			h.ServeHTTP(rr, req)
+
+			// then
+			require.Equal(t, http.StatusBadRequest, rr.Code)
+			require.JSONEq(t, `{"code":400,"message":"Bad request."}`, rr.Body.String())
+		})
+	}
+}
+
+func TestUniformErrorsWriteDuration(t *testing.T) {
+	// given
+	conf := config.Default()
+	conf.UniformErrors = true
+	conf.UniformWriteDuration = config.Duration(50 * time.Millisecond)
+
+	_, h, rr := makeComponentsWithConfig(t, conf)
+
+	req, err := http.NewRequest("PUT", "/nicks", bytes.NewBufferString("[]"))
+	require.NoError(t, err)
+
+	// when
+	start := time.Now()
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	require.True(t, time.Since(start) >= 50*time.Millisecond, "rejected writes should be delayed")
+}
+
+func TestUniformErrorsDisabled(t *testing.T) {
+	// given
+	repo, h, rr := makeComponents(t)
+	repo.putErr = data.NickConflictErr
+
+	req, err := http.NewRequest("PUT", "/nicks", bytes.NewBuffer(makeJsonNickData(t)))
+	require.NoError(t, err)
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	require.Contains(t, rr.Body.String(), data.NickConflictErr.Error(), "errors should be detailed by default")
+}
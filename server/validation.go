@@ -0,0 +1,88 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/boreq/starlight-nick-server/data"
+	"github.com/boreq/starlight-nick-server/server/api"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// reasonUnknown is used if the reason for which nick data was rejected as
+// invalid can't be determined.
+const reasonUnknown = "unknown"
+
+// reasonInvalidNickData is the reason of the errors returned when nick data
+// is rejected as invalid. The detail contains the validation reason.
+const reasonInvalidNickData = "invalid_nick_data"
+
+// validationLogInterval is the min interval between the logged validation
+// failures. The failures aren't logged individually so that a flood of
+// invalid writes doesn't flood the logs.
+const validationLogInterval = time.Second
+
+// validationMetrics counts the validation failures by reason.
+type validationMetrics struct {
+	failures *prometheus.CounterVec
+	lastLog  int64
+}
+
+func newValidationMetrics(registry *prometheus.Registry) *validationMetrics {
+	m := &validationMetrics{
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "validation_failures_total",
+			Help:      "Number of writes rejected because the nick data was invalid by reason.",
+		}, []string{"reason"}),
+	}
+	for _, reason := range data.ValidationReasons {
+		m.failures.WithLabelValues(string(reason))
+	}
+	m.failures.WithLabelValues(reasonUnknown)
+	registry.MustRegister(m.failures)
+	return m
+}
+
+// Record counts the validation failure and logs it unless a different failure
+// was logged recently.
+func (m *validationMetrics) Record(r *http.Request, err error) {
+	reason := reasonUnknown
+	var validationErr *data.ValidationError
+	if errors.As(err, &validationErr) {
+		reason = string(validationErr.Reason)
+	}
+	m.failures.WithLabelValues(reason).Inc()
+
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&m.lastLog)
+	if now-last >= int64(validationLogInterval) && atomic.CompareAndSwapInt64(&m.lastLog, last, now) {
+		log.Warn("rejected invalid nick data", "reason", reason, "client", clientIP(r), "err", err)
+	}
+}
+
+// invalidNickDataError describes why the nick data was rejected. The message
+// starts with the message of InvalidNickDataErr so that it remains meaningful
+// for the clients which don't check the reason.
+func invalidNickDataError(err error) api.Error {
+	detail := reasonUnknown
+	message := data.InvalidNickDataErr.Error()
+	var validationErr *data.ValidationError
+	if errors.As(err, &validationErr) {
+		detail = string(validationErr.Reason)
+		message += ": " + validationErr.Error()
+	}
+	return api.BadRequest.WithMessage(message).WithReason(reasonInvalidNickData, detail)
+}
+
+// clientIP returns the address of the client which sent the request.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// verificationLimiter bounds the number of concurrently processed writes, each
+// of which requires a CPU-heavy signature verification. At most maxRunning
+// writes are processed concurrently and at most maxQueued additional writes
+// wait for their turn. Writes exceeding this bound are rejected instead of
+// being queued indefinitely which protects the memory of the server during a
+// signature flood.
+type verificationLimiter struct {
+	running   chan struct{}
+	queued    int64
+	maxQueued int64
+	rejected  prometheus.Counter
+}
+
+func newVerificationLimiter(maxRunning, maxQueued int, registry *prometheus.Registry) *verificationLimiter {
+	l := &verificationLimiter{
+		running:   make(chan struct{}, maxRunning),
+		maxQueued: int64(maxQueued),
+		rejected: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "verification_rejected_total",
+			Help:      "Number of writes rejected because the verification queue was full.",
+		}),
+	}
+
+	registry.MustRegister(
+		l.rejected,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "verification_queue_depth",
+			Help:      "Number of writes waiting for verification.",
+		}, func() float64 {
+			return float64(atomic.LoadInt64(&l.queued))
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "verification_running",
+			Help:      "Number of writes being verified.",
+		}, func() float64 {
+			return float64(len(l.running))
+		}),
+	)
+	return l
+}
+
+// Acquire reserves a slot for a write. It returns false if the queue is full
+// or the context is cancelled before the slot could be reserved. If true is
+// returned then Release must be called once the write is processed.
+func (l *verificationLimiter) Acquire(ctx context.Context) bool {
+	select {
+	case l.running <- struct{}{}:
+		return true
+	default:
+	}
+
+	if atomic.AddInt64(&l.queued, 1) > l.maxQueued {
+		atomic.AddInt64(&l.queued, -1)
+		l.rejected.Inc()
+		return false
+	}
+	defer atomic.AddInt64(&l.queued, -1)
+
+	select {
+	case l.running <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Release frees a slot reserved using Acquire.
+func (l *verificationLimiter) Release() {
+	<-l.running
+}
+
+// Running returns the number of writes being processed.
+func (l *verificationLimiter) Running() int {
+	return len(l.running)
+}
+
+// MaxRunning returns the max number of writes processed concurrently.
+func (l *verificationLimiter) MaxRunning() int {
+	return cap(l.running)
+}
+
+// Queued returns the number of writes waiting for their turn.
+func (l *verificationLimiter) Queued() int64 {
+	return atomic.LoadInt64(&l.queued)
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/boreq/starlight-nick-server/config"
+	"github.com/boreq/starlight-nick-server/data"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// asyncWriter stores the writes accepted using "?async=true" in the
+// background. The writes are stored one by one in the order in which they were
+// accepted. Errors can't be reported to the clients and are only logged.
+type asyncWriter struct {
+	repository Repository
+	queue      chan *data.NickData
+	done       chan struct{}
+
+	// lock prevents closing the queue while a write is being queued.
+	lock   sync.Mutex
+	closed bool
+}
+
+// newAsyncWriterFromConfig returns nil if the asynchronous writes are
+// disabled.
+func newAsyncWriterFromConfig(repository Repository, conf *config.Config, registry *prometheus.Registry) (*asyncWriter, error) {
+	if conf.AsyncWriteQueueSize < 0 {
+		return nil, errors.New("async write queue size can't be negative")
+	}
+	if conf.AsyncWriteQueueSize == 0 {
+		return nil, nil
+	}
+	return newAsyncWriter(repository, conf.AsyncWriteQueueSize, registry), nil
+}
+
+func newAsyncWriter(repository Repository, size int, registry *prometheus.Registry) *asyncWriter {
+	w := &asyncWriter{
+		repository: repository,
+		queue:      make(chan *data.NickData, size),
+		done:       make(chan struct{}),
+	}
+
+	registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "async_write_queue_depth",
+			Help:      "Number of asynchronous writes waiting to be stored.",
+		}, func() float64 {
+			return float64(len(w.queue))
+		}),
+	)
+
+	go w.run()
+	return w
+}
+
+// Enqueue queues the write. It returns false if the queue is full or the
+// writer was closed.
+func (w *asyncWriter) Enqueue(nickData *data.NickData) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.closed {
+		return false
+	}
+
+	select {
+	case w.queue <- nickData:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stop stops accepting new writes without waiting for the already queued
+// writes. It is called once the shutdown starts so that the clients which are
+// still connected are told to retry the writes instead of having them
+// accepted and possibly not stored. A nil writer can be stopped.
+func (w *asyncWriter) Stop() {
+	if w == nil {
+		return
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+}
+
+// Close stops accepting new writes and waits until the already queued writes
+// are stored. A nil writer can be closed.
+func (w *asyncWriter) Close() {
+	if w == nil {
+		return
+	}
+
+	w.Stop()
+	<-w.done
+}
+
+func (w *asyncWriter) run() {
+	defer close(w.done)
+
+	for nickData := range w.queue {
+		if err := w.repository.Put(nickData); err != nil {
+			if isClientError(err) {
+				log.Debug("async put rejected", "nick", nickData.Nick, "err", err)
+			} else {
+				log.Error("async put failed", "nick", nickData.Nick, "err", err)
+			}
+		}
+	}
+}
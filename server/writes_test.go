@@ -0,0 +1,89 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/boreq/starlight-nick-server/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAsyncWriterCloseStoresQueuedWrites(t *testing.T) {
+	// given
+	repo := &repositoryMock{putBlock: make(chan struct{})}
+	w := newAsyncWriter(repo, 10, prometheus.NewRegistry())
+
+	first := makeNickData()
+	second := makeNickData()
+	second.Nick = "second"
+
+	require.True(t, w.Enqueue(first))
+	require.True(t, w.Enqueue(second))
+
+	go func() {
+		<-time.After(10 * time.Millisecond)
+		close(repo.putBlock)
+	}()
+
+	// when
+	w.Close()
+
+	// then
+	repo.putLock.Lock()
+	defer repo.putLock.Unlock()
+	require.Equal(t, second, repo.putArgument, "all queued writes should be stored")
+}
+
+func TestAsyncWriterEnqueueAfterClose(t *testing.T) {
+	// given
+	repo := &repositoryMock{}
+	w := newAsyncWriter(repo, 10, prometheus.NewRegistry())
+	w.Close()
+
+	// when
+	ok := w.Enqueue(makeNickData())
+
+	// then
+	require.False(t, ok, "closed writer should reject the writes")
+	w.Close()
+}
+
+func TestAsyncWriterCloseNil(t *testing.T) {
+	var w *asyncWriter
+	w.Close()
+}
+
+func TestPutAsyncAfterStop(t *testing.T) {
+	// given
+	repo := &repositoryMock{}
+	registry := prometheus.NewRegistry()
+	writes := newAsyncWriter(repo, 10, registry)
+	h, err := newHandlerWithGuards(repo, config.Default(), makeWriteGuards(t, config.Default(), newReadiness(true), registry), writes, registry)
+	require.NoError(t, err)
+
+	writes.Stop()
+
+	body, err := json.Marshal(makeValidNickData(t))
+	require.NoError(t, err)
+
+	req, err := http.NewRequest("PUT", "/nicks?async=true", bytes.NewBuffer(body))
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+
+	// when
+	h.ServeHTTP(rr, req)
+
+	// then
+	require.Equal(t, 503, rr.Code, "http status should be Service Unavailable")
+	require.Equal(t, "1", rr.Header().Get("Retry-After"))
+
+	writes.Close()
+	repo.putLock.Lock()
+	defer repo.putLock.Unlock()
+	require.Nil(t, repo.putArgument, "write should not be stored")
+}